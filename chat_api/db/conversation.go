@@ -0,0 +1,90 @@
+package db
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Get a page of the direct and group chats of a user, most recently active
+// first. before is the last chat of the previous page, nil for the first,
+// a limit of 0 gets them all. Chats without messages have no
+// last_message_at and come last.
+func (r *ChatRepository) GetChatsByUser(ctx context.Context, userID string, before *models.Chat, limit int) ([]models.Chat, error) {
+	filter := bson.M{"users": userID}
+	if before != nil {
+		filter["$or"] = afterInList(before)
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "last_message_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.chats.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	chats := []models.Chat{}
+	if err := cursor.All(ctx, &chats); err != nil {
+		return nil, err
+	}
+	return chats, nil
+}
+
+// the chats sorted after chat by last activity, ties broken by ID
+func afterInList(chat *models.Chat) bson.A {
+	noActivity := bson.M{"last_message_at": bson.M{"$exists": false}}
+
+	if chat.LastMessageAt.IsZero() {
+		noActivity["_id"] = bson.M{"$lt": chat.ID}
+		return bson.A{noActivity}
+	}
+
+	return bson.A{
+		bson.M{"last_message_at": bson.M{"$lt": chat.LastMessageAt}},
+		bson.M{"last_message_at": chat.LastMessageAt, "_id": bson.M{"$lt": chat.ID}},
+		noActivity,
+	}
+}
+
+// Get the latest message of each chat, leaving out thread replies
+func (r *ChatRepository) GetLastMessages(ctx context.Context, chatIDs []primitive.ObjectID) ([]models.Message, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"chat_id": bson.M{"$in": chatIDs}, "thread_id": nil}}},
+		{{Key: "$sort", Value: bson.D{{Key: "chat_id", Value: 1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$chat_id", "message": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$message"}}},
+	}
+
+	cursor, err := r.messages.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []models.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Get everyone who shares a direct or group chat with a user
+func (r *ChatRepository) GetContacts(ctx context.Context, userID string) ([]string, error) {
+	values, err := r.chats.Distinct(ctx, "users", bson.M{"users": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := make([]string, 0, len(values))
+	for _, v := range values {
+		if contact, ok := v.(string); ok && contact != userID {
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts, nil
+}
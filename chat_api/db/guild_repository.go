@@ -0,0 +1,432 @@
+package db
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GuildRepository stores guilds, their members and invites. Channels are
+// chat documents of type channel so their messages use the normal history.
+type GuildRepository struct {
+	guilds   *mongo.Collection
+	members  *mongo.Collection
+	roles    *mongo.Collection
+	bans     *mongo.Collection
+	invites  *mongo.Collection
+	chats    *mongo.Collection
+	messages *mongo.Collection
+}
+
+// constructor
+func NewGuildRepository(db *mongo.Database) *GuildRepository {
+	return &GuildRepository{
+		guilds:   db.Collection("guilds"),
+		members:  db.Collection("guild_members"),
+		roles:    db.Collection("roles"),
+		bans:     db.Collection("guild_bans"),
+		invites:  db.Collection("invites"),
+		chats:    db.Collection("chats"),
+		messages: db.Collection("messages"),
+	}
+}
+
+func (r *GuildRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.members.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "guild_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.chats.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "guild_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.roles.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "guild_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.bans.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "guild_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// Create a guild with its owner as member, its @everyone role and first
+// channel in one transaction, so a failure leaves none of them behind
+func (r *GuildRepository) CreateGuild(ctx context.Context, guild *models.Guild, everyone *models.Role, channel *models.Chat) error {
+	session, err := r.guilds.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		result, err := r.guilds.InsertOne(sc, guild)
+		if err != nil {
+			return nil, err
+		}
+		guild.ID = result.InsertedID.(primitive.ObjectID)
+
+		if err := r.AddMember(sc, guild.ID, guild.OwnerID); err != nil {
+			return nil, err
+		}
+		if err := r.CreateRole(sc, everyone); err != nil {
+			return nil, err
+		}
+		return nil, r.CreateChannel(sc, channel)
+	})
+	return err
+}
+
+func (r *GuildRepository) GetGuild(ctx context.Context, guildID primitive.ObjectID) (*models.Guild, error) {
+	var guild models.Guild
+	if err := r.guilds.FindOne(ctx, bson.M{"_id": guildID}).Decode(&guild); err != nil {
+		return nil, err
+	}
+	return &guild, nil
+}
+
+// Get the guilds a user is a member of
+func (r *GuildRepository) GetGuildsByMember(ctx context.Context, userID string) ([]models.Guild, error) {
+	guildIDs, err := r.members.Distinct(ctx, "guild_id", bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	guilds := []models.Guild{}
+	if len(guildIDs) == 0 {
+		return guilds, nil
+	}
+
+	cursor, err := r.guilds.Find(ctx, bson.M{"_id": bson.M{"$in": guildIDs}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &guilds); err != nil {
+		return nil, err
+	}
+	return guilds, nil
+}
+
+// Add a member, doing nothing if they already are one
+func (r *GuildRepository) AddMember(ctx context.Context, guildID primitive.ObjectID, userID string) error {
+	filter := bson.M{"guild_id": guildID, "user_id": userID}
+	update := bson.M{
+		"$setOnInsert": bson.M{"joined_at": time.Now()},
+	}
+
+	_, err := r.members.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *GuildRepository) RemoveMember(ctx context.Context, guildID primitive.ObjectID, userID string) error {
+	_, err := r.members.DeleteOne(ctx, bson.M{"guild_id": guildID, "user_id": userID})
+	return err
+}
+
+func (r *GuildRepository) RemoveMemberEverywhere(ctx context.Context, userID string) error {
+	_, err := r.members.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+func (r *GuildRepository) IsMember(ctx context.Context, guildID primitive.ObjectID, userID string) (bool, error) {
+	count, err := r.members.CountDocuments(ctx, bson.M{"guild_id": guildID, "user_id": userID})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Get a membership with its roles, mongo.ErrNoDocuments if the user isn't a member
+func (r *GuildRepository) GetMember(ctx context.Context, guildID primitive.ObjectID, userID string) (*models.GuildMember, error) {
+	var member models.GuildMember
+	if err := r.members.FindOne(ctx, bson.M{"guild_id": guildID, "user_id": userID}).Decode(&member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (r *GuildRepository) GetMembers(ctx context.Context, guildID primitive.ObjectID) ([]models.GuildMember, error) {
+	cursor, err := r.members.Find(ctx, bson.M{"guild_id": guildID})
+	if err != nil {
+		return nil, err
+	}
+
+	members := []models.GuildMember{}
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (r *GuildRepository) CreateChannel(ctx context.Context, channel *models.Chat) error {
+	result, err := r.chats.InsertOne(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	channel.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *GuildRepository) GetChannel(ctx context.Context, channelID primitive.ObjectID) (*models.Chat, error) {
+	var channel models.Chat
+	err := r.chats.FindOne(ctx, bson.M{"_id": channelID, "type": models.ChatTypeChannel}).Decode(&channel)
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (r *GuildRepository) GetChannels(ctx context.Context, guildID primitive.ObjectID) ([]models.Chat, error) {
+	filter := bson.M{"guild_id": guildID, "type": models.ChatTypeChannel}
+
+	cursor, err := r.chats.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	channels := []models.Chat{}
+	if err := cursor.All(ctx, &channels); err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+func (r *GuildRepository) RenameChannel(ctx context.Context, channelID primitive.ObjectID, name string) error {
+	result, err := r.chats.UpdateOne(ctx,
+		bson.M{"_id": channelID, "type": models.ChatTypeChannel},
+		bson.M{"$set": bson.M{"name": name}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Delete a channel together with its messages, returns the files that were
+// attached to them
+func (r *GuildRepository) DeleteChannel(ctx context.Context, channelID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	messages := bson.M{"chat_id": channelID}
+	fileIDs, err := attachedFiles(ctx, r.messages, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.messages.DeleteMany(ctx, messages); err != nil {
+		return nil, err
+	}
+
+	_, err = r.chats.DeleteOne(ctx, bson.M{"_id": channelID, "type": models.ChatTypeChannel})
+	return fileIDs, err
+}
+
+// Replace the overwrite for the same role or member in a channel
+func (r *GuildRepository) SetOverwrite(ctx context.Context, channelID primitive.ObjectID, overwrite models.PermissionOverwrite) error {
+	if err := r.RemoveOverwrite(ctx, channelID, overwrite.Type, overwrite.ID); err != nil {
+		return err
+	}
+
+	_, err := r.chats.UpdateOne(ctx,
+		bson.M{"_id": channelID, "type": models.ChatTypeChannel},
+		bson.M{"$push": bson.M{"overwrites": overwrite}},
+	)
+	return err
+}
+
+func (r *GuildRepository) RemoveOverwrite(ctx context.Context, channelID primitive.ObjectID, overwriteType, id string) error {
+	result, err := r.chats.UpdateOne(ctx,
+		bson.M{"_id": channelID, "type": models.ChatTypeChannel},
+		bson.M{"$pull": bson.M{"overwrites": bson.M{"type": overwriteType, "id": id}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Roles with an ID already set keep it, that's how @everyone shares the
+// guild's ID
+func (r *GuildRepository) CreateRole(ctx context.Context, role *models.Role) error {
+	result, err := r.roles.InsertOne(ctx, role)
+	if err != nil {
+		return err
+	}
+
+	role.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *GuildRepository) GetRole(ctx context.Context, roleID primitive.ObjectID) (*models.Role, error) {
+	var role models.Role
+	if err := r.roles.FindOne(ctx, bson.M{"_id": roleID}).Decode(&role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *GuildRepository) GetRoles(ctx context.Context, guildID primitive.ObjectID) ([]models.Role, error) {
+	cursor, err := r.roles.Find(ctx, bson.M{"guild_id": guildID}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []models.Role{}
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *GuildRepository) UpdateRole(ctx context.Context, roleID primitive.ObjectID, name string, permissions models.Permission) error {
+	result, err := r.roles.UpdateOne(ctx,
+		bson.M{"_id": roleID},
+		bson.M{"$set": bson.M{"name": name, "permissions": permissions}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Delete a role and take it away from members and channel overwrites
+func (r *GuildRepository) DeleteRole(ctx context.Context, role *models.Role) error {
+	_, err := r.members.UpdateMany(ctx,
+		bson.M{"guild_id": role.GuildID, "roles": role.ID},
+		bson.M{"$pull": bson.M{"roles": role.ID}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.chats.UpdateMany(ctx,
+		bson.M{"guild_id": role.GuildID, "type": models.ChatTypeChannel},
+		bson.M{"$pull": bson.M{"overwrites": bson.M{"type": models.OverwriteRole, "id": role.ID.Hex()}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.roles.DeleteOne(ctx, bson.M{"_id": role.ID})
+	return err
+}
+
+// Give a member a role, mongo.ErrNoDocuments if the user isn't a member
+func (r *GuildRepository) AddMemberRole(ctx context.Context, guildID primitive.ObjectID, userID string, roleID primitive.ObjectID) error {
+	return r.updateMember(ctx, guildID, userID, bson.M{"$addToSet": bson.M{"roles": roleID}})
+}
+
+func (r *GuildRepository) RemoveMemberRole(ctx context.Context, guildID primitive.ObjectID, userID string, roleID primitive.ObjectID) error {
+	return r.updateMember(ctx, guildID, userID, bson.M{"$pull": bson.M{"roles": roleID}})
+}
+
+func (r *GuildRepository) updateMember(ctx context.Context, guildID primitive.ObjectID, userID string, update bson.M) error {
+	result, err := r.members.UpdateOne(ctx, bson.M{"guild_id": guildID, "user_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Ban a user and remove their membership
+func (r *GuildRepository) BanMember(ctx context.Context, ban *models.Ban) error {
+	filter := bson.M{"guild_id": ban.GuildID, "user_id": ban.UserID}
+	update := bson.M{"$set": ban}
+
+	if _, err := r.bans.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+		return err
+	}
+	return r.RemoveMember(ctx, ban.GuildID, ban.UserID)
+}
+
+func (r *GuildRepository) UnbanMember(ctx context.Context, guildID primitive.ObjectID, userID string) error {
+	result, err := r.bans.DeleteOne(ctx, bson.M{"guild_id": guildID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *GuildRepository) IsBanned(ctx context.Context, guildID primitive.ObjectID, userID string) (bool, error) {
+	count, err := r.bans.CountDocuments(ctx, bson.M{"guild_id": guildID, "user_id": userID})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *GuildRepository) CreateInvite(ctx context.Context, invite *models.Invite) error {
+	_, err := r.invites.InsertOne(ctx, invite)
+	return err
+}
+
+// Count a use of the invite if it is still valid at now. Expiry and the use
+// limit are checked in the same update so concurrent joins can't overrun it.
+// Returns mongo.ErrNoDocuments when the invite doesn't exist or is used up.
+func (r *GuildRepository) UseInvite(ctx context.Context, code string, now time.Time) (*models.Invite, error) {
+	filter := bson.M{
+		"_id": code,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"expires_at": bson.M{"$exists": false}},
+				bson.M{"expires_at": bson.M{"$gt": now}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"max_uses": 0},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}}},
+			}},
+		},
+	}
+
+	update := bson.M{"$inc": bson.M{"uses": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var invite models.Invite
+	if err := r.invites.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invite); err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func (r *GuildRepository) GetInvite(ctx context.Context, code string) (*models.Invite, error) {
+	var invite models.Invite
+	if err := r.invites.FindOne(ctx, bson.M{"_id": code}).Decode(&invite); err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// chat documents written before messages got their own collection
+type legacyChat struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Messages []models.Message   `bson:"messages"`
+}
+
+// MigrateEmbeddedMessages moves the messages still embedded in chat documents
+// into the messages collection. Message IDs are derived from the chat ID and
+// the position in the old array, so re-running after a partial failure skips
+// whatever was already copied. Returns the number of chats migrated.
+func (r *ChatRepository) MigrateEmbeddedMessages(ctx context.Context) (int, error) {
+	cursor, err := r.chats.Find(ctx, bson.M{"messages": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var chat legacyChat
+		if err := cursor.Decode(&chat); err != nil {
+			return migrated, err
+		}
+
+		if err := r.migrateChat(ctx, chat); err != nil {
+			return migrated, fmt.Errorf("migrating chat %s: %w", chat.ID.Hex(), err)
+		}
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
+
+func (r *ChatRepository) migrateChat(ctx context.Context, chat legacyChat) error {
+	var lastMessageAt time.Time
+
+	if len(chat.Messages) > 0 {
+		docs := make([]interface{}, 0, len(chat.Messages))
+		for i, message := range chat.Messages {
+			message.ID = legacyMessageID(chat.ID, i)
+			message.ChatID = chat.ID
+			docs = append(docs, message)
+
+			if message.Timestamp.After(lastMessageAt) {
+				lastMessageAt = message.Timestamp
+			}
+		}
+
+		_, err := r.messages.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		if err != nil && !onlyDuplicateKeyErrors(err) {
+			return err
+		}
+	}
+
+	update := bson.M{"$unset": bson.M{"messages": ""}}
+	if !lastMessageAt.IsZero() {
+		update["$set"] = bson.M{"last_message_at": lastMessageAt}
+	}
+
+	_, err := r.chats.UpdateByID(ctx, chat.ID, update)
+	return err
+}
+
+// Keep the chat's timestamp and process bytes so migrated messages sort
+// before anything sent afterwards, and use the array index as the counter
+func legacyMessageID(chatID primitive.ObjectID, index int) primitive.ObjectID {
+	var id primitive.ObjectID
+	copy(id[:9], chatID[:9])
+	id[9] = byte(index >> 16)
+	id[10] = byte(index >> 8)
+	id[11] = byte(index)
+	return id
+}
+
+func onlyDuplicateKeyErrors(err error) bool {
+	bulkErr, ok := err.(mongo.BulkWriteException)
+	if !ok || bulkErr.WriteConcernError != nil {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
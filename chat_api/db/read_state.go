@@ -0,0 +1,120 @@
+package db
+
+import (
+	"bytes"
+	"cloudcord/chat_api/models"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Move a member's read position forward to messageID. $max keeps it from
+// going back when receipts arrive out of order. Returns the state before and
+// after, before is nil on the first read.
+func (r *ChatRepository) MarkRead(ctx context.Context, chatID primitive.ObjectID, userID string, messageID primitive.ObjectID, at time.Time) (*models.ReadState, *models.ReadState, error) {
+	filter := bson.M{"chat_id": chatID, "user_id": userID}
+	update := bson.M{
+		"$max": bson.M{"last_read_id": messageID},
+		"$set": bson.M{"read_at": at},
+	}
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.Before)
+
+	var before models.ReadState
+	err := r.readStates.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+
+	var previous *models.ReadState
+	switch {
+	case err == nil:
+		previous = &before
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return nil, nil, err
+	}
+
+	after := &models.ReadState{ChatID: chatID, UserID: userID, LastReadID: messageID, ReadAt: at}
+	if previous != nil && bytes.Compare(previous.LastReadID[:], messageID[:]) > 0 {
+		after.LastReadID = previous.LastReadID
+	}
+	return previous, after, nil
+}
+
+// Get how far every member has read a chat
+func (r *ChatRepository) GetReadStates(ctx context.Context, chatID primitive.ObjectID) ([]models.ReadState, error) {
+	return r.findReadStates(ctx, bson.M{"chat_id": chatID})
+}
+
+// Get how far a user has read the given chats
+func (r *ChatRepository) GetUserReadStates(ctx context.Context, userID string, chatIDs []primitive.ObjectID) ([]models.ReadState, error) {
+	return r.findReadStates(ctx, bson.M{"user_id": userID, "chat_id": bson.M{"$in": chatIDs}})
+}
+
+func (r *ChatRepository) findReadStates(ctx context.Context, filter bson.M) ([]models.ReadState, error) {
+	cursor, err := r.readStates.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	states := []models.ReadState{}
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// Count the unread messages of each chat in one aggregation: those others
+// wrote after the user's last read one, all of them in chats missing from
+// lastRead. Thread replies and deleted messages don't count, chats without
+// unread messages are left out.
+func (r *ChatRepository) CountUnread(ctx context.Context, userID string, chatIDs []primitive.ObjectID, lastRead map[primitive.ObjectID]primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+	counts := make(map[primitive.ObjectID]int64, len(chatIDs))
+	if len(chatIDs) == 0 {
+		return counts, nil
+	}
+
+	neverRead := []primitive.ObjectID{}
+	chats := bson.A{}
+	for _, chatID := range chatIDs {
+		readID, ok := lastRead[chatID]
+		if !ok || readID.IsZero() {
+			neverRead = append(neverRead, chatID)
+			continue
+		}
+		chats = append(chats, bson.M{"chat_id": chatID, "_id": bson.M{"$gt": readID}})
+	}
+	if len(neverRead) > 0 {
+		chats = append(chats, bson.M{"chat_id": bson.M{"$in": neverRead}})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"$or":          chats,
+			"thread_id":    nil,
+			"deleted":      bson.M{"$ne": true},
+			"sent_by_user": bson.M{"$ne": userID},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$chat_id", "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := r.messages.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []struct {
+		ChatID primitive.ObjectID `bson:"_id"`
+		Count  int64              `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		counts[result.ChatID] = result.Count
+	}
+	return counts, nil
+}
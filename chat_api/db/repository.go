@@ -4,40 +4,258 @@ import (
 	"cloudcord/chat_api/models"
 	"context"
 	"sort"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ChatRepository struct {
-	collection *mongo.Collection
+	chats      *mongo.Collection
+	messages   *mongo.Collection
+	readStates *mongo.Collection
 }
 
 // constructor
 func NewChatRepository(db *mongo.Database) *ChatRepository {
 	return &ChatRepository{
-		collection: db.Collection("chats"),
+		chats:      db.Collection("chats"),
+		messages:   db.Collection("messages"),
+		readStates: db.Collection("read_states"),
 	}
 }
 
-// add message to chat
-func (r *ChatRepository) AddMessageToChat(ctx context.Context, users []string, message models.Message) error {
-	filter := bson.M{"users": users}
+// Create the indexes the history queries rely on
+func (r *ChatRepository) EnsureIndexes(ctx context.Context) error {
+	// serves member lookups and the conversation list, newest first
+	_, err := r.chats.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "users", Value: 1}, {Key: "last_message_at", Value: -1}, {Key: "_id", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// one direct chat per pair of users, chats from before the key have none
+	_, err = r.chats.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "pair_key", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"pair_key": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.messages.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "chat_id", Value: 1}, {Key: "_id", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "thread_id", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetSparse(true),
+		},
+		{
+			// no stemming or stop words, chats aren't all in English
+			Keys:    bson.D{{Key: "content", Value: "text"}},
+			Options: options.Index().SetDefaultLanguage("none"),
+		},
+		{
+			// finds the messages of a file file storage reports on
+			Keys:    bson.D{{Key: "attachments.id", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.readStates.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "chat_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "chat_id", Value: 1}},
+		},
+	})
+	return err
+}
+
+// a group can have the same two members as a direct chat, so direct chat
+// lookups must exclude groups
+func directChatFilter(users interface{}) bson.M {
+	return bson.M{
+		"users": users,
+		"type":  bson.M{"$ne": models.ChatTypeGroup},
+	}
+}
+
+// add message to the direct chat of users, which must have been started
+// with CreateChat, mongo.ErrNoDocuments when it wasn't. The message gets its
+// ID and chat ID filled in.
+func (r *ChatRepository) AddMessageToChat(ctx context.Context, users []string, message *models.Message) error {
+	filter := directChatFilter(users)
 
 	update := bson.M{
-		"$push": bson.M{"messages": message},
+		"$set": bson.M{"last_message_at": message.Timestamp},
 	}
 
-	result := r.collection.FindOneAndUpdate(ctx, filter, update)
-	if result.Err() == mongo.ErrNoDocuments {
-		chat := &models.Chat{
-			Users:    users,
-			Messages: []models.Message{message},
-		}
-		_, err := r.collection.InsertOne(ctx, chat)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var chat models.Chat
+	if err := r.chats.FindOneAndUpdate(ctx, filter, update, opts).Decode(&chat); err != nil {
+		return err
+	}
+
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
+	message.ChatID = chat.ID
+
+	_, err := r.messages.InsertOne(ctx, message)
+	return err
+}
+
+// add message to an existing conversation identified by message.ChatID
+func (r *ChatRepository) AddMessage(ctx context.Context, message *models.Message) error {
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
+
+	if _, err := r.messages.InsertOne(ctx, message); err != nil {
 		return err
 	}
-	return result.Err()
+
+	_, err := r.chats.UpdateByID(ctx, message.ChatID, bson.M{
+		"$set": bson.M{"last_message_at": message.Timestamp},
+	})
+	return err
+}
+
+func (r *ChatRepository) GetMessage(ctx context.Context, messageID primitive.ObjectID) (*models.Message, error) {
+	var message models.Message
+	if err := r.messages.FindOne(ctx, bson.M{"_id": messageID}).Decode(&message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// Change the content of a message that isn't deleted, returning the new version
+func (r *ChatRepository) EditMessage(ctx context.Context, messageID primitive.ObjectID, content string, editedAt time.Time) (*models.Message, error) {
+	filter := bson.M{"_id": messageID, "deleted": bson.M{"$ne": true}}
+	update := bson.M{"$set": bson.M{"content": content, "edited_at": editedAt}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var message models.Message
+	if err := r.messages.FindOneAndUpdate(ctx, filter, update, opts).Decode(&message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// Replace a message with a tombstone, returning it
+func (r *ChatRepository) DeleteMessage(ctx context.Context, messageID primitive.ObjectID, deletedAt time.Time) (*models.Message, error) {
+	filter := bson.M{"_id": messageID, "deleted": bson.M{"$ne": true}}
+	update := bson.M{
+		"$set":   bson.M{"content": "", "deleted": true, "deleted_at": deletedAt},
+		"$unset": bson.M{"edited_at": "", "reactions": "", "attachments": ""},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var message models.Message
+	if err := r.messages.FindOneAndUpdate(ctx, filter, update, opts).Decode(&message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// Whether any of the files is attached to a message already
+func (r *ChatRepository) AttachmentsInUse(ctx context.Context, fileIDs []primitive.ObjectID) (bool, error) {
+	count, err := r.messages.CountDocuments(ctx, bson.M{"attachments.id": bson.M{"$in": fileIDs}}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Mark a file blocked in every message it's attached to and return those
+// messages
+func (r *ChatRepository) BlockAttachment(ctx context.Context, fileID primitive.ObjectID) ([]models.Message, error) {
+	filter := bson.M{"attachments.id": fileID}
+	update := bson.M{"$set": bson.M{"attachments.$[file].blocked": true}}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"file.id": fileID}},
+	})
+
+	if _, err := r.messages.UpdateMany(ctx, filter, update, opts); err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.messages.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []models.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Add a reaction to a message that isn't deleted. $addToSet makes concurrent
+// reactions safe and reacting twice with the same emoji a no-op.
+// Add a reaction unless its emoji would go over models.MaxReactionEmoji,
+// mongo.ErrNoDocuments then as for a deleted message
+func (r *ChatRepository) AddReaction(ctx context.Context, messageID primitive.ObjectID, reaction models.Reaction) (*models.Message, error) {
+	distinctEmoji := bson.M{"$size": bson.M{"$setUnion": bson.A{
+		bson.M{"$ifNull": bson.A{"$reactions.emoji", bson.A{}}}, bson.A{},
+	}}}
+	room := bson.M{"$or": bson.A{
+		bson.M{"reactions.emoji": reaction.Emoji},
+		bson.M{"$expr": bson.M{"$lt": bson.A{distinctEmoji, models.MaxReactionEmoji}}},
+	}}
+	return r.updateReactions(ctx, messageID, room, bson.M{"$addToSet": bson.M{"reactions": reaction}})
+}
+
+func (r *ChatRepository) RemoveReaction(ctx context.Context, messageID primitive.ObjectID, reaction models.Reaction) (*models.Message, error) {
+	return r.updateReactions(ctx, messageID, bson.M{}, bson.M{"$pull": bson.M{"reactions": reaction}})
+}
+
+func (r *ChatRepository) updateReactions(ctx context.Context, messageID primitive.ObjectID, filter, update bson.M) (*models.Message, error) {
+	filter["_id"] = messageID
+	filter["deleted"] = bson.M{"$ne": true}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var message models.Message
+	if err := r.messages.FindOneAndUpdate(ctx, filter, update, opts).Decode(&message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (r *ChatRepository) GetMessagesByIDs(ctx context.Context, messageIDs []primitive.ObjectID) ([]models.Message, error) {
+	cursor, err := r.messages.Find(ctx, bson.M{"_id": bson.M{"$in": messageIDs}})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []models.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Count a new message in the thread started on rootID
+func (r *ChatRepository) AddThreadReply(ctx context.Context, rootID primitive.ObjectID, at time.Time) error {
+	_, err := r.messages.UpdateByID(ctx, rootID, bson.M{
+		"$inc": bson.M{"thread_reply_count": 1},
+		"$set": bson.M{"thread_last_reply_at": at},
+	})
+	return err
 }
 
 // Get the chat between two users
@@ -45,34 +263,178 @@ func (r *ChatRepository) GetChatByUsers(ctx context.Context, users []string) (*m
 	sort.Strings(users)
 
 	var chat models.Chat
-	err := r.collection.FindOne(ctx, bson.M{"users": users}).Decode(&chat)
+	err := r.chats.FindOne(ctx, directChatFilter(users)).Decode(&chat)
+	if err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+func (r *ChatRepository) GetChatByID(ctx context.Context, chatID primitive.ObjectID) (*models.Chat, error) {
+	var chat models.Chat
+	err := r.chats.FindOne(ctx, bson.M{"_id": chatID}).Decode(&chat)
 	if err != nil {
 		return nil, err
 	}
 	return &chat, nil
 }
 
-// Create new chat for existing users
+// Get a page of a chat's messages, without those posted in threads. Pages
+// are always returned oldest first.
+func (r *ChatRepository) GetMessages(ctx context.Context, chatID primitive.ObjectID, query models.MessageQuery) ([]models.Message, error) {
+	return r.findPage(ctx, bson.M{"chat_id": chatID, "thread_id": nil}, query)
+}
+
+// Get a page of the messages in the thread started on rootID
+func (r *ChatRepository) GetThreadMessages(ctx context.Context, rootID primitive.ObjectID, query models.MessageQuery) ([]models.Message, error) {
+	return r.findPage(ctx, bson.M{"thread_id": rootID}, query)
+}
+
+func (r *ChatRepository) findPage(ctx context.Context, filter bson.M, query models.MessageQuery) ([]models.Message, error) {
+	order := -1
+
+	switch {
+	case !query.After.IsZero():
+		filter["_id"] = bson.M{"$gt": query.After}
+		order = 1
+	case !query.Before.IsZero():
+		filter["_id"] = bson.M{"$lt": query.Before}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: order}}).
+		SetLimit(int64(query.Limit))
+
+	cursor, err := r.messages.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []models.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	if order < 0 {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	return messages, nil
+}
+
+// Get the direct chat of users, creating it when they have none. Chats it
+// creates get a pair key with a unique index, so when two requests race the
+// second insert fails and is retried to find the first one's chat.
 func (r *ChatRepository) CreateChat(ctx context.Context, users []string) (*models.Chat, error) {
 	sort.Strings(users)
 
-	chat := &models.Chat{
-		Users:    users,
-		Messages: []models.Message{},
+	update := bson.M{"$setOnInsert": bson.M{
+		"type":     models.ChatTypeDirect,
+		"pair_key": strings.Join(users, "|"),
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var chat models.Chat
+	err := r.chats.FindOneAndUpdate(ctx, directChatFilter(users), update, opts).Decode(&chat)
+	if mongo.IsDuplicateKeyError(err) {
+		err = r.chats.FindOneAndUpdate(ctx, directChatFilter(users), update, opts).Decode(&chat)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
 
-	_, err := r.collection.InsertOne(ctx, chat)
+// Insert a conversation of any type, filling in its ID
+func (r *ChatRepository) CreateConversation(ctx context.Context, chat *models.Chat) error {
+	result, err := r.chats.InsertOne(ctx, chat)
 	if err != nil {
+		return err
+	}
+
+	chat.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *ChatRepository) AddMembers(ctx context.Context, chatID primitive.ObjectID, users []string) (*models.Chat, error) {
+	update := bson.M{
+		"$addToSet": bson.M{"users": bson.M{"$each": users}},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var chat models.Chat
+	if err := r.chats.FindOneAndUpdate(ctx, bson.M{"_id": chatID}, update, opts).Decode(&chat); err != nil {
 		return nil, err
 	}
+	return &chat, nil
+}
 
-	return chat, nil
+func (r *ChatRepository) RemoveMember(ctx context.Context, chatID primitive.ObjectID, userID string) error {
+	_, err := r.chats.UpdateByID(ctx, chatID, bson.M{
+		"$pull": bson.M{"users": userID},
+	})
+	return err
 }
 
-func (r *ChatRepository) DeleteChatsByAuth0ID(ctx context.Context, auth0ID string) error {
-	filter := bson.M{
+// Delete the user's direct chats and take them out of every group, returns
+// the files that were attached to the deleted messages
+func (r *ChatRepository) DeleteChatsByAuth0ID(ctx context.Context, auth0ID string) ([]primitive.ObjectID, error) {
+	_, err := r.chats.UpdateMany(ctx, bson.M{
 		"users": auth0ID,
+		"type":  models.ChatTypeGroup,
+	}, bson.M{
+		"$pull": bson.M{"users": auth0ID},
+	})
+	if err != nil {
+		return nil, err
 	}
-	_, err := r.collection.DeleteMany(ctx, filter)
-	return err
+
+	filter := directChatFilter(auth0ID)
+
+	chatIDs, err := r.chats.Distinct(ctx, "_id", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIDs []primitive.ObjectID
+	if len(chatIDs) > 0 {
+		messages := bson.M{"chat_id": bson.M{"$in": chatIDs}}
+		fileIDs, err = attachedFiles(ctx, r.messages, messages)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = r.messages.DeleteMany(ctx, messages)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = r.chats.DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.readStates.DeleteMany(ctx, bson.M{"user_id": auth0ID})
+	return fileIDs, err
+}
+
+// the files attached to the messages matching filter, to release them when
+// the messages are deleted
+func attachedFiles(ctx context.Context, messages *mongo.Collection, filter bson.M) ([]primitive.ObjectID, error) {
+	values, err := messages.Distinct(ctx, "attachments.id", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	fileIDs := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		if id, ok := value.(primitive.ObjectID); ok {
+			fileIDs = append(fileIDs, id)
+		}
+	}
+	return fileIDs, nil
 }
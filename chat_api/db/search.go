@@ -0,0 +1,52 @@
+package db
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Search the messages of the given chats with the text index, newest first.
+// Deleted messages are left out.
+func (r *ChatRepository) SearchMessages(ctx context.Context, chatIDs []primitive.ObjectID, query models.SearchQuery) ([]models.Message, error) {
+	filter := bson.M{
+		"$text":   bson.M{"$search": query.Text},
+		"chat_id": bson.M{"$in": chatIDs},
+		"deleted": bson.M{"$ne": true},
+	}
+	if query.Sender != "" {
+		filter["sent_by_user"] = query.Sender
+	}
+	if !query.Before.IsZero() {
+		filter["_id"] = bson.M{"$lt": query.Before}
+	}
+
+	timestamp := bson.M{}
+	if !query.Since.IsZero() {
+		timestamp["$gte"] = query.Since
+	}
+	if !query.Until.IsZero() {
+		timestamp["$lt"] = query.Until
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(query.Limit))
+
+	cursor, err := r.messages.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []models.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
@@ -0,0 +1,131 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrFileNotFound = errors.New("file not found")
+
+// file_storage_api signs this many links per request
+const linksPerRequest = 100
+
+// Client looks up uploads in file_storage_api. Requests are made on behalf
+// of the caller by forwarding their Authorization header.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// constructor
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// File is the metadata file_storage_api keeps about an upload
+type File struct {
+	ID             primitive.ObjectID `json:"id"`
+	ConversationID primitive.ObjectID `json:"conversation_id"`
+	UploadedBy     string             `json:"uploaded_by"`
+	Name           string             `json:"name"`
+	ContentType    string             `json:"content_type"`
+	Size           int64              `json:"size"`
+	Scan           string             `json:"scan,omitempty"`
+}
+
+// the scan found malware, the file can't be downloaded
+const ScanQuarantined = "quarantined"
+
+// Get the metadata of a file the caller can see, ErrFileNotFound when it
+// doesn't exist or they can't
+func (c *Client) Get(ctx context.Context, authorization string, fileID primitive.ObjectID) (*File, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/files/"+fileID.Hex()+"/info", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden:
+		return nil, ErrFileNotFound
+	default:
+		return nil, fmt.Errorf("file_storage_api returned %s", resp.Status)
+	}
+
+	var file File
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Link is a signed URL to a file, downloadable without a token until it
+// expires
+type Link struct {
+	FileID    primitive.ObjectID `json:"file_id"`
+	URL       string             `json:"url"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
+
+// Links signs download URLs to the files the caller can see. Files they
+// can't, or that aren't ready to be shared, get no link.
+func (c *Client) Links(ctx context.Context, authorization string, fileIDs []primitive.ObjectID) ([]Link, error) {
+	var links []Link
+	for start := 0; start < len(fileIDs); start += linksPerRequest {
+		batch, err := c.signLinks(ctx, authorization, fileIDs[start:min(start+linksPerRequest, len(fileIDs))])
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, batch...)
+	}
+	return links, nil
+}
+
+func (c *Client) signLinks(ctx context.Context, authorization string, fileIDs []primitive.ObjectID) ([]Link, error) {
+	body, err := json.Marshal(map[string]interface{}{"file_ids": fileIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/files/links", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("file_storage_api returned %s", resp.Status)
+	}
+
+	var result struct {
+		Links []Link `json:"links"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Links, nil
+}
@@ -3,6 +3,9 @@ module cloudcord/chat_api
 go 1.24.1
 
 require (
+	github.com/MicahParks/keyfunc v1.9.0
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/gorilla/websocket v1.5.3
 	github.com/joho/godotenv v1.5.1
 	github.com/prometheus/client_golang v1.22.0
 	github.com/streadway/amqp v1.1.0
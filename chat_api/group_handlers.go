@@ -0,0 +1,152 @@
+package main
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/middleware"
+	"cloudcord/chat_api/users"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type createGroupRequest struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+type groupMembersRequest struct {
+	ConversationID string   `json:"conversation_id"`
+	Members        []string `json:"members"`
+}
+
+var errNotFriend = errors.New("only friends can be added to a group")
+
+// every member has to be an existing user the caller is friends with
+func checkFriends(ctx context.Context, directory *users.Client, authorization, auth0ID string, members []string) error {
+	for _, member := range members {
+		if member == auth0ID {
+			continue
+		}
+
+		friends, err := directory.AreFriends(ctx, authorization, auth0ID, member)
+		if err != nil {
+			return err
+		}
+		if !friends {
+			return errNotFriend
+		}
+	}
+	return nil
+}
+
+// create a named group conversation with the caller as owner and friends of
+// theirs as members
+func createGroupHandler(chatLogic *logic.ChatService, directory *users.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req createGroupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if err := checkFriends(ctx, directory, r.Header.Get("Authorization"), auth0ID, req.Members); err != nil {
+			http.Error(w, "Failed to create group: "+err.Error(), statusForError(err))
+			return
+		}
+
+		chat, err := chatLogic.CreateGroup(ctx, auth0ID, req.Name, req.Members)
+		if err != nil {
+			http.Error(w, "Failed to create group: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(chat)
+	}
+}
+
+// POST adds friends of the caller to a group, DELETE removes one (or leaves
+// the group)
+func groupMembersHandler(chatLogic *logic.ChatService, directory *users.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		switch r.Method {
+		case http.MethodPost:
+			var req groupMembersRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			chatID, err := primitive.ObjectIDFromHex(req.ConversationID)
+			if err != nil {
+				http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+				return
+			}
+
+			if err := checkFriends(ctx, directory, r.Header.Get("Authorization"), auth0ID, req.Members); err != nil {
+				http.Error(w, "Failed to add members: "+err.Error(), statusForError(err))
+				return
+			}
+
+			chat, err := chatLogic.AddGroupMembers(ctx, auth0ID, chatID, req.Members)
+			if err != nil {
+				http.Error(w, "Failed to add members: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(chat)
+
+		case http.MethodDelete:
+			chatID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("conversation_id"))
+			if err != nil {
+				http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+				return
+			}
+
+			member := r.URL.Query().Get("user")
+			if member == "" {
+				member = auth0ID
+			}
+
+			if err := chatLogic.RemoveGroupMember(ctx, auth0ID, chatID, member); err != nil {
+				http.Error(w, "Failed to remove member: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"message":"member removed"}`))
+
+		default:
+			http.Error(w, "Only POST and DELETE methods are allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/middleware"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type createGuildRequest struct {
+	Name string `json:"name"`
+}
+
+type channelRequest struct {
+	GuildID   string `json:"guild_id"`
+	ChannelID string `json:"channel_id"`
+	Name      string `json:"name"`
+}
+
+type createInviteRequest struct {
+	GuildID          string `json:"guild_id"`
+	MaxUses          int    `json:"max_uses"`
+	ExpiresInSeconds int    `json:"expires_in_seconds"`
+}
+
+type joinGuildRequest struct {
+	Code string `json:"code"`
+}
+
+type leaveGuildRequest struct {
+	GuildID string `json:"guild_id"`
+}
+
+// GET lists the caller's guilds, or one guild with its channels when
+// guild_id is set. POST creates a guild.
+func guildHandler(guildLogic *logic.GuildService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		switch r.Method {
+		case http.MethodGet:
+			if guildIDStr := r.URL.Query().Get("guild_id"); guildIDStr != "" {
+				guildID, err := primitive.ObjectIDFromHex(guildIDStr)
+				if err != nil {
+					http.Error(w, "Invalid guild_id", http.StatusBadRequest)
+					return
+				}
+
+				guild, err := guildLogic.GetGuild(ctx, auth0ID, guildID)
+				if err != nil {
+					http.Error(w, "Error retrieving guild: "+err.Error(), statusForError(err))
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(guild)
+				return
+			}
+
+			guilds, err := guildLogic.ListGuilds(ctx, auth0ID)
+			if err != nil {
+				http.Error(w, "Error retrieving guilds: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(guilds)
+
+		case http.MethodPost:
+			var req createGuildRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			guild, err := guildLogic.CreateGuild(ctx, auth0ID, req.Name)
+			if err != nil {
+				http.Error(w, "Failed to create guild: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(guild)
+
+		default:
+			http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// POST creates, PATCH renames and DELETE removes a text channel
+func channelHandler(guildLogic *logic.GuildService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		switch r.Method {
+		case http.MethodPost:
+			var req channelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			guildID, err := primitive.ObjectIDFromHex(req.GuildID)
+			if err != nil {
+				http.Error(w, "Invalid guild_id", http.StatusBadRequest)
+				return
+			}
+
+			channel, err := guildLogic.CreateChannel(ctx, auth0ID, guildID, req.Name)
+			if err != nil {
+				http.Error(w, "Failed to create channel: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(channel)
+
+		case http.MethodPatch:
+			var req channelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			channelID, err := primitive.ObjectIDFromHex(req.ChannelID)
+			if err != nil {
+				http.Error(w, "Invalid channel_id", http.StatusBadRequest)
+				return
+			}
+
+			channel, err := guildLogic.RenameChannel(ctx, auth0ID, channelID, req.Name)
+			if err != nil {
+				http.Error(w, "Failed to rename channel: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(channel)
+
+		case http.MethodDelete:
+			channelID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("channel_id"))
+			if err != nil {
+				http.Error(w, "Invalid channel_id", http.StatusBadRequest)
+				return
+			}
+
+			if err := guildLogic.DeleteChannel(ctx, auth0ID, channelID); err != nil {
+				http.Error(w, "Failed to delete channel: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"message":"channel deleted"}`))
+
+		default:
+			http.Error(w, "Only POST, PATCH and DELETE methods are allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func createInviteHandler(guildLogic *logic.GuildService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req createInviteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		guildID, err := primitive.ObjectIDFromHex(req.GuildID)
+		if err != nil {
+			http.Error(w, "Invalid guild_id", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		ttl := time.Duration(req.ExpiresInSeconds) * time.Second
+		invite, err := guildLogic.CreateInvite(ctx, auth0ID, guildID, req.MaxUses, ttl)
+		if err != nil {
+			http.Error(w, "Failed to create invite: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(invite)
+	}
+}
+
+func joinGuildHandler(guildLogic *logic.GuildService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req joinGuildRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		guild, err := guildLogic.JoinGuild(ctx, auth0ID, req.Code)
+		if err != nil {
+			http.Error(w, "Failed to join guild: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(guild)
+	}
+}
+
+func leaveGuildHandler(guildLogic *logic.GuildService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req leaveGuildRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		guildID, err := primitive.ObjectIDFromHex(req.GuildID)
+		if err != nil {
+			http.Error(w, "Invalid guild_id", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if err := guildLogic.LeaveGuild(ctx, auth0ID, guildID); err != nil {
+			http.Error(w, "Failed to leave guild: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message":"left guild"}`))
+	}
+}
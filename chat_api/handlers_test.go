@@ -6,9 +6,12 @@ package main
 import (
 	"bytes"
 	"cloudcord/chat_api/db"
+	"cloudcord/chat_api/files"
 	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/middleware"
 	"cloudcord/chat_api/models"
 	"cloudcord/chat_api/mq"
+	"cloudcord/chat_api/users"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -19,7 +22,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -52,7 +57,7 @@ func TestMain(m *testing.M) {
 
 	mongoDB := mongoClient.Database("Messages")
 	repo := db.NewChatRepository(mongoDB)
-	chatService = logic.NewChatService(repo, &mq.NoopPublisher{})
+	chatService = logic.NewChatService(repo, &mq.NoopPublisher{}, &mq.NoopPublisher{}, &mq.NoopPublisher{}, logic.NewGuildService(db.NewGuildRepository(mongoDB), &mq.NoopPublisher{}))
 
 	code := m.Run()
 
@@ -60,7 +65,20 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
+// attach the claims ValidateJWT would have put on the request
+func withClaims(req *http.Request, sub string) *http.Request {
+	claims := jwt.MapClaims{"sub": sub}
+	return req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, claims))
+}
+
 func TestSendMessageHandler_Integration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := chatService.CreateChat(ctx, "alice_test", "bob_test"); err != nil {
+		t.Fatalf("Failed to start chat: %v", err)
+	}
+
 	payload := map[string]string{
 		"sender":   "alice_test",
 		"receiver": "bob_test",
@@ -70,26 +88,29 @@ func TestSendMessageHandler_Integration(t *testing.T) {
 
 	req := httptest.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
+	req = withClaims(req, "alice_test")
 	rr := httptest.NewRecorder()
 
-	handler := sendMessageHandler(chatService)
+	handler := sendMessageHandler(chatService, nil)
 	handler.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status 200 OK, got %d. Body: %s", rr.Code, rr.Body.String())
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	users := []string{"alice_test", "bob_test"}
 	chat, err := chatService.GetChatByUsers(ctx, users[0], users[1])
 	if err != nil {
 		t.Fatalf("Failed to fetch chat after sending message: %v", err)
 	}
 
+	history, err := chatService.GetChatHistory(ctx, "alice_test", chat, models.MessageQuery{})
+	if err != nil {
+		t.Fatalf("Failed to fetch messages after sending message: %v", err)
+	}
+
 	found := false
-	for _, msg := range chat.Messages {
+	for _, msg := range history.Messages {
 		if msg.Content == "Integration test message" && msg.SentByUser == "alice_test" {
 			found = true
 			break
@@ -105,16 +126,17 @@ func TestGetChatHandler_Integration(t *testing.T) {
 	user2 := "bob_test"
 
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/get?user1=%s&user2=%s", user1, user2), nil)
+	req = withClaims(req, user1)
 	rr := httptest.NewRecorder()
 
-	handler := getChatHandler(chatService)
+	handler := getChatHandler(chatService, files.NewClient(""))
 	handler.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusOK {
 		t.Fatalf("Expected status 200 OK, got %d. Body: %s", rr.Code, rr.Body.String())
 	}
 
-	var chat models.Chat
+	var chat models.ChatHistory
 	if err := json.Unmarshal(rr.Body.Bytes(), &chat); err != nil {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
@@ -123,3 +145,344 @@ func TestGetChatHandler_Integration(t *testing.T) {
 		t.Errorf("Expected user1 to be part of the chat, got: %+v", chat)
 	}
 }
+
+func TestGetChatHandler_Pagination(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/get?user1=alice_test&user2=bob_test&limit=1", nil)
+	req = withClaims(req, "alice_test")
+	rr := httptest.NewRecorder()
+
+	handler := getChatHandler(chatService, files.NewClient(""))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	var history models.ChatHistory
+	if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(history.Messages) > 1 {
+		t.Errorf("Expected at most 1 message, got %d", len(history.Messages))
+	}
+}
+
+func TestGetChatHandler_InvalidCursor(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/get?user1=alice_test&user2=bob_test&before=nope", nil)
+	req = withClaims(req, "alice_test")
+	rr := httptest.NewRecorder()
+
+	handler := getChatHandler(chatService, files.NewClient(""))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 Bad Request, got %d", rr.Code)
+	}
+}
+
+func TestSendMessageHandler_NoChat(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{
+		"sender":   "alice_test",
+		"receiver": "stranger_test",
+		"content":  "Hi stranger",
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = withClaims(req, "alice_test")
+	rr := httptest.NewRecorder()
+
+	handler := sendMessageHandler(chatService, nil)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 Not Found, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := chatService.GetChatByUsers(ctx, "alice_test", "stranger_test"); err != mongo.ErrNoDocuments {
+		t.Errorf("Expected no chat to be stored, got err=%v", err)
+	}
+}
+
+func TestSendMessageHandler_SenderMismatch(t *testing.T) {
+	payload := map[string]string{
+		"sender":   "alice_test",
+		"receiver": "bob_test",
+		"content":  "Forged message",
+	}
+	body, _ := json.Marshal(payload)
+
+	req := httptest.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = withClaims(req, "mallory_test")
+	rr := httptest.NewRecorder()
+
+	handler := sendMessageHandler(chatService, nil)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 Forbidden, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSendMessageHandler_Unauthenticated(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{"receiver": "bob_test", "content": "hi"})
+
+	req := httptest.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+
+	handler := sendMessageHandler(chatService, nil)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 Unauthorized, got %d", rr.Code)
+	}
+}
+
+func TestGetChatHandler_NotParticipant(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/get?user1=alice_test&user2=bob_test", nil)
+	req = withClaims(req, "mallory_test")
+	rr := httptest.NewRecorder()
+
+	handler := getChatHandler(chatService, files.NewClient(""))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 Forbidden, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestGetChatHandler_DoesNotCreateChat(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/get?user1=alice_test&user2=stranger_test", nil)
+	req = withClaims(req, "alice_test")
+	rr := httptest.NewRecorder()
+
+	handler := getChatHandler(chatService, files.NewClient(""))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	var history models.ChatHistory
+	if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(history.Messages) != 0 {
+		t.Errorf("Expected an empty history, got %d messages", len(history.Messages))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := chatService.GetChatByUsers(ctx, "alice_test", "stranger_test"); err != mongo.ErrNoDocuments {
+		t.Errorf("Expected no chat to be stored, got err=%v", err)
+	}
+}
+
+func TestMessageHandler_EditAndDelete_Integration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	group, err := chatService.CreateGroup(ctx, "alice_test", "edit test", []string{"bob_test"})
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+
+	msg, err := chatService.SendMessageToConversation(ctx, "bob_test", group.ID, "typo mesage")
+	if err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	handler := messageRoutes(chatService)
+	path := "/message/" + msg.ID.Hex()
+
+	body, _ := json.Marshal(map[string]string{"content": "fixed message"})
+	req := withClaims(httptest.NewRequest(http.MethodPatch, path, bytes.NewBuffer(body)), "bob_test")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	var edited models.Message
+	if err := json.Unmarshal(rr.Body.Bytes(), &edited); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if edited.Content != "fixed message" || edited.EditedAt == nil {
+		t.Errorf("Expected edited message, got %+v", edited)
+	}
+
+	// alice owns the group, so she can delete bob's message
+	req = withClaims(httptest.NewRequest(http.MethodDelete, path, nil), "alice_test")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	req = withClaims(httptest.NewRequest(http.MethodDelete, path, nil), "alice_test")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusGone {
+		t.Errorf("Expected status 410 Gone for a deleted message, got %d", rr.Code)
+	}
+}
+
+// a user_api that knows alice_test, bob_test and stranger_test, where only
+// alice_test and bob_test are friends
+func fakeUserAPI(t *testing.T) *users.Client {
+	ids := map[string]uint{"alice_test": 1, "bob_test": 2, "stranger_test": 3}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user/auth-user":
+			auth0ID := r.URL.Query().Get("auth0_id")
+			id, ok := ids[auth0ID]
+			if !ok {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"userID": id, "auth0_id": auth0ID})
+		case "/user/is-friend":
+			pair := r.URL.Query().Get("user_id") + "-" + r.URL.Query().Get("other_id")
+			json.NewEncoder(w).Encode(map[string]bool{"are_friends": pair == "1-2" || pair == "2-1"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return users.NewClient(server.URL)
+}
+
+func TestCreateChatHandler_Friends(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{"user": "bob_test"})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", bytes.NewBuffer(body))
+	req = withClaims(req, "alice_test")
+	rr := httptest.NewRecorder()
+
+	createChatHandler(chatService, fakeUserAPI(t)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201 Created, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCreateChatHandler_NotFriends(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{"user": "stranger_test"})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", bytes.NewBuffer(body))
+	req = withClaims(req, "alice_test")
+	rr := httptest.NewRecorder()
+
+	createChatHandler(chatService, fakeUserAPI(t)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 Forbidden, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := chatService.GetChatByUsers(ctx, "alice_test", "stranger_test"); err != mongo.ErrNoDocuments {
+		t.Errorf("Expected no chat to be stored, got err=%v", err)
+	}
+}
+
+func TestCreateChatHandler_UnknownUser(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{"user": "ghost_test"})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", bytes.NewBuffer(body))
+	req = withClaims(req, "alice_test")
+	rr := httptest.NewRecorder()
+
+	createChatHandler(chatService, fakeUserAPI(t)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 Not Found, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func addGroupMembers(t *testing.T, caller string, chatID primitive.ObjectID, members ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"conversation_id": chatID.Hex(), "members": members})
+
+	req := httptest.NewRequest(http.MethodPost, "/group/members", bytes.NewBuffer(body))
+	req = withClaims(req, caller)
+	rr := httptest.NewRecorder()
+
+	groupMembersHandler(chatService, fakeUserAPI(t)).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGroupMembersHandler_AddFriend(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	group, err := chatService.CreateGroup(ctx, "alice_test", "members test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+
+	rr := addGroupMembers(t, "alice_test", group.ID, "bob_test")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestGroupMembersHandler_NotFriends(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	group, err := chatService.CreateGroup(ctx, "alice_test", "members test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+
+	rr := addGroupMembers(t, "alice_test", group.ID, "stranger_test")
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 Forbidden, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := chatService.GetConversation(ctx, "stranger_test", group.ID); err == nil {
+		t.Errorf("Expected stranger_test not to be added")
+	}
+}
+
+func TestGroupMembersHandler_UnknownUser(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	group, err := chatService.CreateGroup(ctx, "alice_test", "members test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+
+	rr := addGroupMembers(t, "alice_test", group.ID, "ghost_test")
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 Not Found, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCreateGroupHandler_NotFriends(t *testing.T) {
+	body, _ := json.Marshal(map[string]interface{}{"name": "strangers", "members": []string{"bob_test", "stranger_test"}})
+
+	req := httptest.NewRequest(http.MethodPost, "/group", bytes.NewBuffer(body))
+	req = withClaims(req, "alice_test")
+	rr := httptest.NewRecorder()
+
+	createGroupHandler(chatService, fakeUserAPI(t)).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403 Forbidden, got %d. Body: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,113 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"errors"
+	"log"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MaxAttachments = 10
+	// in characters
+	MaxMessageLength = 4000
+)
+
+var (
+	ErrTooManyAttachments  = errors.New("too many attachments")
+	ErrMessageTooLong      = errors.New("message content is too long")
+	ErrDuplicateAttachment = errors.New("file is attached more than once")
+	ErrAttachmentInUse     = errors.New("file is already attached to a message")
+)
+
+// a message needs content unless it has attachments
+func checkContent(content string, attachments []models.Attachment) error {
+	if content == "" && len(attachments) == 0 {
+		return ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		return ErrMessageTooLong
+	}
+	if len(attachments) > MaxAttachments {
+		return ErrTooManyAttachments
+	}
+	return nil
+}
+
+// a file goes in one message only, deleting that message releases it
+func (s *ChatService) checkAttachments(ctx context.Context, attachments []models.Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	seen := make(map[primitive.ObjectID]bool, len(attachments))
+	fileIDs := make([]primitive.ObjectID, 0, len(attachments))
+	for _, attachment := range attachments {
+		if seen[attachment.ID] {
+			return ErrDuplicateAttachment
+		}
+		seen[attachment.ID] = true
+		fileIDs = append(fileIDs, attachment.ID)
+	}
+
+	inUse, err := s.repo.AttachmentsInUse(ctx, fileIDs)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrAttachmentInUse
+	}
+	return nil
+}
+
+// what a user may do in a conversation, file_storage_api asks this before
+// storing or serving the files of a conversation
+func (s *ChatService) ConversationAccess(ctx context.Context, userID string, chatID primitive.ObjectID) (*models.ConversationAccess, error) {
+	chat, err := s.repo.GetChatByID(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	perms, err := s.permissions(ctx, chat, userID)
+	if err != nil {
+		return nil, err
+	}
+	if perms == 0 {
+		return nil, ErrNotMember
+	}
+
+	return &models.ConversationAccess{
+		ConversationID: chat.ID,
+		UserID:         userID,
+		Permissions:    perms,
+		Names:          perms.Names(),
+	}, nil
+}
+
+// file storage quarantined a file: it shows as blocked in the messages it's
+// attached to, and their conversations are told
+func (s *ChatService) BlockAttachment(ctx context.Context, status models.AttachmentStatus) error {
+	if status.Status != models.AttachmentBlocked {
+		return nil
+	}
+
+	messages, err := s.repo.BlockAttachment(ctx, status.FileID)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		chat, err := s.repo.GetChatByID(ctx, messages[i].ChatID)
+		if err != nil {
+			log.Printf("Failed to get chat %s: %v", messages[i].ChatID.Hex(), err)
+			continue
+		}
+		s.broadcastChange(ctx, chat, models.EventMessageUpdated, &messages[i])
+	}
+
+	log.Printf("Blocked attachment %s in %d messages", status.FileID.Hex(), len(messages))
+	return nil
+}
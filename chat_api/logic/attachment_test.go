@@ -0,0 +1,182 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSendMessageToConversation_AttachmentsWithoutContent(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	attachment := models.Attachment{ID: primitive.NewObjectID(), Name: "cat.png", ContentType: "image/png", Size: 1024}
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("AttachmentsInUse", ctx, []primitive.ObjectID{attachment.ID}).Return(false, nil)
+	mockRepo.On("AddMessage", ctx, mock.MatchedBy(func(m *models.Message) bool {
+		return m.Content == "" && len(m.Attachments) == 1 && m.Attachments[0] == attachment
+	})).Return(nil)
+	mockPub.On("Publish", mock.Anything).Return(nil)
+	mockEvents.On("Publish", mock.Anything).Return(nil)
+
+	message, err := service.SendMessageToConversation(ctx, "alice", group.ID, "", attachment)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Attachment{attachment}, message.Attachments)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSendMessageToConversation_TooManyAttachments(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	attachments := make([]models.Attachment, logic.MaxAttachments+1)
+	for i := range attachments {
+		attachments[i] = models.Attachment{ID: primitive.NewObjectID()}
+	}
+
+	_, err := service.SendMessageToConversation(ctx, "alice", primitive.NewObjectID(), "look", attachments...)
+
+	assert.ErrorIs(t, err, logic.ErrTooManyAttachments)
+	mockRepo.AssertNotCalled(t, "AddMessage", mock.Anything, mock.Anything)
+}
+
+func TestSendMessageToConversation_DuplicateAttachment(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	attachment := models.Attachment{ID: primitive.NewObjectID(), Name: "cat.png"}
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	_, err := service.SendMessageToConversation(ctx, "alice", group.ID, "", attachment, attachment)
+
+	assert.ErrorIs(t, err, logic.ErrDuplicateAttachment)
+	mockRepo.AssertNotCalled(t, "AddMessage", mock.Anything, mock.Anything)
+}
+
+func TestSendMessageToConversation_AttachmentInUse(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	attachment := models.Attachment{ID: primitive.NewObjectID(), Name: "cat.png"}
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("AttachmentsInUse", ctx, []primitive.ObjectID{attachment.ID}).Return(true, nil)
+
+	_, err := service.SendMessageToConversation(ctx, "alice", group.ID, "again", attachment)
+
+	assert.ErrorIs(t, err, logic.ErrAttachmentInUse)
+	mockRepo.AssertNotCalled(t, "AddMessage", mock.Anything, mock.Anything)
+}
+
+func TestConversationAccess_Member(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	access, err := service.ConversationAccess(ctx, "bob", group.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.DefaultPermissions, access.Permissions)
+	assert.Contains(t, access.Names, "view_channel")
+	assert.Contains(t, access.Names, "send_messages")
+}
+
+func TestConversationAccess_NotMember(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	_, err := service.ConversationAccess(ctx, "mallory", group.ID)
+
+	assert.ErrorIs(t, err, logic.ErrNotMember)
+}
+
+func TestDeleteMessage_ReleasesAttachments(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+	mockReleases := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, mockReleases, new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "bob")
+	fileID := primitive.NewObjectID()
+	message.Attachments = []models.Attachment{{ID: fileID, Name: "cat.png"}}
+
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("DeleteMessage", ctx, message.ID, mock.Anything).Return(&models.Message{ID: message.ID, ChatID: group.ID, Deleted: true}, nil)
+	mockEvents.On("Publish", mock.Anything).Return(nil)
+	mockReleases.On("Publish", models.AttachmentsReleased{FileIDs: []primitive.ObjectID{fileID}}).Return(nil)
+
+	_, err := service.DeleteMessage(ctx, "bob", message.ID)
+
+	assert.NoError(t, err)
+	mockReleases.AssertExpectations(t)
+}
+
+func TestBlockAttachment_UpdatesMessages(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "bob")
+	fileID := primitive.NewObjectID()
+	message.Attachments = []models.Attachment{{ID: fileID, Name: "invoice.exe", Blocked: true}}
+
+	mockRepo.On("BlockAttachment", ctx, fileID).Return([]models.Message{*message}, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockEvents.On("Publish", mock.MatchedBy(func(event models.ChatEvent) bool {
+		return event.Type == models.EventMessageUpdated
+	})).Return(nil)
+
+	err := service.BlockAttachment(ctx, models.AttachmentStatus{FileID: fileID, ConversationID: group.ID, Status: models.AttachmentBlocked})
+
+	assert.NoError(t, err)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestBlockAttachment_IgnoresOtherStatuses(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	err := service.BlockAttachment(ctx, models.AttachmentStatus{FileID: primitive.NewObjectID(), Status: "clean"})
+
+	assert.NoError(t, err)
+	mockRepo.AssertNotCalled(t, "BlockAttachment", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,95 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// previews show the start of the latest message
+const maxPreviewLength = 100
+
+// list a page of the direct and group chats of a user, most recently active
+// first, with their latest message and how many messages they haven't read
+func (s *ChatService) ListConversations(ctx context.Context, userID string, query models.ConversationQuery) (*models.ConversationPage, error) {
+	var before *models.Chat
+	if !query.Before.IsZero() {
+		chat, err := s.repo.GetChatByID(ctx, query.Before)
+		if err != nil {
+			return nil, err
+		}
+		if !chat.HasMember(userID) {
+			return nil, ErrNotMember
+		}
+		before = chat
+	}
+
+	limit := clampLimit(query.Limit)
+	chats, err := s.repo.GetChatsByUser(ctx, userID, before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.ConversationPage{HasMore: len(chats) > limit}
+	if page.HasMore {
+		chats = chats[:limit]
+	}
+
+	chatIDs := make([]primitive.ObjectID, len(chats))
+	for i, chat := range chats {
+		chatIDs[i] = chat.ID
+	}
+
+	states, err := s.repo.GetUserReadStates(ctx, userID, chatIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	lastRead := make(map[primitive.ObjectID]primitive.ObjectID, len(states))
+	for _, state := range states {
+		lastRead[state.ChatID] = state.LastReadID
+	}
+
+	// missing previews or counts are no reason to fail the whole list
+	unread, err := s.repo.CountUnread(ctx, userID, chatIDs, lastRead)
+	if err != nil {
+		log.Printf("Failed to count unread messages for %s: %v", userID, err)
+	}
+
+	lastMessages := make(map[primitive.ObjectID]*models.MessagePreview, len(chats))
+	messages, err := s.repo.GetLastMessages(ctx, chatIDs)
+	if err != nil {
+		log.Printf("Failed to get last messages for %s: %v", userID, err)
+	}
+	for i := range messages {
+		lastMessages[messages[i].ChatID] = previewOf(&messages[i])
+	}
+
+	page.Conversations = make([]models.ConversationSummary, 0, len(chats))
+	for _, chat := range chats {
+		summary := models.ConversationSummary{
+			Chat:        chat,
+			LastMessage: lastMessages[chat.ID],
+		}
+
+		if readID, ok := lastRead[chat.ID]; ok {
+			summary.LastReadID = &readID
+		}
+		summary.UnreadCount = unread[chat.ID]
+
+		page.Conversations = append(page.Conversations, summary)
+	}
+	return page, nil
+}
+
+func previewOf(message *models.Message) *models.MessagePreview {
+	return &models.MessagePreview{
+		ID:         message.ID,
+		SentByUser: message.SentByUser,
+		Content:    truncate(message.Content, maxPreviewLength),
+		Timestamp:  message.Timestamp,
+		Deleted:    message.Deleted,
+	}
+}
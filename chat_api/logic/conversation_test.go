@@ -0,0 +1,92 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestListConversations_CountsUnread(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	read := newGroup("alice", "bob")
+	unread := newGroup("carol", "bob")
+	lastRead := primitive.NewObjectID()
+	last := newMessage(unread, "carol")
+	last.Content = strings.Repeat("a", 150)
+
+	mockRepo.On("GetChatsByUser", ctx, "bob", (*models.Chat)(nil), logic.DefaultPageSize+1).Return([]models.Chat{*read, *unread}, nil)
+	mockRepo.On("GetUserReadStates", ctx, "bob", []primitive.ObjectID{read.ID, unread.ID}).Return([]models.ReadState{
+		{ChatID: read.ID, UserID: "bob", LastReadID: lastRead},
+	}, nil)
+	mockRepo.On("GetLastMessages", ctx, []primitive.ObjectID{read.ID, unread.ID}).Return([]models.Message{*last}, nil)
+	mockRepo.On("CountUnread", ctx, "bob", []primitive.ObjectID{read.ID, unread.ID}, map[primitive.ObjectID]primitive.ObjectID{read.ID: lastRead}).
+		Return(map[primitive.ObjectID]int64{unread.ID: 4}, nil).Once()
+
+	page, err := service.ListConversations(ctx, "bob", models.ConversationQuery{})
+
+	assert.NoError(t, err)
+	assert.False(t, page.HasMore)
+	assert.Len(t, page.Conversations, 2)
+
+	assert.Equal(t, lastRead, *page.Conversations[0].LastReadID)
+	assert.Equal(t, int64(0), page.Conversations[0].UnreadCount)
+	assert.Nil(t, page.Conversations[0].LastMessage)
+
+	assert.Nil(t, page.Conversations[1].LastReadID)
+	assert.Equal(t, int64(4), page.Conversations[1].UnreadCount)
+	assert.Equal(t, last.ID, page.Conversations[1].LastMessage.ID)
+	assert.Equal(t, strings.Repeat("a", 100)+"…", page.Conversations[1].LastMessage.Content)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestListConversations_Paginates(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	cursor := newGroup("alice", "bob")
+	first := newGroup("carol", "bob")
+	second := newGroup("dave", "bob")
+	third := newGroup("erin", "bob")
+	chatIDs := []primitive.ObjectID{first.ID, second.ID}
+
+	mockRepo.On("GetChatByID", ctx, cursor.ID).Return(cursor, nil)
+	mockRepo.On("GetChatsByUser", ctx, "bob", cursor, 3).Return([]models.Chat{*first, *second, *third}, nil)
+	mockRepo.On("GetUserReadStates", ctx, "bob", chatIDs).Return([]models.ReadState{}, nil)
+	mockRepo.On("GetLastMessages", ctx, chatIDs).Return([]models.Message{}, nil)
+	mockRepo.On("CountUnread", ctx, "bob", chatIDs, mock.Anything).Return(map[primitive.ObjectID]int64{}, nil).Once()
+
+	page, err := service.ListConversations(ctx, "bob", models.ConversationQuery{Before: cursor.ID, Limit: 2})
+
+	assert.NoError(t, err)
+	assert.True(t, page.HasMore)
+	assert.Len(t, page.Conversations, 2)
+	assert.Equal(t, second.ID, page.Conversations[1].ID)
+}
+
+func TestListConversations_CursorOfAnotherUser(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	cursor := newGroup("alice", "carol")
+	mockRepo.On("GetChatByID", ctx, cursor.ID).Return(cursor, nil)
+
+	page, err := service.ListConversations(ctx, "bob", models.ConversationQuery{Before: cursor.ID})
+
+	assert.ErrorIs(t, err, logic.ErrNotMember)
+	assert.Nil(t, page)
+	mockRepo.AssertNotCalled(t, "GetChatsByUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
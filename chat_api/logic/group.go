@@ -0,0 +1,89 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// create a named group conversation owned by its creator
+func (s *ChatService) CreateGroup(ctx context.Context, owner, name string, members []string) (*models.Chat, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	chat := &models.Chat{
+		Type:    models.ChatTypeGroup,
+		Name:    name,
+		OwnerID: owner,
+		Users:   uniqueMembers(append([]string{owner}, members...)),
+	}
+
+	if err := s.repo.CreateConversation(ctx, chat); err != nil {
+		log.Printf("Failed to create group %q: %v", name, err)
+		return nil, err
+	}
+
+	log.Printf("Group %s created by %s with %d members", chat.ID.Hex(), owner, len(chat.Users))
+	return chat, nil
+}
+
+// any member of a group can add others to it
+func (s *ChatService) AddGroupMembers(ctx context.Context, actor string, chatID primitive.ObjectID, members []string) (*models.Chat, error) {
+	members = uniqueMembers(members)
+	if len(members) == 0 {
+		return nil, ErrNoNewMembers
+	}
+
+	chat, err := s.GetConversation(ctx, actor, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !chat.IsGroup() {
+		return nil, ErrNotGroup
+	}
+
+	return s.repo.AddMembers(ctx, chatID, members)
+}
+
+// members can leave a group, only the owner can remove someone else
+func (s *ChatService) RemoveGroupMember(ctx context.Context, actor string, chatID primitive.ObjectID, member string) error {
+	chat, err := s.GetConversation(ctx, actor, chatID)
+	if err != nil {
+		return err
+	}
+
+	if !chat.IsGroup() {
+		return ErrNotGroup
+	}
+
+	if member != actor && actor != chat.OwnerID {
+		return ErrNotOwner
+	}
+
+	if member == chat.OwnerID {
+		return ErrOwnerLeaving
+	}
+
+	return s.repo.RemoveMember(ctx, chatID, member)
+}
+
+func uniqueMembers(users []string) []string {
+	seen := make(map[string]bool, len(users))
+	unique := make([]string, 0, len(users))
+
+	for _, u := range users {
+		u = strings.TrimSpace(u)
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		unique = append(unique, u)
+	}
+	return unique
+}
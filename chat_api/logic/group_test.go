@@ -0,0 +1,191 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newGroup(owner string, members ...string) *models.Chat {
+	return &models.Chat{
+		ID:      primitive.NewObjectID(),
+		Type:    models.ChatTypeGroup,
+		Name:    "team",
+		OwnerID: owner,
+		Users:   append([]string{owner}, members...),
+	}
+}
+
+func TestCreateGroup(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	groupMatcher := mock.MatchedBy(func(c *models.Chat) bool {
+		return c.Type == models.ChatTypeGroup &&
+			c.Name == "team" &&
+			c.OwnerID == "alice" &&
+			assert.ObjectsAreEqual([]string{"alice", "bob", "carol"}, c.Users)
+	})
+
+	mockRepo.On("CreateConversation", ctx, groupMatcher).Return(nil)
+
+	chat, err := service.CreateGroup(ctx, "alice", " team ", []string{"bob", "carol", "bob", "alice"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "team", chat.Name)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCreateGroup_MissingName(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	chat, err := service.CreateGroup(ctx, "alice", "  ", []string{"bob"})
+
+	assert.ErrorIs(t, err, logic.ErrMissingName)
+	assert.Nil(t, chat)
+	mockRepo.AssertNotCalled(t, "CreateConversation", mock.Anything, mock.Anything)
+}
+
+func TestSendMessageToConversation_NotifiesEveryoneButSender(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob", "carol")
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("AddMessage", ctx, mock.MatchedBy(func(m *models.Message) bool {
+		return m.ChatID == group.ID && m.SentByUser == "alice" && m.Content == "hi all"
+	})).Return(nil)
+	mockPub.On("Publish", models.MessageNotification{ReceiverID: "bob", Message: "You have a new message by alice in team"}).Return(nil)
+	mockPub.On("Publish", models.MessageNotification{ReceiverID: "carol", Message: "You have a new message by alice in team"}).Return(nil)
+	mockEvents.On("Publish", mock.MatchedBy(func(e models.ChatEvent) bool {
+		return assert.ObjectsAreEqual(group.Users, e.Recipients)
+	})).Return(nil)
+
+	message, err := service.SendMessageToConversation(ctx, "alice", group.ID, "hi all")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "hi all", message.Content)
+	mockRepo.AssertExpectations(t)
+	mockPub.AssertExpectations(t)
+	mockPub.AssertNumberOfCalls(t, "Publish", 2)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestSendMessageToConversation_NotMember(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	message, err := service.SendMessageToConversation(ctx, "mallory", group.ID, "hi")
+
+	assert.ErrorIs(t, err, logic.ErrNotMember)
+	assert.Nil(t, message)
+	mockRepo.AssertNotCalled(t, "AddMessage", mock.Anything, mock.Anything)
+	mockPub.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+func TestAddGroupMembers(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	updated := newGroup("alice", "bob", "carol")
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("AddMembers", ctx, group.ID, []string{"carol"}).Return(updated, nil)
+
+	chat, err := service.AddGroupMembers(ctx, "bob", group.ID, []string{"carol", ""})
+
+	assert.NoError(t, err)
+	assert.Equal(t, updated, chat)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAddGroupMembers_DirectChat(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	direct := &models.Chat{ID: primitive.NewObjectID(), Type: models.ChatTypeDirect, Users: []string{"alice", "bob"}}
+
+	mockRepo.On("GetChatByID", ctx, direct.ID).Return(direct, nil)
+
+	_, err := service.AddGroupMembers(ctx, "alice", direct.ID, []string{"carol"})
+
+	assert.ErrorIs(t, err, logic.ErrNotGroup)
+	mockRepo.AssertNotCalled(t, "AddMembers", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRemoveGroupMember_Leave(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("RemoveMember", ctx, group.ID, "bob").Return(nil)
+
+	err := service.RemoveGroupMember(ctx, "bob", group.ID, "bob")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRemoveGroupMember_OnlyOwnerRemovesOthers(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob", "carol")
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	err := service.RemoveGroupMember(ctx, "bob", group.ID, "carol")
+
+	assert.ErrorIs(t, err, logic.ErrNotOwner)
+	mockRepo.AssertNotCalled(t, "RemoveMember", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRemoveGroupMember_OwnerCannotLeave(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	err := service.RemoveGroupMember(ctx, "alice", group.ID, "alice")
+
+	assert.ErrorIs(t, err, logic.ErrOwnerLeaving)
+}
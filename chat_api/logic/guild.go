@@ -0,0 +1,406 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	DefaultChannelName = "general"
+	maxNameLength      = 100
+	inviteCodeLength   = 8
+	inviteAlphabet     = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	ErrInvalidInvite  = errors.New("invite is invalid or expired")
+	ErrInviteLimits   = errors.New("max_uses and expiry must not be negative")
+	ErrNameTooLong    = errors.New("name is too long")
+	ErrLastChannel    = errors.New("a guild needs at least one channel")
+	ErrNotGuildMember = errors.New("not a member of this guild")
+	ErrBanned         = errors.New("banned from this guild")
+)
+
+type GuildRepository interface {
+	CreateGuild(ctx context.Context, guild *models.Guild, everyone *models.Role, channel *models.Chat) error
+	GetGuild(ctx context.Context, guildID primitive.ObjectID) (*models.Guild, error)
+	GetGuildsByMember(ctx context.Context, userID string) ([]models.Guild, error)
+	AddMember(ctx context.Context, guildID primitive.ObjectID, userID string) error
+	RemoveMember(ctx context.Context, guildID primitive.ObjectID, userID string) error
+	IsMember(ctx context.Context, guildID primitive.ObjectID, userID string) (bool, error)
+	GetMember(ctx context.Context, guildID primitive.ObjectID, userID string) (*models.GuildMember, error)
+	GetMembers(ctx context.Context, guildID primitive.ObjectID) ([]models.GuildMember, error)
+	CreateChannel(ctx context.Context, channel *models.Chat) error
+	GetChannel(ctx context.Context, channelID primitive.ObjectID) (*models.Chat, error)
+	GetChannels(ctx context.Context, guildID primitive.ObjectID) ([]models.Chat, error)
+	RenameChannel(ctx context.Context, channelID primitive.ObjectID, name string) error
+	DeleteChannel(ctx context.Context, channelID primitive.ObjectID) ([]primitive.ObjectID, error)
+	SetOverwrite(ctx context.Context, channelID primitive.ObjectID, overwrite models.PermissionOverwrite) error
+	RemoveOverwrite(ctx context.Context, channelID primitive.ObjectID, overwriteType, id string) error
+	CreateRole(ctx context.Context, role *models.Role) error
+	GetRole(ctx context.Context, roleID primitive.ObjectID) (*models.Role, error)
+	GetRoles(ctx context.Context, guildID primitive.ObjectID) ([]models.Role, error)
+	UpdateRole(ctx context.Context, roleID primitive.ObjectID, name string, permissions models.Permission) error
+	DeleteRole(ctx context.Context, role *models.Role) error
+	AddMemberRole(ctx context.Context, guildID primitive.ObjectID, userID string, roleID primitive.ObjectID) error
+	RemoveMemberRole(ctx context.Context, guildID primitive.ObjectID, userID string, roleID primitive.ObjectID) error
+	BanMember(ctx context.Context, ban *models.Ban) error
+	UnbanMember(ctx context.Context, guildID primitive.ObjectID, userID string) error
+	IsBanned(ctx context.Context, guildID primitive.ObjectID, userID string) (bool, error)
+	CreateInvite(ctx context.Context, invite *models.Invite) error
+	GetInvite(ctx context.Context, code string) (*models.Invite, error)
+	UseInvite(ctx context.Context, code string, now time.Time) (*models.Invite, error)
+}
+
+// GuildService manages guilds and decides who may use their channels based
+// on roles and channel overwrites. Releases tells file storage about the
+// attachments of deleted channels.
+type GuildService struct {
+	repo     GuildRepository
+	releases Publisher
+}
+
+func NewGuildService(repo GuildRepository, releases Publisher) *GuildService {
+	return &GuildService{repo: repo, releases: releases}
+}
+
+// create a guild owned by its creator, with a default text channel
+func (s *GuildService) CreateGuild(ctx context.Context, owner, name string) (*models.GuildDetails, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// the @everyone role shares the guild's ID, so it's chosen up front
+	guild := &models.Guild{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		OwnerID:   owner,
+		CreatedAt: time.Now(),
+	}
+
+	everyone := &models.Role{
+		ID:          guild.ID,
+		GuildID:     guild.ID,
+		Name:        models.EveryoneRoleName,
+		Permissions: models.DefaultPermissions,
+		CreatedAt:   guild.CreatedAt,
+	}
+
+	channel := &models.Chat{
+		Type:    models.ChatTypeChannel,
+		GuildID: guild.ID,
+		Name:    DefaultChannelName,
+	}
+
+	if err := s.repo.CreateGuild(ctx, guild, everyone, channel); err != nil {
+		log.Printf("Failed to create guild %q: %v", name, err)
+		return nil, err
+	}
+
+	log.Printf("Guild %s created by %s", guild.ID.Hex(), owner)
+	return &models.GuildDetails{Guild: *guild, Channels: []models.Chat{*channel}}, nil
+}
+
+// get a guild and the channels the user can see, members only
+func (s *GuildService) GetGuild(ctx context.Context, userID string, guildID primitive.ObjectID) (*models.GuildDetails, error) {
+	access, err := s.loadAccess(ctx, guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := s.repo.GetChannels(ctx, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]models.Chat, 0, len(channels))
+	for i := range channels {
+		if access.permissions(&channels[i]).Has(models.PermViewChannel) {
+			visible = append(visible, channels[i])
+		}
+	}
+
+	return &models.GuildDetails{Guild: *access.guild, Channels: visible}, nil
+}
+
+func (s *GuildService) ListGuilds(ctx context.Context, userID string) ([]models.Guild, error) {
+	return s.repo.GetGuildsByMember(ctx, userID)
+}
+
+func (s *GuildService) CreateChannel(ctx context.Context, actor string, guildID primitive.ObjectID, name string) (*models.Chat, error) {
+	name, err := cleanChannelName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.requirePermission(ctx, actor, guildID, nil, models.PermManageChannels); err != nil {
+		return nil, err
+	}
+
+	channel := &models.Chat{
+		Type:    models.ChatTypeChannel,
+		GuildID: guildID,
+		Name:    name,
+	}
+	if err := s.repo.CreateChannel(ctx, channel); err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+func (s *GuildService) RenameChannel(ctx context.Context, actor string, channelID primitive.ObjectID, name string) (*models.Chat, error) {
+	name, err := cleanChannelName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := s.repo.GetChannel(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.requirePermission(ctx, actor, channel.GuildID, channel, models.PermManageChannels); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.RenameChannel(ctx, channelID, name); err != nil {
+		return nil, err
+	}
+
+	channel.Name = name
+	return channel, nil
+}
+
+func (s *GuildService) DeleteChannel(ctx context.Context, actor string, channelID primitive.ObjectID) error {
+	channel, err := s.repo.GetChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.requirePermission(ctx, actor, channel.GuildID, channel, models.PermManageChannels); err != nil {
+		return err
+	}
+
+	channels, err := s.repo.GetChannels(ctx, channel.GuildID)
+	if err != nil {
+		return err
+	}
+	if len(channels) <= 1 {
+		return ErrLastChannel
+	}
+
+	fileIDs, err := s.repo.DeleteChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	releaseFiles(s.releases, fileIDs, "channel "+channelID.Hex())
+	return nil
+}
+
+// any member can invite others. A zero maxUses or ttl means no limit.
+func (s *GuildService) CreateInvite(ctx context.Context, actor string, guildID primitive.ObjectID, maxUses int, ttl time.Duration) (*models.Invite, error) {
+	if maxUses < 0 || ttl < 0 {
+		return nil, ErrInviteLimits
+	}
+
+	if _, err := s.memberGuild(ctx, actor, guildID); err != nil {
+		return nil, err
+	}
+
+	code, err := newInviteCode()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invite := &models.Invite{
+		Code:      code,
+		GuildID:   guildID,
+		CreatedBy: actor,
+		CreatedAt: now,
+		MaxUses:   maxUses,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	if err := s.repo.CreateInvite(ctx, invite); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// join the guild of an invite. Members following an invite again don't use it up.
+func (s *GuildService) JoinGuild(ctx context.Context, userID, code string) (*models.Guild, error) {
+	invite, err := s.repo.GetInvite(ctx, code)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidInvite
+		}
+		return nil, err
+	}
+
+	member, err := s.repo.IsMember(ctx, invite.GuildID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !member {
+		banned, err := s.repo.IsBanned(ctx, invite.GuildID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if banned {
+			return nil, ErrBanned
+		}
+
+		if _, err := s.repo.UseInvite(ctx, code, time.Now()); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, ErrInvalidInvite
+			}
+			return nil, err
+		}
+
+		if err := s.repo.AddMember(ctx, invite.GuildID, userID); err != nil {
+			return nil, err
+		}
+		log.Printf("User %s joined guild %s with invite %s", userID, invite.GuildID.Hex(), code)
+	}
+
+	return s.repo.GetGuild(ctx, invite.GuildID)
+}
+
+func (s *GuildService) LeaveGuild(ctx context.Context, userID string, guildID primitive.ObjectID) error {
+	guild, err := s.memberGuild(ctx, userID, guildID)
+	if err != nil {
+		return err
+	}
+
+	if guild.OwnerID == userID {
+		return ErrOwnerLeaving
+	}
+
+	return s.repo.RemoveMember(ctx, guildID, userID)
+}
+
+// ChannelPermissions lets ChatService check what a user may do in a
+// channel. Users outside the guild have no permissions.
+func (s *GuildService) ChannelPermissions(ctx context.Context, channel *models.Chat, userID string) (models.Permission, error) {
+	access, err := s.loadAccess(ctx, channel.GuildID, userID)
+	if errors.Is(err, ErrNotGuildMember) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return access.permissions(channel), nil
+}
+
+// ChannelMembers lists who receives the messages of a channel, the members
+// that can view it
+func (s *GuildService) ChannelMembers(ctx context.Context, channel *models.Chat) ([]string, error) {
+	guild, err := s.repo.GetGuild(ctx, channel.GuildID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := s.repo.GetRoles(ctx, channel.GuildID)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.repo.GetMembers(ctx, channel.GuildID)
+	if err != nil {
+		return nil, err
+	}
+
+	viewers := make([]string, 0, len(members))
+	for i := range members {
+		perms := ComputePermissions(guild, &members[i], roles, channel)
+		if perms.Has(models.PermViewChannel) {
+			viewers = append(viewers, members[i].UserID)
+		}
+	}
+	return viewers, nil
+}
+
+// VisibleChannels lists the channels a user can view in all their guilds
+func (s *GuildService) VisibleChannels(ctx context.Context, userID string) ([]primitive.ObjectID, error) {
+	guilds, err := s.repo.GetGuildsByMember(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var channelIDs []primitive.ObjectID
+	for _, guild := range guilds {
+		details, err := s.GetGuild(ctx, userID, guild.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range details.Channels {
+			channelIDs = append(channelIDs, channel.ID)
+		}
+	}
+	return channelIDs, nil
+}
+
+func (s *GuildService) memberGuild(ctx context.Context, userID string, guildID primitive.ObjectID) (*models.Guild, error) {
+	guild, err := s.repo.GetGuild(ctx, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.repo.IsMember(ctx, guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, ErrNotGuildMember
+	}
+	return guild, nil
+}
+
+func cleanName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", ErrMissingName
+	}
+	if len(name) > maxNameLength {
+		return "", ErrNameTooLong
+	}
+	return name, nil
+}
+
+// channel names are lowercase with dashes instead of spaces
+func cleanChannelName(name string) (string, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(strings.ToLower(name)), "-"), nil
+}
+
+func newInviteCode() (string, error) {
+	code := make([]byte, inviteCodeLength)
+	max := big.NewInt(int64(len(inviteAlphabet)))
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = inviteAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,522 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MockGuildRepo struct {
+	mock.Mock
+}
+
+func (m *MockGuildRepo) CreateGuild(ctx context.Context, guild *models.Guild, everyone *models.Role, channel *models.Chat) error {
+	args := m.Called(ctx, guild, everyone, channel)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) GetGuild(ctx context.Context, guildID primitive.ObjectID) (*models.Guild, error) {
+	args := m.Called(ctx, guildID)
+	guild, _ := args.Get(0).(*models.Guild)
+	return guild, args.Error(1)
+}
+
+func (m *MockGuildRepo) GetGuildsByMember(ctx context.Context, userID string) ([]models.Guild, error) {
+	args := m.Called(ctx, userID)
+	guilds, _ := args.Get(0).([]models.Guild)
+	return guilds, args.Error(1)
+}
+
+func (m *MockGuildRepo) AddMember(ctx context.Context, guildID primitive.ObjectID, userID string) error {
+	args := m.Called(ctx, guildID, userID)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) RemoveMember(ctx context.Context, guildID primitive.ObjectID, userID string) error {
+	args := m.Called(ctx, guildID, userID)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) IsMember(ctx context.Context, guildID primitive.ObjectID, userID string) (bool, error) {
+	args := m.Called(ctx, guildID, userID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockGuildRepo) GetMember(ctx context.Context, guildID primitive.ObjectID, userID string) (*models.GuildMember, error) {
+	args := m.Called(ctx, guildID, userID)
+	member, _ := args.Get(0).(*models.GuildMember)
+	return member, args.Error(1)
+}
+
+func (m *MockGuildRepo) GetMembers(ctx context.Context, guildID primitive.ObjectID) ([]models.GuildMember, error) {
+	args := m.Called(ctx, guildID)
+	members, _ := args.Get(0).([]models.GuildMember)
+	return members, args.Error(1)
+}
+
+func (m *MockGuildRepo) CreateChannel(ctx context.Context, channel *models.Chat) error {
+	args := m.Called(ctx, channel)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) GetChannel(ctx context.Context, channelID primitive.ObjectID) (*models.Chat, error) {
+	args := m.Called(ctx, channelID)
+	channel, _ := args.Get(0).(*models.Chat)
+	return channel, args.Error(1)
+}
+
+func (m *MockGuildRepo) GetChannels(ctx context.Context, guildID primitive.ObjectID) ([]models.Chat, error) {
+	args := m.Called(ctx, guildID)
+	channels, _ := args.Get(0).([]models.Chat)
+	return channels, args.Error(1)
+}
+
+func (m *MockGuildRepo) RenameChannel(ctx context.Context, channelID primitive.ObjectID, name string) error {
+	args := m.Called(ctx, channelID, name)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) DeleteChannel(ctx context.Context, channelID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	args := m.Called(ctx, channelID)
+	fileIDs, _ := args.Get(0).([]primitive.ObjectID)
+	return fileIDs, args.Error(1)
+}
+
+func (m *MockGuildRepo) SetOverwrite(ctx context.Context, channelID primitive.ObjectID, overwrite models.PermissionOverwrite) error {
+	args := m.Called(ctx, channelID, overwrite)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) RemoveOverwrite(ctx context.Context, channelID primitive.ObjectID, overwriteType, id string) error {
+	args := m.Called(ctx, channelID, overwriteType, id)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) CreateRole(ctx context.Context, role *models.Role) error {
+	args := m.Called(ctx, role)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) GetRole(ctx context.Context, roleID primitive.ObjectID) (*models.Role, error) {
+	args := m.Called(ctx, roleID)
+	role, _ := args.Get(0).(*models.Role)
+	return role, args.Error(1)
+}
+
+func (m *MockGuildRepo) GetRoles(ctx context.Context, guildID primitive.ObjectID) ([]models.Role, error) {
+	args := m.Called(ctx, guildID)
+	roles, _ := args.Get(0).([]models.Role)
+	return roles, args.Error(1)
+}
+
+func (m *MockGuildRepo) UpdateRole(ctx context.Context, roleID primitive.ObjectID, name string, permissions models.Permission) error {
+	args := m.Called(ctx, roleID, name, permissions)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) DeleteRole(ctx context.Context, role *models.Role) error {
+	args := m.Called(ctx, role)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) AddMemberRole(ctx context.Context, guildID primitive.ObjectID, userID string, roleID primitive.ObjectID) error {
+	args := m.Called(ctx, guildID, userID, roleID)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) RemoveMemberRole(ctx context.Context, guildID primitive.ObjectID, userID string, roleID primitive.ObjectID) error {
+	args := m.Called(ctx, guildID, userID, roleID)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) BanMember(ctx context.Context, ban *models.Ban) error {
+	args := m.Called(ctx, ban)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) UnbanMember(ctx context.Context, guildID primitive.ObjectID, userID string) error {
+	args := m.Called(ctx, guildID, userID)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) IsBanned(ctx context.Context, guildID primitive.ObjectID, userID string) (bool, error) {
+	args := m.Called(ctx, guildID, userID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockGuildRepo) CreateInvite(ctx context.Context, invite *models.Invite) error {
+	args := m.Called(ctx, invite)
+	return args.Error(0)
+}
+
+func (m *MockGuildRepo) GetInvite(ctx context.Context, code string) (*models.Invite, error) {
+	args := m.Called(ctx, code)
+	invite, _ := args.Get(0).(*models.Invite)
+	return invite, args.Error(1)
+}
+
+func (m *MockGuildRepo) UseInvite(ctx context.Context, code string, now time.Time) (*models.Invite, error) {
+	args := m.Called(ctx, code, now)
+	invite, _ := args.Get(0).(*models.Invite)
+	return invite, args.Error(1)
+}
+
+func newGuild(owner string) *models.Guild {
+	return &models.Guild{ID: primitive.NewObjectID(), Name: "gophers", OwnerID: owner}
+}
+
+func everyoneRole(guild *models.Guild, perms models.Permission) models.Role {
+	return models.Role{ID: guild.ID, GuildID: guild.ID, Name: models.EveryoneRoleName, Permissions: perms}
+}
+
+// expect the lookups of loadAccess for a member with the given roles
+func expectMember(repo *MockGuildRepo, ctx context.Context, guild *models.Guild, userID string, roles []models.Role, memberRoles ...primitive.ObjectID) {
+	repo.On("GetGuild", ctx, guild.ID).Return(guild, nil)
+	repo.On("GetMember", ctx, guild.ID, userID).Return(&models.GuildMember{GuildID: guild.ID, UserID: userID, Roles: memberRoles}, nil)
+	repo.On("GetRoles", ctx, guild.ID).Return(roles, nil)
+}
+
+func newChannel(guild *models.Guild, name string) *models.Chat {
+	return &models.Chat{
+		ID:      primitive.NewObjectID(),
+		Type:    models.ChatTypeChannel,
+		GuildID: guild.ID,
+		Name:    name,
+	}
+}
+
+func TestCreateGuild_AddsOwnerAndDefaultChannel(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	var guildID primitive.ObjectID
+	repo.On("CreateGuild", ctx,
+		mock.MatchedBy(func(g *models.Guild) bool {
+			guildID = g.ID
+			return !g.ID.IsZero() && g.Name == "gophers" && g.OwnerID == "alice"
+		}),
+		mock.MatchedBy(func(r *models.Role) bool {
+			return r.IsEveryone() && r.ID == guildID && r.Permissions == models.DefaultPermissions
+		}),
+		mock.MatchedBy(func(c *models.Chat) bool {
+			return c.Type == models.ChatTypeChannel && c.GuildID == guildID && c.Name == logic.DefaultChannelName
+		}),
+	).Return(nil)
+
+	guild, err := service.CreateGuild(ctx, "alice", " gophers ")
+
+	assert.NoError(t, err)
+	assert.Equal(t, guildID, guild.ID)
+	assert.Len(t, guild.Channels, 1)
+	repo.AssertExpectations(t)
+}
+
+func TestCreateGuild_Fails(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	repo.On("CreateGuild", ctx, mock.Anything, mock.Anything, mock.Anything).Return(assert.AnError)
+
+	guild, err := service.CreateGuild(ctx, "alice", "gophers")
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, guild)
+}
+
+func TestCreateGuild_MissingName(t *testing.T) {
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild, err := service.CreateGuild(context.Background(), "alice", " ")
+
+	assert.ErrorIs(t, err, logic.ErrMissingName)
+	assert.Nil(t, guild)
+	repo.AssertNotCalled(t, "CreateGuild", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGetGuild_NotMember(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	repo.On("GetGuild", ctx, guild.ID).Return(guild, nil)
+	repo.On("GetMember", ctx, guild.ID, "mallory").Return(nil, mongo.ErrNoDocuments)
+
+	details, err := service.GetGuild(ctx, "mallory", guild.ID)
+
+	assert.ErrorIs(t, err, logic.ErrNotGuildMember)
+	assert.Nil(t, details)
+	repo.AssertNotCalled(t, "GetChannels", mock.Anything, mock.Anything)
+}
+
+func TestCreateChannel_NormalizesName(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	expectMember(repo, ctx, guild, "alice", nil)
+	repo.On("CreateChannel", ctx, mock.MatchedBy(func(c *models.Chat) bool {
+		return c.Name == "off-topic" && c.GuildID == guild.ID
+	})).Return(nil)
+
+	channel, err := service.CreateChannel(ctx, "alice", guild.ID, "Off  Topic")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "off-topic", channel.Name)
+	repo.AssertExpectations(t)
+}
+
+func TestCreateChannel_NeedsManageChannels(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	expectMember(repo, ctx, guild, "bob", []models.Role{everyoneRole(guild, models.DefaultPermissions)})
+
+	channel, err := service.CreateChannel(ctx, "bob", guild.ID, "random")
+
+	assert.ErrorIs(t, err, logic.ErrMissingPermission)
+	assert.Nil(t, channel)
+	repo.AssertNotCalled(t, "CreateChannel", mock.Anything, mock.Anything)
+}
+
+func TestRenameChannel(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	channel := newChannel(guild, "general")
+	repo.On("GetChannel", ctx, channel.ID).Return(channel, nil)
+	expectMember(repo, ctx, guild, "alice", nil)
+	repo.On("RenameChannel", ctx, channel.ID, "lobby").Return(nil)
+
+	renamed, err := service.RenameChannel(ctx, "alice", channel.ID, "Lobby")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "lobby", renamed.Name)
+	repo.AssertExpectations(t)
+}
+
+func TestDeleteChannel_KeepsLastChannel(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	channel := newChannel(guild, "general")
+	repo.On("GetChannel", ctx, channel.ID).Return(channel, nil)
+	expectMember(repo, ctx, guild, "alice", nil)
+	repo.On("GetChannels", ctx, guild.ID).Return([]models.Chat{*channel}, nil)
+
+	err := service.DeleteChannel(ctx, "alice", channel.ID)
+
+	assert.ErrorIs(t, err, logic.ErrLastChannel)
+	repo.AssertNotCalled(t, "DeleteChannel", mock.Anything, mock.Anything)
+}
+
+func TestDeleteChannel_ReleasesAttachments(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	releases := new(MockPublisher)
+	service := logic.NewGuildService(repo, releases)
+
+	guild := newGuild("alice")
+	channel := newChannel(guild, "general")
+	other := newChannel(guild, "random")
+	fileID := primitive.NewObjectID()
+	repo.On("GetChannel", ctx, channel.ID).Return(channel, nil)
+	expectMember(repo, ctx, guild, "alice", nil)
+	repo.On("GetChannels", ctx, guild.ID).Return([]models.Chat{*channel, *other}, nil)
+	repo.On("DeleteChannel", ctx, channel.ID).Return([]primitive.ObjectID{fileID}, nil)
+	releases.On("Publish", models.AttachmentsReleased{FileIDs: []primitive.ObjectID{fileID}}).Return(nil)
+
+	err := service.DeleteChannel(ctx, "alice", channel.ID)
+
+	assert.NoError(t, err)
+	releases.AssertExpectations(t)
+}
+
+func TestCreateInvite(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	repo.On("GetGuild", ctx, guild.ID).Return(guild, nil)
+	repo.On("IsMember", ctx, guild.ID, "bob").Return(true, nil)
+	repo.On("CreateInvite", ctx, mock.MatchedBy(func(i *models.Invite) bool {
+		return i.GuildID == guild.ID && i.CreatedBy == "bob" && i.MaxUses == 5 && i.ExpiresAt != nil
+	})).Return(nil)
+
+	invite, err := service.CreateInvite(ctx, "bob", guild.ID, 5, time.Hour)
+
+	assert.NoError(t, err)
+	assert.Len(t, invite.Code, 8)
+	repo.AssertExpectations(t)
+}
+
+func TestCreateInvite_NegativeLimits(t *testing.T) {
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	invite, err := service.CreateInvite(context.Background(), "alice", primitive.NewObjectID(), -1, 0)
+
+	assert.ErrorIs(t, err, logic.ErrInviteLimits)
+	assert.Nil(t, invite)
+}
+
+func TestJoinGuild(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	invite := &models.Invite{Code: "abcd2345", GuildID: guild.ID}
+
+	repo.On("GetInvite", ctx, invite.Code).Return(invite, nil)
+	repo.On("IsMember", ctx, guild.ID, "bob").Return(false, nil)
+	repo.On("IsBanned", ctx, guild.ID, "bob").Return(false, nil)
+	repo.On("UseInvite", ctx, invite.Code, mock.Anything).Return(invite, nil)
+	repo.On("AddMember", ctx, guild.ID, "bob").Return(nil)
+	repo.On("GetGuild", ctx, guild.ID).Return(guild, nil)
+
+	joined, err := service.JoinGuild(ctx, "bob", invite.Code)
+
+	assert.NoError(t, err)
+	assert.Equal(t, guild.ID, joined.ID)
+	repo.AssertExpectations(t)
+}
+
+func TestJoinGuild_ExpiredInvite(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	invite := &models.Invite{Code: "abcd2345", GuildID: guild.ID}
+
+	repo.On("GetInvite", ctx, invite.Code).Return(invite, nil)
+	repo.On("IsMember", ctx, guild.ID, "bob").Return(false, nil)
+	repo.On("IsBanned", ctx, guild.ID, "bob").Return(false, nil)
+	repo.On("UseInvite", ctx, invite.Code, mock.Anything).Return(nil, mongo.ErrNoDocuments)
+
+	joined, err := service.JoinGuild(ctx, "bob", invite.Code)
+
+	assert.ErrorIs(t, err, logic.ErrInvalidInvite)
+	assert.Nil(t, joined)
+	repo.AssertNotCalled(t, "AddMember", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestJoinGuild_AlreadyMemberDoesNotUseInvite(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	invite := &models.Invite{Code: "abcd2345", GuildID: guild.ID, MaxUses: 1}
+
+	repo.On("GetInvite", ctx, invite.Code).Return(invite, nil)
+	repo.On("IsMember", ctx, guild.ID, "bob").Return(true, nil)
+	repo.On("GetGuild", ctx, guild.ID).Return(guild, nil)
+
+	_, err := service.JoinGuild(ctx, "bob", invite.Code)
+
+	assert.NoError(t, err)
+	repo.AssertNotCalled(t, "UseInvite", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLeaveGuild_OwnerCannotLeave(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	repo.On("GetGuild", ctx, guild.ID).Return(guild, nil)
+	repo.On("IsMember", ctx, guild.ID, "alice").Return(true, nil)
+
+	err := service.LeaveGuild(ctx, "alice", guild.ID)
+
+	assert.ErrorIs(t, err, logic.ErrOwnerLeaving)
+	repo.AssertNotCalled(t, "RemoveMember", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSendMessageToConversation_ChannelBroadcastsToGuild(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+	channels := new(MockChannelAccess)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), channels)
+
+	channel := newChannel(newGuild("alice"), "general")
+
+	mockRepo.On("GetChatByID", ctx, channel.ID).Return(channel, nil)
+	channels.On("ChannelPermissions", ctx, channel, "bob").Return(models.DefaultPermissions, nil)
+	channels.On("ChannelMembers", ctx, channel).Return([]string{"alice", "bob"}, nil)
+	mockRepo.On("AddMessage", ctx, mock.Anything).Return(nil)
+	mockEvents.On("Publish", mock.MatchedBy(func(e models.ChatEvent) bool {
+		return assert.ObjectsAreEqual([]string{"alice", "bob"}, e.Recipients)
+	})).Return(nil)
+
+	msg, err := service.SendMessageToConversation(ctx, "bob", channel.ID, "hello guild")
+
+	assert.NoError(t, err)
+	assert.Equal(t, channel.ID, msg.ChatID)
+	mockPub.AssertNotCalled(t, "Publish", mock.Anything)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestSendMessageToConversation_ChannelNotMember(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	channels := new(MockChannelAccess)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), channels)
+
+	channel := newChannel(newGuild("alice"), "general")
+
+	mockRepo.On("GetChatByID", ctx, channel.ID).Return(channel, nil)
+	channels.On("ChannelPermissions", ctx, channel, "mallory").Return(models.Permission(0), nil)
+
+	msg, err := service.SendMessageToConversation(ctx, "mallory", channel.ID, "hi")
+
+	assert.ErrorIs(t, err, logic.ErrNotMember)
+	assert.Nil(t, msg)
+	mockRepo.AssertNotCalled(t, "AddMessage", mock.Anything, mock.Anything)
+}
+
+func TestVisibleChannels_SkipsHiddenChannels(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	general := newChannel(guild, "general")
+	staff := newChannel(guild, "staff")
+	staff.Overwrites = []models.PermissionOverwrite{
+		{Type: models.OverwriteRole, ID: guild.ID.Hex(), Deny: models.PermViewChannel},
+	}
+
+	repo.On("GetGuildsByMember", ctx, "bob").Return([]models.Guild{*guild}, nil)
+	expectMember(repo, ctx, guild, "bob", []models.Role{everyoneRole(guild, models.DefaultPermissions)})
+	repo.On("GetChannels", ctx, guild.ID).Return([]models.Chat{*general, *staff}, nil)
+
+	channelIDs, err := service.VisibleChannels(ctx, "bob")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []primitive.ObjectID{general.ID}, channelIDs)
+}
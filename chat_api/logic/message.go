@@ -0,0 +1,126 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrMessageDeleted = errors.New("message was deleted")
+
+// change the content of a message. Only its author can, unless the actor
+// moderates the conversation.
+func (s *ChatService) EditMessage(ctx context.Context, actor string, messageID primitive.ObjectID, content string) (*models.Message, error) {
+	if err := checkContent(content, nil); err != nil {
+		return nil, err
+	}
+
+	message, chat, err := s.authorizeMessage(ctx, actor, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	edited, err := s.repo.EditMessage(ctx, message.ID, content, time.Now())
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrMessageDeleted
+		}
+		return nil, err
+	}
+
+	s.broadcastChange(ctx, chat, models.EventMessageUpdated, edited)
+	return edited, nil
+}
+
+// replace a message with a tombstone, with the same rules as editing. Its
+// attachments go with it.
+func (s *ChatService) DeleteMessage(ctx context.Context, actor string, messageID primitive.ObjectID) (*models.Message, error) {
+	message, chat, err := s.authorizeMessage(ctx, actor, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.repo.DeleteMessage(ctx, message.ID, time.Now())
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrMessageDeleted
+		}
+		return nil, err
+	}
+
+	log.Printf("Message %s deleted by %s", message.ID.Hex(), actor)
+	s.releaseAttachments(message)
+	s.broadcastChange(ctx, chat, models.EventMessageDeleted, deleted)
+	return deleted, nil
+}
+
+// let file storage remove the files of a deleted message
+func (s *ChatService) releaseAttachments(message *models.Message) {
+	if len(message.Attachments) == 0 {
+		return
+	}
+
+	fileIDs := make([]primitive.ObjectID, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		fileIDs = append(fileIDs, attachment.ID)
+	}
+	releaseFiles(s.releases, fileIDs, "message "+message.ID.Hex())
+}
+
+// publish the release of files whose messages are gone, failing is only
+// logged as the messages can't be restored anyway
+func releaseFiles(releases Publisher, fileIDs []primitive.ObjectID, of string) {
+	if len(fileIDs) == 0 {
+		return
+	}
+	if err := releases.Publish(models.AttachmentsReleased{FileIDs: fileIDs}); err != nil {
+		log.Printf("Failed to release attachments of %s: %v", of, err)
+	}
+}
+
+// load a message the actor may change: their own, or any message in a
+// conversation where they can manage messages
+func (s *ChatService) authorizeMessage(ctx context.Context, actor string, messageID primitive.ObjectID) (*models.Message, *models.Chat, error) {
+	message, err := s.repo.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if message.Deleted {
+		return nil, nil, ErrMessageDeleted
+	}
+
+	chat, err := s.GetConversation(ctx, actor, message.ChatID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if message.SentByUser == actor {
+		return message, chat, nil
+	}
+
+	perms, err := s.permissions(ctx, chat, actor)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !perms.Has(models.PermManageMessages) {
+		return nil, nil, ErrMissingPermission
+	}
+	return message, chat, nil
+}
+
+// push an edit or delete to the clients of the conversation, failures are
+// only logged as the change is stored
+func (s *ChatService) broadcastChange(ctx context.Context, chat *models.Chat, eventType string, message *models.Message) {
+	members, err := s.recipients(ctx, chat)
+	if err != nil {
+		log.Printf("Failed to get members of chat %s: %v", chat.ID.Hex(), err)
+		return
+	}
+	s.broadcast(eventType, members, message)
+}
@@ -0,0 +1,147 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func newMessage(chat *models.Chat, author string) *models.Message {
+	return &models.Message{
+		ID:         primitive.NewObjectID(),
+		ChatID:     chat.ID,
+		Content:    "hello",
+		SentByUser: author,
+		Timestamp:  time.Now(),
+	}
+}
+
+func TestEditMessage_ByAuthor(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "bob")
+	editedAt := time.Now()
+	edited := *message
+	edited.Content = "hello there"
+	edited.EditedAt = &editedAt
+
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("EditMessage", ctx, message.ID, "hello there", mock.Anything).Return(&edited, nil)
+	mockEvents.On("Publish", models.ChatEvent{
+		Type:       models.EventMessageUpdated,
+		Recipients: group.Users,
+		Message:    &edited,
+	}).Return(nil)
+
+	result, err := service.EditMessage(ctx, "bob", message.ID, "hello there")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "hello there", result.Content)
+	assert.NotNil(t, result.EditedAt)
+	mockRepo.AssertExpectations(t)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestEditMessage_SomeoneElsesMessage(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob", "carol")
+	message := newMessage(group, "bob")
+
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	result, err := service.EditMessage(ctx, "carol", message.ID, "hijacked")
+
+	assert.ErrorIs(t, err, logic.ErrMissingPermission)
+	assert.Nil(t, result)
+	mockRepo.AssertNotCalled(t, "EditMessage", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeleteMessage_GroupOwnerModerates(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "bob")
+	deletedAt := time.Now()
+	tombstone := &models.Message{ID: message.ID, ChatID: group.ID, SentByUser: "bob", Deleted: true, DeletedAt: &deletedAt}
+
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("DeleteMessage", ctx, message.ID, mock.Anything).Return(tombstone, nil)
+	mockEvents.On("Publish", mock.MatchedBy(func(e models.ChatEvent) bool {
+		return e.Type == models.EventMessageDeleted && e.Message.Deleted && e.Message.Content == ""
+	})).Return(nil)
+
+	result, err := service.DeleteMessage(ctx, "alice", message.ID)
+
+	assert.NoError(t, err)
+	assert.True(t, result.Deleted)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestDeleteMessage_ChannelModerator(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+	channels := new(MockChannelAccess)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), channels)
+
+	channel := newChannel(newGuild("alice"), "general")
+	message := newMessage(channel, "bob")
+
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("GetChatByID", ctx, channel.ID).Return(channel, nil)
+	channels.On("ChannelPermissions", ctx, channel, "carol").Return(models.DefaultPermissions|models.PermManageMessages, nil)
+	channels.On("ChannelMembers", ctx, channel).Return([]string{"alice", "bob", "carol"}, nil)
+	mockRepo.On("DeleteMessage", ctx, message.ID, mock.Anything).Return(&models.Message{ID: message.ID, ChatID: channel.ID, Deleted: true}, nil)
+	mockEvents.On("Publish", mock.MatchedBy(func(e models.ChatEvent) bool {
+		return e.Type == models.EventMessageDeleted && len(e.Recipients) == 3
+	})).Return(nil)
+
+	_, err := service.DeleteMessage(ctx, "carol", message.ID)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestDeleteMessage_AlreadyDeleted(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "bob")
+
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("DeleteMessage", ctx, message.ID, mock.Anything).Return(nil, mongo.ErrNoDocuments)
+
+	result, err := service.DeleteMessage(ctx, "bob", message.ID)
+
+	assert.ErrorIs(t, err, logic.ErrMessageDeleted)
+	assert.Nil(t, result)
+}
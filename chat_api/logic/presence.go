@@ -0,0 +1,88 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// how long a typing indicator shows, clients repeat it while the user types
+const TypingTimeout = 8 * time.Second
+
+// tell the other members of a conversation that userID is typing
+func (s *ChatService) StartTyping(ctx context.Context, userID string, chatID primitive.ObjectID) (*models.Typing, error) {
+	typing := &models.Typing{UserID: userID, ExpiresAt: time.Now().Add(TypingTimeout)}
+	if err := s.publishTyping(ctx, models.EventTypingStarted, chatID, typing); err != nil {
+		return nil, err
+	}
+	return typing, nil
+}
+
+// take the typing indicator down before it expires, e.g. when the user
+// cleared their draft
+func (s *ChatService) StopTyping(ctx context.Context, userID string, chatID primitive.ObjectID) error {
+	return s.publishTyping(ctx, models.EventTypingStopped, chatID, &models.Typing{UserID: userID})
+}
+
+func (s *ChatService) publishTyping(ctx context.Context, eventType string, chatID primitive.ObjectID, typing *models.Typing) error {
+	chat, err := s.authorize(ctx, typing.UserID, chatID, models.PermViewChannel|models.PermSendMessages)
+	if err != nil {
+		return err
+	}
+	typing.ChatID = chat.ID
+
+	members, err := s.recipients(ctx, chat)
+	if err != nil {
+		return err
+	}
+
+	s.publishEvent(models.ChatEvent{
+		Type:       eventType,
+		Recipients: without(members, typing.UserID),
+		Typing:     typing,
+	})
+	return nil
+}
+
+// tell everyone who shares a direct or group chat with the user that their
+// status changed. Called by the websocket hub, failures are only logged.
+func (s *ChatService) AnnouncePresence(presence models.Presence) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	contacts, err := s.repo.GetContacts(ctx, presence.UserID)
+	if err != nil {
+		log.Printf("Failed to get contacts of %s: %v", presence.UserID, err)
+	}
+
+	s.publishEvent(models.ChatEvent{
+		Type:       models.EventPresenceUpdated,
+		Recipients: contacts,
+		Presence:   []models.Presence{presence},
+	})
+}
+
+// refresh the users connected to this replica on all replicas
+func (s *ChatService) SyncPresence(presence []models.Presence) {
+	if len(presence) == 0 {
+		return
+	}
+
+	s.publishEvent(models.ChatEvent{
+		Type:     models.EventPresenceSync,
+		Presence: presence,
+	})
+}
+
+func without(users []string, userID string) []string {
+	rest := make([]string, 0, len(users))
+	for _, u := range users {
+		if u != userID {
+			rest = append(rest, u)
+		}
+	}
+	return rest
+}
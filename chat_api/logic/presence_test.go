@@ -0,0 +1,91 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestStartTyping_NotifiesOtherMembers(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob", "carol")
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockEvents.On("Publish", mock.MatchedBy(func(e models.ChatEvent) bool {
+		return e.Type == models.EventTypingStarted &&
+			assert.ObjectsAreEqual([]string{"bob", "carol"}, e.Recipients) &&
+			e.Typing.ChatID == group.ID && e.Typing.UserID == "alice"
+	})).Return(nil)
+
+	typing, err := service.StartTyping(ctx, "alice", group.ID)
+
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(logic.TypingTimeout), typing.ExpiresAt, time.Second)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestStartTyping_NotAMember(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	typing, err := service.StartTyping(ctx, "mallory", group.ID)
+
+	assert.ErrorIs(t, err, logic.ErrNotMember)
+	assert.Nil(t, typing)
+	mockEvents.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+func TestStopTyping(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockEvents.On("Publish", models.ChatEvent{
+		Type:       models.EventTypingStopped,
+		Recipients: []string{"bob"},
+		Typing:     &models.Typing{ChatID: group.ID, UserID: "alice"},
+	}).Return(nil)
+
+	err := service.StopTyping(ctx, "alice", group.ID)
+
+	assert.NoError(t, err)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestAnnouncePresence_ToContacts(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	presence := models.Presence{UserID: "alice", Status: models.PresenceIdle, Replica: "chat-0"}
+	mockRepo.On("GetContacts", mock.Anything, "alice").Return([]string{"bob", "carol"}, nil)
+	mockEvents.On("Publish", models.ChatEvent{
+		Type:       models.EventPresenceUpdated,
+		Recipients: []string{"bob", "carol"},
+		Presence:   []models.Presence{presence},
+	}).Return(nil)
+
+	service.AnnouncePresence(presence)
+
+	mockEvents.AssertExpectations(t)
+}
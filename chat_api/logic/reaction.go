@@ -0,0 +1,125 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// in code points, long enough for emoji sequences like flags and families
+const maxEmojiLength = 10
+
+var (
+	ErrInvalidEmoji     = errors.New("invalid emoji")
+	ErrTooManyReactions = errors.New("message has too many different reactions")
+)
+
+// react to a message, anyone who can send messages in the conversation can
+func (s *ChatService) AddReaction(ctx context.Context, userID string, messageID primitive.ObjectID, emoji string) (*models.Message, error) {
+	return s.react(ctx, userID, messageID, emoji, models.EventReactionAdded)
+}
+
+func (s *ChatService) RemoveReaction(ctx context.Context, userID string, messageID primitive.ObjectID, emoji string) (*models.Message, error) {
+	return s.react(ctx, userID, messageID, emoji, models.EventReactionRemoved)
+}
+
+func (s *ChatService) react(ctx context.Context, userID string, messageID primitive.ObjectID, emoji, eventType string) (*models.Message, error) {
+	if !validEmoji(emoji) {
+		return nil, ErrInvalidEmoji
+	}
+
+	message, err := s.repo.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := s.authorize(ctx, userID, message.ChatID, models.PermViewChannel|models.PermSendMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	if eventType == models.EventReactionAdded && !message.Deleted && !roomForEmoji(message, emoji) {
+		return nil, ErrTooManyReactions
+	}
+
+	reaction := models.Reaction{Emoji: emoji, UserID: userID}
+
+	var updated *models.Message
+	if eventType == models.EventReactionAdded {
+		updated, err = s.repo.AddReaction(ctx, messageID, reaction)
+	} else {
+		updated, err = s.repo.RemoveReaction(ctx, messageID, reaction)
+	}
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrMessageDeleted
+		}
+		return nil, err
+	}
+
+	s.broadcastReaction(ctx, chat, eventType, updated, reaction)
+
+	updated.SummarizeReactions(userID)
+	return updated, nil
+}
+
+func (s *ChatService) broadcastReaction(ctx context.Context, chat *models.Chat, eventType string, message *models.Message, reaction models.Reaction) {
+	members, err := s.recipients(ctx, chat)
+	if err != nil {
+		return
+	}
+
+	event := models.ChatEvent{
+		Type:       eventType,
+		Recipients: members,
+		Reaction: &models.ReactionChange{
+			MessageID: message.ID,
+			ChatID:    message.ChatID,
+			Emoji:     reaction.Emoji,
+			UserID:    reaction.UserID,
+		},
+	}
+	s.publishEvent(event)
+}
+
+// whether emoji is on the message already or there's room for another one,
+// the repository checks again when adding
+func roomForEmoji(message *models.Message, emoji string) bool {
+	distinct := make(map[string]bool)
+	for _, r := range message.Reactions {
+		if r.Emoji == emoji {
+			return true
+		}
+		distinct[r.Emoji] = true
+	}
+	return len(distinct) < models.MaxReactionEmoji
+}
+
+// a single emoji: symbols with the joiners, selectors, skin tones and tags
+// that make up sequences. Same rules as the status emoji of profiles.
+func validEmoji(emoji string) bool {
+	if emoji == "" || utf8.RuneCountInString(emoji) > maxEmojiLength {
+		return false
+	}
+
+	symbols := 0
+	for _, r := range emoji {
+		switch {
+		case unicode.Is(unicode.So, r):
+			symbols++
+		case r == '\u200d', r == '\ufe0f', r == '\u20e3',
+			r >= 0x1f3fb && r <= 0x1f3ff,
+			r >= 0xe0020 && r <= 0xe007f,
+			r == '#', r == '*', r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return symbols > 0 || strings.ContainsRune(emoji, '\u20e3')
+}
@@ -0,0 +1,154 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestAddReaction(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "alice")
+	reacted := *message
+	reacted.Reactions = []models.Reaction{
+		{Emoji: "👍", UserID: "alice"},
+		{Emoji: "👍", UserID: "bob"},
+	}
+
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("AddReaction", ctx, message.ID, models.Reaction{Emoji: "👍", UserID: "bob"}).Return(&reacted, nil)
+	mockEvents.On("Publish", mock.MatchedBy(func(e models.ChatEvent) bool {
+		return e.Type == models.EventReactionAdded &&
+			e.Reaction.MessageID == message.ID &&
+			e.Reaction.Emoji == "👍" &&
+			e.Reaction.UserID == "bob"
+	})).Return(nil)
+
+	result, err := service.AddReaction(ctx, "bob", message.ID, "👍")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.ReactionSummary{{Emoji: "👍", Count: 2, Me: true}}, result.ReactionSummary)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestAddReaction_InvalidEmoji(t *testing.T) {
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	result, err := service.AddReaction(context.Background(), "bob", primitive.NewObjectID(), "not an emoji")
+
+	assert.ErrorIs(t, err, logic.ErrInvalidEmoji)
+	assert.Nil(t, result)
+	mockRepo.AssertNotCalled(t, "GetMessage", mock.Anything, mock.Anything)
+}
+
+func TestAddReaction_NotAnEmoji(t *testing.T) {
+	service := logic.NewChatService(new(MockRepo), new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	for _, emoji := range []string{"lol", "x", "<script>", "👍lol", "👍👍👍👍👍👍👍👍👍👍👍"} {
+		_, err := service.AddReaction(context.Background(), "bob", primitive.NewObjectID(), emoji)
+		assert.ErrorIs(t, err, logic.ErrInvalidEmoji, emoji)
+	}
+}
+
+func TestAddReaction_TooManyEmoji(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "alice")
+	for i := 0; i < models.MaxReactionEmoji; i++ {
+		message.Reactions = append(message.Reactions, models.Reaction{Emoji: string(rune(0x1f600 + i)), UserID: "alice"})
+	}
+
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("AddReaction", ctx, message.ID, models.Reaction{Emoji: "😀", UserID: "bob"}).Return(message, nil)
+	mockEvents.On("Publish", mock.Anything).Return(nil)
+
+	_, err := service.AddReaction(ctx, "bob", message.ID, "🎉")
+	assert.ErrorIs(t, err, logic.ErrTooManyReactions)
+
+	// joining a reaction that's already there is fine
+	_, err = service.AddReaction(ctx, "bob", message.ID, "😀")
+	assert.NoError(t, err)
+}
+
+func TestAddReaction_NotMember(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "alice")
+
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	_, err := service.AddReaction(ctx, "mallory", message.ID, "👍")
+
+	assert.ErrorIs(t, err, logic.ErrNotMember)
+	mockRepo.AssertNotCalled(t, "AddReaction", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRemoveReaction_DeletedMessage(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "alice")
+
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("RemoveReaction", ctx, message.ID, models.Reaction{Emoji: "🎉", UserID: "bob"}).Return(nil, mongo.ErrNoDocuments)
+
+	_, err := service.RemoveReaction(ctx, "bob", message.ID, "🎉")
+
+	assert.ErrorIs(t, err, logic.ErrMessageDeleted)
+}
+
+func TestGetChatHistory_SummarizesReactions(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	chat := &models.Chat{ID: primitive.NewObjectID(), Users: []string{"alice", "bob"}}
+	message := newMessage(chat, "alice")
+	message.Reactions = []models.Reaction{
+		{Emoji: "🎉", UserID: "bob"},
+		{Emoji: "👍", UserID: "alice"},
+		{Emoji: "🎉", UserID: "alice"},
+	}
+
+	mockRepo.On("GetMessages", ctx, chat.ID, mock.Anything).Return([]models.Message{*message}, nil)
+	mockRepo.On("GetReadStates", ctx, chat.ID).Return([]models.ReadState{}, nil)
+
+	history, err := service.GetChatHistory(ctx, "bob", chat, models.MessageQuery{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.ReactionSummary{
+		{Emoji: "🎉", Count: 2, Me: true},
+		{Emoji: "👍", Count: 1, Me: false},
+	}, history.Messages[0].ReactionSummary)
+}
@@ -0,0 +1,46 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrWrongConversation = errors.New("message is not in this conversation")
+
+// mark a conversation as read up to messageID. Read positions only move
+// forward, the members of direct and group chats are told when they do.
+func (s *ChatService) MarkRead(ctx context.Context, userID string, chatID, messageID primitive.ObjectID) (*models.ReadState, error) {
+	chat, err := s.GetConversation(ctx, userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := s.repo.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.ChatID != chat.ID {
+		return nil, ErrWrongConversation
+	}
+
+	before, after, err := s.repo.MarkRead(ctx, chat.ID, userID, message.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	advanced := before == nil || before.LastReadID != after.LastReadID
+
+	// a receipt for every reader of a channel would flood the guild
+	if advanced && !chat.IsChannel() {
+		s.publishEvent(models.ChatEvent{
+			Type:       models.EventMessageRead,
+			Recipients: chat.Users,
+			ReadState:  after,
+		})
+	}
+	return after, nil
+}
@@ -0,0 +1,81 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMarkRead_PublishesReceipt(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "alice")
+	after := &models.ReadState{ChatID: group.ID, UserID: "bob", LastReadID: message.ID, ReadAt: time.Now()}
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("MarkRead", ctx, group.ID, "bob", message.ID, mock.Anything).Return(nil, after, nil)
+	mockEvents.On("Publish", models.ChatEvent{
+		Type:       models.EventMessageRead,
+		Recipients: group.Users,
+		ReadState:  after,
+	}).Return(nil)
+
+	state, err := service.MarkRead(ctx, "bob", group.ID, message.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, message.ID, state.LastReadID)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestMarkRead_OlderMessageDoesNotPublish(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "alice")
+	newer := primitive.NewObjectID()
+	state := &models.ReadState{ChatID: group.ID, UserID: "bob", LastReadID: newer}
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+	mockRepo.On("MarkRead", ctx, group.ID, "bob", message.ID, mock.Anything).Return(state, state, nil)
+
+	result, err := service.MarkRead(ctx, "bob", group.ID, message.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, newer, result.LastReadID)
+	mockEvents.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+func TestMarkRead_MessageInOtherConversation(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(newGroup("carol", "bob"), "carol")
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("GetMessage", ctx, message.ID).Return(message, nil)
+
+	_, err := service.MarkRead(ctx, "bob", group.ID, message.ID)
+
+	assert.ErrorIs(t, err, logic.ErrWrongConversation)
+	mockRepo.AssertNotCalled(t, "MarkRead", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
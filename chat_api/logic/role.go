@@ -0,0 +1,442 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrEveryoneRole     = errors.New("the @everyone role cannot be renamed or deleted")
+	ErrInvalidOverwrite = errors.New("an overwrite needs either a role or a member")
+	ErrModerateOwner    = errors.New("the owner cannot be kicked or banned")
+	ErrRoleHierarchy    = errors.New("the member has as many permissions as you")
+)
+
+// guildAccess is what's needed to compute a member's permissions
+type guildAccess struct {
+	guild  *models.Guild
+	member *models.GuildMember
+	roles  []models.Role
+}
+
+func (a *guildAccess) permissions(channel *models.Chat) models.Permission {
+	return ComputePermissions(a.guild, a.member, a.roles, channel)
+}
+
+// whether the member ranks above another of the guild. The owner is above
+// everyone, anyone else needs every permission of the other and more.
+func (a *guildAccess) outranks(other *models.GuildMember) bool {
+	if a.member.UserID == a.guild.OwnerID {
+		return true
+	}
+	if other.UserID == a.guild.OwnerID {
+		return false
+	}
+
+	mine := a.permissions(nil)
+	theirs := ComputePermissions(a.guild, other, a.roles, nil)
+	return mine.Has(theirs) && mine != theirs
+}
+
+// ComputePermissions works out what a member may do, in a channel when one is
+// given. The owner and administrators may do everything. Otherwise the
+// permissions of @everyone and the member's roles are combined, then the
+// channel's overwrites are applied: @everyone first, then all of the member's
+// roles together, then the member's own overwrite.
+func ComputePermissions(guild *models.Guild, member *models.GuildMember, roles []models.Role, channel *models.Chat) models.Permission {
+	if member.UserID == guild.OwnerID {
+		return models.PermAll
+	}
+
+	memberRoles := make(map[primitive.ObjectID]bool, len(member.Roles))
+	for _, id := range member.Roles {
+		memberRoles[id] = true
+	}
+
+	perms := models.DefaultPermissions
+	for _, role := range roles {
+		if role.IsEveryone() {
+			perms = role.Permissions
+		}
+	}
+	for _, role := range roles {
+		if memberRoles[role.ID] {
+			perms |= role.Permissions
+		}
+	}
+
+	if perms.Has(models.PermAdministrator) {
+		return models.PermAll
+	}
+
+	if channel == nil {
+		return perms
+	}
+
+	var roleAllow, roleDeny models.Permission
+	var everyone, own *models.PermissionOverwrite
+
+	for i, o := range channel.Overwrites {
+		switch o.Type {
+		case models.OverwriteRole:
+			if o.ID == guild.ID.Hex() {
+				everyone = &channel.Overwrites[i]
+				continue
+			}
+			id, err := primitive.ObjectIDFromHex(o.ID)
+			if err == nil && memberRoles[id] {
+				roleAllow |= o.Allow
+				roleDeny |= o.Deny
+			}
+		case models.OverwriteMember:
+			if o.ID == member.UserID {
+				own = &channel.Overwrites[i]
+			}
+		}
+	}
+
+	if everyone != nil {
+		perms = everyone.Apply(perms)
+	}
+	perms = models.PermissionOverwrite{Allow: roleAllow, Deny: roleDeny}.Apply(perms)
+	if own != nil {
+		perms = own.Apply(perms)
+	}
+	return perms
+}
+
+func (s *GuildService) loadAccess(ctx context.Context, guildID primitive.ObjectID, userID string) (*guildAccess, error) {
+	guild, err := s.repo.GetGuild(ctx, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.repo.GetMember(ctx, guildID, userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotGuildMember
+		}
+		return nil, err
+	}
+
+	roles, err := s.repo.GetRoles(ctx, guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &guildAccess{guild: guild, member: member, roles: roles}, nil
+}
+
+// requirePermission checks the actor has perm in the guild, or in channel
+// when it isn't nil
+func (s *GuildService) requirePermission(ctx context.Context, actor string, guildID primitive.ObjectID, channel *models.Chat, perm models.Permission) (*guildAccess, error) {
+	access, err := s.loadAccess(ctx, guildID, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	if !access.permissions(channel).Has(perm) {
+		return nil, ErrMissingPermission
+	}
+	return access, nil
+}
+
+// roles can only hand out permissions their manager has, so nobody can
+// raise their own permissions by managing roles
+func (s *GuildService) requireRoleManager(ctx context.Context, actor string, guildID primitive.ObjectID, grants models.Permission) (*guildAccess, error) {
+	access, err := s.requirePermission(ctx, actor, guildID, nil, models.PermManageRoles)
+	if err != nil {
+		return nil, err
+	}
+
+	if !access.permissions(nil).Has(grants) {
+		return nil, ErrMissingPermission
+	}
+	return access, nil
+}
+
+// requireOutranks checks the actor ranks above userID, someone who isn't a
+// member has no rank
+func (s *GuildService) requireOutranks(ctx context.Context, access *guildAccess, userID string) error {
+	member, err := s.repo.GetMember(ctx, access.guild.ID, userID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !access.outranks(member) {
+		return ErrRoleHierarchy
+	}
+	return nil
+}
+
+func (s *GuildService) GetRoles(ctx context.Context, actor string, guildID primitive.ObjectID) ([]models.Role, error) {
+	if _, err := s.memberGuild(ctx, actor, guildID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetRoles(ctx, guildID)
+}
+
+func (s *GuildService) CreateRole(ctx context.Context, actor string, guildID primitive.ObjectID, name string, permissions models.Permission) (*models.Role, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions &= models.PermAll
+	if _, err := s.requireRoleManager(ctx, actor, guildID, permissions); err != nil {
+		return nil, err
+	}
+
+	role := &models.Role{
+		GuildID:     guildID,
+		Name:        name,
+		Permissions: permissions,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.repo.CreateRole(ctx, role); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Role %s created in guild %s by %s", role.ID.Hex(), guildID.Hex(), actor)
+	return role, nil
+}
+
+// change the name and permissions of a role. @everyone keeps its name.
+func (s *GuildService) UpdateRole(ctx context.Context, actor string, roleID primitive.ObjectID, name string, permissions models.Permission) (*models.Role, error) {
+	role, err := s.repo.GetRole(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if role.IsEveryone() {
+		if name != "" && name != role.Name {
+			return nil, ErrEveryoneRole
+		}
+		name = role.Name
+	} else if name, err = cleanName(name); err != nil {
+		return nil, err
+	}
+
+	permissions &= models.PermAll
+	if _, err := s.requireRoleManager(ctx, actor, role.GuildID, role.Permissions|permissions); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdateRole(ctx, roleID, name, permissions); err != nil {
+		return nil, err
+	}
+
+	role.Name = name
+	role.Permissions = permissions
+	return role, nil
+}
+
+func (s *GuildService) DeleteRole(ctx context.Context, actor string, roleID primitive.ObjectID) error {
+	role, err := s.repo.GetRole(ctx, roleID)
+	if err != nil {
+		return err
+	}
+
+	if role.IsEveryone() {
+		return ErrEveryoneRole
+	}
+
+	if _, err := s.requireRoleManager(ctx, actor, role.GuildID, role.Permissions); err != nil {
+		return err
+	}
+
+	return s.repo.DeleteRole(ctx, role)
+}
+
+// give a role to a member of its guild, below the actor unless it's
+// themselves
+func (s *GuildService) AssignRole(ctx context.Context, actor string, roleID primitive.ObjectID, userID string) error {
+	role, err := s.memberRole(ctx, actor, roleID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.AddMemberRole(ctx, role.GuildID, userID, role.ID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotGuildMember
+	}
+	return err
+}
+
+func (s *GuildService) UnassignRole(ctx context.Context, actor string, roleID primitive.ObjectID, userID string) error {
+	role, err := s.memberRole(ctx, actor, roleID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.RemoveMemberRole(ctx, role.GuildID, userID, role.ID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotGuildMember
+	}
+	return err
+}
+
+// a role that can be given to members, checking the actor may manage it
+// and the member it's given to or taken from
+func (s *GuildService) memberRole(ctx context.Context, actor string, roleID primitive.ObjectID, userID string) (*models.Role, error) {
+	role, err := s.repo.GetRole(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if role.IsEveryone() {
+		return nil, ErrEveryoneRole
+	}
+
+	access, err := s.requireRoleManager(ctx, actor, role.GuildID, role.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID != actor {
+		if err := s.requireOutranks(ctx, access, userID); err != nil {
+			return nil, err
+		}
+	}
+	return role, nil
+}
+
+// set the overwrite of a role or member in a channel
+func (s *GuildService) SetChannelOverwrite(ctx context.Context, actor string, channelID primitive.ObjectID, overwrite models.PermissionOverwrite) (*models.Chat, error) {
+	if overwrite.Type != models.OverwriteRole && overwrite.Type != models.OverwriteMember || overwrite.ID == "" {
+		return nil, ErrInvalidOverwrite
+	}
+
+	channel, err := s.repo.GetChannel(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	overwrite.Allow &= models.PermAll
+	overwrite.Deny &= models.PermAll
+	// replacing an overwrite also drops what it allowed or denied
+	existing := channelOverwrite(channel, overwrite.Type, overwrite.ID)
+	if _, err := s.requireRoleManager(ctx, actor, channel.GuildID, overwrite.Allow|overwrite.Deny|existing.Allow|existing.Deny); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.SetOverwrite(ctx, channelID, overwrite); err != nil {
+		return nil, err
+	}
+	return s.repo.GetChannel(ctx, channelID)
+}
+
+// remove an overwrite, which like setting one needs every permission it
+// allows or denies: dropping a deny grants that permission
+func (s *GuildService) RemoveChannelOverwrite(ctx context.Context, actor string, channelID primitive.ObjectID, overwriteType, id string) error {
+	channel, err := s.repo.GetChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+
+	existing := channelOverwrite(channel, overwriteType, id)
+	if _, err := s.requireRoleManager(ctx, actor, channel.GuildID, existing.Allow|existing.Deny); err != nil {
+		return err
+	}
+
+	return s.repo.RemoveOverwrite(ctx, channelID, overwriteType, id)
+}
+
+// the overwrite of a role or member in a channel, empty when there is none
+func channelOverwrite(channel *models.Chat, overwriteType, id string) models.PermissionOverwrite {
+	for _, overwrite := range channel.Overwrites {
+		if overwrite.Type == overwriteType && overwrite.ID == id {
+			return overwrite
+		}
+	}
+	return models.PermissionOverwrite{}
+}
+
+// compute the effective permissions of a member, in a channel when channelID
+// is set. Any member of the guild may ask.
+func (s *GuildService) GetPermissions(ctx context.Context, actor string, guildID primitive.ObjectID, channelID *primitive.ObjectID, userID string) (*models.EffectivePermissions, error) {
+	if _, err := s.memberGuild(ctx, actor, guildID); err != nil {
+		return nil, err
+	}
+
+	var channel *models.Chat
+	if channelID != nil {
+		c, err := s.repo.GetChannel(ctx, *channelID)
+		if err != nil {
+			return nil, err
+		}
+		if c.GuildID != guildID {
+			return nil, mongo.ErrNoDocuments
+		}
+		channel = c
+	}
+
+	access, err := s.loadAccess(ctx, guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	perms := access.permissions(channel)
+	return &models.EffectivePermissions{
+		GuildID:     guildID,
+		ChannelID:   channelID,
+		UserID:      userID,
+		Permissions: perms,
+		Names:       perms.Names(),
+	}, nil
+}
+
+// remove a member, they can join again with an invite
+func (s *GuildService) KickMember(ctx context.Context, actor string, guildID primitive.ObjectID, userID string) error {
+	if err := s.moderate(ctx, actor, guildID, userID, models.PermKickMembers); err != nil {
+		return err
+	}
+
+	log.Printf("User %s kicked from guild %s by %s", userID, guildID.Hex(), actor)
+	return s.repo.RemoveMember(ctx, guildID, userID)
+}
+
+// remove a member and keep them from joining again
+func (s *GuildService) BanMember(ctx context.Context, actor string, guildID primitive.ObjectID, userID string) error {
+	if err := s.moderate(ctx, actor, guildID, userID, models.PermBanMembers); err != nil {
+		return err
+	}
+
+	ban := &models.Ban{
+		GuildID:  guildID,
+		UserID:   userID,
+		BannedBy: actor,
+		BannedAt: time.Now(),
+	}
+
+	log.Printf("User %s banned from guild %s by %s", userID, guildID.Hex(), actor)
+	return s.repo.BanMember(ctx, ban)
+}
+
+func (s *GuildService) UnbanMember(ctx context.Context, actor string, guildID primitive.ObjectID, userID string) error {
+	if _, err := s.requirePermission(ctx, actor, guildID, nil, models.PermBanMembers); err != nil {
+		return err
+	}
+	return s.repo.UnbanMember(ctx, guildID, userID)
+}
+
+func (s *GuildService) moderate(ctx context.Context, actor string, guildID primitive.ObjectID, userID string, perm models.Permission) error {
+	access, err := s.requirePermission(ctx, actor, guildID, nil, perm)
+	if err != nil {
+		return err
+	}
+
+	if userID == access.guild.OwnerID {
+		return ErrModerateOwner
+	}
+	return s.requireOutranks(ctx, access, userID)
+}
@@ -0,0 +1,302 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestComputePermissions_OwnerHasAll(t *testing.T) {
+	guild := newGuild("alice")
+	roles := []models.Role{everyoneRole(guild, 0)}
+
+	perms := logic.ComputePermissions(guild, &models.GuildMember{UserID: "alice"}, roles, nil)
+
+	assert.Equal(t, models.PermAll, perms)
+}
+
+func TestComputePermissions_CombinesRoles(t *testing.T) {
+	guild := newGuild("alice")
+	mods := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermKickMembers}
+	roles := []models.Role{everyoneRole(guild, models.PermViewChannel), mods}
+
+	perms := logic.ComputePermissions(guild, &models.GuildMember{UserID: "bob", Roles: []primitive.ObjectID{mods.ID}}, roles, nil)
+
+	assert.Equal(t, models.PermViewChannel|models.PermKickMembers, perms)
+}
+
+func TestComputePermissions_AdministratorHasAll(t *testing.T) {
+	guild := newGuild("alice")
+	admins := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermAdministrator}
+	roles := []models.Role{everyoneRole(guild, models.DefaultPermissions), admins}
+	channel := newChannel(guild, "staff")
+	channel.Overwrites = []models.PermissionOverwrite{
+		{Type: models.OverwriteMember, ID: "bob", Deny: models.PermViewChannel},
+	}
+
+	perms := logic.ComputePermissions(guild, &models.GuildMember{UserID: "bob", Roles: []primitive.ObjectID{admins.ID}}, roles, channel)
+
+	assert.Equal(t, models.PermAll, perms)
+}
+
+func TestComputePermissions_ChannelOverwrites(t *testing.T) {
+	guild := newGuild("alice")
+	staff := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID}
+	roles := []models.Role{everyoneRole(guild, models.DefaultPermissions), staff}
+
+	channel := newChannel(guild, "announcements")
+	channel.Overwrites = []models.PermissionOverwrite{
+		{Type: models.OverwriteRole, ID: guild.ID.Hex(), Deny: models.PermSendMessages},
+		{Type: models.OverwriteRole, ID: staff.ID.Hex(), Allow: models.PermSendMessages},
+		{Type: models.OverwriteMember, ID: "carol", Deny: models.PermSendMessages},
+	}
+
+	member := logic.ComputePermissions(guild, &models.GuildMember{UserID: "bob"}, roles, channel)
+	staffer := logic.ComputePermissions(guild, &models.GuildMember{UserID: "dave", Roles: []primitive.ObjectID{staff.ID}}, roles, channel)
+	muted := logic.ComputePermissions(guild, &models.GuildMember{UserID: "carol", Roles: []primitive.ObjectID{staff.ID}}, roles, channel)
+
+	assert.Equal(t, models.PermViewChannel, member)
+	assert.Equal(t, models.DefaultPermissions, staffer)
+	assert.Equal(t, models.PermViewChannel, muted)
+}
+
+func TestCreateRole(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	expectMember(repo, ctx, guild, "alice", nil)
+	repo.On("CreateRole", ctx, mock.MatchedBy(func(r *models.Role) bool {
+		return r.GuildID == guild.ID && r.Name == "mods" && r.Permissions == models.PermKickMembers
+	})).Return(nil)
+
+	role, err := service.CreateRole(ctx, "alice", guild.ID, "mods", models.PermKickMembers)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "mods", role.Name)
+	repo.AssertExpectations(t)
+}
+
+func TestCreateRole_CannotGrantMissingPermissions(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	managers := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermManageRoles}
+	expectMember(repo, ctx, guild, "bob", []models.Role{everyoneRole(guild, models.DefaultPermissions), managers}, managers.ID)
+
+	role, err := service.CreateRole(ctx, "bob", guild.ID, "admins", models.PermAdministrator)
+
+	assert.ErrorIs(t, err, logic.ErrMissingPermission)
+	assert.Nil(t, role)
+	repo.AssertNotCalled(t, "CreateRole", mock.Anything, mock.Anything)
+}
+
+func TestDeleteRole_Everyone(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	everyone := everyoneRole(guild, models.DefaultPermissions)
+	repo.On("GetRole", ctx, guild.ID).Return(&everyone, nil)
+
+	err := service.DeleteRole(ctx, "alice", guild.ID)
+
+	assert.ErrorIs(t, err, logic.ErrEveryoneRole)
+	repo.AssertNotCalled(t, "DeleteRole", mock.Anything, mock.Anything)
+}
+
+func TestAssignRole(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	mods := &models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermKickMembers}
+	repo.On("GetRole", ctx, mods.ID).Return(mods, nil)
+	expectMember(repo, ctx, guild, "alice", nil)
+	repo.On("GetMember", ctx, guild.ID, "bob").Return(&models.GuildMember{GuildID: guild.ID, UserID: "bob"}, nil)
+	repo.On("AddMemberRole", ctx, guild.ID, "bob", mods.ID).Return(nil)
+
+	err := service.AssignRole(ctx, "alice", mods.ID, "bob")
+
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestAssignRole_NotAboveMember(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	managers := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermManageRoles | models.PermKickMembers}
+	mods := &models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermKickMembers}
+	repo.On("GetRole", ctx, mods.ID).Return(mods, nil)
+	expectMember(repo, ctx, guild, "bob", []models.Role{managers, *mods}, managers.ID)
+	repo.On("GetMember", ctx, guild.ID, "carol").Return(&models.GuildMember{GuildID: guild.ID, UserID: "carol", Roles: []primitive.ObjectID{managers.ID}}, nil)
+
+	err := service.UnassignRole(ctx, "bob", mods.ID, "carol")
+
+	assert.ErrorIs(t, err, logic.ErrRoleHierarchy)
+	repo.AssertNotCalled(t, "RemoveMemberRole", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestKickMember_AboveMember(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	mods := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermKickMembers}
+	roles := []models.Role{everyoneRole(guild, models.DefaultPermissions), mods}
+	expectMember(repo, ctx, guild, "bob", roles, mods.ID)
+	repo.On("GetMember", ctx, guild.ID, "carol").Return(&models.GuildMember{GuildID: guild.ID, UserID: "carol"}, nil)
+	repo.On("RemoveMember", ctx, guild.ID, "carol").Return(nil)
+
+	err := service.KickMember(ctx, "bob", guild.ID, "carol")
+
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestKickMember_NotAboveMember(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	mods := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermKickMembers}
+	admins := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermAdministrator}
+	roles := []models.Role{everyoneRole(guild, models.DefaultPermissions), mods, admins}
+	expectMember(repo, ctx, guild, "bob", roles, mods.ID)
+
+	for _, carol := range [][]primitive.ObjectID{{mods.ID}, {admins.ID}} {
+		repo.On("GetMember", ctx, guild.ID, "carol").Return(&models.GuildMember{GuildID: guild.ID, UserID: "carol", Roles: carol}, nil).Once()
+
+		err := service.KickMember(ctx, "bob", guild.ID, "carol")
+
+		assert.ErrorIs(t, err, logic.ErrRoleHierarchy)
+	}
+	repo.AssertNotCalled(t, "RemoveMember", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestKickMember_NeedsPermission(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	expectMember(repo, ctx, guild, "bob", []models.Role{everyoneRole(guild, models.DefaultPermissions)})
+
+	err := service.KickMember(ctx, "bob", guild.ID, "carol")
+
+	assert.ErrorIs(t, err, logic.ErrMissingPermission)
+	repo.AssertNotCalled(t, "RemoveMember", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBanMember_NotTheOwner(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	admins := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermAdministrator}
+	expectMember(repo, ctx, guild, "bob", []models.Role{admins}, admins.ID)
+
+	err := service.BanMember(ctx, "bob", guild.ID, "alice")
+
+	assert.ErrorIs(t, err, logic.ErrModerateOwner)
+	repo.AssertNotCalled(t, "BanMember", mock.Anything, mock.Anything)
+}
+
+func TestRemoveChannelOverwrite_CannotLiftMissingDeny(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	managers := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermManageRoles}
+	expectMember(repo, ctx, guild, "bob", []models.Role{everyoneRole(guild, models.DefaultPermissions), managers}, managers.ID)
+
+	channel := newChannel(guild, "announcements")
+	channel.Overwrites = []models.PermissionOverwrite{
+		{Type: models.OverwriteMember, ID: "carol", Deny: models.PermBanMembers},
+	}
+	repo.On("GetChannel", ctx, channel.ID).Return(channel, nil)
+
+	err := service.RemoveChannelOverwrite(ctx, "bob", channel.ID, models.OverwriteMember, "carol")
+
+	assert.ErrorIs(t, err, logic.ErrMissingPermission)
+	repo.AssertNotCalled(t, "RemoveOverwrite", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRemoveChannelOverwrite(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	managers := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermManageRoles}
+	expectMember(repo, ctx, guild, "bob", []models.Role{everyoneRole(guild, models.DefaultPermissions), managers}, managers.ID)
+
+	channel := newChannel(guild, "announcements")
+	channel.Overwrites = []models.PermissionOverwrite{
+		{Type: models.OverwriteMember, ID: "carol", Deny: models.PermSendMessages},
+	}
+	repo.On("GetChannel", ctx, channel.ID).Return(channel, nil)
+	repo.On("RemoveOverwrite", ctx, channel.ID, models.OverwriteMember, "carol").Return(nil)
+
+	err := service.RemoveChannelOverwrite(ctx, "bob", channel.ID, models.OverwriteMember, "carol")
+
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestSetChannelOverwrite_CannotReplaceMissingDeny(t *testing.T) {
+	ctx := context.Background()
+	repo := new(MockGuildRepo)
+	service := logic.NewGuildService(repo, new(MockPublisher))
+
+	guild := newGuild("alice")
+	managers := models.Role{ID: primitive.NewObjectID(), GuildID: guild.ID, Permissions: models.PermManageRoles}
+	expectMember(repo, ctx, guild, "bob", []models.Role{everyoneRole(guild, models.DefaultPermissions), managers}, managers.ID)
+
+	channel := newChannel(guild, "announcements")
+	channel.Overwrites = []models.PermissionOverwrite{
+		{Type: models.OverwriteMember, ID: "carol", Deny: models.PermBanMembers},
+	}
+	repo.On("GetChannel", ctx, channel.ID).Return(channel, nil)
+
+	_, err := service.SetChannelOverwrite(ctx, "bob", channel.ID, models.PermissionOverwrite{Type: models.OverwriteMember, ID: "carol"})
+
+	assert.ErrorIs(t, err, logic.ErrMissingPermission)
+	repo.AssertNotCalled(t, "SetOverwrite", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSendMessageToConversation_ChannelMissingSendPermission(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	channels := new(MockChannelAccess)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), channels)
+
+	channel := newChannel(newGuild("alice"), "announcements")
+
+	mockRepo.On("GetChatByID", ctx, channel.ID).Return(channel, nil)
+	channels.On("ChannelPermissions", ctx, channel, "bob").Return(models.PermViewChannel, nil)
+
+	msg, err := service.SendMessageToConversation(ctx, "bob", channel.ID, "hi")
+
+	assert.ErrorIs(t, err, logic.ErrMissingPermission)
+	assert.Nil(t, msg)
+	mockRepo.AssertNotCalled(t, "AddMessage", mock.Anything, mock.Anything)
+}
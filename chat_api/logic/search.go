@@ -0,0 +1,208 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxSearchLength  = 200
+	maxSnippetLength = 160
+
+	// context kept before the first match of a snippet
+	snippetLead = 40
+)
+
+var (
+	ErrEmptySearch   = errors.New("search text is required")
+	ErrSearchTooLong = errors.New("search text is too long")
+)
+
+// search the conversations a user can view, or just one when the query
+// names it. Results are newest first with a snippet around the matches.
+func (s *ChatService) SearchMessages(ctx context.Context, userID string, query models.SearchQuery) (*models.SearchPage, error) {
+	query.Text = strings.TrimSpace(query.Text)
+	if query.Text == "" {
+		return nil, ErrEmptySearch
+	}
+	if len(query.Text) > maxSearchLength {
+		return nil, ErrSearchTooLong
+	}
+
+	chatIDs, err := s.searchScope(ctx, userID, query.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &models.SearchPage{Results: []models.SearchResult{}}
+	if len(chatIDs) == 0 {
+		return page, nil
+	}
+
+	limit := clampLimit(query.Limit)
+	query.Limit = limit + 1
+
+	messages, err := s.repo.SearchMessages(ctx, chatIDs, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page.HasMore = len(messages) > limit
+	if page.HasMore {
+		messages = messages[:limit]
+	}
+
+	s.prepareMessages(ctx, userID, messages)
+
+	terms := searchTerms(query.Text)
+	for _, message := range messages {
+		snippet, highlights := highlight(message.Content, terms)
+		page.Results = append(page.Results, models.SearchResult{
+			Message:    message,
+			Snippet:    snippet,
+			Highlights: highlights,
+		})
+	}
+	return page, nil
+}
+
+// the conversations to search: the one asked for, or every direct and group
+// chat of the user and every channel they can view
+func (s *ChatService) searchScope(ctx context.Context, userID string, chatID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if !chatID.IsZero() {
+		chat, err := s.GetConversation(ctx, userID, chatID)
+		if err != nil {
+			return nil, err
+		}
+		return []primitive.ObjectID{chat.ID}, nil
+	}
+
+	chats, err := s.repo.GetChatsByUser(ctx, userID, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	channelIDs, err := s.channels.VisibleChannels(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	chatIDs := make([]primitive.ObjectID, 0, len(chats)+len(channelIDs))
+	for _, chat := range chats {
+		chatIDs = append(chatIDs, chat.ID)
+	}
+	return append(chatIDs, channelIDs...), nil
+}
+
+// the words and phrases to highlight, lower case. Excluded words can't be
+// in a result so they're skipped.
+func searchTerms(text string) [][]rune {
+	var terms [][]rune
+
+	fields := strings.Split(text, `"`)
+	for i, field := range fields {
+		// odd fields are between quotes
+		if i%2 == 1 {
+			if phrase := strings.TrimSpace(field); phrase != "" {
+				terms = append(terms, lowerRunes(phrase))
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(field) {
+			if strings.HasPrefix(word, "-") {
+				continue
+			}
+			terms = append(terms, lowerRunes(word))
+		}
+	}
+	return terms
+}
+
+// cut a snippet of content around the first match and find the matches in
+// it. Terms only match whole words, like the text index does.
+func highlight(content string, terms [][]rune) (string, []models.Highlight) {
+	runes := []rune(content)
+	lower := lowerRunes(content)
+
+	var matches []models.Highlight
+	for _, term := range terms {
+		for i := 0; i+len(term) <= len(lower); i++ {
+			if !wordAt(lower, i, term) {
+				continue
+			}
+			matches = append(matches, models.Highlight{Start: i, End: i + len(term)})
+			i += len(term) - 1
+		}
+	}
+
+	start := 0
+	if first := firstMatch(matches); first > snippetLead {
+		start = first - snippetLead
+	}
+	end := start + maxSnippetLength
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	snippet := string(runes[start:end])
+	offset := -start
+	if start > 0 {
+		snippet = "…" + snippet
+		offset++
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+
+	highlights := []models.Highlight{}
+	for _, m := range matches {
+		if m.Start >= start && m.End <= end {
+			highlights = append(highlights, models.Highlight{Start: m.Start + offset, End: m.End + offset})
+		}
+	}
+	return snippet, highlights
+}
+
+func firstMatch(matches []models.Highlight) int {
+	first := -1
+	for _, m := range matches {
+		if first == -1 || m.Start < first {
+			first = m.Start
+		}
+	}
+	return first
+}
+
+// whether term is at i in text with no letters or digits around it
+func wordAt(text []rune, i int, term []rune) bool {
+	for j, r := range term {
+		if text[i+j] != r {
+			return false
+		}
+	}
+
+	if i > 0 && isWordRune(text[i-1]) {
+		return false
+	}
+	end := i + len(term)
+	return end == len(text) || !isWordRune(text[end])
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// lower case rune by rune, so offsets stay those of the original text
+func lowerRunes(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
@@ -0,0 +1,92 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSearchMessages_OwnConversationsAndChannels(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	channels := new(MockChannelAccess)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), channels)
+
+	group := newGroup("alice", "bob")
+	channelID := primitive.NewObjectID()
+	message := newMessage(group, "bob")
+	message.Content = "Are we still on for Lunch tomorrow?"
+
+	query := models.SearchQuery{Text: "lunch", Sender: "bob"}
+	expected := query
+	expected.Limit = logic.DefaultPageSize + 1
+
+	mockRepo.On("GetChatsByUser", ctx, "alice", (*models.Chat)(nil), 0).Return([]models.Chat{*group}, nil)
+	channels.On("VisibleChannels", ctx, "alice").Return([]primitive.ObjectID{channelID}, nil)
+	mockRepo.On("SearchMessages", ctx, []primitive.ObjectID{group.ID, channelID}, expected).Return([]models.Message{*message}, nil)
+
+	page, err := service.SearchMessages(ctx, "alice", query)
+
+	assert.NoError(t, err)
+	assert.False(t, page.HasMore)
+	assert.Len(t, page.Results, 1)
+	assert.Equal(t, message.Content, page.Results[0].Snippet)
+	assert.Equal(t, []models.Highlight{{Start: 20, End: 25}}, page.Results[0].Highlights)
+}
+
+func TestSearchMessages_PhraseSnippet(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	message := newMessage(group, "bob")
+	message.Content = strings.Repeat("x ", 50) + "the release party is friday"
+
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("SearchMessages", ctx, []primitive.ObjectID{group.ID}, mock.Anything).Return([]models.Message{*message}, nil)
+
+	page, err := service.SearchMessages(ctx, "alice", models.SearchQuery{Text: `"release party" -cancelled`, ChatID: group.ID})
+
+	assert.NoError(t, err)
+	result := page.Results[0]
+	assert.True(t, strings.HasPrefix(result.Snippet, "…"))
+	assert.Len(t, result.Highlights, 1)
+
+	runes := []rune(result.Snippet)
+	h := result.Highlights[0]
+	assert.Equal(t, "release party", string(runes[h.Start:h.End]))
+}
+
+func TestSearchMessages_ConversationOfOthers(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	page, err := service.SearchMessages(ctx, "mallory", models.SearchQuery{Text: "secret", ChatID: group.ID})
+
+	assert.ErrorIs(t, err, logic.ErrNotMember)
+	assert.Nil(t, page)
+	mockRepo.AssertNotCalled(t, "SearchMessages", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSearchMessages_EmptyText(t *testing.T) {
+	service := logic.NewChatService(new(MockRepo), new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	page, err := service.SearchMessages(context.Background(), "alice", models.SearchQuery{Text: "   "})
+
+	assert.ErrorIs(t, err, logic.ErrEmptySearch)
+	assert.Nil(t, page)
+}
@@ -3,39 +3,105 @@ package logic
 import (
 	"cloudcord/chat_api/models"
 	"context"
+	"errors"
 	"log"
 	"sort"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// page size of chat history when the client doesn't ask for one
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 100
+)
+
+var (
+	ErrNotMember         = errors.New("not a member of this conversation")
+	ErrMissingPermission = errors.New("missing permission")
+	ErrNotGroup          = errors.New("conversation is not a group")
+	ErrNotOwner          = errors.New("only the owner can do this")
+	ErrOwnerLeaving      = errors.New("the owner cannot leave")
+	ErrMissingName       = errors.New("name is required")
+	ErrEmptyMessage      = errors.New("message content is required")
+	ErrNoNewMembers      = errors.New("no members given")
+	ErrChatWithSelf      = errors.New("cannot start a chat with yourself")
 )
 
 // Define interfaces for dependency inversion
 type ChatRepository interface {
-	AddMessageToChat(ctx context.Context, users []string, message models.Message) error
+	AddMessageToChat(ctx context.Context, users []string, message *models.Message) error
+	AddMessage(ctx context.Context, message *models.Message) error
 	GetChatByUsers(ctx context.Context, users []string) (*models.Chat, error)
+	GetChatByID(ctx context.Context, chatID primitive.ObjectID) (*models.Chat, error)
+	GetMessages(ctx context.Context, chatID primitive.ObjectID, query models.MessageQuery) ([]models.Message, error)
 	CreateChat(ctx context.Context, users []string) (*models.Chat, error)
-	DeleteChatsByAuth0ID(ctx context.Context, auth0ID string) error
+	CreateConversation(ctx context.Context, chat *models.Chat) error
+	AddMembers(ctx context.Context, chatID primitive.ObjectID, users []string) (*models.Chat, error)
+	RemoveMember(ctx context.Context, chatID primitive.ObjectID, userID string) error
+	DeleteChatsByAuth0ID(ctx context.Context, auth0ID string) ([]primitive.ObjectID, error)
+	GetMessage(ctx context.Context, messageID primitive.ObjectID) (*models.Message, error)
+	EditMessage(ctx context.Context, messageID primitive.ObjectID, content string, editedAt time.Time) (*models.Message, error)
+	DeleteMessage(ctx context.Context, messageID primitive.ObjectID, deletedAt time.Time) (*models.Message, error)
+	GetMessagesByIDs(ctx context.Context, messageIDs []primitive.ObjectID) ([]models.Message, error)
+	GetThreadMessages(ctx context.Context, rootID primitive.ObjectID, query models.MessageQuery) ([]models.Message, error)
+	AddThreadReply(ctx context.Context, rootID primitive.ObjectID, at time.Time) error
+	MarkRead(ctx context.Context, chatID primitive.ObjectID, userID string, messageID primitive.ObjectID, at time.Time) (*models.ReadState, *models.ReadState, error)
+	GetReadStates(ctx context.Context, chatID primitive.ObjectID) ([]models.ReadState, error)
+	GetUserReadStates(ctx context.Context, userID string, chatIDs []primitive.ObjectID) ([]models.ReadState, error)
+	CountUnread(ctx context.Context, userID string, chatIDs []primitive.ObjectID, lastRead map[primitive.ObjectID]primitive.ObjectID) (map[primitive.ObjectID]int64, error)
+	GetChatsByUser(ctx context.Context, userID string, before *models.Chat, limit int) ([]models.Chat, error)
+	GetLastMessages(ctx context.Context, chatIDs []primitive.ObjectID) ([]models.Message, error)
+	GetContacts(ctx context.Context, userID string) ([]string, error)
+	SearchMessages(ctx context.Context, chatIDs []primitive.ObjectID, query models.SearchQuery) ([]models.Message, error)
+	AddReaction(ctx context.Context, messageID primitive.ObjectID, reaction models.Reaction) (*models.Message, error)
+	RemoveReaction(ctx context.Context, messageID primitive.ObjectID, reaction models.Reaction) (*models.Message, error)
+	BlockAttachment(ctx context.Context, fileID primitive.ObjectID) ([]models.Message, error)
+	AttachmentsInUse(ctx context.Context, fileIDs []primitive.ObjectID) (bool, error)
 }
 
 type Publisher interface {
 	Publish(msg interface{}) error
 }
 
+// ChannelAccess decides who can use a guild channel, see GuildService
+type ChannelAccess interface {
+	ChannelPermissions(ctx context.Context, channel *models.Chat, userID string) (models.Permission, error)
+	ChannelMembers(ctx context.Context, channel *models.Chat) ([]string, error)
+	VisibleChannels(ctx context.Context, userID string) ([]primitive.ObjectID, error)
+}
+
 // ChatService depends on interfaces, not concrete types
+// publisher feeds the notification queue, events reaches the websocket
+// clients on every replica
 type ChatService struct {
 	repo      ChatRepository
 	publisher Publisher
+	events    Publisher
+	releases  Publisher
+	channels  ChannelAccess
 }
 
-// Constructor takes interfaces now
-func NewChatService(repo ChatRepository, publisher Publisher) *ChatService {
+// Constructor takes interfaces now. Releases tells file storage about
+// attachments of deleted messages.
+func NewChatService(repo ChatRepository, publisher Publisher, events Publisher, releases Publisher, channels ChannelAccess) *ChatService {
 	return &ChatService{
 		repo:      repo,
 		publisher: publisher,
+		events:    events,
+		releases:  releases,
+		channels:  channels,
 	}
 }
 
 // send message to user and publish notification to rabbitmq queue
 func (s *ChatService) SendMessageToUser(ctx context.Context, sender, receiver, content string) error {
+	if err := checkContent(content, nil); err != nil {
+		return err
+	}
+
 	users := []string{sender, receiver}
 	sort.Strings(users)
 
@@ -45,23 +111,169 @@ func (s *ChatService) SendMessageToUser(ctx context.Context, sender, receiver, c
 		Timestamp:  time.Now(),
 	}
 
-	err := s.repo.AddMessageToChat(ctx, users, message)
+	err := s.repo.AddMessageToChat(ctx, users, &message)
 	if err != nil {
 		return err
 	}
 
-	notification := models.MessageNotification{
-		ReceiverID: receiver,
-		Message:    "You have a new message by " + sender,
+	s.publishMessage(users, &message, "You have a new message by "+sender)
+	return nil
+}
+
+// send message to a conversation by its ID, the sender needs permission to
+// send messages there
+func (s *ChatService) SendMessageToConversation(ctx context.Context, sender string, chatID primitive.ObjectID, content string, attachments ...models.Attachment) (*models.Message, error) {
+	if err := checkContent(content, attachments); err != nil {
+		return nil, err
 	}
 
-	if err := s.publisher.Publish(notification); err != nil {
-		log.Printf("Failed to publish notification: %v", err)
+	chat, err := s.authorize(ctx, sender, chatID, models.PermViewChannel|models.PermSendMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAttachments(ctx, attachments); err != nil {
+		return nil, err
+	}
+
+	message := &models.Message{
+		ChatID:      chat.ID,
+		Content:     content,
+		SentByUser:  sender,
+		Timestamp:   time.Now(),
+		Attachments: attachments,
+	}
+
+	if err := s.postMessage(ctx, chat, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// store a message in chat and tell its members
+func (s *ChatService) postMessage(ctx context.Context, chat *models.Chat, message *models.Message) error {
+	if err := s.repo.AddMessage(ctx, message); err != nil {
+		return err
 	}
 
+	if message.ThreadID != nil {
+		if err := s.repo.AddThreadReply(ctx, *message.ThreadID, message.Timestamp); err != nil {
+			log.Printf("Failed to count reply in thread %s: %v", message.ThreadID.Hex(), err)
+		}
+	}
+
+	// channel messages only go out in realtime, notifying a whole guild
+	// about every message would drown the notification queue
+	if chat.IsChannel() {
+		members, err := s.recipients(ctx, chat)
+		if err != nil {
+			log.Printf("Failed to get members of channel %s: %v", chat.ID.Hex(), err)
+			return nil
+		}
+		s.broadcastMessage(members, message)
+		return nil
+	}
+
+	notice := "You have a new message by " + message.SentByUser
+	if chat.IsGroup() {
+		notice += " in " + chat.Name
+	}
+
+	s.publishMessage(chat.Users, message, notice)
 	return nil
 }
 
+// notify every member but the sender through the notification queue and push
+// the message to the websocket clients of all members. Failures are only
+// logged, the message is already stored.
+func (s *ChatService) publishMessage(members []string, message *models.Message, notice string) {
+	for _, member := range members {
+		if member == message.SentByUser {
+			continue
+		}
+
+		notification := models.MessageNotification{
+			ReceiverID: member,
+			Message:    notice,
+		}
+
+		if err := s.publisher.Publish(notification); err != nil {
+			log.Printf("Failed to publish notification: %v", err)
+		}
+	}
+
+	s.broadcastMessage(members, message)
+}
+
+func (s *ChatService) broadcastMessage(members []string, message *models.Message) {
+	s.broadcast(models.EventMessageCreated, members, message)
+}
+
+func (s *ChatService) broadcast(eventType string, members []string, message *models.Message) {
+	s.publishEvent(models.ChatEvent{
+		Type:       eventType,
+		Recipients: members,
+		Message:    message,
+	})
+}
+
+func (s *ChatService) publishEvent(event models.ChatEvent) {
+	if err := s.events.Publish(event); err != nil {
+		log.Printf("Failed to publish chat event: %v", err)
+	}
+}
+
+// get a conversation the user can view
+func (s *ChatService) GetConversation(ctx context.Context, userID string, chatID primitive.ObjectID) (*models.Chat, error) {
+	return s.authorize(ctx, userID, chatID, models.PermViewChannel)
+}
+
+// get a conversation checking the user has perm in it
+func (s *ChatService) authorize(ctx context.Context, userID string, chatID primitive.ObjectID, perm models.Permission) (*models.Chat, error) {
+	chat, err := s.repo.GetChatByID(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	perms, err := s.permissions(ctx, chat, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if perms == 0 {
+		return nil, ErrNotMember
+	}
+	if !perms.Has(perm) {
+		return nil, ErrMissingPermission
+	}
+	return chat, nil
+}
+
+// members of direct and group conversations have the default permissions,
+// the owner of a group also moderates its messages. In guild channels they
+// come from roles and overwrites.
+func (s *ChatService) permissions(ctx context.Context, chat *models.Chat, userID string) (models.Permission, error) {
+	if chat.IsChannel() {
+		return s.channels.ChannelPermissions(ctx, chat, userID)
+	}
+
+	if !chat.HasMember(userID) {
+		return 0, nil
+	}
+	if chat.IsGroup() && chat.OwnerID == userID {
+		return models.DefaultPermissions | models.PermManageMessages, nil
+	}
+	return models.DefaultPermissions, nil
+}
+
+// everyone who receives the events of a conversation
+func (s *ChatService) recipients(ctx context.Context, chat *models.Chat) ([]string, error) {
+	if chat.IsChannel() {
+		return s.channels.ChannelMembers(ctx, chat)
+	}
+	return chat.Users, nil
+}
+
 // get chat by two users
 func (s *ChatService) GetChatByUsers(ctx context.Context, user1, user2 string) (*models.Chat, error) {
 	users := []string{user1, user2}
@@ -70,7 +282,106 @@ func (s *ChatService) GetChatByUsers(ctx context.Context, user1, user2 string) (
 	return s.repo.GetChatByUsers(ctx, users)
 }
 
+// get a page of the direct chat between two users, one of which has to be
+// viewer. Users who never talked get an empty history, nothing is stored
+// until they do.
+func (s *ChatService) GetDirectHistory(ctx context.Context, viewer, user1, user2 string, query models.MessageQuery) (*models.ChatHistory, error) {
+	if viewer != user1 && viewer != user2 {
+		return nil, ErrNotMember
+	}
+
+	chat, err := s.GetChatByUsers(ctx, user1, user2)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		users := []string{user1, user2}
+		sort.Strings(users)
+
+		return &models.ChatHistory{
+			Chat:     models.Chat{Type: models.ChatTypeDirect, Users: users},
+			Messages: []models.Message{},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetChatHistory(ctx, viewer, chat, query)
+}
+
+// get one page of a chat's history. One extra message is fetched to find
+// out whether there is more in the direction being paged. Reactions are
+// counted as seen by viewer.
+func (s *ChatService) GetChatHistory(ctx context.Context, viewer string, chat *models.Chat, query models.MessageQuery) (*models.ChatHistory, error) {
+	messages, hasMore, err := s.page(ctx, viewer, query, func(q models.MessageQuery) ([]models.Message, error) {
+		return s.repo.GetMessages(ctx, chat.ID, q)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	history := &models.ChatHistory{
+		Chat:     *chat,
+		Messages: messages,
+		HasMore:  hasMore,
+	}
+
+	if !chat.IsChannel() {
+		history.ReadStates, err = s.repo.GetReadStates(ctx, chat.ID)
+		if err != nil {
+			log.Printf("Failed to get read states of chat %s: %v", chat.ID.Hex(), err)
+		}
+	}
+	return history, nil
+}
+
+// fetch one page with the query's limit clamped, and get the messages ready
+// for viewer
+func (s *ChatService) page(ctx context.Context, viewer string, query models.MessageQuery, fetch func(models.MessageQuery) ([]models.Message, error)) ([]models.Message, bool, error) {
+	limit := clampLimit(query.Limit)
+	query.Limit = limit + 1
+
+	messages, err := fetch(query)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		if !query.After.IsZero() {
+			messages = messages[:limit]
+		} else {
+			messages = messages[len(messages)-limit:]
+		}
+	}
+
+	s.prepareMessages(ctx, viewer, messages)
+	return messages, hasMore, nil
+}
+
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
+
+// count reactions as seen by viewer and quote the parents of replies
+func (s *ChatService) prepareMessages(ctx context.Context, viewer string, messages []models.Message) {
+	for i := range messages {
+		messages[i].SummarizeReactions(viewer)
+	}
+	s.fillQuotes(ctx, messages)
+}
+
+// start the direct chat between two users, or get it if they already have
+// one. Callers check that both users exist.
 func (s *ChatService) CreateChat(ctx context.Context, user1, user2 string) (*models.Chat, error) {
+	if user1 == user2 {
+		return nil, ErrChatWithSelf
+	}
+
 	users := []string{user1, user2}
 	sort.Strings(users)
 
@@ -78,11 +389,12 @@ func (s *ChatService) CreateChat(ctx context.Context, user1, user2 string) (*mod
 }
 
 func (s *ChatService) DeleteChatsByAuth0ID(ctx context.Context, auth0ID string) error {
-	err := s.repo.DeleteChatsByAuth0ID(ctx, auth0ID)
+	fileIDs, err := s.repo.DeleteChatsByAuth0ID(ctx, auth0ID)
 	if err != nil {
 		log.Printf("Failed to delete chats for user %s: %v", auth0ID, err)
 		return err
 	}
+	releaseFiles(s.releases, fileIDs, "the chats of "+auth0ID)
 	log.Printf("Successfully deleted chats for user %s", auth0ID)
 	return nil
 }
@@ -5,39 +5,183 @@ import (
 	"cloudcord/chat_api/models"
 	"context"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type MockRepo struct {
 	mock.Mock
 }
 
-func (m *MockRepo) AddMessageToChat(ctx context.Context, users []string, message models.Message) error {
+func (m *MockRepo) AddMessageToChat(ctx context.Context, users []string, message *models.Message) error {
 	args := m.Called(ctx, users, message)
 	return args.Error(0)
 }
 
+func (m *MockRepo) AddMessage(ctx context.Context, message *models.Message) error {
+	args := m.Called(ctx, message)
+	return args.Error(0)
+}
+
+func (m *MockRepo) GetChatByID(ctx context.Context, chatID primitive.ObjectID) (*models.Chat, error) {
+	args := m.Called(ctx, chatID)
+	chat, _ := args.Get(0).(*models.Chat)
+	return chat, args.Error(1)
+}
+
+func (m *MockRepo) CreateConversation(ctx context.Context, chat *models.Chat) error {
+	args := m.Called(ctx, chat)
+	return args.Error(0)
+}
+
+func (m *MockRepo) AddMembers(ctx context.Context, chatID primitive.ObjectID, users []string) (*models.Chat, error) {
+	args := m.Called(ctx, chatID, users)
+	chat, _ := args.Get(0).(*models.Chat)
+	return chat, args.Error(1)
+}
+
+func (m *MockRepo) RemoveMember(ctx context.Context, chatID primitive.ObjectID, userID string) error {
+	args := m.Called(ctx, chatID, userID)
+	return args.Error(0)
+}
+
 func (m *MockRepo) GetChatByUsers(ctx context.Context, users []string) (*models.Chat, error) {
 	args := m.Called(ctx, users)
 	chat, _ := args.Get(0).(*models.Chat)
 	return chat, args.Error(1)
 }
 
+func (m *MockRepo) GetMessages(ctx context.Context, chatID primitive.ObjectID, query models.MessageQuery) ([]models.Message, error) {
+	args := m.Called(ctx, chatID, query)
+	messages, _ := args.Get(0).([]models.Message)
+	return messages, args.Error(1)
+}
+
 func (m *MockRepo) CreateChat(ctx context.Context, users []string) (*models.Chat, error) {
 	args := m.Called(ctx, users)
 	chat, _ := args.Get(0).(*models.Chat)
 	return chat, args.Error(1)
 }
 
-func (m *MockRepo) DeleteChatsByAuth0ID(ctx context.Context, auth0ID string) error {
+func (m *MockRepo) DeleteChatsByAuth0ID(ctx context.Context, auth0ID string) ([]primitive.ObjectID, error) {
 	args := m.Called(ctx, auth0ID)
+	fileIDs, _ := args.Get(0).([]primitive.ObjectID)
+	return fileIDs, args.Error(1)
+}
+
+func (m *MockRepo) GetMessage(ctx context.Context, messageID primitive.ObjectID) (*models.Message, error) {
+	args := m.Called(ctx, messageID)
+	message, _ := args.Get(0).(*models.Message)
+	return message, args.Error(1)
+}
+
+func (m *MockRepo) EditMessage(ctx context.Context, messageID primitive.ObjectID, content string, editedAt time.Time) (*models.Message, error) {
+	args := m.Called(ctx, messageID, content, editedAt)
+	message, _ := args.Get(0).(*models.Message)
+	return message, args.Error(1)
+}
+
+func (m *MockRepo) DeleteMessage(ctx context.Context, messageID primitive.ObjectID, deletedAt time.Time) (*models.Message, error) {
+	args := m.Called(ctx, messageID, deletedAt)
+	message, _ := args.Get(0).(*models.Message)
+	return message, args.Error(1)
+}
+
+func (m *MockRepo) AddReaction(ctx context.Context, messageID primitive.ObjectID, reaction models.Reaction) (*models.Message, error) {
+	args := m.Called(ctx, messageID, reaction)
+	message, _ := args.Get(0).(*models.Message)
+	return message, args.Error(1)
+}
+
+func (m *MockRepo) RemoveReaction(ctx context.Context, messageID primitive.ObjectID, reaction models.Reaction) (*models.Message, error) {
+	args := m.Called(ctx, messageID, reaction)
+	message, _ := args.Get(0).(*models.Message)
+	return message, args.Error(1)
+}
+
+func (m *MockRepo) BlockAttachment(ctx context.Context, fileID primitive.ObjectID) ([]models.Message, error) {
+	args := m.Called(ctx, fileID)
+	messages, _ := args.Get(0).([]models.Message)
+	return messages, args.Error(1)
+}
+
+func (m *MockRepo) AttachmentsInUse(ctx context.Context, fileIDs []primitive.ObjectID) (bool, error) {
+	args := m.Called(ctx, fileIDs)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) GetMessagesByIDs(ctx context.Context, messageIDs []primitive.ObjectID) ([]models.Message, error) {
+	args := m.Called(ctx, messageIDs)
+	messages, _ := args.Get(0).([]models.Message)
+	return messages, args.Error(1)
+}
+
+func (m *MockRepo) GetThreadMessages(ctx context.Context, rootID primitive.ObjectID, query models.MessageQuery) ([]models.Message, error) {
+	args := m.Called(ctx, rootID, query)
+	messages, _ := args.Get(0).([]models.Message)
+	return messages, args.Error(1)
+}
+
+func (m *MockRepo) AddThreadReply(ctx context.Context, rootID primitive.ObjectID, at time.Time) error {
+	args := m.Called(ctx, rootID, at)
 	return args.Error(0)
 }
 
+func (m *MockRepo) MarkRead(ctx context.Context, chatID primitive.ObjectID, userID string, messageID primitive.ObjectID, at time.Time) (*models.ReadState, *models.ReadState, error) {
+	args := m.Called(ctx, chatID, userID, messageID, at)
+	before, _ := args.Get(0).(*models.ReadState)
+	after, _ := args.Get(1).(*models.ReadState)
+	return before, after, args.Error(2)
+}
+
+func (m *MockRepo) GetReadStates(ctx context.Context, chatID primitive.ObjectID) ([]models.ReadState, error) {
+	args := m.Called(ctx, chatID)
+	states, _ := args.Get(0).([]models.ReadState)
+	return states, args.Error(1)
+}
+
+func (m *MockRepo) GetUserReadStates(ctx context.Context, userID string, chatIDs []primitive.ObjectID) ([]models.ReadState, error) {
+	args := m.Called(ctx, userID, chatIDs)
+	states, _ := args.Get(0).([]models.ReadState)
+	return states, args.Error(1)
+}
+
+func (m *MockRepo) CountUnread(ctx context.Context, userID string, chatIDs []primitive.ObjectID, lastRead map[primitive.ObjectID]primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+	args := m.Called(ctx, userID, chatIDs, lastRead)
+	counts, _ := args.Get(0).(map[primitive.ObjectID]int64)
+	return counts, args.Error(1)
+}
+
+func (m *MockRepo) GetChatsByUser(ctx context.Context, userID string, before *models.Chat, limit int) ([]models.Chat, error) {
+	args := m.Called(ctx, userID, before, limit)
+	chats, _ := args.Get(0).([]models.Chat)
+	return chats, args.Error(1)
+}
+
+func (m *MockRepo) GetLastMessages(ctx context.Context, chatIDs []primitive.ObjectID) ([]models.Message, error) {
+	args := m.Called(ctx, chatIDs)
+	messages, _ := args.Get(0).([]models.Message)
+	return messages, args.Error(1)
+}
+
+func (m *MockRepo) GetContacts(ctx context.Context, userID string) ([]string, error) {
+	args := m.Called(ctx, userID)
+	contacts, _ := args.Get(0).([]string)
+	return contacts, args.Error(1)
+}
+
+func (m *MockRepo) SearchMessages(ctx context.Context, chatIDs []primitive.ObjectID, query models.SearchQuery) ([]models.Message, error) {
+	args := m.Called(ctx, chatIDs, query)
+	messages, _ := args.Get(0).([]models.Message)
+	return messages, args.Error(1)
+}
+
 type MockPublisher struct {
 	mock.Mock
 }
@@ -47,12 +191,36 @@ func (m *MockPublisher) Publish(msg interface{}) error {
 	return args.Error(0)
 }
 
+type MockChannelAccess struct {
+	mock.Mock
+}
+
+func (m *MockChannelAccess) ChannelPermissions(ctx context.Context, channel *models.Chat, userID string) (models.Permission, error) {
+	args := m.Called(ctx, channel, userID)
+	return args.Get(0).(models.Permission), args.Error(1)
+}
+
+func (m *MockChannelAccess) ChannelMembers(ctx context.Context, channel *models.Chat) ([]string, error) {
+	args := m.Called(ctx, channel)
+	if members := args.Get(0); members != nil {
+		return members.([]string), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockChannelAccess) VisibleChannels(ctx context.Context, userID string) ([]primitive.ObjectID, error) {
+	args := m.Called(ctx, userID)
+	channelIDs, _ := args.Get(0).([]primitive.ObjectID)
+	return channelIDs, args.Error(1)
+}
+
 func TestSendMessageToUser(t *testing.T) {
 	ctx := context.Background()
 	mockRepo := new(MockRepo)
 	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
 
-	service := logic.NewChatService(mockRepo, mockPub)
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
 
 	sender := "alice"
 	receiver := "bob"
@@ -61,7 +229,7 @@ func TestSendMessageToUser(t *testing.T) {
 	users := []string{sender, receiver}
 	sort.Strings(users)
 
-	msgMatcher := mock.MatchedBy(func(m models.Message) bool {
+	msgMatcher := mock.MatchedBy(func(m *models.Message) bool {
 		return m.Content == content && m.SentByUser == sender
 	})
 
@@ -70,14 +238,22 @@ func TestSendMessageToUser(t *testing.T) {
 		Message:    "You have a new message by " + sender,
 	}
 
+	eventMatcher := mock.MatchedBy(func(e models.ChatEvent) bool {
+		return e.Type == models.EventMessageCreated &&
+			assert.ObjectsAreEqual(users, e.Recipients) &&
+			e.Message != nil && e.Message.Content == content
+	})
+
 	mockRepo.On("AddMessageToChat", ctx, users, msgMatcher).Return(nil)
 	mockPub.On("Publish", notification).Return(nil)
+	mockEvents.On("Publish", eventMatcher).Return(nil)
 
 	err := service.SendMessageToUser(ctx, sender, receiver, content)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 	mockPub.AssertExpectations(t)
+	mockEvents.AssertExpectations(t)
 }
 
 // Test SendMessageToUser when AddMessageToChat fails
@@ -85,8 +261,9 @@ func TestSendMessageToUser_AddMessageFails(t *testing.T) {
 	ctx := context.Background()
 	mockRepo := new(MockRepo)
 	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
 
-	service := logic.NewChatService(mockRepo, mockPub)
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
 
 	sender := "alice"
 	receiver := "bob"
@@ -95,7 +272,7 @@ func TestSendMessageToUser_AddMessageFails(t *testing.T) {
 	users := []string{sender, receiver}
 	sort.Strings(users)
 
-	msgMatcher := mock.MatchedBy(func(m models.Message) bool {
+	msgMatcher := mock.MatchedBy(func(m *models.Message) bool {
 		return m.Content == content && m.SentByUser == sender
 	})
 
@@ -106,6 +283,7 @@ func TestSendMessageToUser_AddMessageFails(t *testing.T) {
 	assert.Error(t, err)
 	mockRepo.AssertExpectations(t)
 	mockPub.AssertNotCalled(t, "Publish", mock.Anything)
+	mockEvents.AssertNotCalled(t, "Publish", mock.Anything)
 }
 
 // Test SendMessageToUser when Publish fails (should not return error)
@@ -113,8 +291,9 @@ func TestSendMessageToUser_PublishFails(t *testing.T) {
 	ctx := context.Background()
 	mockRepo := new(MockRepo)
 	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
 
-	service := logic.NewChatService(mockRepo, mockPub)
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
 
 	sender := "alice"
 	receiver := "bob"
@@ -123,7 +302,7 @@ func TestSendMessageToUser_PublishFails(t *testing.T) {
 	users := []string{sender, receiver}
 	sort.Strings(users)
 
-	msgMatcher := mock.MatchedBy(func(m models.Message) bool {
+	msgMatcher := mock.MatchedBy(func(m *models.Message) bool {
 		return m.Content == content && m.SentByUser == sender
 	})
 
@@ -134,20 +313,62 @@ func TestSendMessageToUser_PublishFails(t *testing.T) {
 
 	mockRepo.On("AddMessageToChat", ctx, users, msgMatcher).Return(nil)
 	mockPub.On("Publish", notification).Return(assert.AnError)
+	mockEvents.On("Publish", mock.AnythingOfType("models.ChatEvent")).Return(nil)
 
 	err := service.SendMessageToUser(ctx, sender, receiver, content)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 	mockPub.AssertExpectations(t)
+	mockEvents.AssertExpectations(t)
+}
+
+// Test SendMessageToUser when the realtime broadcast fails (should not return error)
+func TestSendMessageToUser_BroadcastFails(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	users := []string{"alice", "bob"}
+
+	mockRepo.On("AddMessageToChat", ctx, users, mock.AnythingOfType("*models.Message")).Return(nil)
+	mockPub.On("Publish", mock.AnythingOfType("models.MessageNotification")).Return(nil)
+	mockEvents.On("Publish", mock.AnythingOfType("models.ChatEvent")).Return(assert.AnError)
+
+	err := service.SendMessageToUser(ctx, "alice", "bob", "Hey Bob!")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockEvents.AssertExpectations(t)
+}
+
+func TestSendMessageToUser_InvalidContent(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	err := service.SendMessageToUser(ctx, "alice", "bob", "")
+	assert.ErrorIs(t, err, logic.ErrEmptyMessage)
+
+	err = service.SendMessageToUser(ctx, "alice", "bob", strings.Repeat("é", logic.MaxMessageLength+1))
+	assert.ErrorIs(t, err, logic.ErrMessageTooLong)
+
+	mockRepo.AssertNotCalled(t, "AddMessageToChat", mock.Anything, mock.Anything, mock.Anything)
+	mockPub.AssertNotCalled(t, "Publish", mock.Anything)
 }
 
 func TestGetChatByUsers(t *testing.T) {
 	ctx := context.Background()
 	mockRepo := new(MockRepo)
 	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
 
-	service := logic.NewChatService(mockRepo, mockPub)
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
 
 	user1 := "alice"
 	user2 := "bob"
@@ -155,10 +376,9 @@ func TestGetChatByUsers(t *testing.T) {
 	sort.Strings(users)
 
 	expectedChat := &models.Chat{
-		Users: users,
-		Messages: []models.Message{
-			{Content: "Hi", SentByUser: "alice", Timestamp: time.Now()},
-		},
+		ID:            primitive.NewObjectID(),
+		Users:         users,
+		LastMessageAt: time.Now(),
 	}
 
 	mockRepo.On("GetChatByUsers", ctx, users).Return(expectedChat, nil)
@@ -175,8 +395,9 @@ func TestGetChatByUsers_RepoFails(t *testing.T) {
 	ctx := context.Background()
 	mockRepo := new(MockRepo)
 	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
 
-	service := logic.NewChatService(mockRepo, mockPub)
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
 
 	user1 := "alice"
 	user2 := "bob"
@@ -192,13 +413,109 @@ func TestGetChatByUsers_RepoFails(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
+func TestGetChatHistory_LatestPage(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	chat := &models.Chat{ID: primitive.NewObjectID(), Users: []string{"alice", "bob"}}
+	messages := []models.Message{
+		{ID: primitive.NewObjectID(), Content: "1"},
+		{ID: primitive.NewObjectID(), Content: "2"},
+		{ID: primitive.NewObjectID(), Content: "3"},
+	}
+
+	mockRepo.On("GetMessages", ctx, chat.ID, models.MessageQuery{Limit: 3}).Return(messages, nil)
+	mockRepo.On("GetReadStates", ctx, chat.ID).Return([]models.ReadState{}, nil)
+
+	history, err := service.GetChatHistory(ctx, "alice", chat, models.MessageQuery{Limit: 2})
+
+	assert.NoError(t, err)
+	assert.True(t, history.HasMore)
+	assert.Equal(t, messages[1:], history.Messages)
+	assert.Equal(t, chat.ID, history.ID)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetChatHistory_AfterCursor(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	chat := &models.Chat{ID: primitive.NewObjectID(), Users: []string{"alice", "bob"}}
+	after := primitive.NewObjectID()
+	messages := []models.Message{
+		{ID: primitive.NewObjectID(), Content: "1"},
+		{ID: primitive.NewObjectID(), Content: "2"},
+		{ID: primitive.NewObjectID(), Content: "3"},
+	}
+
+	mockRepo.On("GetMessages", ctx, chat.ID, models.MessageQuery{After: after, Limit: 3}).Return(messages, nil)
+	mockRepo.On("GetReadStates", ctx, chat.ID).Return([]models.ReadState{}, nil)
+
+	history, err := service.GetChatHistory(ctx, "alice", chat, models.MessageQuery{After: after, Limit: 2})
+
+	assert.NoError(t, err)
+	assert.True(t, history.HasMore)
+	assert.Equal(t, messages[:2], history.Messages)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetChatHistory_DefaultLimit(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	chat := &models.Chat{ID: primitive.NewObjectID()}
+	messages := []models.Message{{ID: primitive.NewObjectID(), Content: "only"}}
+
+	mockRepo.On("GetMessages", ctx, chat.ID, models.MessageQuery{Limit: logic.DefaultPageSize + 1}).Return(messages, nil)
+	mockRepo.On("GetReadStates", ctx, chat.ID).Return([]models.ReadState{}, nil)
+
+	history, err := service.GetChatHistory(ctx, "alice", chat, models.MessageQuery{})
+
+	assert.NoError(t, err)
+	assert.False(t, history.HasMore)
+	assert.Equal(t, messages, history.Messages)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGetChatHistory_RepoFails(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	chat := &models.Chat{ID: primitive.NewObjectID()}
+
+	mockRepo.On("GetMessages", ctx, chat.ID, mock.Anything).Return(nil, assert.AnError)
+
+	history, err := service.GetChatHistory(ctx, "alice", chat, models.MessageQuery{Limit: 500})
+
+	assert.Error(t, err)
+	assert.Nil(t, history)
+	mockRepo.AssertExpectations(t)
+}
+
 // Test successful chat creation
 func TestCreateChat(t *testing.T) {
 	ctx := context.Background()
 	mockRepo := new(MockRepo)
 	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
 
-	service := logic.NewChatService(mockRepo, mockPub)
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
 
 	user1 := "alice"
 	user2 := "bob"
@@ -206,8 +523,7 @@ func TestCreateChat(t *testing.T) {
 	sort.Strings(users)
 
 	expectedChat := &models.Chat{
-		Users:    users,
-		Messages: []models.Message{},
+		Users: users,
 	}
 
 	mockRepo.On("CreateChat", ctx, users).Return(expectedChat, nil)
@@ -224,8 +540,9 @@ func TestCreateChat_RepoFails(t *testing.T) {
 	ctx := context.Background()
 	mockRepo := new(MockRepo)
 	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
 
-	service := logic.NewChatService(mockRepo, mockPub)
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
 
 	user1 := "alice"
 	user2 := "bob"
@@ -241,16 +558,61 @@ func TestCreateChat_RepoFails(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
+func TestCreateChat_WithSelf(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	chat, err := service.CreateChat(ctx, "alice", "alice")
+
+	assert.ErrorIs(t, err, logic.ErrChatWithSelf)
+	assert.Nil(t, chat)
+	mockRepo.AssertNotCalled(t, "CreateChat", mock.Anything, mock.Anything)
+}
+
+// Users who never talked get an empty history and no chat is created
+func TestGetDirectHistory_NoChatYet(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	mockRepo.On("GetChatByUsers", ctx, []string{"alice", "bob"}).Return(nil, mongo.ErrNoDocuments)
+
+	history, err := service.GetDirectHistory(ctx, "bob", "bob", "alice", models.MessageQuery{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, history.Users)
+	assert.Empty(t, history.Messages)
+	assert.False(t, history.HasMore)
+	mockRepo.AssertNotCalled(t, "CreateChat", mock.Anything, mock.Anything)
+}
+
+func TestGetDirectHistory_NotAParticipant(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	history, err := service.GetDirectHistory(ctx, "carol", "alice", "bob", models.MessageQuery{})
+
+	assert.ErrorIs(t, err, logic.ErrNotMember)
+	assert.Nil(t, history)
+	mockRepo.AssertNotCalled(t, "GetChatByUsers", mock.Anything, mock.Anything)
+}
+
 func TestDeleteChatsByAuth0ID(t *testing.T) {
 	ctx := context.Background()
 	mockRepo := new(MockRepo)
 	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
 
-	service := logic.NewChatService(mockRepo, mockPub)
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
 
 	auth0ID := "auth0|123456"
 
-	mockRepo.On("DeleteChatsByAuth0ID", ctx, auth0ID).Return(nil)
+	mockRepo.On("DeleteChatsByAuth0ID", ctx, auth0ID).Return(nil, nil)
 
 	err := service.DeleteChatsByAuth0ID(ctx, auth0ID)
 
@@ -258,17 +620,37 @@ func TestDeleteChatsByAuth0ID(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
+func TestDeleteChatsByAuth0ID_ReleasesAttachments(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockReleases := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), mockReleases, new(MockChannelAccess))
+
+	auth0ID := "auth0|123456"
+	fileIDs := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+
+	mockRepo.On("DeleteChatsByAuth0ID", ctx, auth0ID).Return(fileIDs, nil)
+	mockReleases.On("Publish", models.AttachmentsReleased{FileIDs: fileIDs}).Return(nil)
+
+	err := service.DeleteChatsByAuth0ID(ctx, auth0ID)
+
+	assert.NoError(t, err)
+	mockReleases.AssertExpectations(t)
+}
+
 // test delete chat failure
 func TestDeleteChatsByAuth0ID_RepoFails(t *testing.T) {
 	ctx := context.Background()
 	mockRepo := new(MockRepo)
 	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
 
-	service := logic.NewChatService(mockRepo, mockPub)
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
 
 	auth0ID := "auth0|fail-case"
 
-	mockRepo.On("DeleteChatsByAuth0ID", ctx, auth0ID).Return(assert.AnError)
+	mockRepo.On("DeleteChatsByAuth0ID", ctx, auth0ID).Return(nil, assert.AnError)
 
 	err := service.DeleteChatsByAuth0ID(ctx, auth0ID)
 
@@ -0,0 +1,199 @@
+package logic
+
+import (
+	"cloudcord/chat_api/models"
+	"context"
+	"errors"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// quotes show the start of the parent message
+const maxQuoteLength = 200
+
+var ErrReplyElsewhere = errors.New("reply_to is not in this conversation")
+
+// reply to a message in its conversation, quoting it. chatID is optional,
+// when set the parent has to be in that conversation. Replies to messages in
+// a thread stay in the thread.
+func (s *ChatService) ReplyToMessage(ctx context.Context, sender string, chatID, parentID primitive.ObjectID, content string, attachments ...models.Attachment) (*models.Message, error) {
+	if err := checkContent(content, attachments); err != nil {
+		return nil, err
+	}
+
+	parent, err := s.repo.GetMessage(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !chatID.IsZero() && parent.ChatID != chatID {
+		return nil, ErrReplyElsewhere
+	}
+
+	chat, err := s.authorize(ctx, sender, parent.ChatID, models.PermViewChannel|models.PermSendMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAttachments(ctx, attachments); err != nil {
+		return nil, err
+	}
+
+	message := &models.Message{
+		ChatID:      chat.ID,
+		Content:     content,
+		SentByUser:  sender,
+		Timestamp:   time.Now(),
+		ReplyTo:     &parent.ID,
+		ThreadID:    parent.ThreadID,
+		Attachments: attachments,
+	}
+	message.Quote = quoteOf(parent)
+
+	if err := s.postMessage(ctx, chat, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// post a message in the thread on rootID, starting the thread if it's the
+// first. replyTo optionally quotes another message of the thread.
+func (s *ChatService) SendThreadMessage(ctx context.Context, sender string, rootID primitive.ObjectID, content string, replyTo primitive.ObjectID) (*models.Message, error) {
+	if err := checkContent(content, nil); err != nil {
+		return nil, err
+	}
+
+	root, chat, err := s.threadRoot(ctx, sender, rootID, models.PermViewChannel|models.PermSendMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	if root.Deleted {
+		return nil, ErrMessageDeleted
+	}
+
+	message := &models.Message{
+		ChatID:     chat.ID,
+		Content:    content,
+		SentByUser: sender,
+		Timestamp:  time.Now(),
+		ThreadID:   &root.ID,
+	}
+
+	if !replyTo.IsZero() {
+		parent, err := s.repo.GetMessage(ctx, replyTo)
+		if err != nil {
+			return nil, err
+		}
+		if parent.ID != root.ID && (parent.ThreadID == nil || *parent.ThreadID != root.ID) {
+			return nil, ErrReplyElsewhere
+		}
+		message.ReplyTo = &parent.ID
+		message.Quote = quoteOf(parent)
+	}
+
+	if err := s.postMessage(ctx, chat, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// get the message a thread was started on with a page of the thread
+func (s *ChatService) GetThread(ctx context.Context, viewer string, rootID primitive.ObjectID, query models.MessageQuery) (*models.ThreadHistory, error) {
+	root, _, err := s.threadRoot(ctx, viewer, rootID, models.PermViewChannel)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, hasMore, err := s.page(ctx, viewer, query, func(q models.MessageQuery) ([]models.Message, error) {
+		return s.repo.GetThreadMessages(ctx, root.ID, q)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	roots := []models.Message{*root}
+	s.prepareMessages(ctx, viewer, roots)
+
+	return &models.ThreadHistory{
+		Root:     roots[0],
+		Messages: messages,
+		HasMore:  hasMore,
+	}, nil
+}
+
+// load the message a thread is on, checking perm in its conversation.
+// Threads aren't nested, a message inside a thread leads to that thread.
+func (s *ChatService) threadRoot(ctx context.Context, userID string, messageID primitive.ObjectID, perm models.Permission) (*models.Message, *models.Chat, error) {
+	root, err := s.repo.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if root.ThreadID != nil {
+		if root, err = s.repo.GetMessage(ctx, *root.ThreadID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	chat, err := s.authorize(ctx, userID, root.ChatID, perm)
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, chat, nil
+}
+
+// fill in the quotes of replies with one lookup of their parents. A missing
+// parent just leaves the quote out.
+func (s *ChatService) fillQuotes(ctx context.Context, messages []models.Message) {
+	var parentIDs []primitive.ObjectID
+	for _, m := range messages {
+		if m.ReplyTo != nil {
+			parentIDs = append(parentIDs, *m.ReplyTo)
+		}
+	}
+	if len(parentIDs) == 0 {
+		return
+	}
+
+	parents, err := s.repo.GetMessagesByIDs(ctx, parentIDs)
+	if err != nil {
+		log.Printf("Failed to load quoted messages: %v", err)
+		return
+	}
+
+	byID := make(map[primitive.ObjectID]*models.Message, len(parents))
+	for i := range parents {
+		byID[parents[i].ID] = &parents[i]
+	}
+
+	for i := range messages {
+		if messages[i].ReplyTo == nil {
+			continue
+		}
+		if parent, ok := byID[*messages[i].ReplyTo]; ok {
+			messages[i].Quote = quoteOf(parent)
+		}
+	}
+}
+
+func quoteOf(parent *models.Message) *models.Quote {
+	return &models.Quote{
+		ID:         parent.ID,
+		SentByUser: parent.SentByUser,
+		Content:    truncate(parent.Content, maxQuoteLength),
+		Deleted:    parent.Deleted,
+	}
+}
+
+// cut content to at most n runes, marking that it was cut
+func truncate(content string, n int) string {
+	if utf8.RuneCountInString(content) <= n {
+		return content
+	}
+	runes := []rune(content)
+	return string(runes[:n]) + "…"
+}
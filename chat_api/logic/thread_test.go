@@ -0,0 +1,178 @@
+package logic_test
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/models"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReplyToMessage_QuotesParent(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	parent := newMessage(group, "alice")
+
+	mockRepo.On("GetMessage", ctx, parent.ID).Return(parent, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("AddMessage", ctx, mock.MatchedBy(func(m *models.Message) bool {
+		return m.ReplyTo != nil && *m.ReplyTo == parent.ID && m.ThreadID == nil
+	})).Return(nil)
+	mockPub.On("Publish", mock.Anything).Return(nil)
+	mockEvents.On("Publish", mock.Anything).Return(nil)
+
+	reply, err := service.ReplyToMessage(ctx, "bob", primitive.NilObjectID, parent.ID, "agreed")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &models.Quote{ID: parent.ID, SentByUser: "alice", Content: "hello"}, reply.Quote)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestReplyToMessage_OtherConversation(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	parent := newMessage(newGroup("alice", "bob"), "alice")
+	mockRepo.On("GetMessage", ctx, parent.ID).Return(parent, nil)
+
+	_, err := service.ReplyToMessage(ctx, "bob", primitive.NewObjectID(), parent.ID, "agreed")
+
+	assert.ErrorIs(t, err, logic.ErrReplyElsewhere)
+	mockRepo.AssertNotCalled(t, "AddMessage", mock.Anything, mock.Anything)
+}
+
+func TestReplyToMessage_StaysInThread(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	root := newMessage(group, "alice")
+	parent := newMessage(group, "alice")
+	parent.ThreadID = &root.ID
+
+	mockRepo.On("GetMessage", ctx, parent.ID).Return(parent, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("AddMessage", ctx, mock.MatchedBy(func(m *models.Message) bool {
+		return m.ThreadID != nil && *m.ThreadID == root.ID
+	})).Return(nil)
+	mockRepo.On("AddThreadReply", ctx, root.ID, mock.Anything).Return(nil)
+	mockPub.On("Publish", mock.Anything).Return(nil)
+	mockEvents.On("Publish", mock.Anything).Return(nil)
+
+	_, err := service.ReplyToMessage(ctx, "bob", group.ID, parent.ID, "in the thread")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSendThreadMessage_CountsReply(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+	mockPub := new(MockPublisher)
+	mockEvents := new(MockPublisher)
+
+	service := logic.NewChatService(mockRepo, mockPub, mockEvents, new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	root := newMessage(group, "alice")
+
+	mockRepo.On("GetMessage", ctx, root.ID).Return(root, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("AddMessage", ctx, mock.MatchedBy(func(m *models.Message) bool {
+		return m.ThreadID != nil && *m.ThreadID == root.ID && m.ReplyTo == nil
+	})).Return(nil)
+	mockRepo.On("AddThreadReply", ctx, root.ID, mock.Anything).Return(nil)
+	mockPub.On("Publish", models.MessageNotification{ReceiverID: "alice", Message: "You have a new message by bob in team"}).Return(nil)
+	mockEvents.On("Publish", mock.Anything).Return(nil)
+
+	msg, err := service.SendThreadMessage(ctx, "bob", root.ID, "first!", primitive.NilObjectID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, root.ID, *msg.ThreadID)
+	mockRepo.AssertExpectations(t)
+	mockPub.AssertExpectations(t)
+}
+
+func TestSendThreadMessage_ReplyToOutsideThread(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	root := newMessage(group, "alice")
+	other := newMessage(group, "bob")
+
+	mockRepo.On("GetMessage", ctx, root.ID).Return(root, nil)
+	mockRepo.On("GetMessage", ctx, other.ID).Return(other, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+
+	_, err := service.SendThreadMessage(ctx, "bob", root.ID, "hm", other.ID)
+
+	assert.ErrorIs(t, err, logic.ErrReplyElsewhere)
+	mockRepo.AssertNotCalled(t, "AddMessage", mock.Anything, mock.Anything)
+}
+
+func TestGetThread(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	group := newGroup("alice", "bob")
+	root := newMessage(group, "alice")
+	root.ThreadReplyCount = 3
+	replies := []models.Message{*newMessage(group, "bob"), *newMessage(group, "alice"), *newMessage(group, "bob")}
+
+	mockRepo.On("GetMessage", ctx, root.ID).Return(root, nil)
+	mockRepo.On("GetChatByID", ctx, group.ID).Return(group, nil)
+	mockRepo.On("GetThreadMessages", ctx, root.ID, models.MessageQuery{Limit: 3}).Return(replies, nil)
+
+	thread, err := service.GetThread(ctx, "bob", root.ID, models.MessageQuery{Limit: 2})
+
+	assert.NoError(t, err)
+	assert.Equal(t, root.ID, thread.Root.ID)
+	assert.Equal(t, 3, thread.Root.ThreadReplyCount)
+	assert.Len(t, thread.Messages, 2)
+	assert.True(t, thread.HasMore)
+}
+
+func TestGetChatHistory_FillsQuotes(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepo)
+
+	service := logic.NewChatService(mockRepo, new(MockPublisher), new(MockPublisher), new(MockPublisher), new(MockChannelAccess))
+
+	chat := &models.Chat{ID: primitive.NewObjectID(), Users: []string{"alice", "bob"}}
+	parent := newMessage(chat, "alice")
+	parent.Content = strings.Repeat("a", 300)
+	reply := newMessage(chat, "bob")
+	reply.ReplyTo = &parent.ID
+
+	mockRepo.On("GetMessages", ctx, chat.ID, mock.Anything).Return([]models.Message{*reply}, nil)
+	mockRepo.On("GetReadStates", ctx, chat.ID).Return([]models.ReadState{}, nil)
+	mockRepo.On("GetMessagesByIDs", ctx, []primitive.ObjectID{parent.ID}).Return([]models.Message{*parent}, nil)
+
+	history, err := service.GetChatHistory(ctx, "alice", chat, models.MessageQuery{})
+
+	assert.NoError(t, err)
+	quote := history.Messages[0].Quote
+	assert.Equal(t, parent.ID, quote.ID)
+	assert.Equal(t, strings.Repeat("a", 200)+"…", quote.Content)
+}
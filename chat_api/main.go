@@ -1,19 +1,30 @@
 package main
 
 import (
+	"bufio"
 	"cloudcord/chat_api/db"
+	"cloudcord/chat_api/files"
 	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/middleware"
+	"cloudcord/chat_api/models"
 	"cloudcord/chat_api/mq"
+	"cloudcord/chat_api/realtime"
+	"cloudcord/chat_api/users"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -57,6 +68,16 @@ func (rw *statusResponseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// websocket upgrades need the underlying connection
+func (rw *statusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
 func metricsMiddleware(path string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -79,32 +100,108 @@ type createChatRequest struct {
 	Content string `json:"content"`
 }
 
+// a message goes either to a conversation by ID or to the direct chat with
+// receiver. A reply goes to the conversation of the message in reply_to.
 type sendMessageRequest struct {
-	Sender   string `json:"sender"`
-	Receiver string `json:"receiver"`
-	Content  string `json:"content"`
+	Sender         string   `json:"sender"`
+	Receiver       string   `json:"receiver"`
+	ConversationID string   `json:"conversation_id"`
+	ReplyTo        string   `json:"reply_to"`
+	Content        string   `json:"content"`
+	Attachments    []string `json:"attachments"`
 }
 
-func sendMessageHandler(chatLogic *logic.ChatService) http.HandlerFunc {
+// a message can carry files uploaded to file_storage_api, attachments need a
+// conversation_id as uploads belong to a conversation
+func sendMessageHandler(chatLogic *logic.ChatService, fileStore *files.Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
 		var req sendMessageRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		if req.Sender == "" {
+			req.Sender = auth0ID
+		}
+		if req.Sender != auth0ID {
+			http.Error(w, "Sender does not match the authenticated user", http.StatusForbidden)
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 
-		err := chatLogic.SendMessageToUser(ctx, req.Sender, req.Receiver, req.Content)
-		if err != nil {
-			http.Error(w, "Failed to send message: "+err.Error(), http.StatusInternalServerError)
-			return
+		var chatID primitive.ObjectID
+		if req.ConversationID != "" {
+			id, err := primitive.ObjectIDFromHex(req.ConversationID)
+			if err != nil {
+				http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+				return
+			}
+			chatID = id
+		}
+
+		var attachments []models.Attachment
+		if len(req.Attachments) > 0 {
+			if chatID.IsZero() {
+				http.Error(w, "Attachments need a conversation_id", http.StatusBadRequest)
+				return
+			}
+			if len(req.Attachments) > logic.MaxAttachments {
+				http.Error(w, "Failed to send message: "+logic.ErrTooManyAttachments.Error(), http.StatusBadRequest)
+				return
+			}
+
+			resolved, err := resolveAttachments(ctx, fileStore, r.Header.Get("Authorization"), req.Sender, chatID, req.Attachments)
+			if err != nil {
+				http.Error(w, "Invalid attachments: "+err.Error(), statusForError(err))
+				return
+			}
+			attachments = resolved
+		}
+
+		if req.ReplyTo != "" {
+			parentID, err := primitive.ObjectIDFromHex(req.ReplyTo)
+			if err != nil {
+				http.Error(w, "Invalid reply_to", http.StatusBadRequest)
+				return
+			}
+
+			_, err = chatLogic.ReplyToMessage(ctx, req.Sender, chatID, parentID, req.Content, attachments...)
+			if err != nil {
+				http.Error(w, "Failed to send message: "+err.Error(), statusForError(err))
+				return
+			}
+		} else if !chatID.IsZero() {
+			_, err := chatLogic.SendMessageToConversation(ctx, req.Sender, chatID, req.Content, attachments...)
+			if err != nil {
+				http.Error(w, "Failed to send message: "+err.Error(), statusForError(err))
+				return
+			}
+		} else {
+			if req.Receiver == "" {
+				http.Error(w, "Missing receiver or conversation_id", http.StatusBadRequest)
+				return
+			}
+
+			// the chat has to be started with POST /message/chat first
+			err := chatLogic.SendMessageToUser(ctx, req.Sender, req.Receiver, req.Content)
+			if err != nil {
+				http.Error(w, "Failed to send message: "+err.Error(), statusForError(err))
+				return
+			}
 		}
 
 		w.WriteHeader(http.StatusOK)
@@ -112,56 +209,367 @@ func sendMessageHandler(chatLogic *logic.ChatService) http.HandlerFunc {
 	}
 }
 
-// get chat by two users
-func getChatHandler(chatLogic *logic.ChatService) http.HandlerFunc {
+// look up the files to attach, each must be an upload of the sender to the
+// conversation the message goes to
+func resolveAttachments(ctx context.Context, fileStore *files.Client, authorization, sender string, chatID primitive.ObjectID, fileIDs []string) ([]models.Attachment, error) {
+	attachments := make([]models.Attachment, 0, len(fileIDs))
+	for _, hexID := range fileIDs {
+		fileID, err := primitive.ObjectIDFromHex(hexID)
+		if err != nil {
+			return nil, files.ErrFileNotFound
+		}
+
+		file, err := fileStore.Get(ctx, authorization, fileID)
+		if err != nil {
+			return nil, err
+		}
+		if file.ConversationID != chatID || file.UploadedBy != sender {
+			return nil, errForeignAttachment
+		}
+
+		attachments = append(attachments, models.Attachment{
+			ID:          file.ID,
+			Name:        file.Name,
+			ContentType: file.ContentType,
+			Size:        file.Size,
+			Blocked:     file.Scan == files.ScanQuarantined,
+		})
+	}
+	return attachments, nil
+}
+
+// fill in signed download links for the attachments of messages. Without
+// them clients still download through the API, so failing is only logged.
+func linkAttachments(ctx context.Context, fileStore *files.Client, authorization string, messages []models.Message) {
+	var fileIDs []primitive.ObjectID
+	for _, message := range messages {
+		for _, attachment := range message.Attachments {
+			if !attachment.Blocked {
+				fileIDs = append(fileIDs, attachment.ID)
+			}
+		}
+	}
+	if len(fileIDs) == 0 {
+		return
+	}
+
+	links, err := fileStore.Links(ctx, authorization, fileIDs)
+	if err != nil {
+		log.Printf("Failed to get download links: %v", err)
+		return
+	}
+
+	urls := make(map[primitive.ObjectID]string, len(links))
+	for _, link := range links {
+		urls[link.FileID] = link.URL
+	}
+	for i := range messages {
+		for j := range messages[i].Attachments {
+			messages[i].Attachments[j].URL = urls[messages[i].Attachments[j].ID]
+		}
+	}
+}
+
+type startChatRequest struct {
+	User string `json:"user"`
+}
+
+// GET reads a chat by conversation ID or by two users, POST starts the
+// direct chat of the caller and another user
+func chatHandler(chatLogic *logic.ChatService, directory *users.Client, fileStore *files.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getChatHandler(chatLogic, fileStore)(w, r)
+		case http.MethodPost:
+			createChatHandler(chatLogic, directory)(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// get chat by conversation ID or by two users. Users without a chat get an
+// empty history, reading never creates one. Attachments come with signed
+// download links.
+func getChatHandler(chatLogic *logic.ChatService, fileStore *files.Client) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		user1 := r.URL.Query().Get("user1")
-		user2 := r.URL.Query().Get("user2")
-		log.Printf("📥 Received query: user1=%q, user2=%q", user1, user2)
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
 
-		if user1 == "" || user2 == "" {
-			http.Error(w, "Missing user1 or user2 query parameters", http.StatusBadRequest)
+		query, err := parseMessageQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 
-		chat, err := chatLogic.GetChatByUsers(ctx, user1, user2)
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				chat, err = chatLogic.CreateChat(ctx, user1, user2)
-				if err != nil {
-					http.Error(w, "Failed to create chat: "+err.Error(), http.StatusInternalServerError)
-					return
-				}
-			} else {
-				http.Error(w, "Error retrieving chat: "+err.Error(), http.StatusInternalServerError)
+		var history *models.ChatHistory
+		if conversationID := r.URL.Query().Get("conversation_id"); conversationID != "" {
+			chatID, err := primitive.ObjectIDFromHex(conversationID)
+			if err != nil {
+				http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
 				return
 			}
+
+			chat, err := chatLogic.GetConversation(ctx, auth0ID, chatID)
+			if err != nil {
+				http.Error(w, "Error retrieving chat: "+err.Error(), statusForError(err))
+				return
+			}
+
+			history, err = chatLogic.GetChatHistory(ctx, auth0ID, chat, query)
+			if err != nil {
+				http.Error(w, "Error retrieving messages: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			user1 := r.URL.Query().Get("user1")
+			user2 := r.URL.Query().Get("user2")
+			log.Printf("📥 Received query: user1=%q, user2=%q", user1, user2)
+
+			if user1 == "" || user2 == "" {
+				http.Error(w, "Missing user1 or user2 query parameters", http.StatusBadRequest)
+				return
+			}
+
+			history, err = chatLogic.GetDirectHistory(ctx, auth0ID, user1, user2, query)
+			if err != nil {
+				http.Error(w, "Error retrieving chat: "+err.Error(), statusForError(err))
+				return
+			}
+		}
+
+		linkAttachments(ctx, fileStore, r.Header.Get("Authorization"), history.Messages)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+// start the direct chat of the caller and another user, after checking with
+// user_api that both exist and are friends. Starting a chat that exists
+// returns it.
+func createChatHandler(chatLogic *logic.ChatService, directory *users.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req startChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.User == "" {
+			http.Error(w, "Missing user", http.StatusBadRequest)
+			return
+		}
+		if req.User == auth0ID {
+			http.Error(w, logic.ErrChatWithSelf.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		friends, err := directory.AreFriends(ctx, r.Header.Get("Authorization"), auth0ID, req.User)
+		if err != nil {
+			http.Error(w, "Error checking users: "+err.Error(), statusForError(err))
+			return
+		}
+		if !friends {
+			http.Error(w, "You can only start chats with friends", http.StatusForbidden)
+			return
+		}
+
+		chat, err := chatLogic.CreateChat(ctx, auth0ID, req.User)
+		if err != nil {
+			http.Error(w, "Failed to create chat: "+err.Error(), statusForError(err))
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(chat)
 	}
 }
 
+// map service errors to the HTTP status the client should see
+var errForeignAttachment = errors.New("attachment was not uploaded by the sender to this conversation")
+
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments),
+		errors.Is(err, users.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, logic.ErrNotMember),
+		errors.Is(err, logic.ErrNotGuildMember),
+		errors.Is(err, logic.ErrMissingPermission),
+		errors.Is(err, logic.ErrNotOwner),
+		errors.Is(err, logic.ErrOwnerLeaving),
+		errors.Is(err, logic.ErrModerateOwner),
+		errors.Is(err, logic.ErrRoleHierarchy),
+		errors.Is(err, logic.ErrBanned),
+		errors.Is(err, errNotFriend):
+		return http.StatusForbidden
+	case errors.Is(err, logic.ErrNotGroup),
+		errors.Is(err, logic.ErrMissingName),
+		errors.Is(err, logic.ErrNameTooLong),
+		errors.Is(err, logic.ErrEmptyMessage),
+		errors.Is(err, logic.ErrMessageTooLong),
+		errors.Is(err, logic.ErrNoNewMembers),
+		errors.Is(err, logic.ErrInviteLimits),
+		errors.Is(err, logic.ErrLastChannel),
+		errors.Is(err, logic.ErrEveryoneRole),
+		errors.Is(err, logic.ErrInvalidOverwrite),
+		errors.Is(err, logic.ErrInvalidEmoji),
+		errors.Is(err, logic.ErrTooManyReactions),
+		errors.Is(err, logic.ErrReplyElsewhere),
+		errors.Is(err, logic.ErrWrongConversation),
+		errors.Is(err, logic.ErrChatWithSelf),
+		errors.Is(err, logic.ErrEmptySearch),
+		errors.Is(err, logic.ErrSearchTooLong),
+		errors.Is(err, logic.ErrTooManyAttachments),
+		errors.Is(err, logic.ErrDuplicateAttachment),
+		errors.Is(err, logic.ErrAttachmentInUse),
+		errors.Is(err, files.ErrFileNotFound),
+		errors.Is(err, errForeignAttachment):
+		return http.StatusBadRequest
+	case errors.Is(err, logic.ErrInvalidInvite):
+		return http.StatusNotFound
+	case errors.Is(err, logic.ErrMessageDeleted):
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
+
+// read the before/after/limit cursor parameters of a history request
+func parseMessageQuery(r *http.Request) (models.MessageQuery, error) {
+	var query models.MessageQuery
+	params := r.URL.Query()
+
+	if before := params.Get("before"); before != "" {
+		id, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			return query, fmt.Errorf("Invalid before cursor")
+		}
+		query.Before = id
+	}
+
+	if after := params.Get("after"); after != "" {
+		id, err := primitive.ObjectIDFromHex(after)
+		if err != nil {
+			return query, fmt.Errorf("Invalid after cursor")
+		}
+		query.After = id
+	}
+
+	if !query.Before.IsZero() && !query.After.IsZero() {
+		return query, fmt.Errorf("Only one of before or after may be set")
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return query, fmt.Errorf("Invalid limit")
+		}
+		query.Limit = n
+	}
+
+	return query, nil
+}
+
+// read the before/limit cursor parameters of the conversation list
+func parseConversationQuery(r *http.Request) (models.ConversationQuery, error) {
+	var query models.ConversationQuery
+	params := r.URL.Query()
+
+	if before := params.Get("before"); before != "" {
+		id, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			return query, fmt.Errorf("Invalid before cursor")
+		}
+		query.Before = id
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return query, fmt.Errorf("Invalid limit")
+		}
+		query.Limit = n
+	}
+
+	return query, nil
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || isAllowedOrigin(origin)
+	},
+}
+
+// subscribe to new messages of the user's conversations
+func wsHandler(hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("Websocket upgrade failed: %v", err)
+			return
+		}
+
+		hub.Serve(conn, userID)
+	}
+}
+
+func isAllowedOrigin(origin string) bool {
+	return origin == "http://localhost:3000" || origin == "https://cloudcord.com" || origin == "https://cloudcord.info"
+}
+
 func withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
 
-		if origin == "http://localhost:3000" || origin == "https://cloudcord.com" || origin == "https://cloudcord.info" {
+		if isAllowedOrigin(origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
 
 		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -202,10 +610,31 @@ func main() {
 
 	log.Println("✅ Successfully connected to MongoDB Atlas")
 
+	middleware.InitMiddleware()
+
 	mongoDB := client.Database("Messages")
 
 	chatRepo := db.NewChatRepository(mongoDB)
 
+	guildRepo := db.NewGuildRepository(mongoDB)
+
+	if err := chatRepo.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+	if err := guildRepo.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), 5*time.Minute)
+	migrated, err := chatRepo.MigrateEmbeddedMessages(migrateCtx)
+	cancelMigrate()
+	if err != nil {
+		log.Fatalf("Failed to migrate embedded chat messages: %v", err)
+	}
+	if migrated > 0 {
+		log.Printf("✅ Moved embedded messages of %d chats into the messages collection", migrated)
+	}
+
 	var publisher *mq.Publisher
 	var err2 error
 	rabbitURI := os.Getenv("RABBITMQ_URI")
@@ -227,10 +656,78 @@ func main() {
 		log.Fatalf("Failed to set up RabbitMQ publisher after retries: %v", err2)
 	}
 
+	var releasePublisher *mq.Publisher
+	for i := 0; i < 8; i++ {
+		releasePublisher, err2 = mq.NewPublisher(rabbitURI, "released_attachments")
+		if err2 == nil {
+			log.Println("✅ RabbitMQ attachment release publisher set up successfully")
+			break
+		}
+		log.Printf("Attempt %d: Failed to set up RabbitMQ attachment release publisher: %v", i+1, err2)
+		time.Sleep(3 * time.Second)
+	}
+
+	if err2 != nil {
+		log.Fatalf("Failed to set up RabbitMQ attachment release publisher after retries: %v", err2)
+	}
+
+	var broadcaster *mq.Broadcaster
+	for i := 0; i < 8; i++ {
+		broadcaster, err2 = mq.NewBroadcaster(rabbitURI, "chat_events")
+		if err2 == nil {
+			log.Println("✅ RabbitMQ chat event broadcaster set up successfully")
+			break
+		}
+		log.Printf("Attempt %d: Failed to set up RabbitMQ broadcaster: %v", i+1, err2)
+		time.Sleep(3 * time.Second)
+	}
+
+	if err2 != nil {
+		log.Fatalf("Failed to set up RabbitMQ broadcaster after retries: %v", err2)
+	}
+
+	replica, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("Failed to get hostname: %v", err)
+	}
+	hub := realtime.NewHub(replica)
+
 	go func() {
 		maxRetries := 8
 		for i := 0; i < maxRetries; i++ {
-			err := mq.StartUserDeletionConsumer(rabbitURI, "user_deletion", chatRepo)
+			err := mq.StartChatEventConsumer(rabbitURI, "chat_events", hub.Deliver)
+			if err == nil {
+				log.Println("✅ Chat event consumer started successfully, listening on RabbitMQ...")
+				return
+			}
+
+			log.Printf("Attempt %d: Failed to start chat event consumer: %v", i+1, err)
+			time.Sleep(3 * time.Second)
+		}
+
+		log.Fatal("❌ Failed to start chat event consumer after retries")
+	}()
+
+	guildService := logic.NewGuildService(guildRepo, releasePublisher)
+
+	userAPIURL := os.Getenv("USER_API_URL")
+	if userAPIURL == "" {
+		userAPIURL = "http://user-service:8081"
+	}
+	userDirectory := users.NewClient(userAPIURL)
+
+	fileStorageURL := os.Getenv("FILE_STORAGE_URL")
+	if fileStorageURL == "" {
+		fileStorageURL = "http://file-storage-service:8082"
+	}
+	fileStore := files.NewClient(fileStorageURL)
+	chatService := logic.NewChatService(chatRepo, publisher, broadcaster, releasePublisher, guildService)
+	go hub.Run(chatService)
+
+	go func() {
+		maxRetries := 8
+		for i := 0; i < maxRetries; i++ {
+			err := mq.StartUserDeletionConsumer(rabbitURI, "user_deletion", "user_deletion", chatService.DeleteChatsByAuth0ID, guildRepo)
 			if err == nil {
 				log.Println("✅ User deletion consumer started successfully, listening on RabbitMQ...")
 				return
@@ -243,12 +740,48 @@ func main() {
 		log.Fatal("❌ Failed to start user deletion consumer after retries")
 	}()
 
-	chatService := logic.NewChatService(chatRepo, publisher)
+	go func() {
+		maxRetries := 8
+		for i := 0; i < maxRetries; i++ {
+			err := mq.StartAttachmentStatusConsumer(rabbitURI, "attachment_status", chatService.BlockAttachment)
+			if err == nil {
+				log.Println("✅ Attachment status consumer started successfully, listening on RabbitMQ...")
+				return
+			}
+
+			log.Printf("Attempt %d: Failed to start attachment status consumer: %v", i+1, err)
+			time.Sleep(3 * time.Second)
+		}
+
+		log.Fatal("❌ Failed to start attachment status consumer after retries")
+	}()
 
 	http.HandleFunc("/", handleOK)
 
-	http.Handle("/message/send", metricsMiddleware("/message/send", withCORS(sendMessageHandler(chatService))))
-	http.Handle("/message/chat", metricsMiddleware("/message/chat", withCORS(getChatHandler(chatService))))
+	// /message/{id}..., everything under /message/ without its own route
+	http.Handle("/message/", metricsMiddleware("/message/{id}", withCORS(middleware.ValidateJWT(messageRoutes(chatService)))))
+	http.Handle("/message/send", metricsMiddleware("/message/send", withCORS(middleware.ValidateJWT(sendMessageHandler(chatService, fileStore)))))
+	http.Handle("/message/chat", metricsMiddleware("/message/chat", withCORS(middleware.ValidateJWT(chatHandler(chatService, userDirectory, fileStore)))))
+	http.Handle("/message/group", metricsMiddleware("/message/group", withCORS(middleware.ValidateJWT(createGroupHandler(chatService, userDirectory)))))
+	http.Handle("/message/read", metricsMiddleware("/message/read", withCORS(middleware.ValidateJWT(markReadHandler(chatService)))))
+	http.Handle("/message/conversations", metricsMiddleware("/message/conversations", withCORS(middleware.ValidateJWT(conversationsHandler(chatService)))))
+	http.Handle("/message/search", metricsMiddleware("/message/search", withCORS(middleware.ValidateJWT(searchHandler(chatService)))))
+	http.Handle("/message/typing", metricsMiddleware("/message/typing", withCORS(middleware.ValidateJWT(typingHandler(chatService)))))
+	http.Handle("/message/presence", metricsMiddleware("/message/presence", withCORS(middleware.ValidateJWT(presenceHandler(hub.Presence())))))
+	http.Handle("/message/access", metricsMiddleware("/message/access", withCORS(middleware.ValidateJWT(accessHandler(chatService)))))
+	http.Handle("/message/group/members", metricsMiddleware("/message/group/members", withCORS(middleware.ValidateJWT(groupMembersHandler(chatService, userDirectory)))))
+	http.Handle("/guild", metricsMiddleware("/guild", withCORS(middleware.ValidateJWT(guildHandler(guildService)))))
+	http.Handle("/guild/channels", metricsMiddleware("/guild/channels", withCORS(middleware.ValidateJWT(channelHandler(guildService)))))
+	http.Handle("/guild/invites", metricsMiddleware("/guild/invites", withCORS(middleware.ValidateJWT(createInviteHandler(guildService)))))
+	http.Handle("/guild/join", metricsMiddleware("/guild/join", withCORS(middleware.ValidateJWT(joinGuildHandler(guildService)))))
+	http.Handle("/guild/leave", metricsMiddleware("/guild/leave", withCORS(middleware.ValidateJWT(leaveGuildHandler(guildService)))))
+	http.Handle("/guild/roles", metricsMiddleware("/guild/roles", withCORS(middleware.ValidateJWT(roleHandler(guildService)))))
+	http.Handle("/guild/roles/members", metricsMiddleware("/guild/roles/members", withCORS(middleware.ValidateJWT(roleMembersHandler(guildService, userDirectory)))))
+	http.Handle("/guild/channels/overwrites", metricsMiddleware("/guild/channels/overwrites", withCORS(middleware.ValidateJWT(overwriteHandler(guildService, userDirectory)))))
+	http.Handle("/guild/permissions", metricsMiddleware("/guild/permissions", withCORS(middleware.ValidateJWT(permissionsHandler(guildService, userDirectory)))))
+	http.Handle("/guild/kick", metricsMiddleware("/guild/kick", withCORS(middleware.ValidateJWT(kickHandler(guildService, userDirectory)))))
+	http.Handle("/guild/bans", metricsMiddleware("/guild/bans", withCORS(middleware.ValidateJWT(banHandler(guildService, userDirectory)))))
+	http.Handle("/message/ws", metricsMiddleware("/message/ws", middleware.ValidateJWT(wsHandler(hub))))
 
 	go func() {
 		fmt.Println("Starting metrics server on :2112...")
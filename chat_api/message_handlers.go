@@ -0,0 +1,327 @@
+package main
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/middleware"
+	"cloudcord/chat_api/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type editMessageRequest struct {
+	Content string `json:"content"`
+}
+
+type reactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+type threadMessageRequest struct {
+	Content string `json:"content"`
+	ReplyTo string `json:"reply_to"`
+}
+
+// routes /message/{id}, /message/{id}/reactions and /message/{id}/thread
+func messageRoutes(chatLogic *logic.ChatService) http.HandlerFunc {
+	single := messageHandler(chatLogic)
+	reactions := reactionsHandler(chatLogic)
+	thread := threadHandler(chatLogic)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/message/"), "/")
+
+		messageID, err := primitive.ObjectIDFromHex(parts[0])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch {
+		case len(parts) == 1:
+			single(w, r, messageID)
+		case len(parts) == 2 && parts[1] == "reactions":
+			reactions(w, r, messageID)
+		case len(parts) == 2 && parts[1] == "thread":
+			thread(w, r, messageID)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// PATCH edits and DELETE deletes a single message
+func messageHandler(chatLogic *logic.ChatService) func(http.ResponseWriter, *http.Request, primitive.ObjectID) {
+	return func(w http.ResponseWriter, r *http.Request, messageID primitive.ObjectID) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		switch r.Method {
+		case http.MethodPatch:
+			var req editMessageRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			message, err := chatLogic.EditMessage(ctx, auth0ID, messageID, req.Content)
+			if err != nil {
+				http.Error(w, "Failed to edit message: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(message)
+
+		case http.MethodDelete:
+			message, err := chatLogic.DeleteMessage(ctx, auth0ID, messageID)
+			if err != nil {
+				http.Error(w, "Failed to delete message: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(message)
+
+		default:
+			http.Error(w, "Only PATCH and DELETE methods are allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// POST adds a reaction to a message, DELETE ?emoji= removes the caller's
+func reactionsHandler(chatLogic *logic.ChatService) func(http.ResponseWriter, *http.Request, primitive.ObjectID) {
+	return func(w http.ResponseWriter, r *http.Request, messageID primitive.ObjectID) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		var (
+			message *models.Message
+			err     error
+		)
+
+		switch r.Method {
+		case http.MethodPost:
+			var req reactionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			message, err = chatLogic.AddReaction(ctx, auth0ID, messageID, req.Emoji)
+
+		case http.MethodDelete:
+			message, err = chatLogic.RemoveReaction(ctx, auth0ID, messageID, r.URL.Query().Get("emoji"))
+
+		default:
+			http.Error(w, "Only POST and DELETE methods are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "Failed to update reaction: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(message)
+	}
+}
+
+// GET returns a page of the thread on a message, POST posts in it
+func threadHandler(chatLogic *logic.ChatService) func(http.ResponseWriter, *http.Request, primitive.ObjectID) {
+	return func(w http.ResponseWriter, r *http.Request, rootID primitive.ObjectID) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		switch r.Method {
+		case http.MethodGet:
+			query, err := parseMessageQuery(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			thread, err := chatLogic.GetThread(ctx, auth0ID, rootID, query)
+			if err != nil {
+				http.Error(w, "Error retrieving thread: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(thread)
+
+		case http.MethodPost:
+			var req threadMessageRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			var replyTo primitive.ObjectID
+			if req.ReplyTo != "" {
+				id, err := primitive.ObjectIDFromHex(req.ReplyTo)
+				if err != nil {
+					http.Error(w, "Invalid reply_to", http.StatusBadRequest)
+					return
+				}
+				replyTo = id
+			}
+
+			message, err := chatLogic.SendThreadMessage(ctx, auth0ID, rootID, req.Content, replyTo)
+			if err != nil {
+				http.Error(w, "Failed to send message: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(message)
+
+		default:
+			http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+type markReadRequest struct {
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id"`
+}
+
+// mark a conversation as read up to a message
+func markReadHandler(chatLogic *logic.ChatService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req markReadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		chatID, err := primitive.ObjectIDFromHex(req.ConversationID)
+		if err != nil {
+			http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+			return
+		}
+
+		messageID, err := primitive.ObjectIDFromHex(req.MessageID)
+		if err != nil {
+			http.Error(w, "Invalid message_id", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		state, err := chatLogic.MarkRead(ctx, auth0ID, chatID, messageID)
+		if err != nil {
+			http.Error(w, "Failed to mark as read: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	}
+}
+
+// list a page of the caller's conversations with their latest message and
+// unread counts, most recently active first
+func conversationsHandler(chatLogic *logic.ChatService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		query, err := parseConversationQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		page, err := chatLogic.ListConversations(ctx, auth0ID, query)
+		if err != nil {
+			http.Error(w, "Error retrieving conversations: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// GET /message/access?conversation_id= tells what the caller may do in a
+// conversation, non-members get 403
+func accessHandler(chatLogic *logic.ChatService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		chatID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("conversation_id"))
+		if err != nil {
+			http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		access, err := chatLogic.ConversationAccess(ctx, auth0ID, chatID)
+		if err != nil {
+			http.Error(w, "Failed to get access: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(access)
+	}
+}
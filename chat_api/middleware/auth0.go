@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type ContextKey string
+
+const UserContextKey = ContextKey("user")
+
+var (
+	auth0Domain = "https://dev-p3oldabcwb4l1kia.us.auth0.com/"
+	audience    = "https://cloudcord/api"
+	jwksURL     = auth0Domain + ".well-known/jwks.json"
+	jwks        *keyfunc.JWKS
+)
+
+func InitMiddleware() {
+	var err error
+	jwks, err = keyfunc.Get(jwksURL, keyfunc.Options{
+		RefreshInterval: time.Hour,
+		RefreshErrorHandler: func(err error) {
+			fmt.Printf("Error refreshing JWKS: %v\n", err)
+		},
+		RefreshUnknownKID: true,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create JWKS from URL: %v", err))
+	}
+}
+
+// validation of JWT tokens
+func ValidateJWT(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString, err := bearerToken(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		token, err := jwt.Parse(tokenString, jwks.Keyfunc)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
+			return
+		}
+
+		if !token.Valid {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			return
+		}
+
+		if claims["iss"] != auth0Domain {
+			http.Error(w, "Invalid token issuer", http.StatusUnauthorized)
+			return
+		}
+
+		audClaim := claims["aud"]
+		validAud := false
+		switch aud := audClaim.(type) {
+		case string:
+			if aud == audience {
+				validAud = true
+			}
+		case []interface{}:
+			for _, a := range aud {
+				if s, ok := a.(string); ok && s == audience {
+					validAud = true
+					break
+				}
+			}
+		}
+		if !validAud {
+			http.Error(w, "Invalid token audience", http.StatusUnauthorized)
+			return
+		}
+
+		auth0ID, ok := claims["sub"].(string)
+		if !ok || auth0ID == "" {
+			http.Error(w, "Invalid token: missing sub claim", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), UserContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Browsers can't set headers on a websocket handshake, so upgrade requests
+// may pass the token as the access_token query parameter instead
+func bearerToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			if token := r.URL.Query().Get("access_token"); token != "" {
+				return token, nil
+			}
+		}
+		return "", fmt.Errorf("Missing Authorization header")
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", fmt.Errorf("Invalid Authorization header format")
+	}
+
+	return parts[1], nil
+}
+
+// Auth0IDFromContext returns the sub claim of the token validated by ValidateJWT
+func Auth0IDFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ctx.Value(UserContextKey).(jwt.MapClaims)
+	if !ok || claims == nil {
+		return "", false
+	}
+
+	auth0ID, ok := claims["sub"].(string)
+	if !ok || auth0ID == "" {
+		return "", false
+	}
+	return auth0ID, true
+}
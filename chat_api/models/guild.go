@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Guild is a server: a set of members sharing text channels
+type Guild struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	OwnerID   string             `bson:"owner_id" json:"owner_id"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type GuildMember struct {
+	GuildID  primitive.ObjectID   `bson:"guild_id" json:"guild_id"`
+	UserID   string               `bson:"user_id" json:"user_id"`
+	Roles    []primitive.ObjectID `bson:"roles,omitempty" json:"roles"`
+	JoinedAt time.Time            `bson:"joined_at" json:"joined_at"`
+}
+
+// Invite lets anyone holding the code join the guild. A nil ExpiresAt never
+// expires and a zero MaxUses allows unlimited joins.
+type Invite struct {
+	Code      string             `bson:"_id" json:"code"`
+	GuildID   primitive.ObjectID `bson:"guild_id" json:"guild_id"`
+	CreatedBy string             `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	MaxUses   int                `bson:"max_uses" json:"max_uses"`
+	Uses      int                `bson:"uses" json:"uses"`
+}
+
+// GuildDetails is a guild together with its channels
+type GuildDetails struct {
+	Guild
+	Channels []Chat `json:"channels"`
+}
@@ -1,16 +1,219 @@
 package models
 
-import "time"
+import (
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// messages live in their own collection, ordered by their ObjectID.
+// A deleted message stays as a tombstone without content so the history
+// keeps its place.
 type Message struct {
-	Content    string    `bson:"content" json:"content"`
-	SentByUser string    `bson:"sent_by_user" json:"sent_by_user"`
-	Timestamp  time.Time `bson:"timestamp" json:"timestamp"`
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ChatID     primitive.ObjectID `bson:"chat_id" json:"chat_id"`
+	Content    string             `bson:"content" json:"content"`
+	SentByUser string             `bson:"sent_by_user" json:"sent_by_user"`
+	Timestamp  time.Time          `bson:"timestamp" json:"timestamp"`
+	EditedAt   *time.Time         `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	Deleted    bool               `bson:"deleted,omitempty" json:"deleted,omitempty"`
+	DeletedAt  *time.Time         `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+
+	// a reply quotes its parent, the quote is filled in when reading so it
+	// follows edits and deletes of the parent
+	ReplyTo *primitive.ObjectID `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+	Quote   *Quote              `bson:"-" json:"quote,omitempty"`
+
+	// messages in a thread have the ID of the message it was started on,
+	// that message counts its replies
+	ThreadID          *primitive.ObjectID `bson:"thread_id,omitempty" json:"thread_id,omitempty"`
+	ThreadReplyCount  int                 `bson:"thread_reply_count,omitempty" json:"thread_reply_count,omitempty"`
+	ThreadLastReplyAt *time.Time          `bson:"thread_last_reply_at,omitempty" json:"thread_last_reply_at,omitempty"`
+
+	// every reaction is stored, clients get them counted per emoji
+	Reactions       []Reaction        `bson:"reactions,omitempty" json:"-"`
+	ReactionSummary []ReactionSummary `bson:"-" json:"reactions,omitempty"`
+
+	Attachments []Attachment `bson:"attachments,omitempty" json:"attachments,omitempty"`
+}
+
+// Attachment is a file uploaded to file_storage_api, clients download it
+// from there by ID
+type Attachment struct {
+	ID          primitive.ObjectID `bson:"id" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	ContentType string             `bson:"content_type" json:"content_type"`
+	Size        int64              `bson:"size" json:"size"`
+
+	// the file was flagged by the malware scan and can't be downloaded
+	Blocked bool `bson:"blocked,omitempty" json:"blocked,omitempty"`
+
+	// a signed download link, filled in when history is read
+	URL string `bson:"-" json:"url,omitempty"`
+}
+
+// AttachmentsReleased tells file storage the files of a deleted message
+// aren't referenced anymore
+type AttachmentsReleased struct {
+	FileIDs []primitive.ObjectID `json:"file_ids"`
+}
+
+// AttachmentStatus is sent by file storage when a file changes state
+type AttachmentStatus struct {
+	FileID         primitive.ObjectID `json:"file_id"`
+	ConversationID primitive.ObjectID `json:"conversation_id"`
+	Status         string             `json:"status"`
+}
+
+// the file was quarantined after a malware scan
+const AttachmentBlocked = "blocked"
+
+// Quote is the preview of a replied-to message
+type Quote struct {
+	ID         primitive.ObjectID `json:"id"`
+	SentByUser string             `json:"sent_by_user"`
+	Content    string             `json:"content"`
+	Deleted    bool               `json:"deleted,omitempty"`
+}
+
+// MaxReactionEmoji caps the different emoji reacted to one message, users
+// can still join the reactions already there
+const MaxReactionEmoji = 20
+
+type Reaction struct {
+	Emoji  string `bson:"emoji" json:"emoji"`
+	UserID string `bson:"user_id" json:"user_id"`
 }
 
+// ReactionSummary counts the reactions with one emoji, Me tells if the
+// viewer is one of them
+type ReactionSummary struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Me    bool   `json:"me"`
+}
+
+// SummarizeReactions fills ReactionSummary as seen by viewer, emojis in the
+// order they were first used
+func (m *Message) SummarizeReactions(viewer string) {
+	m.ReactionSummary = nil
+	index := make(map[string]int)
+
+	for _, r := range m.Reactions {
+		i, ok := index[r.Emoji]
+		if !ok {
+			i = len(m.ReactionSummary)
+			index[r.Emoji] = i
+			m.ReactionSummary = append(m.ReactionSummary, ReactionSummary{Emoji: r.Emoji})
+		}
+
+		m.ReactionSummary[i].Count++
+		if r.UserID == viewer {
+			m.ReactionSummary[i].Me = true
+		}
+	}
+}
+
+// chats written before groups existed have no type and are direct chats.
+// Channels belong to a guild and their members are the guild's members.
+const (
+	ChatTypeDirect  = "direct"
+	ChatTypeGroup   = "group"
+	ChatTypeChannel = "channel"
+)
+
 type Chat struct {
-	Users    []string  `bson:"users" json:"users"`
-	Messages []Message `bson:"messages" json:"messages"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type          string             `bson:"type,omitempty" json:"type"`
+	GuildID       primitive.ObjectID `bson:"guild_id,omitempty" json:"guild_id,omitempty"`
+	Name          string             `bson:"name,omitempty" json:"name,omitempty"`
+	OwnerID       string             `bson:"owner_id,omitempty" json:"owner_id,omitempty"`
+	Users         []string           `bson:"users,omitempty" json:"users"`
+	LastMessageAt time.Time          `bson:"last_message_at,omitempty" json:"last_message_at"`
+
+	// per-channel permission changes, only used by guild channels
+	Overwrites []PermissionOverwrite `bson:"overwrites,omitempty" json:"overwrites,omitempty"`
+}
+
+func (c *Chat) IsGroup() bool {
+	return c.Type == ChatTypeGroup
+}
+
+func (c *Chat) IsChannel() bool {
+	return c.Type == ChatTypeChannel
+}
+
+func (c *Chat) HasMember(userID string) bool {
+	for _, u := range c.Users {
+		if u == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageQuery selects a page of history. Before and After are message IDs
+// used as exclusive cursors, at most one of them is set.
+type MessageQuery struct {
+	Before primitive.ObjectID
+	After  primitive.ObjectID
+	Limit  int
+}
+
+// ChatHistory is a chat with one page of its messages, oldest first.
+// ReadStates tells how far the members of direct and group chats have read.
+type ChatHistory struct {
+	Chat
+	Messages   []Message   `json:"messages"`
+	HasMore    bool        `json:"has_more"`
+	ReadStates []ReadState `json:"read_states,omitempty"`
+}
+
+// ThreadHistory is the message a thread was started on with one page of
+// the thread, oldest first
+type ThreadHistory struct {
+	Root     Message   `json:"root"`
+	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"has_more"`
+}
+
+// ReadState is how far a member has read a conversation
+type ReadState struct {
+	ChatID     primitive.ObjectID `bson:"chat_id" json:"chat_id"`
+	UserID     string             `bson:"user_id" json:"user_id"`
+	LastReadID primitive.ObjectID `bson:"last_read_id" json:"last_read_id"`
+	ReadAt     time.Time          `bson:"read_at" json:"read_at"`
+}
+
+// ConversationQuery selects a page of the conversation list. Before is the
+// ID of the last conversation of the previous page.
+type ConversationQuery struct {
+	Before primitive.ObjectID
+	Limit  int
+}
+
+// MessagePreview is the start of the latest message of a conversation
+type MessagePreview struct {
+	ID         primitive.ObjectID `json:"id"`
+	SentByUser string             `json:"sent_by_user"`
+	Content    string             `json:"content"`
+	Timestamp  time.Time          `json:"timestamp"`
+	Deleted    bool               `json:"deleted,omitempty"`
+}
+
+// ConversationSummary is an entry of the caller's conversation list
+type ConversationSummary struct {
+	Chat
+	LastMessage *MessagePreview     `json:"last_message,omitempty"`
+	LastReadID  *primitive.ObjectID `json:"last_read_id,omitempty"`
+	UnreadCount int64               `json:"unread_count"`
+}
+
+// ConversationPage is one page of the conversation list, most recently
+// active first
+type ConversationPage struct {
+	Conversations []ConversationSummary `json:"conversations"`
+	HasMore       bool                  `json:"has_more"`
 }
 
 type MessageNotification struct {
@@ -18,6 +221,50 @@ type MessageNotification struct {
 	Message    string `json:"message"`
 }
 
+// event types pushed to websocket clients
+const (
+	EventMessageCreated  = "message.created"
+	EventMessageUpdated  = "message.updated"
+	EventMessageDeleted  = "message.deleted"
+	EventReactionAdded   = "reaction.added"
+	EventReactionRemoved = "reaction.removed"
+	EventMessageRead     = "message.read"
+	EventTypingStarted   = "typing.started"
+	EventTypingStopped   = "typing.stopped"
+	EventPresenceUpdated = "presence.updated"
+
+	// keeps the presence of connected users alive on the other replicas,
+	// it has no recipients
+	EventPresenceSync = "presence.sync"
+)
+
+// ChatEvent is fanned out to every chat_api replica and delivered to the
+// websocket connections of its recipients
+type ChatEvent struct {
+	Type       string   `json:"type"`
+	Recipients []string `json:"recipients"`
+	Message    *Message `json:"message,omitempty"`
+
+	// set for reaction events
+	Reaction *ReactionChange `json:"reaction,omitempty"`
+
+	// set for read receipts
+	ReadState *ReadState `json:"read_state,omitempty"`
+
+	// set for typing events
+	Typing *Typing `json:"typing,omitempty"`
+
+	// set for presence events, a sync carries every user of a replica
+	Presence []Presence `json:"presence,omitempty"`
+}
+
+type ReactionChange struct {
+	MessageID primitive.ObjectID `json:"message_id"`
+	ChatID    primitive.ObjectID `json:"chat_id"`
+	Emoji     string             `json:"emoji"`
+	UserID    string             `json:"user_id"`
+}
+
 type UserDeletedMessage struct {
 	Auth0ID string `json:"auth0_id"`
 }
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Permission is a bit set of what a member may do in a guild or channel
+type Permission uint64
+
+const (
+	PermViewChannel Permission = 1 << iota
+	PermSendMessages
+	PermManageChannels
+	PermKickMembers
+	PermBanMembers
+	PermManageRoles
+	PermAdministrator
+	PermManageMessages
+)
+
+// PermAll is every permission, which guild owners and administrators have
+const PermAll = PermViewChannel | PermSendMessages | PermManageChannels |
+	PermKickMembers | PermBanMembers | PermManageRoles | PermAdministrator |
+	PermManageMessages
+
+// DefaultPermissions is what @everyone may do in a new guild, and what members
+// of direct and group conversations may do there
+const DefaultPermissions = PermViewChannel | PermSendMessages
+
+var permissionNames = []struct {
+	perm Permission
+	name string
+}{
+	{PermViewChannel, "view_channel"},
+	{PermSendMessages, "send_messages"},
+	{PermManageChannels, "manage_channels"},
+	{PermKickMembers, "kick_members"},
+	{PermBanMembers, "ban_members"},
+	{PermManageRoles, "manage_roles"},
+	{PermAdministrator, "administrator"},
+	{PermManageMessages, "manage_messages"},
+}
+
+func (p Permission) Has(perm Permission) bool {
+	return p&perm == perm
+}
+
+// Names lists the permissions in p, for API responses
+func (p Permission) Names() []string {
+	names := []string{}
+	for _, pn := range permissionNames {
+		if p.Has(pn.perm) {
+			names = append(names, pn.name)
+		}
+	}
+	return names
+}
+
+// Role is a named set of permissions in a guild. Every guild has an
+// @everyone role whose ID is the guild's ID, it applies to all members.
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	GuildID     primitive.ObjectID `bson:"guild_id" json:"guild_id"`
+	Name        string             `bson:"name" json:"name"`
+	Permissions Permission         `bson:"permissions" json:"permissions"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+const EveryoneRoleName = "@everyone"
+
+func (r *Role) IsEveryone() bool {
+	return r.ID == r.GuildID
+}
+
+// overwrite targets
+const (
+	OverwriteRole   = "role"
+	OverwriteMember = "member"
+)
+
+// PermissionOverwrite changes the permissions of a role or a member in one
+// channel. Denied bits are removed before allowed bits are added.
+type PermissionOverwrite struct {
+	Type  string     `bson:"type" json:"type"`
+	ID    string     `bson:"id" json:"id"`
+	Allow Permission `bson:"allow" json:"allow"`
+	Deny  Permission `bson:"deny" json:"deny"`
+}
+
+func (o PermissionOverwrite) Apply(perms Permission) Permission {
+	return (perms &^ o.Deny) | o.Allow
+}
+
+// Ban keeps a user from rejoining a guild
+type Ban struct {
+	GuildID  primitive.ObjectID `bson:"guild_id" json:"guild_id"`
+	UserID   string             `bson:"user_id" json:"user_id"`
+	BannedBy string             `bson:"banned_by" json:"banned_by"`
+	BannedAt time.Time          `bson:"banned_at" json:"banned_at"`
+}
+
+// EffectivePermissions is the answer of the permissions endpoint
+type EffectivePermissions struct {
+	GuildID     primitive.ObjectID  `json:"guild_id"`
+	ChannelID   *primitive.ObjectID `json:"channel_id,omitempty"`
+	UserID      string              `json:"user_id"`
+	Permissions Permission          `json:"permissions"`
+	Names       []string            `json:"names"`
+}
+
+// ConversationAccess is what a user may do in a conversation
+type ConversationAccess struct {
+	ConversationID primitive.ObjectID `json:"conversation_id"`
+	UserID         string             `json:"user_id"`
+	Permissions    Permission         `json:"permissions"`
+	Names          []string           `json:"names"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// presence statuses
+const (
+	PresenceOnline  = "online"
+	PresenceIdle    = "idle"
+	PresenceOffline = "offline"
+)
+
+// Presence is whether a user is connected and active. It is never stored,
+// every replica reports the users connected to it and keeps the reports of
+// the others in memory, so LastSeen only goes back to when the replica
+// answering started. Replica is only set between replicas, clients get the
+// presence merged over all of them without it.
+type Presence struct {
+	UserID   string    `json:"user_id"`
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen,omitempty"`
+	Replica  string    `json:"replica,omitempty"`
+}
+
+// Typing tells the members of a conversation that a user is writing. It is
+// over at ExpiresAt unless the user sends another one.
+type Typing struct {
+	ChatID    primitive.ObjectID `json:"chat_id"`
+	UserID    string             `json:"user_id"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SearchQuery selects a page of search results, newest first. Text is
+// words and "quoted phrases", a word starting with - excludes messages
+// containing it. The other fields are optional filters, Before is the ID
+// of the last result of the previous page.
+type SearchQuery struct {
+	Text   string
+	ChatID primitive.ObjectID
+	Sender string
+	Since  time.Time
+	Until  time.Time
+	Before primitive.ObjectID
+	Limit  int
+}
+
+// Highlight is a match in a snippet, as rune offsets with End exclusive
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchResult is a matching message with the part of it that matched
+type SearchResult struct {
+	Message    Message     `json:"message"`
+	Snippet    string      `json:"snippet"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+type SearchPage struct {
+	Results []SearchResult `json:"results"`
+	HasMore bool           `json:"has_more"`
+}
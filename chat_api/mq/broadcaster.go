@@ -0,0 +1,65 @@
+package mq
+
+import (
+	"encoding/json"
+
+	"github.com/streadway/amqp"
+)
+
+// Broadcaster publishes to a fanout exchange so that every chat_api replica
+// gets a copy of the event, unlike Publisher which feeds a single work queue
+type Broadcaster struct {
+	channel  *amqp.Channel
+	exchange string
+}
+
+func NewBroadcaster(amqpURL, exchange string) (*Broadcaster, error) {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := declareFanout(ch, exchange); err != nil {
+		return nil, err
+	}
+
+	return &Broadcaster{
+		channel:  ch,
+		exchange: exchange,
+	}, nil
+}
+
+func (b *Broadcaster) Publish(event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return b.channel.Publish(
+		b.exchange,
+		"",
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		},
+	)
+}
+
+func declareFanout(ch *amqp.Channel, exchange string) error {
+	return ch.ExchangeDeclare(
+		exchange,
+		"fanout",
+		true,  // durable
+		false, // auto-delete
+		false,
+		false,
+		nil,
+	)
+}
@@ -6,11 +6,15 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"time"
 
 	"github.com/streadway/amqp"
 )
 
-func StartUserDeletionConsumer(amqpURL string, queueName string, repo *db.ChatRepository) error {
+// StartUserDeletionConsumer binds a durable queue of chat_api to the user
+// deletion exchange, other services bind their own and get every deletion too.
+// deleteChats removes the user's chats and releases their attachments.
+func StartUserDeletionConsumer(amqpURL string, exchange string, queueName string, deleteChats func(context.Context, string) error, guilds *db.GuildRepository) error {
 	conn, err := amqp.Dial(amqpURL)
 	if err != nil {
 		return err
@@ -21,6 +25,26 @@ func StartUserDeletionConsumer(amqpURL string, queueName string, repo *db.ChatRe
 		return err
 	}
 
+	if err := declareFanout(ch, exchange); err != nil {
+		return err
+	}
+
+	q, err := ch.QueueDeclare(
+		queueName,
+		true,  // durable
+		false, // auto-delete
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := ch.QueueBind(q.Name, "", exchange, false, nil); err != nil {
+		return err
+	}
+
 	msgs, err := ch.Consume(
 		queueName,
 		"",
@@ -44,11 +68,143 @@ func StartUserDeletionConsumer(amqpURL string, queueName string, repo *db.ChatRe
 			}
 
 			log.Printf("Received user deletion for Auth0ID: %s", msg.Auth0ID)
-			if err := repo.DeleteChatsByAuth0ID(context.Background(), msg.Auth0ID); err != nil {
+			if err := deleteChats(context.Background(), msg.Auth0ID); err != nil {
 				log.Printf("❌ Failed to delete chats for user %s: %v", msg.Auth0ID, err)
 			} else {
 				log.Printf("✅ Deleted chats for user %s", msg.Auth0ID)
 			}
+
+			if err := guilds.RemoveMemberEverywhere(context.Background(), msg.Auth0ID); err != nil {
+				log.Printf("❌ Failed to remove user %s from guilds: %v", msg.Auth0ID, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// StartChatEventConsumer binds a private queue to the fanout exchange so this
+// replica receives every chat event, whichever replica produced it
+func StartChatEventConsumer(amqpURL string, exchange string, handle func(models.ChatEvent)) error {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	if err := declareFanout(ch, exchange); err != nil {
+		return err
+	}
+
+	q, err := ch.QueueDeclare(
+		"",
+		false, // durable
+		true,  // auto-delete
+		true,  // exclusive
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := ch.QueueBind(q.Name, "", exchange, false, nil); err != nil {
+		return err
+	}
+
+	msgs, err := ch.Consume(
+		q.Name,
+		"",
+		true, // auto-ack
+		true, // exclusive
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range msgs {
+			var event models.ChatEvent
+			if err := json.Unmarshal(d.Body, &event); err != nil {
+				log.Printf("Failed to parse chat event: %v", err)
+				continue
+			}
+			handle(event)
+		}
+	}()
+
+	return nil
+}
+
+// StartAttachmentStatusConsumer reads the state changes file storage reports
+// for attachments. A message that fails is requeued, to be tried again.
+func StartAttachmentStatusConsumer(amqpURL string, queueName string, handle func(context.Context, models.AttachmentStatus) error) error {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(
+		queueName,
+		true,  // durable
+		false, // auto-delete
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := ch.Qos(1, 0, false); err != nil {
+		return err
+	}
+
+	msgs, err := ch.Consume(
+		queueName,
+		"",
+		false, // manual ack
+		false, // exclusive
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range msgs {
+			var status models.AttachmentStatus
+			if err := json.Unmarshal(d.Body, &status); err != nil {
+				log.Printf("Failed to parse attachment status: %v", err)
+				d.Ack(false)
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := handle(ctx, status)
+			cancel()
+
+			if err != nil {
+				log.Printf("❌ Failed to update attachment %s: %v", status.FileID.Hex(), err)
+				time.Sleep(5 * time.Second)
+				d.Nack(false, true)
+				continue
+			}
+			d.Ack(false)
 		}
 	}()
 
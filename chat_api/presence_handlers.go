@@ -0,0 +1,106 @@
+package main
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/middleware"
+	"cloudcord/chat_api/models"
+	"cloudcord/chat_api/realtime"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// presence of at most this many users can be asked for at once
+const maxPresenceUsers = 100
+
+type typingRequest struct {
+	ConversationID string `json:"conversation_id"`
+}
+
+// POST tells the other members of a conversation that the caller is typing,
+// DELETE takes that back. Nothing is stored, indicators expire on their own.
+func typingHandler(chatLogic *logic.ChatService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		switch r.Method {
+		case http.MethodPost:
+			var req typingRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			chatID, err := primitive.ObjectIDFromHex(req.ConversationID)
+			if err != nil {
+				http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+				return
+			}
+
+			typing, err := chatLogic.StartTyping(ctx, auth0ID, chatID)
+			if err != nil {
+				http.Error(w, "Failed to send typing indicator: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(typing)
+
+		case http.MethodDelete:
+			chatID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("conversation_id"))
+			if err != nil {
+				http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+				return
+			}
+
+			if err := chatLogic.StopTyping(ctx, auth0ID, chatID); err != nil {
+				http.Error(w, "Failed to stop typing indicator: "+err.Error(), statusForError(err))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// get whether users are online, idle or offline and when they were last
+// seen, one user query parameter per user
+func presenceHandler(tracker *realtime.PresenceTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userIDs := r.URL.Query()["user"]
+		if len(userIDs) == 0 {
+			http.Error(w, "Missing user query parameter", http.StatusBadRequest)
+			return
+		}
+		if len(userIDs) > maxPresenceUsers {
+			http.Error(w, "Too many users", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		presence := make([]models.Presence, 0, len(userIDs))
+		for _, userID := range userIDs {
+			presence = append(presence, tracker.Get(userID, now))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(presence)
+	}
+}
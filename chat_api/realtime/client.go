@@ -0,0 +1,110 @@
+package realtime
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 4096
+	sendBufferSize = 64
+)
+
+// Client is a single websocket connection of a user
+type Client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	userID string
+	send   chan []byte
+
+	// last heartbeat, guarded by the hub's lock
+	lastActive time.Time
+}
+
+// frames clients send over the websocket
+type clientFrame struct {
+	Type string `json:"type"`
+}
+
+// clients send a heartbeat while their user is active, without one for a
+// while the user turns idle
+const frameHeartbeat = "heartbeat"
+
+// Serve registers the connection with the hub and pumps events to it until
+// the peer goes away. It blocks for the lifetime of the connection.
+func (h *Hub) Serve(conn *websocket.Conn, userID string) {
+	c := &Client{
+		hub:    h,
+		conn:   conn,
+		userID: userID,
+		send:   make(chan []byte, sendBufferSize),
+
+		lastActive: time.Now(),
+	}
+	h.register(c)
+
+	go c.writePump()
+	c.readPump()
+}
+
+// readPump processes control frames and heartbeats and notices disconnects,
+// clients publish messages through the REST endpoints
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var frame clientFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			continue
+		}
+		if frame.Type == frameHeartbeat {
+			c.hub.touch(c, time.Now())
+		}
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case payload, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
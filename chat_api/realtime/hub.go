@@ -0,0 +1,223 @@
+package realtime
+
+import (
+	"cloudcord/chat_api/models"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// presence changes waiting to be announced, more are dropped
+const announceBufferSize = 256
+
+// PresenceAnnouncer publishes the presence of the users of this replica
+type PresenceAnnouncer interface {
+	// tell the contacts of a user that their status changed
+	AnnouncePresence(presence models.Presence)
+
+	// refresh the users of this replica on the other replicas
+	SyncPresence(presence []models.Presence)
+}
+
+// Hub keeps track of the websocket clients connected to this replica,
+// indexed by the user they belong to
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[string]map[*Client]struct{}
+
+	// the last status announced for each connected user
+	status map[string]string
+
+	replica  string
+	presence *PresenceTracker
+	changes  chan models.Presence
+}
+
+// constructor, replica names this instance in presence reports
+func NewHub(replica string) *Hub {
+	return &Hub{
+		clients:  make(map[string]map[*Client]struct{}),
+		status:   make(map[string]string),
+		replica:  replica,
+		presence: NewPresenceTracker(),
+		changes:  make(chan models.Presence, announceBufferSize),
+	}
+}
+
+// Presence is the presence of users across all replicas
+func (h *Hub) Presence() *PresenceTracker {
+	return h.presence
+}
+
+// Run announces presence changes and periodically syncs the users of this
+// replica. It blocks, changes made before it starts are queued.
+func (h *Hub) Run(announcer PresenceAnnouncer) {
+	ticker := time.NewTicker(syncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case p := <-h.changes:
+			announcer.AnnouncePresence(p)
+		case now := <-ticker.C:
+			for _, p := range h.refreshStatus(now) {
+				announcer.AnnouncePresence(p)
+			}
+			announcer.SyncPresence(h.localPresence(now))
+			h.presence.Prune(now)
+		}
+	}
+}
+
+func (h *Hub) register(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.clients[c.userID] == nil {
+		h.clients[c.userID] = make(map[*Client]struct{})
+	}
+	h.clients[c.userID][c] = struct{}{}
+	h.setStatus(c.userID, models.PresenceOnline, c.lastActive)
+}
+
+func (h *Hub) unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	conns, ok := h.clients[c.userID]
+	if !ok {
+		return
+	}
+	if _, ok := conns[c]; !ok {
+		return
+	}
+
+	delete(conns, c)
+	close(c.send)
+	if len(conns) == 0 {
+		delete(h.clients, c.userID)
+		h.setStatus(c.userID, models.PresenceOffline, time.Now())
+	}
+}
+
+// touch records a heartbeat of a client, the user is active again
+func (h *Hub) touch(c *Client, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c.lastActive = now
+	h.setStatus(c.userID, models.PresenceOnline, now)
+}
+
+// queue an announcement when the status of a user changes, h.mu is held
+func (h *Hub) setStatus(userID, status string, lastSeen time.Time) {
+	if h.status[userID] == status {
+		return
+	}
+
+	if status == models.PresenceOffline {
+		delete(h.status, userID)
+	} else {
+		h.status[userID] = status
+	}
+
+	p := models.Presence{UserID: userID, Status: status, LastSeen: lastSeen, Replica: h.replica}
+	select {
+	case h.changes <- p:
+	default:
+		log.Printf("Dropping presence change of user %s, announcer is behind", userID)
+	}
+}
+
+// mark users without a recent heartbeat idle, returning who changed
+func (h *Hub) refreshStatus(now time.Time) []models.Presence {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var changed []models.Presence
+	for userID := range h.clients {
+		status, lastSeen := h.localStatus(userID, now)
+		if h.status[userID] != status {
+			h.status[userID] = status
+			changed = append(changed, models.Presence{UserID: userID, Status: status, LastSeen: lastSeen, Replica: h.replica})
+		}
+	}
+	return changed
+}
+
+func (h *Hub) localPresence(now time.Time) []models.Presence {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	presence := make([]models.Presence, 0, len(h.clients))
+	for userID := range h.clients {
+		status, lastSeen := h.localStatus(userID, now)
+		presence = append(presence, models.Presence{UserID: userID, Status: status, LastSeen: lastSeen, Replica: h.replica})
+	}
+	return presence
+}
+
+// a user is online when any of their connections had a recent heartbeat,
+// h.mu is held
+func (h *Hub) localStatus(userID string, now time.Time) (string, time.Time) {
+	var lastActive time.Time
+	for c := range h.clients[userID] {
+		if c.lastActive.After(lastActive) {
+			lastActive = c.lastActive
+		}
+	}
+
+	if now.Sub(lastActive) >= idleAfter {
+		return models.PresenceIdle, lastActive
+	}
+	return models.PresenceOnline, lastActive
+}
+
+// Deliver pushes the event to every local connection of its recipients.
+// Clients that can't keep up are dropped instead of blocking the others.
+// Presence reports are recorded first, whichever replica sent them, and
+// clients get the presence merged over all replicas instead.
+func (h *Hub) Deliver(event models.ChatEvent) {
+	now := time.Now()
+	for _, p := range event.Presence {
+		h.presence.Update(p, now)
+	}
+
+	if len(event.Recipients) == 0 {
+		return
+	}
+
+	if len(event.Presence) > 0 {
+		merged := make([]models.Presence, len(event.Presence))
+		for i, p := range event.Presence {
+			merged[i] = h.presence.Get(p.UserID, now)
+		}
+		event.Presence = merged
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode chat event: %v", err)
+		return
+	}
+
+	var slow []*Client
+
+	h.mu.RLock()
+	for _, userID := range event.Recipients {
+		for c := range h.clients[userID] {
+			select {
+			case c.send <- payload:
+			default:
+				slow = append(slow, c)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, c := range slow {
+		log.Printf("Dropping slow websocket client for user %s", c.userID)
+		h.unregister(c)
+	}
+}
@@ -0,0 +1,72 @@
+package realtime
+
+import (
+	"cloudcord/chat_api/models"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a hub with bob connected, who gets alice's presence
+func hubWithWatcher(t *testing.T) (*Hub, *Client) {
+	hub := NewHub("replica-c")
+	bob := &Client{hub: hub, userID: "bob", send: make(chan []byte, 4), lastActive: time.Now()}
+	hub.register(bob)
+	return hub, bob
+}
+
+func presenceOf(t *testing.T, c *Client) (models.Presence, string) {
+	select {
+	case payload := <-c.send:
+		var event models.ChatEvent
+		require.NoError(t, json.Unmarshal(payload, &event))
+		require.Len(t, event.Presence, 1)
+		return event.Presence[0], string(payload)
+	default:
+		t.Fatal("no event delivered")
+		return models.Presence{}, ""
+	}
+}
+
+func TestDeliver_PresenceMergedOverReplicas(t *testing.T) {
+	hub, bob := hubWithWatcher(t)
+
+	// alice is connected to replica b
+	hub.Deliver(models.ChatEvent{
+		Type:     models.EventPresenceSync,
+		Presence: []models.Presence{{UserID: "alice", Status: models.PresenceOnline, Replica: "replica-b"}},
+	})
+
+	// and drops her connection to replica a
+	hub.Deliver(models.ChatEvent{
+		Type:       models.EventPresenceUpdated,
+		Recipients: []string{"bob"},
+		Presence:   []models.Presence{{UserID: "alice", Status: models.PresenceOffline, LastSeen: time.Now(), Replica: "replica-a"}},
+	})
+
+	p, payload := presenceOf(t, bob)
+	assert.Equal(t, "alice", p.UserID)
+	assert.Equal(t, models.PresenceOnline, p.Status)
+	assert.False(t, strings.Contains(payload, "replica"), "replica leaked to client: %s", payload)
+}
+
+func TestDeliver_PresenceOfflineOnEveryReplica(t *testing.T) {
+	hub, bob := hubWithWatcher(t)
+
+	hub.Deliver(models.ChatEvent{
+		Type:     models.EventPresenceSync,
+		Presence: []models.Presence{{UserID: "alice", Status: models.PresenceOnline, Replica: "replica-b"}},
+	})
+	hub.Deliver(models.ChatEvent{
+		Type:       models.EventPresenceUpdated,
+		Recipients: []string{"bob"},
+		Presence:   []models.Presence{{UserID: "alice", Status: models.PresenceOffline, Replica: "replica-b"}},
+	})
+
+	p, _ := presenceOf(t, bob)
+	assert.Equal(t, models.PresenceOffline, p.Status)
+}
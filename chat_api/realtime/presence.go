@@ -0,0 +1,118 @@
+package realtime
+
+import (
+	"cloudcord/chat_api/models"
+	"sync"
+	"time"
+)
+
+const (
+	// connected users without a heartbeat for this long are idle
+	idleAfter = 5 * time.Minute
+
+	// how often replicas re-announce their users and look for idle ones
+	syncPeriod = 30 * time.Second
+
+	// reports of a replica that stopped syncing are dropped after this, so
+	// users of a crashed replica go offline
+	reportTTL = 3 * syncPeriod
+
+	// offline users are forgotten this long after they were last seen
+	lastSeenTTL = 7 * 24 * time.Hour
+)
+
+var statusRank = map[string]int{
+	models.PresenceOffline: 0,
+	models.PresenceIdle:    1,
+	models.PresenceOnline:  2,
+}
+
+type presenceReport struct {
+	status  string
+	expires time.Time
+}
+
+// PresenceTracker merges the presence reports of all replicas. A user
+// connected to several replicas has the most active status reported.
+//
+// Like presence, last seen is kept in memory only: it's known for users
+// reported since this process started and no longer ago than lastSeenTTL,
+// so after a restart, or on a replica that started later, it can be
+// missing until the user connects again.
+type PresenceTracker struct {
+	mu       sync.RWMutex
+	reports  map[string]map[string]presenceReport // user -> replica -> report
+	lastSeen map[string]time.Time
+}
+
+func NewPresenceTracker() *PresenceTracker {
+	return &PresenceTracker{
+		reports:  make(map[string]map[string]presenceReport),
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// Update records what a replica reported about a user
+func (t *PresenceTracker) Update(p models.Presence, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p.LastSeen.After(t.lastSeen[p.UserID]) {
+		t.lastSeen[p.UserID] = p.LastSeen
+	}
+
+	if p.Status == models.PresenceOffline {
+		delete(t.reports[p.UserID], p.Replica)
+		if len(t.reports[p.UserID]) == 0 {
+			delete(t.reports, p.UserID)
+		}
+		return
+	}
+
+	if t.reports[p.UserID] == nil {
+		t.reports[p.UserID] = make(map[string]presenceReport)
+	}
+	t.reports[p.UserID][p.Replica] = presenceReport{status: p.Status, expires: now.Add(reportTTL)}
+}
+
+// Get the presence of a user across all replicas
+func (t *PresenceTracker) Get(userID string, now time.Time) models.Presence {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	p := models.Presence{
+		UserID:   userID,
+		Status:   models.PresenceOffline,
+		LastSeen: t.lastSeen[userID],
+	}
+	for _, report := range t.reports[userID] {
+		if now.Before(report.expires) && statusRank[report.status] > statusRank[p.Status] {
+			p.Status = report.status
+		}
+	}
+	return p
+}
+
+// Prune drops expired reports and forgets offline users last seen before
+// lastSeenTTL, so the tracker doesn't grow with every user ever seen
+func (t *PresenceTracker) Prune(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for userID, reports := range t.reports {
+		for replica, report := range reports {
+			if !now.Before(report.expires) {
+				delete(reports, replica)
+			}
+		}
+		if len(reports) == 0 {
+			delete(t.reports, userID)
+		}
+	}
+
+	for userID, lastSeen := range t.lastSeen {
+		if _, online := t.reports[userID]; !online && now.Sub(lastSeen) > lastSeenTTL {
+			delete(t.lastSeen, userID)
+		}
+	}
+}
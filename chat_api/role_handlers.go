@@ -0,0 +1,456 @@
+package main
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/middleware"
+	"cloudcord/chat_api/models"
+	"cloudcord/chat_api/users"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type roleRequest struct {
+	GuildID     string            `json:"guild_id"`
+	RoleID      string            `json:"role_id"`
+	Name        string            `json:"name"`
+	Permissions models.Permission `json:"permissions"`
+}
+
+type roleMemberRequest struct {
+	RoleID string `json:"role_id"`
+	UserID uint   `json:"user_id"`
+}
+
+// an overwrite targets either a role or a member, members are given by
+// their user_api ID
+type overwriteRequest struct {
+	ChannelID string            `json:"channel_id"`
+	RoleID    string            `json:"role_id"`
+	UserID    uint              `json:"user_id"`
+	Allow     models.Permission `json:"allow"`
+	Deny      models.Permission `json:"deny"`
+}
+
+type moderationRequest struct {
+	GuildID string `json:"guild_id"`
+	UserID  uint   `json:"user_id"`
+}
+
+// resolve a user_api user ID to the Auth0 ID chat_api stores
+func resolveUser(r *http.Request, directory *users.Client, userID uint) (string, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	return directory.Auth0ID(ctx, r.Header.Get("Authorization"), userID)
+}
+
+// GET lists the roles of a guild, POST creates, PATCH updates and DELETE
+// removes a role
+func roleHandler(guildLogic *logic.GuildService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		switch r.Method {
+		case http.MethodGet:
+			guildID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("guild_id"))
+			if err != nil {
+				http.Error(w, "Invalid guild_id", http.StatusBadRequest)
+				return
+			}
+
+			roles, err := guildLogic.GetRoles(ctx, auth0ID, guildID)
+			if err != nil {
+				http.Error(w, "Error retrieving roles: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(roles)
+
+		case http.MethodPost:
+			var req roleRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			guildID, err := primitive.ObjectIDFromHex(req.GuildID)
+			if err != nil {
+				http.Error(w, "Invalid guild_id", http.StatusBadRequest)
+				return
+			}
+
+			role, err := guildLogic.CreateRole(ctx, auth0ID, guildID, req.Name, req.Permissions)
+			if err != nil {
+				http.Error(w, "Failed to create role: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(role)
+
+		case http.MethodPatch:
+			var req roleRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			roleID, err := primitive.ObjectIDFromHex(req.RoleID)
+			if err != nil {
+				http.Error(w, "Invalid role_id", http.StatusBadRequest)
+				return
+			}
+
+			role, err := guildLogic.UpdateRole(ctx, auth0ID, roleID, req.Name, req.Permissions)
+			if err != nil {
+				http.Error(w, "Failed to update role: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(role)
+
+		case http.MethodDelete:
+			roleID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("role_id"))
+			if err != nil {
+				http.Error(w, "Invalid role_id", http.StatusBadRequest)
+				return
+			}
+
+			if err := guildLogic.DeleteRole(ctx, auth0ID, roleID); err != nil {
+				http.Error(w, "Failed to delete role: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"message":"role deleted"}`))
+
+		default:
+			http.Error(w, "Only GET, POST, PATCH and DELETE methods are allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// POST gives a role to a member, DELETE takes it away
+func roleMembersHandler(guildLogic *logic.GuildService, directory *users.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req roleMemberRequest
+		switch r.Method {
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			userID, err := strconv.ParseUint(r.URL.Query().Get("user_id"), 10, 32)
+			if err != nil {
+				http.Error(w, "Invalid user_id", http.StatusBadRequest)
+				return
+			}
+			req.RoleID = r.URL.Query().Get("role_id")
+			req.UserID = uint(userID)
+		default:
+			http.Error(w, "Only POST and DELETE methods are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		roleID, err := primitive.ObjectIDFromHex(req.RoleID)
+		if err != nil {
+			http.Error(w, "Invalid role_id", http.StatusBadRequest)
+			return
+		}
+
+		member, err := resolveUser(r, directory, req.UserID)
+		if err != nil {
+			http.Error(w, "Failed to look up user: "+err.Error(), statusForError(err))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if r.Method == http.MethodPost {
+			err = guildLogic.AssignRole(ctx, auth0ID, roleID, member)
+		} else {
+			err = guildLogic.UnassignRole(ctx, auth0ID, roleID, member)
+		}
+		if err != nil {
+			http.Error(w, "Failed to update member roles: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message":"member roles updated"}`))
+	}
+}
+
+// POST sets the overwrite of a role or member in a channel, DELETE removes it
+func overwriteHandler(guildLogic *logic.GuildService, directory *users.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req overwriteRequest
+		switch r.Method {
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			query := r.URL.Query()
+			req.ChannelID = query.Get("channel_id")
+			req.RoleID = query.Get("role_id")
+			if userIDStr := query.Get("user_id"); userIDStr != "" {
+				userID, err := strconv.ParseUint(userIDStr, 10, 32)
+				if err != nil {
+					http.Error(w, "Invalid user_id", http.StatusBadRequest)
+					return
+				}
+				req.UserID = uint(userID)
+			}
+		default:
+			http.Error(w, "Only POST and DELETE methods are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		channelID, err := primitive.ObjectIDFromHex(req.ChannelID)
+		if err != nil {
+			http.Error(w, "Invalid channel_id", http.StatusBadRequest)
+			return
+		}
+
+		overwrite := models.PermissionOverwrite{Allow: req.Allow, Deny: req.Deny}
+		switch {
+		case req.RoleID != "" && req.UserID == 0:
+			if _, err := primitive.ObjectIDFromHex(req.RoleID); err != nil {
+				http.Error(w, "Invalid role_id", http.StatusBadRequest)
+				return
+			}
+			overwrite.Type = models.OverwriteRole
+			overwrite.ID = req.RoleID
+		case req.RoleID == "" && req.UserID != 0:
+			member, err := resolveUser(r, directory, req.UserID)
+			if err != nil {
+				http.Error(w, "Failed to look up user: "+err.Error(), statusForError(err))
+				return
+			}
+			overwrite.Type = models.OverwriteMember
+			overwrite.ID = member
+		default:
+			http.Error(w, logic.ErrInvalidOverwrite.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if r.Method == http.MethodDelete {
+			if err := guildLogic.RemoveChannelOverwrite(ctx, auth0ID, channelID, overwrite.Type, overwrite.ID); err != nil {
+				http.Error(w, "Failed to remove overwrite: "+err.Error(), statusForError(err))
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"message":"overwrite removed"}`))
+			return
+		}
+
+		channel, err := guildLogic.SetChannelOverwrite(ctx, auth0ID, channelID, overwrite)
+		if err != nil {
+			http.Error(w, "Failed to set overwrite: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(channel)
+	}
+}
+
+// effective permissions of a member, the caller when user_id isn't given,
+// in the guild or in one of its channels
+func permissionsHandler(guildLogic *logic.GuildService, directory *users.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		query := r.URL.Query()
+
+		guildID, err := primitive.ObjectIDFromHex(query.Get("guild_id"))
+		if err != nil {
+			http.Error(w, "Invalid guild_id", http.StatusBadRequest)
+			return
+		}
+
+		var channelID *primitive.ObjectID
+		if channelIDStr := query.Get("channel_id"); channelIDStr != "" {
+			id, err := primitive.ObjectIDFromHex(channelIDStr)
+			if err != nil {
+				http.Error(w, "Invalid channel_id", http.StatusBadRequest)
+				return
+			}
+			channelID = &id
+		}
+
+		member := auth0ID
+		if userIDStr := query.Get("user_id"); userIDStr != "" {
+			userID, err := strconv.ParseUint(userIDStr, 10, 32)
+			if err != nil {
+				http.Error(w, "Invalid user_id", http.StatusBadRequest)
+				return
+			}
+
+			member, err = resolveUser(r, directory, uint(userID))
+			if err != nil {
+				http.Error(w, "Failed to look up user: "+err.Error(), statusForError(err))
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		perms, err := guildLogic.GetPermissions(ctx, auth0ID, guildID, channelID, member)
+		if err != nil {
+			http.Error(w, "Error computing permissions: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(perms)
+	}
+}
+
+func kickHandler(guildLogic *logic.GuildService, directory *users.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req moderationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		guildID, err := primitive.ObjectIDFromHex(req.GuildID)
+		if err != nil {
+			http.Error(w, "Invalid guild_id", http.StatusBadRequest)
+			return
+		}
+
+		member, err := resolveUser(r, directory, req.UserID)
+		if err != nil {
+			http.Error(w, "Failed to look up user: "+err.Error(), statusForError(err))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if err := guildLogic.KickMember(ctx, auth0ID, guildID, member); err != nil {
+			http.Error(w, "Failed to kick member: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message":"member kicked"}`))
+	}
+}
+
+// POST bans a user, DELETE lifts the ban
+func banHandler(guildLogic *logic.GuildService, directory *users.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req moderationRequest
+		switch r.Method {
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			userID, err := strconv.ParseUint(r.URL.Query().Get("user_id"), 10, 32)
+			if err != nil {
+				http.Error(w, "Invalid user_id", http.StatusBadRequest)
+				return
+			}
+			req.GuildID = r.URL.Query().Get("guild_id")
+			req.UserID = uint(userID)
+		default:
+			http.Error(w, "Only POST and DELETE methods are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		guildID, err := primitive.ObjectIDFromHex(req.GuildID)
+		if err != nil {
+			http.Error(w, "Invalid guild_id", http.StatusBadRequest)
+			return
+		}
+
+		member, err := resolveUser(r, directory, req.UserID)
+		if err != nil {
+			http.Error(w, "Failed to look up user: "+err.Error(), statusForError(err))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if r.Method == http.MethodPost {
+			err = guildLogic.BanMember(ctx, auth0ID, guildID, member)
+		} else {
+			err = guildLogic.UnbanMember(ctx, auth0ID, guildID, member)
+		}
+		if err != nil {
+			http.Error(w, "Failed to update ban: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"message":"ban updated"}`))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"cloudcord/chat_api/logic"
+	"cloudcord/chat_api/middleware"
+	"cloudcord/chat_api/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// search the messages of the caller's conversations. q is required,
+// conversation_id, sender, since, until, before and limit narrow it down.
+func searchHandler(chatLogic *logic.ChatService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		query, err := parseSearchQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		page, err := chatLogic.SearchMessages(ctx, auth0ID, query)
+		if err != nil {
+			http.Error(w, "Error searching messages: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// read the parameters of a search, dates are RFC 3339
+func parseSearchQuery(r *http.Request) (models.SearchQuery, error) {
+	params := r.URL.Query()
+	query := models.SearchQuery{
+		Text:   params.Get("q"),
+		Sender: params.Get("sender"),
+	}
+
+	if conversationID := params.Get("conversation_id"); conversationID != "" {
+		id, err := primitive.ObjectIDFromHex(conversationID)
+		if err != nil {
+			return query, fmt.Errorf("Invalid conversation_id")
+		}
+		query.ChatID = id
+	}
+
+	if before := params.Get("before"); before != "" {
+		id, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			return query, fmt.Errorf("Invalid before cursor")
+		}
+		query.Before = id
+	}
+
+	if since := params.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return query, fmt.Errorf("Invalid since date")
+		}
+		query.Since = t
+	}
+
+	if until := params.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return query, fmt.Errorf("Invalid until date")
+		}
+		query.Until = t
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return query, fmt.Errorf("Invalid limit")
+		}
+		query.Limit = n
+	}
+
+	return query, nil
+}
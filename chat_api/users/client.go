@@ -0,0 +1,114 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+// Client looks up users in user_api. Requests are made on behalf of the
+// caller by forwarding their Authorization header.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// constructor
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type userResponse struct {
+	UserID   uint   `json:"userID"`
+	Username string `json:"username"`
+	Auth0ID  string `json:"auth0_id"`
+}
+
+// Auth0ID resolves the numeric user_api ID of a user to their Auth0 ID,
+// which is how chat_api refers to users
+func (c *Client) Auth0ID(ctx context.Context, authorization string, userID uint) (string, error) {
+	user, err := c.get(ctx, authorization, "/user/user?id="+strconv.FormatUint(uint64(userID), 10))
+	if err != nil {
+		return "", err
+	}
+	return user.Auth0ID, nil
+}
+
+// AreFriends asks user_api whether two users, by Auth0 ID, are friends.
+// ErrUserNotFound when either of them doesn't exist.
+func (c *Client) AreFriends(ctx context.Context, authorization string, auth0ID, otherAuth0ID string) (bool, error) {
+	user, err := c.get(ctx, authorization, "/user/auth-user?auth0_id="+url.QueryEscape(auth0ID))
+	if err != nil {
+		return false, err
+	}
+	other, err := c.get(ctx, authorization, "/user/auth-user?auth0_id="+url.QueryEscape(otherAuth0ID))
+	if err != nil {
+		return false, err
+	}
+
+	path := fmt.Sprintf("/user/is-friend?user_id=%d&other_id=%d", user.UserID, other.UserID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("user_api returned %s", resp.Status)
+	}
+
+	var result struct {
+		AreFriends bool `json:"are_friends"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.AreFriends, nil
+}
+
+func (c *Client) get(ctx context.Context, authorization string, path string) (*userResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrUserNotFound
+	default:
+		return nil, fmt.Errorf("user_api returned %s", resp.Status)
+	}
+
+	var user userResponse
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+	if user.Auth0ID == "" {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"cloudcord/fileStorage/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// Client asks chat_api what users may do in conversations. Requests are
+// made on behalf of the caller by forwarding their token.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// constructor
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type accessResponse struct {
+	Names []string `json:"names"`
+}
+
+// Access gets what the caller may do in a conversation. Non-members may do
+// nothing, that is no error.
+func (c *Client) Access(ctx context.Context, caller models.Caller, conversationID primitive.ObjectID) (models.ChatAccess, error) {
+	var access models.ChatAccess
+
+	endpoint := c.baseURL + "/message/access?conversation_id=" + url.QueryEscape(conversationID.Hex())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return access, err
+	}
+	req.Header.Set("Authorization", "Bearer "+caller.Token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return access, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return access, nil
+	case http.StatusNotFound:
+		return access, ErrConversationNotFound
+	default:
+		return access, fmt.Errorf("chat_api returned %s", resp.Status)
+	}
+
+	var body accessResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return access, err
+	}
+
+	for _, name := range body.Names {
+		switch name {
+		case "view_channel":
+			access.CanView = true
+		case "send_messages":
+			access.CanSend = true
+		}
+	}
+	return access, nil
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"cloudcord/fileStorage/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Take a reference to the blob with the SHA256 of blob, creating it from
+// blob when there is none. The stored blob is returned, its StorageKey is
+// not blob's when the bytes were already there.
+func (r *FileRepository) AcquireBlob(ctx context.Context, blob *models.Blob) (*models.Blob, error) {
+	var stored models.Blob
+	err := r.blobs.FindOneAndUpdate(ctx,
+		bson.M{"_id": blob.SHA256},
+		bson.M{
+			"$inc": bson.M{"refs": 1},
+			"$setOnInsert": bson.M{
+				"storage_key":  blob.StorageKey,
+				"size":         blob.Size,
+				"content_type": blob.ContentType,
+				"created_at":   blob.CreatedAt,
+			},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&stored)
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// Drop a reference to a blob. The blob document goes with the last one,
+// collected tells the caller to remove its bytes. A blob taken again in
+// between keeps its document, the delete only matches an unreferenced blob.
+func (r *FileRepository) ReleaseBlob(ctx context.Context, sha256 string) (*models.Blob, bool, error) {
+	var blob models.Blob
+	err := r.blobs.FindOneAndUpdate(ctx,
+		bson.M{"_id": sha256},
+		bson.M{"$inc": bson.M{"refs": -1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&blob)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if blob.Refs > 0 {
+		return &blob, false, nil
+	}
+
+	result, err := r.blobs.DeleteOne(ctx, bson.M{"_id": sha256, "refs": bson.M{"$lte": 0}})
+	if err != nil {
+		return nil, false, err
+	}
+	return &blob, result.DeletedCount == 1, nil
+}
+
+// Blobs without references, left by a release that failed halfway
+func (r *FileRepository) GetUnreferencedBlobs(ctx context.Context, limit int) ([]models.Blob, error) {
+	cursor, err := r.blobs.Find(ctx, bson.M{"refs": bson.M{"$lte": 0}}, options.Find().SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []models.Blob
+	if err := cursor.All(ctx, &blobs); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// Remove a blob that still has no references
+func (r *FileRepository) DeleteUnreferencedBlob(ctx context.Context, sha256 string) error {
+	result, err := r.blobs.DeleteOne(ctx, bson.M{"_id": sha256, "refs": bson.M{"$lte": 0}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LinkRepository keeps the nonces of spent single use links, a nonce is only
+// needed until its link expires
+type LinkRepository struct {
+	used *mongo.Collection
+}
+
+// constructor
+func NewLinkRepository(db *mongo.Database) *LinkRepository {
+	return &LinkRepository{
+		used: db.Collection("used_links"),
+	}
+}
+
+// Create the index that drops nonces once their link has expired
+func (r *LinkRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.used.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// Spend a nonce, false when it was spent before. The unique _id settles two
+// downloads racing for the same link.
+func (r *LinkRepository) UseLink(ctx context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	_, err := r.used.InsertOne(ctx, bson.M{"_id": nonce, "expires_at": expiresAt})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
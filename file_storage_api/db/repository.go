@@ -0,0 +1,167 @@
+package db
+
+import (
+	"cloudcord/fileStorage/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type FileRepository struct {
+	files *mongo.Collection
+	blobs *mongo.Collection
+}
+
+// constructor
+func NewFileRepository(db *mongo.Database) *FileRepository {
+	return &FileRepository{
+		files: db.Collection("files"),
+		blobs: db.Collection("blobs"),
+	}
+}
+
+// Create the indexes the lookups rely on
+func (r *FileRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.files.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "conversation_id", Value: 1}}},
+		{Keys: bson.D{{Key: "uploaded_by", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.blobs.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "refs", Value: 1}}})
+	return err
+}
+
+// Insert the metadata of a file, filling in its ID when it has none
+func (r *FileRepository) CreateFile(ctx context.Context, file *models.File) error {
+	if file.ID.IsZero() {
+		file.ID = primitive.NewObjectID()
+	}
+	_, err := r.files.InsertOne(ctx, file)
+	return err
+}
+
+func (r *FileRepository) GetFile(ctx context.Context, fileID primitive.ObjectID) (*models.File, error) {
+	var file models.File
+	if err := r.files.FindOne(ctx, bson.M{"_id": fileID}).Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (r *FileRepository) DeleteFile(ctx context.Context, fileID primitive.ObjectID) error {
+	result, err := r.files.DeleteOne(ctx, bson.M{"_id": fileID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Save the outcome of processing a file. Only a file still waiting for it
+// is updated, ErrNoDocuments means it was deleted or already processed.
+func (r *FileRepository) SaveProcessing(ctx context.Context, file *models.File) error {
+	result, err := r.files.UpdateOne(ctx,
+		bson.M{"_id": file.ID, "processing": models.ProcessingPending},
+		bson.M{"$set": bson.M{
+			"processing":  file.Processing,
+			"size":        file.Size,
+			"sha256":      file.SHA256,
+			"storage_key": file.StorageKey,
+			"media":       file.Media,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *FileRepository) GetFilesByUploader(ctx context.Context, userID string, limit int) ([]models.File, error) {
+	cursor, err := r.files.Find(ctx, bson.M{"uploaded_by": userID}, options.Find().SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []models.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Add up the files of a user and the length of their resumable uploads
+// that haven't expired
+func (r *FileRepository) GetUsage(ctx context.Context, userID string) (*models.Usage, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"uploaded_by": userID}}},
+		{{Key: "$project", Value: bson.M{"size": 1, "pending": bson.M{"$literal": false}}}},
+		{{Key: "$unionWith", Value: bson.M{
+			"coll": uploadsCollection,
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"uploaded_by": userID, "expires_at": bson.M{"$gt": time.Now()}}},
+				bson.M{"$project": bson.M{"size": "$length", "pending": bson.M{"$literal": true}}},
+			},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$pending",
+			"bytes": bson.M{"$sum": "$size"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+
+	cursor, err := r.files.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Pending bool  `bson:"_id"`
+		Bytes   int64 `bson:"bytes"`
+		Count   int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	usage := &models.Usage{}
+	for _, group := range groups {
+		if group.Pending {
+			usage.Pending = group.Bytes
+		} else {
+			usage.Bytes = group.Bytes
+			usage.Files = group.Count
+		}
+	}
+	return usage, nil
+}
+
+// Save the verdict of a scan. Only a file still waiting for one is updated,
+// ErrNoDocuments means it was deleted or already scanned.
+func (r *FileRepository) SaveScan(ctx context.Context, file *models.File) error {
+	result, err := r.files.UpdateOne(ctx,
+		bson.M{"_id": file.ID, "scan": models.ScanPending},
+		bson.M{"$set": bson.M{
+			"scan":   file.Scan,
+			"threat": file.Threat,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
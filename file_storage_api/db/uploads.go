@@ -0,0 +1,108 @@
+package db
+
+import (
+	"cloudcord/fileStorage/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const uploadsCollection = "uploads"
+
+type UploadRepository struct {
+	uploads *mongo.Collection
+}
+
+// constructor
+func NewUploadRepository(db *mongo.Database) *UploadRepository {
+	return &UploadRepository{
+		uploads: db.Collection(uploadsCollection),
+	}
+}
+
+// Create the indexes the lookups rely on
+func (r *UploadRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.uploads.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "uploaded_by", Value: 1}}},
+	})
+	return err
+}
+
+func (r *UploadRepository) CreateUpload(ctx context.Context, upload *models.UploadSession) error {
+	if upload.ID.IsZero() {
+		upload.ID = primitive.NewObjectID()
+	}
+	_, err := r.uploads.InsertOne(ctx, upload)
+	return err
+}
+
+func (r *UploadRepository) GetUpload(ctx context.Context, uploadID primitive.ObjectID) (*models.UploadSession, error) {
+	var upload models.UploadSession
+	if err := r.uploads.FindOne(ctx, bson.M{"_id": uploadID}).Decode(&upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// Add a part received at offset and move the session's expiry. Nothing
+// changes when another chunk got there first, that is ErrNoDocuments.
+func (r *UploadRepository) AppendPart(ctx context.Context, uploadID primitive.ObjectID, offset int64, part models.UploadPart, expiresAt time.Time) (*models.UploadSession, error) {
+	var upload models.UploadSession
+	err := r.uploads.FindOneAndUpdate(ctx,
+		bson.M{"_id": uploadID, "offset": offset},
+		bson.M{
+			"$push": bson.M{"parts": part},
+			"$inc":  bson.M{"offset": part.Size},
+			"$set":  bson.M{"expires_at": expiresAt},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&upload)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (r *UploadRepository) DeleteUpload(ctx context.Context, uploadID primitive.ObjectID) error {
+	result, err := r.uploads.DeleteOne(ctx, bson.M{"_id": uploadID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Sessions that expired before now, oldest first
+func (r *UploadRepository) GetExpiredUploads(ctx context.Context, now time.Time, limit int) ([]models.UploadSession, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "expires_at", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.uploads.Find(ctx, bson.M{"expires_at": bson.M{"$lt": now}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []models.UploadSession
+	if err := cursor.All(ctx, &uploads); err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+func (r *UploadRepository) GetUploadsByUploader(ctx context.Context, userID string) ([]models.UploadSession, error) {
+	cursor, err := r.uploads.Find(ctx, bson.M{"uploaded_by": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads []models.UploadSession
+	if err := cursor.All(ctx, &uploads); err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
@@ -1,26 +1,344 @@
 package main
 
 import (
+	"cloudcord/fileStorage/chat"
+	"cloudcord/fileStorage/db"
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/middleware"
+	"cloudcord/fileStorage/mq"
+	"cloudcord/fileStorage/scan"
+	"cloudcord/fileStorage/signing"
+	"cloudcord/fileStorage/storage"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func handleOK(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "200 file storage! Current time is: %s", time.Now())
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
 
-	log.Printf("Request received: Method: %s, Path: %s, Headers: %v\n", r.Method, r.URL.Path, r.Header)
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
 
-	w.WriteHeader(http.StatusOK)
+		if isAllowedOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset, Upload-Checksum")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition, ETag, Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Expires")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isAllowedOrigin(origin string) bool {
+	return origin == "http://localhost:3000" || origin == "https://cloudcord.com" || origin == "https://cloudcord.info"
+}
+
+// the backend named by STORAGE_BACKEND, local disk unless it is s3
+func newStorage(ctx context.Context) (logic.Storage, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "/data/files"
+		}
+		return storage.NewLocal(dir)
+	case "s3":
+		bucket := os.Getenv("S3_BUCKET")
+		if bucket == "" {
+			bucket = "cloudcord-files"
+		}
+		return storage.NewS3(ctx, storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Bucket:    bucket,
+			UseSSL:    os.Getenv("S3_USE_SSL") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// the upload policy, FILE_QUOTA and MAX_FILE_SIZE are in bytes and the
+// content type lists comma separated
+func policyFromEnv() (logic.Policy, error) {
+	policy := logic.DefaultPolicy
+
+	for name, limit := range map[string]*int64{"FILE_QUOTA": &policy.Quota, "MAX_FILE_SIZE": &policy.MaxFileSize} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return policy, fmt.Errorf("%s must be a number of bytes", name)
+		}
+		*limit = n
+	}
+
+	if value := os.Getenv("ALLOWED_CONTENT_TYPES"); value != "" {
+		policy.AllowedTypes = strings.Split(value, ",")
+	}
+	if value := os.Getenv("DENIED_CONTENT_TYPES"); value != "" {
+		policy.DeniedTypes = strings.Split(value, ",")
+	}
+
+	if policy.MaxFileSize == 0 {
+		return policy, fmt.Errorf("MAX_FILE_SIZE must be positive")
+	}
+	return policy, nil
+}
+
+// the scanner named by SCANNER, clamd or none
+func newScanner() (scan.Scanner, error) {
+	switch name := os.Getenv("SCANNER"); name {
+	case "", "none":
+		log.Println("⚠️ Uploads are not scanned for malware")
+		return scan.Noop{}, nil
+	case "clamd":
+		address := os.Getenv("CLAMD_ADDRESS")
+		if address == "" {
+			address = "clamav-service:3310"
+		}
+		return scan.NewClamd(address), nil
+	default:
+		return nil, fmt.Errorf("unknown scanner %q", name)
+	}
+}
+
+// the signer of download links, DOWNLOAD_SIGNING_KEYS holds "id:base64"
+// keys with the one to sign with first. It's required: every replica has to
+// verify the links of the others, also after restarting.
+func newSigner() (*signing.Signer, error) {
+	spec := os.Getenv("DOWNLOAD_SIGNING_KEYS")
+	if spec == "" {
+		return nil, fmt.Errorf("DOWNLOAD_SIGNING_KEYS is not set")
+	}
+	return signing.ParseKeys(spec)
+}
+
+// a publisher to a work queue, retried while RabbitMQ starts
+func newPublisher(rabbitURI, queueName string) *mq.Publisher {
+	var publisher *mq.Publisher
+	var err error
+	for i := 0; i < 8; i++ {
+		publisher, err = mq.NewPublisher(rabbitURI, queueName)
+		if err == nil {
+			log.Printf("✅ RabbitMQ publisher for %s set up successfully", queueName)
+			return publisher
+		}
+		log.Printf("Attempt %d: Failed to set up RabbitMQ publisher for %s: %v", i+1, queueName, err)
+		time.Sleep(3 * time.Second)
+	}
+
+	log.Fatalf("Failed to set up RabbitMQ publisher for %s after retries: %v", queueName, err)
+	return nil
 }
 
 func main() {
-	http.HandleFunc("/", handleOK) // 200 Ok
+	user := os.Getenv("MONGODB_USER")
+	pass := os.Getenv("MONGODB_PASS")
 
-	fmt.Println("Starting server on :8082...")
+	if user == "" || pass == "" {
+		log.Fatal("MongoDB credentials are not set in environment variables")
+	}
+
+	uri := fmt.Sprintf(
+		"mongodb+srv://%s:%s@messages.vbkzymr.mongodb.net/?retryWrites=true&w=majority&appName=Messages",
+		user,
+		pass,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer client.Disconnect(ctx)
+
+	if err := client.Ping(ctx, nil); err != nil {
+		log.Fatal("Could not connect to MongoDB:", err)
+	}
 
-	http.ListenAndServe(":8082", nil)
+	log.Println("✅ Successfully connected to MongoDB Atlas")
+
+	middleware.InitMiddleware()
+
+	filesDB := client.Database("Files")
+
+	fileRepo := db.NewFileRepository(filesDB)
+	uploadRepo := db.NewUploadRepository(filesDB)
+	linkRepo := db.NewLinkRepository(filesDB)
+	if err := fileRepo.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+	if err := uploadRepo.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+	if err := linkRepo.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	backend, err := newStorage(ctx)
+	if err != nil {
+		log.Fatalf("Failed to set up file storage: %v", err)
+	}
+
+	chatAPIURL := os.Getenv("CHAT_API_URL")
+	if chatAPIURL == "" {
+		chatAPIURL = "http://chat-api-service:8084"
+	}
+
+	rabbitURI := os.Getenv("RABBITMQ_URI")
+	if rabbitURI == "" {
+		log.Fatal("RabbitMQ path not set in environment")
+	}
+
+	scanPublisher := newPublisher(rabbitURI, "file_scanning")
+	mediaPublisher := newPublisher(rabbitURI, "media_processing")
+	statusPublisher := newPublisher(rabbitURI, "attachment_status")
+
+	scanner, err := newScanner()
+	if err != nil {
+		log.Fatalf("Failed to set up the malware scanner: %v", err)
+	}
+
+	policy, err := policyFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid upload policy: %v", err)
+	}
+
+	signer, err := newSigner()
+	if err != nil {
+		log.Fatalf("Invalid download signing keys: %v", err)
+	}
+
+	fileService := logic.NewFileService(fileRepo, backend, chat.NewClient(chatAPIURL), scanPublisher, policy)
+	uploadService := logic.NewUploadService(fileService, uploadRepo)
+	scanService := logic.NewScanService(fileService, scanner, mediaPublisher, statusPublisher)
+	linkService := logic.NewLinkService(fileService, signer, linkRepo, os.Getenv("PUBLIC_URL"))
+
+	go func() {
+		maxRetries := 8
+		for i := 0; i < maxRetries; i++ {
+			err := mq.StartFileJobConsumer(rabbitURI, "file_scanning", scanService.ScanFile)
+			if err == nil {
+				log.Println("✅ Scan consumer started successfully, listening on RabbitMQ...")
+				return
+			}
+
+			log.Printf("Attempt %d: Failed to start scan consumer: %v", i+1, err)
+			time.Sleep(3 * time.Second)
+		}
+
+		log.Fatal("❌ Failed to start scan consumer after retries")
+	}()
+
+	go func() {
+		maxRetries := 8
+		for i := 0; i < maxRetries; i++ {
+			err := mq.StartFileJobConsumer(rabbitURI, "media_processing", fileService.ProcessMedia)
+			if err == nil {
+				log.Println("✅ Media consumer started successfully, listening on RabbitMQ...")
+				return
+			}
+
+			log.Printf("Attempt %d: Failed to start media consumer: %v", i+1, err)
+			time.Sleep(3 * time.Second)
+		}
+
+		log.Fatal("❌ Failed to start media consumer after retries")
+	}()
+
+	go func() {
+		maxRetries := 8
+		for i := 0; i < maxRetries; i++ {
+			err := mq.StartUserDeletionConsumer(rabbitURI, "user_deletion", "file_storage.user_deletion", func(ctx context.Context, userID string) error {
+				if err := uploadService.DeleteUserUploads(ctx, userID); err != nil {
+					return err
+				}
+				return fileService.DeleteUserFiles(ctx, userID)
+			})
+			if err == nil {
+				log.Println("✅ User deletion consumer started successfully, listening on RabbitMQ...")
+				return
+			}
+
+			log.Printf("Attempt %d: Failed to start user deletion consumer: %v", i+1, err)
+			time.Sleep(3 * time.Second)
+		}
+
+		log.Fatal("❌ Failed to start user deletion consumer after retries")
+	}()
+
+	go func() {
+		maxRetries := 8
+		for i := 0; i < maxRetries; i++ {
+			err := mq.StartReleaseConsumer(rabbitURI, "released_attachments", fileService.ReleaseFiles)
+			if err == nil {
+				log.Println("✅ Release consumer started successfully, listening on RabbitMQ...")
+				return
+			}
+
+			log.Printf("Attempt %d: Failed to start release consumer: %v", i+1, err)
+			time.Sleep(3 * time.Second)
+		}
+
+		log.Fatal("❌ Failed to start release consumer after retries")
+	}()
+
+	// abandoned uploads and blobs nobody refers to hold storage until
+	// they're swept
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			sweepCtx, cancelSweep := context.WithTimeout(context.Background(), 10*time.Minute)
+			if err := uploadService.ExpireUploads(sweepCtx); err != nil {
+				log.Printf("Failed to remove expired uploads: %v", err)
+			}
+			if err := fileService.CollectBlobs(sweepCtx); err != nil {
+				log.Printf("Failed to collect blobs: %v", err)
+			}
+			cancelSweep()
+		}
+	}()
+
+	http.HandleFunc("/", handleOK)
+
+	http.Handle("/files", withCORS(middleware.ValidateJWT(uploadHandler(fileService))))
+	http.Handle("/files/", withCORS(middleware.ValidateJWT(fileRoutes(fileService))))
+	http.Handle("/files/avatars", withCORS(middleware.ValidateJWT(avatarUploadHandler(fileService))))
+	http.Handle("/files/links", withCORS(middleware.ValidateJWT(linksHandler(linkService))))
+	http.Handle(logic.SignedPath, withCORS(signedDownloadHandler(linkService)))
+	http.Handle("/files/usage", withCORS(middleware.ValidateJWT(usageHandler(fileService))))
+	http.Handle("/files/uploads", withTus(policy.MaxFileSize, withCORS(middleware.ValidateJWT(createUploadHandler(uploadService)))))
+	http.Handle(uploadsPath, withTus(policy.MaxFileSize, withCORS(middleware.ValidateJWT(uploadRoutes(uploadService)))))
+
+	fmt.Println("Starting server on :8082...")
 
 	log.Fatal(http.ListenAndServe(":8082", nil))
 }
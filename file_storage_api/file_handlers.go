@@ -0,0 +1,328 @@
+package main
+
+import (
+	"cloudcord/fileStorage/chat"
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/middleware"
+	"cloudcord/fileStorage/models"
+	"cloudcord/fileStorage/signing"
+	"cloudcord/fileStorage/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// uploads and downloads stream the whole file, they get more time than the
+// other requests
+const transferTimeout = 2 * time.Minute
+
+// room for the other form fields next to the file
+const maxFormOverhead = 1 << 20
+
+// the authenticated user and their token, which is forwarded to chat_api
+func callerFromRequest(r *http.Request) (models.Caller, bool) {
+	auth0ID, ok := middleware.Auth0IDFromContext(r.Context())
+	if !ok {
+		return models.Caller{}, false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return models.Caller{UserID: auth0ID, Token: token}, true
+}
+
+// POST /files, a multipart form with conversation_id, file and optionally
+// the sha256 of the file
+func uploadHandler(fileLogic *logic.FileService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		caller, ok := callerFromRequest(r)
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, fileLogic.Policy().MaxFileSize+maxFormOverhead)
+		if err := r.ParseMultipartForm(maxFormOverhead); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Failed to upload file: "+logic.ErrFileTooLarge.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid multipart form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		conversationID, err := primitive.ObjectIDFromHex(r.FormValue("conversation_id"))
+		if err != nil {
+			http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+			return
+		}
+
+		content, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Missing file", http.StatusBadRequest)
+			return
+		}
+		defer content.Close()
+
+		ctx, cancel := context.WithTimeout(r.Context(), transferTimeout)
+		defer cancel()
+
+		file, err := fileLogic.Upload(ctx, caller, logic.Upload{
+			ConversationID: conversationID,
+			Name:           header.Filename,
+			Content:        content,
+			SHA256:         r.FormValue("sha256"),
+		})
+		if err != nil {
+			http.Error(w, "Failed to upload file: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(file)
+	}
+}
+
+// POST /files/avatars, a multipart form with the image to use as avatar
+func avatarUploadHandler(fileLogic *logic.FileService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		caller, ok := callerFromRequest(r)
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, logic.MaxAvatarSize+maxFormOverhead)
+		if err := r.ParseMultipartForm(maxFormOverhead); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Failed to upload avatar: "+logic.ErrFileTooLarge.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid multipart form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		content, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Missing file", http.StatusBadRequest)
+			return
+		}
+		defer content.Close()
+
+		ctx, cancel := context.WithTimeout(r.Context(), transferTimeout)
+		defer cancel()
+
+		file, err := fileLogic.UploadAvatar(ctx, caller, header.Filename, content)
+		if err != nil {
+			http.Error(w, "Failed to upload avatar: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(file)
+	}
+}
+
+// /files/{id} downloads or deletes a file, /files/{id}/info gets its
+// metadata
+func fileRoutes(fileLogic *logic.FileService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := callerFromRequest(r)
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		rest := strings.TrimPrefix(r.URL.Path, "/files/")
+		idPart, action, _ := strings.Cut(rest, "/")
+
+		fileID, err := primitive.ObjectIDFromHex(idPart)
+		if err != nil {
+			http.Error(w, "Invalid file ID", http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			downloadFile(w, r, fileLogic, caller, fileID)
+		case action == "" && r.Method == http.MethodDelete:
+			deleteFile(w, r, fileLogic, caller, fileID)
+		case action == "info" && r.Method == http.MethodGet:
+			fileInfo(w, r, fileLogic, caller, fileID)
+		case action == "" || action == "info":
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// ?size= picks a thumbnail of an image
+func downloadFile(w http.ResponseWriter, r *http.Request, fileLogic *logic.FileService, caller models.Caller, fileID primitive.ObjectID) {
+	size := 0
+	if param := r.URL.Query().Get("size"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), transferTimeout)
+	defer cancel()
+
+	download, err := fileLogic.Open(ctx, caller, fileID, size)
+	if err != nil {
+		http.Error(w, "Failed to get file: "+err.Error(), statusForError(err))
+		return
+	}
+	defer download.Content.Close()
+
+	writeDownload(w, r, download, "private, max-age=86400")
+}
+
+// send a file opened for download, unless the client has it already
+func writeDownload(w http.ResponseWriter, r *http.Request, download *logic.Download, cacheControl string) {
+	etag := `"` + download.ETag + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", download.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(download.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": download.File.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, download.Content); err != nil {
+		log.Printf("Failed to send file %s: %v", download.File.ID.Hex(), err)
+	}
+}
+
+func fileInfo(w http.ResponseWriter, r *http.Request, fileLogic *logic.FileService, caller models.Caller, fileID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	file, err := fileLogic.GetFile(ctx, caller, fileID)
+	if err != nil {
+		http.Error(w, "Failed to get file: "+err.Error(), statusForError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
+
+func deleteFile(w http.ResponseWriter, r *http.Request, fileLogic *logic.FileService, caller models.Caller, fileID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := fileLogic.DeleteFile(ctx, caller, fileID); err != nil {
+		http.Error(w, "Failed to delete file: "+err.Error(), statusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /files/usage, how much of their quota the caller uses
+func usageHandler(fileLogic *logic.FileService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		caller, ok := callerFromRequest(r)
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		usage, err := fileLogic.Usage(ctx, caller)
+		if err != nil {
+			http.Error(w, "Failed to get usage: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage)
+	}
+}
+
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments),
+		errors.Is(err, storage.ErrNotFound),
+		errors.Is(err, chat.ErrConversationNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, signing.ErrInvalidLink):
+		return http.StatusForbidden
+	case errors.Is(err, signing.ErrLinkExpired),
+		errors.Is(err, logic.ErrLinkUsed):
+		return http.StatusGone
+	case errors.Is(err, logic.ErrNotMember),
+		errors.Is(err, logic.ErrMissingPermission),
+		errors.Is(err, logic.ErrNotUploader),
+		errors.Is(err, logic.ErrQuarantined):
+		return http.StatusForbidden
+	case errors.Is(err, logic.ErrEmptyFile),
+		errors.Is(err, logic.ErrChecksumMismatch),
+		errors.Is(err, logic.ErrInvalidSize),
+		errors.Is(err, logic.ErrInvalidLength),
+		errors.Is(err, logic.ErrTooManyLinks),
+		errors.Is(err, logic.ErrUnsupportedChecksum):
+		return http.StatusBadRequest
+	case errors.Is(err, logic.ErrOffsetMismatch):
+		return http.StatusConflict
+	case errors.Is(err, logic.ErrUploadExpired):
+		return http.StatusGone
+	case errors.Is(err, logic.ErrChunkChecksum):
+		// tus' Checksum Mismatch
+		return 460
+	case errors.Is(err, logic.ErrNotProcessed),
+		errors.Is(err, logic.ErrNotScanned):
+		return http.StatusConflict
+	case errors.Is(err, logic.ErrProcessingFailed):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, logic.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, logic.ErrTypeNotAllowed),
+		errors.Is(err, logic.ErrNotAnImage):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, logic.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
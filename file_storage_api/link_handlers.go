@@ -0,0 +1,99 @@
+package main
+
+import (
+	"cloudcord/fileStorage/logic"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type linksRequest struct {
+	FileIDs    []primitive.ObjectID `json:"file_ids"`
+	Size       int                  `json:"size"`
+	TTLSeconds int64                `json:"ttl_seconds"`
+	SingleUse  bool                 `json:"single_use"`
+}
+
+// POST /files/links signs download URLs to files the caller can see, they
+// work without a token until they expire
+func linksHandler(linkLogic *logic.LinkService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		caller, ok := callerFromRequest(r)
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var req linksRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		links, err := linkLogic.IssueLinks(ctx, caller, logic.LinkRequest{
+			FileIDs:   req.FileIDs,
+			Size:      req.Size,
+			TTL:       time.Duration(req.TTLSeconds) * time.Second,
+			SingleUse: req.SingleUse,
+		})
+		if err != nil {
+			http.Error(w, "Failed to sign links: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"links": links})
+	}
+}
+
+// GET /files/signed/{id} downloads a file by a signed link, no token needed.
+// Reusable links may be cached by anyone until they expire.
+func signedDownloadHandler(linkLogic *logic.LinkService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		fileID, err := primitive.ObjectIDFromHex(strings.TrimPrefix(r.URL.Path, logic.SignedPath))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		link, err := linkLogic.Verify(fileID, r.URL.Query())
+		if err != nil {
+			http.Error(w, "Failed to get file: "+err.Error(), statusForError(err))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), transferTimeout)
+		defer cancel()
+
+		download, err := linkLogic.Open(ctx, link)
+		if err != nil {
+			http.Error(w, "Failed to get file: "+err.Error(), statusForError(err))
+			return
+		}
+		defer download.Content.Close()
+
+		cacheControl := "no-store"
+		if link.Nonce == "" {
+			cacheControl = fmt.Sprintf("public, max-age=%d", int(time.Until(link.ExpiresAt).Seconds()))
+		}
+		writeDownload(w, r, download, cacheControl)
+	}
+}
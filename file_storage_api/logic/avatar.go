@@ -0,0 +1,37 @@
+package logic
+
+import (
+	"cloudcord/fileStorage/models"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// avatars are shown small, a large photo is cut down by the thumbnails
+const MaxAvatarSize = 8 << 20
+
+var ErrNotAnImage = errors.New("avatar must be an image")
+
+// store an image the caller can set as their avatar. It belongs to no
+// conversation and goes through the same scanning and processing as an
+// attachment, others get it once that is done.
+func (s *FileService) UploadAvatar(ctx context.Context, caller models.Caller, name string, content io.Reader) (*models.File, error) {
+	if err := s.checkQuota(ctx, caller.UserID, 1); err != nil {
+		return nil, err
+	}
+
+	file := &models.File{
+		ID:         primitive.NewObjectID(),
+		UploadedBy: caller.UserID,
+		Name:       cleanFileName(name),
+		Purpose:    models.PurposeAvatar,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.store(ctx, file, content, "", min(MaxAvatarSize, s.policy.MaxFileSize), 0); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,57 @@
+package logic_test
+
+import (
+	"bytes"
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/models"
+	"context"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUploadAvatar(t *testing.T) {
+	service, repo, storage, chats, _ := newService()
+	ctx := context.Background()
+
+	storage.On("Put", ctx, mock.Anything, "image/png").Return(nil)
+	repo.On("CreateFile", ctx, mock.AnythingOfType("*models.File")).Return(nil)
+
+	content := encodePNG(t, solidImage(64, 64, color.White))
+	file, err := service.UploadAvatar(ctx, alice, "me.png", bytes.NewReader(content))
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.PurposeAvatar, file.Purpose)
+	assert.True(t, file.ConversationID.IsZero())
+	assert.Equal(t, models.ProcessingPending, file.Processing)
+	chats.AssertNotCalled(t, "Access", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUploadAvatar_NotAnImage(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+
+	_, err := service.UploadAvatar(ctx, alice, "me.txt", strings.NewReader("just text"))
+
+	assert.ErrorIs(t, err, logic.ErrNotAnImage)
+	storage.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+	repo.AssertNotCalled(t, "CreateFile", mock.Anything, mock.Anything)
+}
+
+func TestGetFile_AvatarForEveryone(t *testing.T) {
+	service, repo, _, chats, _ := newService()
+	ctx := context.Background()
+	file := &models.File{ID: primitive.NewObjectID(), UploadedBy: "alice", Purpose: models.PurposeAvatar}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+
+	got, err := service.GetFile(ctx, bob, file.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, file, got)
+	chats.AssertNotCalled(t, "Access", mock.Anything, mock.Anything, mock.Anything)
+}
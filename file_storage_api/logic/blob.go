@@ -0,0 +1,119 @@
+package logic
+
+import (
+	"cloudcord/fileStorage/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// unreferenced blobs collected per sweep
+const blobSweepBatch = 500
+
+// point a file whose bytes were just stored at the blob of its SHA256. When
+// the same bytes are already there the new copy is removed and the file
+// shares the stored one.
+func (s *FileService) acquireBlob(ctx context.Context, file *models.File) error {
+	blob, err := s.repo.AcquireBlob(ctx, &models.Blob{
+		SHA256:      file.SHA256,
+		StorageKey:  file.StorageKey,
+		Size:        file.Size,
+		ContentType: file.ContentType,
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if blob.StorageKey != file.StorageKey {
+		s.removeObject(file.StorageKey)
+		file.StorageKey = blob.StorageKey
+		log.Printf("File %s shares blob %s", file.ID.Hex(), blob.SHA256)
+	}
+	return nil
+}
+
+// drop the reference of a file to its blob, removing the bytes with the
+// last one. Files stored before blobs own their objects.
+func (s *FileService) releaseBlob(file *models.File) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	blob, collected, err := s.repo.ReleaseBlob(ctx, file.SHA256)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		s.removeObjects(file)
+		return
+	}
+	if err != nil {
+		// the sweep collects it if this was the last reference
+		log.Printf("Failed to release blob %s: %v", file.SHA256, err)
+		return
+	}
+
+	if collected {
+		s.removeBlobObjects(blob.StorageKey)
+	}
+}
+
+// remove the bytes of a blob and the thumbnails made of them
+func (s *FileService) removeBlobObjects(key string) {
+	s.removeObject(key)
+	for _, size := range ThumbnailSizes {
+		s.removeObject(fmt.Sprintf("%s-%d", key, size))
+	}
+}
+
+// remove the files of messages that were deleted, chat_api lets go of them
+func (s *FileService) ReleaseFiles(ctx context.Context, fileIDs []primitive.ObjectID) error {
+	for _, fileID := range fileIDs {
+		file, err := s.repo.GetFile(ctx, fileID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.repo.DeleteFile(ctx, file.ID); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				continue
+			}
+			return err
+		}
+		s.releaseBlob(file)
+		log.Printf("File %s released by its message", file.ID.Hex())
+	}
+	return nil
+}
+
+// collect blobs whose last release failed before removing them
+func (s *FileService) CollectBlobs(ctx context.Context) error {
+	blobs, err := s.repo.GetUnreferencedBlobs(ctx, blobSweepBatch)
+	if err != nil {
+		return err
+	}
+
+	collected := 0
+	for _, blob := range blobs {
+		err := s.repo.DeleteUnreferencedBlob(ctx, blob.SHA256)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// referenced again meanwhile
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.removeBlobObjects(blob.StorageKey)
+		collected++
+	}
+
+	if collected > 0 {
+		log.Printf("Collected %d unreferenced blobs", collected)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package logic_test
+
+import (
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/models"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func uploadText(t *testing.T, service *logic.FileService, chatID primitive.ObjectID, content string) *models.File {
+	file, err := service.Upload(context.Background(), alice, logic.Upload{ConversationID: chatID, Name: "meme.txt", Content: strings.NewReader(content)})
+	assert.NoError(t, err)
+	return file
+}
+
+func TestUpload_IdenticalBytesStoredOnce(t *testing.T) {
+	service, repo, storage, chats, _ := newService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	storage.On("Put", ctx, mock.Anything, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+	repo.On("CreateFile", ctx, mock.AnythingOfType("*models.File")).Return(nil)
+
+	first := uploadText(t, service, chatID, "the same old joke")
+	second := uploadText(t, service, chatID, "the same old joke")
+
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, first.StorageKey, second.StorageKey)
+	assert.Len(t, storage.objects, 1)
+	assert.Equal(t, int64(2), repo.blobs[first.SHA256].Refs)
+}
+
+func TestDeleteFile_LastReferenceRemovesBlob(t *testing.T) {
+	service, repo, storage, chats, _ := newService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	storage.On("Put", ctx, mock.Anything, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+	repo.On("CreateFile", ctx, mock.AnythingOfType("*models.File")).Return(nil)
+	repo.On("DeleteFile", ctx, mock.Anything).Return(nil)
+
+	first := uploadText(t, service, chatID, "forwarded again")
+	second := uploadText(t, service, chatID, "forwarded again")
+	repo.On("GetFile", ctx, first.ID).Return(first, nil)
+	repo.On("GetFile", ctx, second.ID).Return(second, nil)
+
+	assert.NoError(t, service.DeleteFile(ctx, alice, first.ID))
+	assert.Contains(t, storage.objects, second.StorageKey)
+
+	assert.NoError(t, service.DeleteFile(ctx, alice, second.ID))
+	assert.Empty(t, storage.objects)
+	assert.Empty(t, repo.blobs)
+}
+
+func TestReleaseFiles(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+	file := &models.File{ID: primitive.NewObjectID(), UploadedBy: "alice", SHA256: "abc", StorageKey: "key"}
+	repo.blobs = map[string]*models.Blob{"abc": {SHA256: "abc", StorageKey: "key", Refs: 1}}
+	storage.objects = map[string][]byte{"key": []byte("bytes"), "key-64": []byte("thumb")}
+	gone := primitive.NewObjectID()
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	repo.On("GetFile", ctx, gone).Return(nil, mongo.ErrNoDocuments)
+	repo.On("DeleteFile", ctx, file.ID).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	err := service.ReleaseFiles(ctx, []primitive.ObjectID{gone, file.ID})
+
+	assert.NoError(t, err)
+	assert.Empty(t, storage.objects)
+	assert.Empty(t, repo.blobs)
+}
+
+func TestCollectBlobs(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+	repo.blobs = map[string]*models.Blob{
+		"orphan": {SHA256: "orphan", StorageKey: "orphan-key", Refs: 0},
+		"used":   {SHA256: "used", StorageKey: "used-key", Refs: 2},
+	}
+	storage.objects = map[string][]byte{"orphan-key": []byte("a"), "used-key": []byte("b")}
+
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	err := service.CollectBlobs(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"used-key": []byte("b")}, storage.objects)
+	assert.NotContains(t, repo.blobs, "orphan")
+}
@@ -0,0 +1,162 @@
+package logic
+
+import (
+	"cloudcord/fileStorage/models"
+	"cloudcord/fileStorage/signing"
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// links asked for without a lifetime, long enough to read a chat
+	DefaultLinkTTL = time.Hour
+
+	// longer lifetimes are cut down to this, rotating the signing key is
+	// the only way to revoke a link sooner
+	MaxLinkTTL = 7 * 24 * time.Hour
+
+	// files linked in one request, a page of chat history fits
+	MaxLinksPerRequest = 100
+
+	// path of signed downloads, served without a token
+	SignedPath = "/files/signed/"
+)
+
+var (
+	ErrTooManyLinks = errors.New("too many files to link")
+	ErrLinkUsed     = errors.New("download link was already used")
+)
+
+// UsedLinks remembers the nonces of single use links until they expire
+type UsedLinks interface {
+	UseLink(ctx context.Context, nonce string, expiresAt time.Time) (bool, error)
+}
+
+// LinkService hands out signed URLs to files, for <img> tags and CDNs that
+// can't send a token. A link is checked by its signature alone, it stays
+// valid when the holder loses access to the conversation.
+type LinkService struct {
+	files   *FileService
+	signer  *signing.Signer
+	used    UsedLinks
+	baseURL string
+}
+
+// constructor, baseURL is where clients reach this service and may be empty
+// for links relative to it
+func NewLinkService(files *FileService, signer *signing.Signer, used UsedLinks, baseURL string) *LinkService {
+	return &LinkService{files: files, signer: signer, used: used, baseURL: baseURL}
+}
+
+// LinkRequest asks for links to files, or to their thumbnails of Size
+type LinkRequest struct {
+	FileIDs   []primitive.ObjectID
+	Size      int
+	TTL       time.Duration
+	SingleUse bool
+}
+
+// SignedLink is the URL a file can be downloaded from until ExpiresAt
+type SignedLink struct {
+	FileID    primitive.ObjectID `json:"file_id"`
+	URL       string             `json:"url"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
+
+// sign links to the files the caller can download. Files they can't see,
+// or that others can't get yet, are left out rather than failing the
+// request, clients fall back to the authenticated download.
+func (s *LinkService) IssueLinks(ctx context.Context, caller models.Caller, request LinkRequest) ([]SignedLink, error) {
+	if len(request.FileIDs) > MaxLinksPerRequest {
+		return nil, ErrTooManyLinks
+	}
+	if request.Size != 0 && !slices.Contains(ThumbnailSizes, request.Size) {
+		return nil, ErrInvalidSize
+	}
+
+	ttl := request.TTL
+	if ttl <= 0 {
+		ttl = DefaultLinkTTL
+	}
+	ttl = min(ttl, MaxLinkTTL)
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+
+	// the files of a page of history share a few conversations
+	access := make(map[primitive.ObjectID]error)
+
+	links := make([]SignedLink, 0, len(request.FileIDs))
+	for _, fileID := range request.FileIDs {
+		file, err := s.files.repo.GetFile(ctx, fileID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if file.UploadedBy != caller.UserID && file.Purpose != models.PurposeAvatar {
+			accessErr, checked := access[file.ConversationID]
+			if !checked {
+				accessErr = s.files.requireAccess(ctx, caller, file.ConversationID, false)
+				access[file.ConversationID] = accessErr
+			}
+			if errors.Is(accessErr, ErrNotMember) {
+				continue
+			}
+			if accessErr != nil {
+				return nil, accessErr
+			}
+		}
+
+		// whoever gets the link sees the file, not only its uploader
+		if ready(file) != nil {
+			continue
+		}
+
+		link := signing.Link{FileID: file.ID, Size: request.Size, ExpiresAt: expiresAt}
+		if request.SingleUse {
+			link.Nonce = signing.NewNonce()
+		}
+		links = append(links, SignedLink{
+			FileID:    file.ID,
+			URL:       s.baseURL + SignedPath + file.ID.Hex() + "?" + s.signer.Sign(link).Encode(),
+			ExpiresAt: expiresAt,
+		})
+	}
+	return links, nil
+}
+
+// Verify checks a link from its query parameters, see signing.Signer
+func (s *LinkService) Verify(fileID primitive.ObjectID, query url.Values) (signing.Link, error) {
+	return s.signer.Verify(fileID, query, time.Now())
+}
+
+// open the file of a verified link. The file may have been quarantined or
+// deleted since the link was signed. A single use link is spent here.
+func (s *LinkService) Open(ctx context.Context, link signing.Link) (*Download, error) {
+	file, err := s.files.repo.GetFile(ctx, link.FileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ready(file); err != nil {
+		return nil, err
+	}
+
+	if link.Nonce != "" {
+		fresh, err := s.used.UseLink(ctx, link.Nonce, link.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			return nil, ErrLinkUsed
+		}
+	}
+
+	return s.files.openContent(ctx, file, link.Size)
+}
@@ -0,0 +1,177 @@
+package logic_test
+
+import (
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/models"
+	"cloudcord/fileStorage/signing"
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MockUsedLinks spends each nonce once
+type MockUsedLinks struct {
+	used map[string]bool
+}
+
+func (m *MockUsedLinks) UseLink(ctx context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	if m.used[nonce] {
+		return false, nil
+	}
+	m.used[nonce] = true
+	return true, nil
+}
+
+func newLinkService() (*logic.LinkService, *MockRepo, *MockStorage, *MockChatAccess) {
+	files, repo, storage, chats, _ := newService()
+	links := logic.NewLinkService(files, signing.RandomSigner(), &MockUsedLinks{used: map[string]bool{}}, "https://files.example")
+	return links, repo, storage, chats
+}
+
+func readyFile(conversationID primitive.ObjectID) *models.File {
+	return &models.File{
+		ID:             primitive.NewObjectID(),
+		ConversationID: conversationID,
+		UploadedBy:     "alice",
+		Name:           "notes.txt",
+		StorageKey:     "key",
+		Scan:           models.ScanClean,
+	}
+}
+
+// the file ID and query of a signed URL
+func parseLink(t *testing.T, link logic.SignedLink) (primitive.ObjectID, url.Values) {
+	parsed, err := url.Parse(link.URL)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(link.URL, "https://files.example"+logic.SignedPath))
+
+	fileID, err := primitive.ObjectIDFromHex(strings.TrimPrefix(parsed.Path, logic.SignedPath))
+	assert.NoError(t, err)
+	return fileID, parsed.Query()
+}
+
+func TestIssueLinks_OnlyFilesOthersCanGet(t *testing.T) {
+	service, repo, _, chats := newLinkService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	clean := readyFile(chatID)
+	pending := readyFile(chatID)
+	pending.Scan = models.ScanPending
+	gone := primitive.NewObjectID()
+
+	repo.On("GetFile", ctx, clean.ID).Return(clean, nil)
+	repo.On("GetFile", ctx, pending.ID).Return(pending, nil)
+	repo.On("GetFile", ctx, gone).Return(nil, mongo.ErrNoDocuments)
+	chats.On("Access", ctx, bob, chatID).Return(models.ChatAccess{CanView: true}, nil).Once()
+
+	links, err := service.IssueLinks(ctx, bob, logic.LinkRequest{FileIDs: []primitive.ObjectID{clean.ID, pending.ID, gone}})
+
+	assert.NoError(t, err)
+	assert.Len(t, links, 1)
+	assert.Equal(t, clean.ID, links[0].FileID)
+	assert.WithinDuration(t, time.Now().Add(logic.DefaultLinkTTL), links[0].ExpiresAt, 2*time.Second)
+
+	// one access check for the conversation
+	chats.AssertNumberOfCalls(t, "Access", 1)
+}
+
+func TestIssueLinks_NotMember(t *testing.T) {
+	service, repo, _, chats := newLinkService()
+	ctx := context.Background()
+	file := readyFile(primitive.NewObjectID())
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	chats.On("Access", ctx, bob, file.ConversationID).Return(models.ChatAccess{}, nil)
+
+	links, err := service.IssueLinks(ctx, bob, logic.LinkRequest{FileIDs: []primitive.ObjectID{file.ID}})
+
+	assert.NoError(t, err)
+	assert.Empty(t, links)
+}
+
+func TestIssueLinks_Limits(t *testing.T) {
+	service, _, _, _ := newLinkService()
+	ctx := context.Background()
+
+	_, err := service.IssueLinks(ctx, alice, logic.LinkRequest{FileIDs: make([]primitive.ObjectID, logic.MaxLinksPerRequest+1)})
+	assert.ErrorIs(t, err, logic.ErrTooManyLinks)
+
+	_, err = service.IssueLinks(ctx, alice, logic.LinkRequest{Size: 100})
+	assert.ErrorIs(t, err, logic.ErrInvalidSize)
+}
+
+func TestSignedLink_Download(t *testing.T) {
+	service, repo, storage, _ := newLinkService()
+	ctx := context.Background()
+	file := readyFile(primitive.NewObjectID())
+	storage.objects = map[string][]byte{"key": []byte("hello")}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+
+	links, err := service.IssueLinks(ctx, alice, logic.LinkRequest{FileIDs: []primitive.ObjectID{file.ID}, TTL: 30 * 24 * time.Hour})
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(logic.MaxLinkTTL), links[0].ExpiresAt, 2*time.Second)
+
+	fileID, query := parseLink(t, links[0])
+	link, err := service.Verify(fileID, query)
+	assert.NoError(t, err)
+
+	// reusable
+	for i := 0; i < 2; i++ {
+		download, err := service.Open(ctx, link)
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(download.Content)
+		assert.Equal(t, "hello", string(data))
+	}
+}
+
+func TestSignedLink_SingleUse(t *testing.T) {
+	service, repo, storage, _ := newLinkService()
+	ctx := context.Background()
+	file := readyFile(primitive.NewObjectID())
+	storage.objects = map[string][]byte{"key": []byte("hello")}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+
+	links, err := service.IssueLinks(ctx, alice, logic.LinkRequest{FileIDs: []primitive.ObjectID{file.ID}, SingleUse: true})
+	assert.NoError(t, err)
+
+	link, err := service.Verify(parseLink(t, links[0]))
+	assert.NoError(t, err)
+
+	_, err = service.Open(ctx, link)
+	assert.NoError(t, err)
+
+	_, err = service.Open(ctx, link)
+	assert.ErrorIs(t, err, logic.ErrLinkUsed)
+}
+
+func TestSignedLink_QuarantinedAfterSigning(t *testing.T) {
+	service, repo, storage, _ := newLinkService()
+	ctx := context.Background()
+	file := readyFile(primitive.NewObjectID())
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+
+	links, err := service.IssueLinks(ctx, alice, logic.LinkRequest{FileIDs: []primitive.ObjectID{file.ID}})
+	assert.NoError(t, err)
+	link, err := service.Verify(parseLink(t, links[0]))
+	assert.NoError(t, err)
+
+	file.Scan = models.ScanQuarantined
+	_, err = service.Open(ctx, link)
+
+	assert.ErrorIs(t, err, logic.ErrQuarantined)
+	storage.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,142 @@
+package logic
+
+import (
+	"bytes"
+	"cloudcord/fileStorage/media"
+	"cloudcord/fileStorage/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// the sizes of the thumbnails made of images, the largest side fits in them
+var ThumbnailSizes = []int{64, 256, 1024}
+
+var (
+	ErrInvalidSize      = errors.New("size must be 64, 256 or 1024")
+	ErrNotProcessed     = errors.New("image is still being processed")
+	ErrProcessingFailed = errors.New("image could not be processed")
+)
+
+// ProcessMedia strips the location from an uploaded image, makes its
+// thumbnails and records its dimensions and dominant colour. Thumbnails
+// belong to the blob, files sharing it make the same ones. Images that
+// can't be processed are marked failed; an error is only returned when the
+// job should be retried.
+func (s *FileService) ProcessMedia(ctx context.Context, fileID primitive.ObjectID) error {
+	file, err := s.repo.GetFile(ctx, fileID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+
+	// a job delivered twice finds the file processed
+	if file.Processing != models.ProcessingPending {
+		return nil
+	}
+
+	original, err := s.readObject(ctx, file.StorageKey)
+	if err != nil {
+		return err
+	}
+
+	stripped, orientation, err := media.StripLocation(original, file.ContentType)
+	if err != nil {
+		return s.failProcessing(ctx, file, err)
+	}
+
+	info, err := media.Process(stripped, file.ContentType, orientation, ThumbnailSizes)
+	if err != nil {
+		return s.failProcessing(ctx, file, err)
+	}
+
+	// the stripped image is other bytes, it goes to the blob of its own
+	// SHA256 and the file lets go of the one it was uploaded as
+	uploaded := *file
+	if !bytes.Equal(stripped, original) {
+		sum := sha256.Sum256(stripped)
+		file.StorageKey = primitive.NewObjectID().Hex()
+		file.Size = int64(len(stripped))
+		file.SHA256 = hex.EncodeToString(sum[:])
+
+		if err := s.storage.Put(ctx, file.StorageKey, bytes.NewReader(stripped), file.ContentType); err != nil {
+			return err
+		}
+		if err := s.acquireBlob(ctx, file); err != nil {
+			s.removeObject(file.StorageKey)
+			return err
+		}
+	}
+	stored := file.SHA256 != uploaded.SHA256
+
+	file.Media = &models.Media{
+		Width:         info.Width,
+		Height:        info.Height,
+		DominantColor: info.DominantColor,
+	}
+	for _, thumb := range info.Thumbnails {
+		variant := models.Variant{
+			Size:        thumb.Size,
+			Width:       thumb.Width,
+			Height:      thumb.Height,
+			ContentType: thumb.ContentType,
+			Bytes:       int64(len(thumb.Data)),
+			StorageKey:  fmt.Sprintf("%s-%d", file.StorageKey, thumb.Size),
+		}
+		if err := s.storage.Put(ctx, variant.StorageKey, bytes.NewReader(thumb.Data), variant.ContentType); err != nil {
+			if stored {
+				s.releaseBlob(file)
+			}
+			return err
+		}
+		file.Media.Variants = append(file.Media.Variants, variant)
+	}
+
+	file.Processing = models.ProcessingDone
+	if err := s.repo.SaveProcessing(ctx, file); err != nil {
+		if stored {
+			s.releaseBlob(file)
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// deleted while it was processed
+			return nil
+		}
+		return err
+	}
+
+	if stored {
+		s.releaseBlob(&uploaded)
+	}
+
+	log.Printf("Processed image %s (%dx%d, %d thumbnails)", file.ID.Hex(), info.Width, info.Height, len(info.Thumbnails))
+	return nil
+}
+
+func (s *FileService) failProcessing(ctx context.Context, file *models.File, cause error) error {
+	log.Printf("Failed to process image %s: %v", file.ID.Hex(), cause)
+
+	file.Processing = models.ProcessingFailed
+	if err := s.repo.SaveProcessing(ctx, file); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileService) readObject(ctx context.Context, key string) ([]byte, error) {
+	content, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return io.ReadAll(content)
+}
@@ -0,0 +1,258 @@
+package logic_test
+
+import (
+	"bytes"
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/models"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bytes of the GPS latitude written by withGPS, easy to spot in a file
+var latitudeMarker = []byte{0x13, 0x37, 0xC0, 0xDE}
+
+func solidImage(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// a JPEG with EXIF holding an orientation and a GPS latitude
+func jpegWithGPS(t *testing.T, img image.Image, orientation uint16) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, nil))
+	data := buf.Bytes()
+
+	be := binary.BigEndian
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+
+	// IFD0 at 8: orientation and the GPS pointer
+	tiff = be.AppendUint16(tiff, 2)
+	tiff = be.AppendUint16(tiff, 0x0112)
+	tiff = be.AppendUint16(tiff, 3)
+	tiff = be.AppendUint32(tiff, 1)
+	tiff = be.AppendUint16(tiff, orientation)
+	tiff = be.AppendUint16(tiff, 0)
+	tiff = be.AppendUint16(tiff, 0x8825)
+	tiff = be.AppendUint16(tiff, 4)
+	tiff = be.AppendUint32(tiff, 1)
+	tiff = be.AppendUint32(tiff, 38)
+	tiff = be.AppendUint32(tiff, 0)
+
+	// GPS IFD at 38: latitude as three rationals at 56
+	tiff = be.AppendUint16(tiff, 1)
+	tiff = be.AppendUint16(tiff, 0x0002)
+	tiff = be.AppendUint16(tiff, 5)
+	tiff = be.AppendUint32(tiff, 3)
+	tiff = be.AppendUint32(tiff, 56)
+	tiff = be.AppendUint32(tiff, 0)
+	for i := 0; i < 3; i++ {
+		tiff = append(tiff, latitudeMarker...)
+		tiff = be.AppendUint32(tiff, 1)
+	}
+
+	segment := []byte{0xFF, 0xE1}
+	segment = be.AppendUint16(segment, uint16(2+6+len(tiff)))
+	segment = append(segment, "Exif\x00\x00"...)
+	segment = append(segment, tiff...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func pendingImage(contentType string, data []byte) *models.File {
+	return &models.File{
+		ID:             primitive.NewObjectID(),
+		ConversationID: primitive.NewObjectID(),
+		UploadedBy:     "alice",
+		ContentType:    contentType,
+		Size:           int64(len(data)),
+		StorageKey:     "key",
+		Processing:     models.ProcessingPending,
+	}
+}
+
+func TestUpload_QueuesScan(t *testing.T) {
+	service, repo, storage, chats, jobs := newService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	storage.On("Put", ctx, mock.Anything, "image/png").Return(nil)
+	repo.On("CreateFile", ctx, mock.AnythingOfType("*models.File")).Return(nil)
+
+	content := encodePNG(t, solidImage(10, 10, color.White))
+	file, err := service.Upload(ctx, alice, logic.Upload{ConversationID: chatID, Name: "dot.png", Content: bytes.NewReader(content)})
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ProcessingPending, file.Processing)
+	assert.Equal(t, models.ScanPending, file.Scan)
+	jobs.AssertCalled(t, "Publish", models.ScanJob{FileID: file.ID})
+}
+
+func TestProcessMedia_MakesThumbnails(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+	content := encodePNG(t, solidImage(300, 200, color.RGBA{R: 255, A: 255}))
+	file := pendingImage("image/png", content)
+	storage.objects = map[string][]byte{"key": content}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+	storage.On("Put", ctx, mock.Anything, "image/png").Return(nil)
+	repo.On("SaveProcessing", ctx, file).Return(nil)
+
+	err := service.ProcessMedia(ctx, file.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ProcessingDone, file.Processing)
+	assert.Equal(t, 300, file.Media.Width)
+	assert.Equal(t, 200, file.Media.Height)
+	assert.Equal(t, "#ff0000", file.Media.DominantColor)
+
+	// the image fits in 1024 already
+	assert.Len(t, file.Media.Variants, 2)
+	small := file.Variant(64)
+	assert.Equal(t, 64, small.Width)
+	assert.Equal(t, 42, small.Height)
+	assert.Contains(t, storage.objects, small.StorageKey)
+	assert.Nil(t, file.Variant(1024))
+}
+
+func TestProcessMedia_StripsLocation(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+	content := jpegWithGPS(t, solidImage(400, 100, color.Gray{Y: 128}), 6)
+	file := pendingImage("image/jpeg", content)
+	file.SHA256 = checksum(content)
+	repo.blobs = map[string]*models.Blob{file.SHA256: {SHA256: file.SHA256, StorageKey: "key", Refs: 1}}
+	storage.objects = map[string][]byte{"key": content}
+	assert.True(t, bytes.Contains(content, latitudeMarker))
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+	storage.On("Put", ctx, mock.Anything, "image/jpeg").Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+	repo.On("SaveProcessing", ctx, file).Return(nil)
+
+	err := service.ProcessMedia(ctx, file.ID)
+
+	assert.NoError(t, err)
+	stored := storage.objects[file.StorageKey]
+	assert.False(t, bytes.Contains(stored, latitudeMarker))
+	assert.Equal(t, checksum(stored), file.SHA256)
+	assert.Equal(t, int64(len(stored)), file.Size)
+
+	// the uploaded bytes were only this file's, they're gone
+	assert.NotContains(t, storage.objects, "key")
+	assert.NotContains(t, repo.blobs, checksum(content))
+	assert.Equal(t, int64(1), repo.blobs[file.SHA256].Refs)
+
+	// orientation 6 is rotated a quarter turn
+	assert.Equal(t, 100, file.Media.Width)
+	assert.Equal(t, 400, file.Media.Height)
+	assert.Equal(t, 64, file.Variant(256).Width)
+
+	_, err = jpeg.Decode(bytes.NewReader(stored))
+	assert.NoError(t, err)
+}
+
+func TestProcessMedia_MarksBrokenImagesFailed(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+	content := []byte("\x89PNG\r\n\x1a\nnot really")
+	file := pendingImage("image/png", content)
+	storage.objects = map[string][]byte{"key": content}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+	repo.On("SaveProcessing", ctx, file).Return(nil)
+
+	err := service.ProcessMedia(ctx, file.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ProcessingFailed, file.Processing)
+	storage.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessMedia_SkipsProcessedFiles(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+	file := &models.File{ID: primitive.NewObjectID(), Processing: models.ProcessingDone}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+
+	err := service.ProcessMedia(ctx, file.ID)
+
+	assert.NoError(t, err)
+	storage.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+}
+
+func TestOpen_PendingImageOnlyForUploader(t *testing.T) {
+	service, repo, _, chats, _ := newService()
+	ctx := context.Background()
+	file := pendingImage("image/jpeg", nil)
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	chats.On("Access", ctx, bob, file.ConversationID).Return(models.ChatAccess{CanView: true}, nil)
+
+	_, err := service.Open(ctx, bob, file.ID, 0)
+
+	assert.ErrorIs(t, err, logic.ErrNotProcessed)
+}
+
+func TestOpen_Thumbnail(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+	file := &models.File{
+		ID:          primitive.NewObjectID(),
+		UploadedBy:  "alice",
+		ContentType: "image/png",
+		SHA256:      "abc",
+		StorageKey:  "key",
+		Processing:  models.ProcessingDone,
+		Media: &models.Media{Variants: []models.Variant{
+			{Size: 64, ContentType: "image/png", Bytes: 5, StorageKey: "key-64"},
+		}},
+	}
+	storage.objects = map[string][]byte{"key": []byte("original"), "key-64": []byte("thumb")}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	storage.On("Get", ctx, mock.Anything).Return(nil)
+
+	download, err := service.Open(ctx, alice, file.ID, 64)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(download.Content)
+	assert.Equal(t, "thumb", string(data))
+	assert.Equal(t, "abc-64", download.ETag)
+
+	// no 256 thumbnail, the image is smaller than that
+	download, err = service.Open(ctx, alice, file.ID, 256)
+	assert.NoError(t, err)
+	data, _ = io.ReadAll(download.Content)
+	assert.Equal(t, "original", string(data))
+
+	_, err = service.Open(ctx, alice, file.ID, 100)
+	assert.ErrorIs(t, err, logic.ErrInvalidSize)
+}
@@ -0,0 +1,126 @@
+package logic
+
+import (
+	"cloudcord/fileStorage/models"
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// files removed at a time when a user is deleted
+const deleteBatch = 500
+
+var (
+	ErrQuotaExceeded  = errors.New("storage quota exceeded")
+	ErrTypeNotAllowed = errors.New("file type is not allowed")
+)
+
+// Policy limits what users may upload. Content types are the ones sniffed
+// from the bytes, an entry like "image/*" matches a whole family.
+type Policy struct {
+	// the largest file, uploaded in a single request or resumably
+	MaxFileSize int64
+
+	// bytes a user may store across all their files, 0 is unlimited
+	Quota int64
+
+	// when not empty only these types are accepted
+	AllowedTypes []string
+	DeniedTypes  []string
+}
+
+// DefaultPolicy accepts any type, up to 5 GiB per user
+var DefaultPolicy = Policy{
+	MaxFileSize: MaxResumableSize,
+	Quota:       5 << 30,
+}
+
+// AllowsType tells whether files of a sniffed content type may be uploaded
+func (p Policy) AllowsType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	if matchesType(p.DeniedTypes, mediaType) {
+		return false
+	}
+	return len(p.AllowedTypes) == 0 || matchesType(p.AllowedTypes, mediaType)
+}
+
+func matchesType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if family, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, family+"/") {
+				return true
+			}
+		} else if pattern == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *FileService) Policy() Policy {
+	return s.policy
+}
+
+// get how much of their quota the caller uses
+func (s *FileService) Usage(ctx context.Context, caller models.Caller) (*models.Usage, error) {
+	usage, err := s.repo.GetUsage(ctx, caller.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage.Quota = s.policy.Quota
+	usage.MaxFileSize = s.policy.MaxFileSize
+	return usage, nil
+}
+
+// make sure a user has room for size more bytes. Space is only claimed by
+// recording the file or upload, so after that this runs again with what
+// the record itself doesn't already count: concurrent uploads then see each
+// other and the ones that don't fit remove their record. A negative size
+// takes back what's counted twice, like the upload a file completes.
+func (s *FileService) checkQuota(ctx context.Context, userID string, size int64) error {
+	if s.policy.Quota == 0 {
+		return nil
+	}
+
+	usage, err := s.repo.GetUsage(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if usage.Bytes+usage.Pending+size > s.policy.Quota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// remove every file of a deleted user, freeing their quota
+func (s *FileService) DeleteUserFiles(ctx context.Context, userID string) error {
+	removed := 0
+	for {
+		files, err := s.repo.GetFilesByUploader(ctx, userID, deleteBatch)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			break
+		}
+
+		for i := range files {
+			if err := s.repo.DeleteFile(ctx, files[i].ID); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return err
+			}
+			s.releaseBlob(&files[i])
+			removed++
+		}
+	}
+
+	log.Printf("Removed %d files of deleted user %s", removed, userID)
+	return nil
+}
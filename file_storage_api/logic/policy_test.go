@@ -0,0 +1,242 @@
+package logic_test
+
+import (
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/models"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPolicy_AllowsType(t *testing.T) {
+	policy := logic.Policy{AllowedTypes: []string{"image/*", "application/pdf"}, DeniedTypes: []string{"image/svg+xml"}}
+
+	assert.True(t, policy.AllowsType("image/png"))
+	assert.True(t, policy.AllowsType("application/pdf"))
+	assert.False(t, policy.AllowsType("image/svg+xml"))
+	assert.False(t, policy.AllowsType("text/plain; charset=utf-8"))
+
+	deny := logic.Policy{DeniedTypes: []string{"text/html"}}
+	assert.False(t, deny.AllowsType("text/html; charset=utf-8"))
+	assert.True(t, deny.AllowsType("application/octet-stream"))
+}
+
+func TestUpload_DeniedTypeIsNotStored(t *testing.T) {
+	policy := logic.DefaultPolicy
+	policy.DeniedTypes = []string{"text/html"}
+	service, repo, storage, chats, _ := newServiceWithPolicy(policy)
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	repo.On("GetUsage", ctx, "alice").Return(&models.Usage{}, nil)
+
+	// named like an image, the content decides
+	_, err := service.Upload(ctx, alice, logic.Upload{ConversationID: chatID, Name: "cat.png", Content: strings.NewReader("<html><script>alert(1)</script>")})
+
+	assert.ErrorIs(t, err, logic.ErrTypeNotAllowed)
+	storage.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUpload_QuotaExceededRemovesObject(t *testing.T) {
+	policy := logic.DefaultPolicy
+	policy.Quota = 100
+	service, repo, storage, chats, _ := newServiceWithPolicy(policy)
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	repo.On("GetUsage", ctx, "alice").Return(&models.Usage{Bytes: 90, Files: 3}, nil)
+	storage.On("Put", ctx, mock.Anything, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	_, err := service.Upload(ctx, alice, logic.Upload{ConversationID: chatID, Name: "a.txt", Content: strings.NewReader(strings.Repeat("a", 20))})
+
+	assert.ErrorIs(t, err, logic.ErrQuotaExceeded)
+	assert.Empty(t, storage.objects)
+	repo.AssertNotCalled(t, "CreateFile", mock.Anything, mock.Anything)
+}
+
+func TestUpload_FullQuotaRefusedUpFront(t *testing.T) {
+	policy := logic.DefaultPolicy
+	policy.Quota = 100
+	service, repo, storage, chats, _ := newServiceWithPolicy(policy)
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	repo.On("GetUsage", ctx, "alice").Return(&models.Usage{Bytes: 60, Pending: 40}, nil)
+
+	_, err := service.Upload(ctx, alice, logic.Upload{ConversationID: chatID, Name: "a.txt", Content: strings.NewReader("a")})
+
+	assert.ErrorIs(t, err, logic.ErrQuotaExceeded)
+	storage.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateUpload_ReservesQuota(t *testing.T) {
+	policy := logic.DefaultPolicy
+	policy.Quota = 1000
+	files, repo, _, chats, _ := newServiceWithPolicy(policy)
+	uploads := new(MockUploadRepo)
+	service := logic.NewUploadService(files, uploads)
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	repo.On("GetUsage", ctx, "alice").Return(&models.Usage{Bytes: 500}, nil)
+
+	_, err := service.CreateUpload(ctx, alice, logic.NewUpload{ConversationID: chatID, Name: "a.bin", Length: 501})
+
+	assert.ErrorIs(t, err, logic.ErrQuotaExceeded)
+	uploads.AssertNotCalled(t, "CreateUpload", mock.Anything, mock.Anything)
+}
+
+// ledger keeps the upload sessions of a user like the database would, so
+// concurrent uploads see each other in the usage
+type ledger struct {
+	*MockRepo
+	*MockUploadRepo
+
+	mu       sync.Mutex
+	sessions map[primitive.ObjectID]int64
+
+	// every CreateUpload waits for the others, so they all pass the first
+	// quota check before any of them is recorded
+	started sync.WaitGroup
+}
+
+func (l *ledger) GetUsage(ctx context.Context, userID string) (*models.Usage, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	usage := &models.Usage{}
+	for _, length := range l.sessions {
+		usage.Pending += length
+	}
+	return usage, nil
+}
+
+func (l *ledger) CreateUpload(ctx context.Context, upload *models.UploadSession) error {
+	l.started.Done()
+	l.started.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sessions[upload.ID] = upload.Length
+	return nil
+}
+
+func (l *ledger) DeleteUpload(ctx context.Context, uploadID primitive.ObjectID) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.sessions, uploadID)
+	return nil
+}
+
+func TestCreateUpload_ConcurrentUploadsStayWithinQuota(t *testing.T) {
+	const uploads, length = 8, 300
+	policy := logic.DefaultPolicy
+	policy.Quota = 1000
+
+	repo := &ledger{MockRepo: new(MockRepo), MockUploadRepo: new(MockUploadRepo), sessions: map[primitive.ObjectID]int64{}}
+	repo.started.Add(uploads)
+	chats := new(MockChatAccess)
+	files := logic.NewFileService(repo, new(MockStorage), chats, new(MockJobs), policy)
+	service := logic.NewUploadService(files, repo)
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", mock.Anything, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.CreateUpload(context.Background(), alice, logic.NewUpload{ConversationID: chatID, Name: "a.bin", Length: length})
+			if err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			} else {
+				assert.ErrorIs(t, err, logic.ErrQuotaExceeded)
+			}
+		}()
+	}
+	wg.Wait()
+
+	usage, _ := repo.GetUsage(context.Background(), "alice")
+	assert.LessOrEqual(t, usage.Pending, policy.Quota)
+	assert.Equal(t, int64(created*length), usage.Pending)
+}
+
+func TestCreateUpload_PolicyMaxFileSize(t *testing.T) {
+	policy := logic.DefaultPolicy
+	policy.MaxFileSize = 10
+	files, _, _, _, chats := newServiceWithPolicy(policy)
+	service := logic.NewUploadService(files, new(MockUploadRepo))
+
+	_, err := service.CreateUpload(context.Background(), alice, logic.NewUpload{ConversationID: primitive.NewObjectID(), Length: 11})
+
+	assert.ErrorIs(t, err, logic.ErrFileTooLarge)
+	chats.AssertNotCalled(t, "Access", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAppendChunk_DeniedTypeDropsUpload(t *testing.T) {
+	policy := logic.DefaultPolicy
+	policy.AllowedTypes = []string{"video/*"}
+	files, _, storage, _, _ := newServiceWithPolicy(policy)
+	uploads := new(MockUploadRepo)
+	service := logic.NewUploadService(files, uploads)
+	ctx := context.Background()
+	session := openSession(100, "")
+
+	uploads.On("GetUpload", ctx, session.ID).Return(session, nil)
+	uploads.On("DeleteUpload", ctx, session.ID).Return(nil)
+
+	_, err := service.AppendChunk(ctx, alice, session.ID, 0, strings.NewReader("just some text"), nil)
+
+	assert.ErrorIs(t, err, logic.ErrTypeNotAllowed)
+	uploads.AssertCalled(t, "DeleteUpload", ctx, session.ID)
+	storage.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUsage(t *testing.T) {
+	policy := logic.DefaultPolicy
+	policy.Quota = 1000
+	service, repo, _, _, _ := newServiceWithPolicy(policy)
+	ctx := context.Background()
+
+	repo.On("GetUsage", ctx, "alice").Return(&models.Usage{Bytes: 300, Files: 2, Pending: 100}, nil)
+
+	usage, err := service.Usage(ctx, alice)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &models.Usage{Bytes: 300, Files: 2, Pending: 100, Quota: 1000, MaxFileSize: policy.MaxFileSize}, usage)
+}
+
+func TestDeleteUserFiles(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+	files := []models.File{
+		{ID: primitive.NewObjectID(), UploadedBy: "alice", StorageKey: "one"},
+		{ID: primitive.NewObjectID(), UploadedBy: "alice", StorageKey: "two", Media: &models.Media{Variants: []models.Variant{{Size: 64, StorageKey: "two-64"}}}},
+	}
+	storage.objects = map[string][]byte{"one": []byte("1"), "two": []byte("2"), "two-64": []byte("t"), "bobs": []byte("b")}
+
+	repo.On("GetFilesByUploader", ctx, "alice").Return(files, nil).Once()
+	repo.On("GetFilesByUploader", ctx, "alice").Return(nil, nil).Once()
+	repo.On("DeleteFile", ctx, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	err := service.DeleteUserFiles(ctx, "alice")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"bobs": []byte("b")}, storage.objects)
+	repo.AssertNumberOfCalls(t, "DeleteFile", 2)
+}
@@ -0,0 +1,96 @@
+package logic
+
+import (
+	"cloudcord/fileStorage/models"
+	"cloudcord/fileStorage/scan"
+	"context"
+	"errors"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrNotScanned  = errors.New("file is still being scanned")
+	ErrQuarantined = errors.New("file was quarantined")
+)
+
+// the threat recorded for files the scanner refuses, they're quarantined
+// rather than served unscanned
+const unscannable = "could not be scanned"
+
+// ScanService runs uploaded files through a malware scanner. Clean images
+// move on to media processing, flagged files are quarantined and chat_api
+// is told to show them as blocked.
+type ScanService struct {
+	files   *FileService
+	scanner scan.Scanner
+	media   JobQueue
+	notices JobQueue
+}
+
+// constructor
+func NewScanService(files *FileService, scanner scan.Scanner, media JobQueue, notices JobQueue) *ScanService {
+	return &ScanService{files: files, scanner: scanner, media: media, notices: notices}
+}
+
+// ScanFile scans a file waiting for it. An error is only returned when the
+// job should be retried, like when the scanner can't be reached.
+func (s *ScanService) ScanFile(ctx context.Context, fileID primitive.ObjectID) error {
+	file, err := s.files.repo.GetFile(ctx, fileID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+
+	// a job delivered twice finds the file scanned
+	if file.Scan != models.ScanPending {
+		return nil
+	}
+
+	content, err := s.files.storage.Get(ctx, file.StorageKey)
+	if err != nil {
+		return err
+	}
+	result, err := s.scanner.Scan(ctx, content)
+	content.Close()
+
+	if errors.Is(err, scan.ErrRejected) {
+		log.Printf("Scanner rejected file %s: %v", file.ID.Hex(), err)
+		result = scan.Result{Infected: true, Threat: unscannable}
+	} else if err != nil {
+		return err
+	}
+
+	file.Scan = models.ScanClean
+	if result.Infected {
+		file.Scan = models.ScanQuarantined
+		file.Threat = result.Threat
+	}
+
+	if err := s.files.repo.SaveScan(ctx, file); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+
+	if result.Infected {
+		log.Printf("File %s uploaded by %s quarantined: %s", file.ID.Hex(), file.UploadedBy, file.Threat)
+		status := models.AttachmentStatus{FileID: file.ID, ConversationID: file.ConversationID, Status: models.AttachmentBlocked}
+		if err := s.notices.Publish(status); err != nil {
+			log.Printf("Failed to tell chat about quarantined file %s: %v", file.ID.Hex(), err)
+		}
+		return nil
+	}
+
+	if file.Processing == models.ProcessingPending {
+		if err := s.media.Publish(models.MediaJob{FileID: file.ID}); err != nil {
+			log.Printf("Failed to queue processing of file %s: %v", file.ID.Hex(), err)
+		}
+	}
+	return nil
+}
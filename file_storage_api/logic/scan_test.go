@@ -0,0 +1,137 @@
+package logic_test
+
+import (
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/models"
+	"cloudcord/fileStorage/scan"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+func newScanService(scanner scan.Scanner) (*logic.ScanService, *MockRepo, *MockStorage, *MockJobs, *MockJobs) {
+	files, repo, storage, _, _ := newService()
+	media := new(MockJobs)
+	notices := new(MockJobs)
+	return logic.NewScanService(files, scanner, media, notices), repo, storage, media, notices
+}
+
+func pendingScan(contentType string) *models.File {
+	return &models.File{
+		ID:             primitive.NewObjectID(),
+		ConversationID: primitive.NewObjectID(),
+		UploadedBy:     "alice",
+		ContentType:    contentType,
+		StorageKey:     "key",
+		Scan:           models.ScanPending,
+	}
+}
+
+func TestScanFile_CleanImageGoesToProcessing(t *testing.T) {
+	service, repo, storage, media, notices := newScanService(scan.Fake{})
+	ctx := context.Background()
+	file := pendingScan("image/png")
+	file.Processing = models.ProcessingPending
+	storage.objects = map[string][]byte{"key": []byte("pixels")}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+	repo.On("SaveScan", ctx, file).Return(nil)
+	media.On("Publish", models.MediaJob{FileID: file.ID}).Return(nil)
+
+	err := service.ScanFile(ctx, file.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ScanClean, file.Scan)
+	media.AssertExpectations(t)
+	notices.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+func TestScanFile_QuarantinesAndTellsChat(t *testing.T) {
+	service, repo, storage, media, notices := newScanService(scan.Fake{})
+	ctx := context.Background()
+	file := pendingScan("text/plain; charset=utf-8")
+	storage.objects = map[string][]byte{"key": []byte("totally a document " + eicar)}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+	repo.On("SaveScan", ctx, file).Return(nil)
+	notices.On("Publish", models.AttachmentStatus{FileID: file.ID, ConversationID: file.ConversationID, Status: models.AttachmentBlocked}).Return(nil)
+
+	err := service.ScanFile(ctx, file.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ScanQuarantined, file.Scan)
+	assert.Equal(t, "Eicar-Test-Signature", file.Threat)
+	notices.AssertExpectations(t)
+	media.AssertNotCalled(t, "Publish", mock.Anything)
+}
+
+func TestScanFile_RejectedFileIsQuarantined(t *testing.T) {
+	service, repo, storage, _, notices := newScanService(scan.Fake{Err: fmt.Errorf("%w: size limit exceeded", scan.ErrRejected)})
+	ctx := context.Background()
+	file := pendingScan("application/zip")
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+	repo.On("SaveScan", ctx, file).Return(nil)
+	notices.On("Publish", mock.Anything).Return(nil)
+
+	err := service.ScanFile(ctx, file.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ScanQuarantined, file.Scan)
+}
+
+func TestScanFile_RetriedWhenScannerIsDown(t *testing.T) {
+	service, repo, storage, _, _ := newScanService(scan.Fake{Err: errors.New("connection refused")})
+	ctx := context.Background()
+	file := pendingScan("application/zip")
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+
+	err := service.ScanFile(ctx, file.ID)
+
+	assert.Error(t, err)
+	assert.Equal(t, models.ScanPending, file.Scan)
+	repo.AssertNotCalled(t, "SaveScan", mock.Anything, mock.Anything)
+}
+
+func TestOpen_PendingScanOnlyForUploader(t *testing.T) {
+	service, repo, storage, chats, _ := newService()
+	ctx := context.Background()
+	file := pendingScan("application/pdf")
+	storage.objects = map[string][]byte{"key": []byte("%PDF")}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	chats.On("Access", ctx, bob, file.ConversationID).Return(models.ChatAccess{CanView: true}, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+
+	_, err := service.Open(ctx, bob, file.ID, 0)
+	assert.ErrorIs(t, err, logic.ErrNotScanned)
+
+	_, err = service.Open(ctx, alice, file.ID, 0)
+	assert.NoError(t, err)
+}
+
+func TestOpen_QuarantinedForEveryone(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+	file := pendingScan("application/pdf")
+	file.Scan = models.ScanQuarantined
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+
+	_, err := service.Open(ctx, alice, file.ID, 0)
+
+	assert.ErrorIs(t, err, logic.ErrQuarantined)
+	storage.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+}
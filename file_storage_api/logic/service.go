@@ -0,0 +1,371 @@
+package logic
+
+import (
+	"bytes"
+	"cloudcord/fileStorage/media"
+	"cloudcord/fileStorage/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxNameLength = 255
+
+	// http.DetectContentType looks at no more than this
+	sniffLength = 512
+)
+
+var (
+	ErrNotMember         = errors.New("not a member of this conversation")
+	ErrMissingPermission = errors.New("missing permission")
+	ErrNotUploader       = errors.New("only the uploader can do this")
+	ErrFileTooLarge      = errors.New("file is too large")
+	ErrEmptyFile         = errors.New("file is empty")
+	ErrChecksumMismatch  = errors.New("file does not match the given checksum")
+)
+
+// Define interfaces for dependency inversion
+type FileRepository interface {
+	CreateFile(ctx context.Context, file *models.File) error
+	GetFile(ctx context.Context, fileID primitive.ObjectID) (*models.File, error)
+	DeleteFile(ctx context.Context, fileID primitive.ObjectID) error
+	SaveProcessing(ctx context.Context, file *models.File) error
+	GetFilesByUploader(ctx context.Context, userID string, limit int) ([]models.File, error)
+	GetUsage(ctx context.Context, userID string) (*models.Usage, error)
+	AcquireBlob(ctx context.Context, blob *models.Blob) (*models.Blob, error)
+	ReleaseBlob(ctx context.Context, sha256 string) (*models.Blob, bool, error)
+	GetUnreferencedBlobs(ctx context.Context, limit int) ([]models.Blob, error)
+	DeleteUnreferencedBlob(ctx context.Context, sha256 string) error
+	SaveScan(ctx context.Context, file *models.File) error
+}
+
+// Storage holds the bytes of files, see storage.Backend
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// ChatAccess tells what a caller may do in a conversation of chat_api
+type ChatAccess interface {
+	Access(ctx context.Context, caller models.Caller, conversationID primitive.ObjectID) (models.ChatAccess, error)
+}
+
+// JobQueue hands work to the workers, uploads go to the scanners first
+type JobQueue interface {
+	Publish(msg interface{}) error
+}
+
+type FileService struct {
+	repo    FileRepository
+	storage Storage
+	chats   ChatAccess
+	jobs    JobQueue
+	policy  Policy
+}
+
+// constructor
+func NewFileService(repo FileRepository, storage Storage, chats ChatAccess, jobs JobQueue, policy Policy) *FileService {
+	return &FileService{repo: repo, storage: storage, chats: chats, jobs: jobs, policy: policy}
+}
+
+// Upload is a file to store, SHA256 is optional and checked when given
+type Upload struct {
+	ConversationID primitive.ObjectID
+	Name           string
+	Content        io.Reader
+	SHA256         string
+}
+
+// store a file in a conversation the caller can send messages to
+func (s *FileService) Upload(ctx context.Context, caller models.Caller, upload Upload) (*models.File, error) {
+	if err := s.requireAccess(ctx, caller, upload.ConversationID, true); err != nil {
+		return nil, err
+	}
+
+	// a user out of room doesn't get to send the file first
+	if err := s.checkQuota(ctx, caller.UserID, 1); err != nil {
+		return nil, err
+	}
+
+	file := &models.File{
+		ID:             primitive.NewObjectID(),
+		ConversationID: upload.ConversationID,
+		UploadedBy:     caller.UserID,
+		Name:           cleanFileName(upload.Name),
+		CreatedAt:      time.Now(),
+	}
+	if err := s.store(ctx, file, upload.Content, upload.SHA256, s.policy.MaxFileSize, 0); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// write the content of a new file and save its metadata. The content type
+// is sniffed from the bytes rather than trusted from the client, bytes
+// stored already are shared and the file is queued for scanning. Reserved
+// is the quota already set aside for it.
+func (s *FileService) store(ctx context.Context, file *models.File, content io.Reader, expectedSHA string, maxSize int64, reserved int64) error {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if n == 0 {
+		return ErrEmptyFile
+	}
+	head = head[:n]
+
+	file.ContentType = http.DetectContentType(head)
+	if !s.policy.AllowsType(file.ContentType) {
+		return ErrTypeNotAllowed
+	}
+	if file.Purpose == models.PurposeAvatar && !media.IsImage(file.ContentType) {
+		return ErrNotAnImage
+	}
+
+	file.StorageKey = file.ID.Hex()
+	file.Scan = models.ScanPending
+	if media.IsImage(file.ContentType) {
+		file.Processing = models.ProcessingPending
+	}
+
+	// one byte over the limit is enough to know the file is too large
+	hash := sha256.New()
+	counter := &countingReader{r: io.LimitReader(io.MultiReader(bytes.NewReader(head), content), maxSize+1)}
+	if err := s.storage.Put(ctx, file.StorageKey, io.TeeReader(counter, hash), file.ContentType); err != nil {
+		return err
+	}
+
+	file.Size = counter.n
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	switch {
+	case file.Size > maxSize:
+		err = ErrFileTooLarge
+	case expectedSHA != "" && !strings.EqualFold(expectedSHA, file.SHA256):
+		err = ErrChecksumMismatch
+	default:
+		err = s.checkQuota(ctx, file.UploadedBy, file.Size-reserved)
+	}
+	if err == nil {
+		err = s.acquireBlob(ctx, file)
+	}
+	if err != nil {
+		s.removeObject(file.StorageKey)
+		return err
+	}
+
+	if err := s.repo.CreateFile(ctx, file); err != nil {
+		s.releaseBlob(file)
+		return err
+	}
+
+	// the file counts from here on, concurrent uploads may have taken the
+	// room it was checked for above
+	if err := s.checkQuota(ctx, file.UploadedBy, -reserved); err != nil {
+		if err := s.repo.DeleteFile(ctx, file.ID); err != nil {
+			log.Printf("Failed to remove file %s over quota: %v", file.ID.Hex(), err)
+			return err
+		}
+		s.releaseBlob(file)
+		return err
+	}
+
+	log.Printf("File %s (%d bytes) uploaded by %s", file.ID.Hex(), file.Size, file.UploadedBy)
+
+	if err := s.jobs.Publish(models.ScanJob{FileID: file.ID}); err != nil {
+		log.Printf("Failed to queue scan of file %s: %v", file.ID.Hex(), err)
+	}
+	return nil
+}
+
+// get the metadata of a file, the uploader and members of its conversation
+// can. Anyone can get an avatar.
+func (s *FileService) GetFile(ctx context.Context, caller models.Caller, fileID primitive.ObjectID) (*models.File, error) {
+	file, err := s.repo.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.UploadedBy != caller.UserID && file.Purpose != models.PurposeAvatar {
+		if err := s.requireAccess(ctx, caller, file.ConversationID, false); err != nil {
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+// Download is a file or one of its thumbnails, ready to be sent
+type Download struct {
+	File        *models.File
+	ContentType string
+	Size        int64
+	ETag        string
+	Content     io.ReadCloser
+}
+
+// open a file for download, with the same rules as GetFile. A size asks for
+// the thumbnail of that size, the file itself is sent when the image is
+// small enough to have none. The caller closes the content.
+func (s *FileService) Open(ctx context.Context, caller models.Caller, fileID primitive.ObjectID, size int) (*Download, error) {
+	if size != 0 && !slices.Contains(ThumbnailSizes, size) {
+		return nil, ErrInvalidSize
+	}
+
+	file, err := s.GetFile(ctx, caller, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Scan == models.ScanQuarantined {
+		return nil, ErrQuarantined
+	}
+	if file.UploadedBy != caller.UserID {
+		if err := ready(file); err != nil {
+			return nil, err
+		}
+	}
+	return s.openContent(ctx, file, size)
+}
+
+// until a file is scanned it may be malware, until an image is processed it
+// may still carry its location. Only its uploader gets it before then.
+func ready(file *models.File) error {
+	switch {
+	case file.Scan == models.ScanQuarantined:
+		return ErrQuarantined
+	case file.Scan == models.ScanPending:
+		return ErrNotScanned
+	case file.Processing == models.ProcessingPending:
+		return ErrNotProcessed
+	case file.Processing == models.ProcessingFailed:
+		return ErrProcessingFailed
+	}
+	return nil
+}
+
+// open the bytes of a file, or of its thumbnail of size
+func (s *FileService) openContent(ctx context.Context, file *models.File, size int) (*Download, error) {
+	download := &Download{
+		File:        file,
+		ContentType: file.ContentType,
+		Size:        file.Size,
+		ETag:        file.SHA256,
+	}
+	key := file.StorageKey
+
+	if variant := file.Variant(size); variant != nil {
+		download.ContentType = variant.ContentType
+		download.Size = variant.Bytes
+		download.ETag = fmt.Sprintf("%s-%d", file.SHA256, variant.Size)
+		key = variant.StorageKey
+	}
+
+	var err error
+	download.Content, err = s.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return download, nil
+}
+
+// remove a file, only its uploader can
+func (s *FileService) DeleteFile(ctx context.Context, caller models.Caller, fileID primitive.ObjectID) error {
+	file, err := s.repo.GetFile(ctx, fileID)
+	if err != nil {
+		return err
+	}
+
+	if file.UploadedBy != caller.UserID {
+		return ErrNotUploader
+	}
+
+	if err := s.repo.DeleteFile(ctx, file.ID); err != nil {
+		return err
+	}
+
+	s.releaseBlob(file)
+	log.Printf("File %s deleted by %s", file.ID.Hex(), caller.UserID)
+	return nil
+}
+
+func (s *FileService) requireAccess(ctx context.Context, caller models.Caller, conversationID primitive.ObjectID, send bool) error {
+	access, err := s.chats.Access(ctx, caller, conversationID)
+	if err != nil {
+		return err
+	}
+
+	if !access.CanView {
+		return ErrNotMember
+	}
+	if send && !access.CanSend {
+		return ErrMissingPermission
+	}
+	return nil
+}
+
+// the metadata is what counts, an object left behind only costs space
+func (s *FileService) removeObject(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.storage.Delete(ctx, key); err != nil {
+		log.Printf("Failed to remove stored object %s: %v", key, err)
+	}
+}
+
+// remove a file stored before blobs and its thumbnails
+func (s *FileService) removeObjects(file *models.File) {
+	s.removeObject(file.StorageKey)
+	if file.Media != nil {
+		for _, variant := range file.Media.Variants {
+			s.removeObject(variant.StorageKey)
+		}
+	}
+}
+
+// keep the base name of what the client sent, without control characters
+func cleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		name = string([]rune(name)[:maxNameLength])
+	}
+	return name
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
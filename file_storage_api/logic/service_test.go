@@ -0,0 +1,373 @@
+package logic_test
+
+import (
+	"bytes"
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MockRepo counts blob references in memory, like MockStorage keeps objects
+type MockRepo struct {
+	mock.Mock
+	blobs map[string]*models.Blob
+}
+
+func (m *MockRepo) CreateFile(ctx context.Context, file *models.File) error {
+	args := m.Called(ctx, file)
+	return args.Error(0)
+}
+
+func (m *MockRepo) GetFile(ctx context.Context, fileID primitive.ObjectID) (*models.File, error) {
+	args := m.Called(ctx, fileID)
+	file, _ := args.Get(0).(*models.File)
+	return file, args.Error(1)
+}
+
+func (m *MockRepo) DeleteFile(ctx context.Context, fileID primitive.ObjectID) error {
+	args := m.Called(ctx, fileID)
+	return args.Error(0)
+}
+
+func (m *MockRepo) SaveProcessing(ctx context.Context, file *models.File) error {
+	args := m.Called(ctx, file)
+	return args.Error(0)
+}
+
+func (m *MockRepo) SaveScan(ctx context.Context, file *models.File) error {
+	args := m.Called(ctx, file)
+	return args.Error(0)
+}
+
+func (m *MockRepo) GetFilesByUploader(ctx context.Context, userID string, limit int) ([]models.File, error) {
+	args := m.Called(ctx, userID)
+	files, _ := args.Get(0).([]models.File)
+	return files, args.Error(1)
+}
+
+func (m *MockRepo) GetUsage(ctx context.Context, userID string) (*models.Usage, error) {
+	args := m.Called(ctx, userID)
+	usage, _ := args.Get(0).(*models.Usage)
+	return usage, args.Error(1)
+}
+
+func (m *MockRepo) AcquireBlob(ctx context.Context, blob *models.Blob) (*models.Blob, error) {
+	if m.blobs == nil {
+		m.blobs = make(map[string]*models.Blob)
+	}
+	stored, ok := m.blobs[blob.SHA256]
+	if !ok {
+		stored = &models.Blob{SHA256: blob.SHA256, StorageKey: blob.StorageKey, Size: blob.Size, ContentType: blob.ContentType}
+		m.blobs[blob.SHA256] = stored
+	}
+	stored.Refs++
+	acquired := *stored
+	return &acquired, nil
+}
+
+func (m *MockRepo) ReleaseBlob(ctx context.Context, sha256 string) (*models.Blob, bool, error) {
+	stored, ok := m.blobs[sha256]
+	if !ok {
+		return nil, false, mongo.ErrNoDocuments
+	}
+	stored.Refs--
+	if stored.Refs > 0 {
+		return stored, false, nil
+	}
+	delete(m.blobs, sha256)
+	return stored, true, nil
+}
+
+func (m *MockRepo) GetUnreferencedBlobs(ctx context.Context, limit int) ([]models.Blob, error) {
+	var blobs []models.Blob
+	for _, blob := range m.blobs {
+		if blob.Refs <= 0 {
+			blobs = append(blobs, *blob)
+		}
+	}
+	return blobs, nil
+}
+
+func (m *MockRepo) DeleteUnreferencedBlob(ctx context.Context, sha256 string) error {
+	blob, ok := m.blobs[sha256]
+	if !ok || blob.Refs > 0 {
+		return mongo.ErrNoDocuments
+	}
+	delete(m.blobs, sha256)
+	return nil
+}
+
+// MockStorage keeps what is put in memory so tests can look at it
+type MockStorage struct {
+	mock.Mock
+	objects map[string][]byte
+}
+
+func (m *MockStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if m.objects == nil {
+		m.objects = make(map[string][]byte)
+	}
+	m.objects[key] = data
+
+	args := m.Called(ctx, key, contentType)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	args := m.Called(ctx, key)
+	return io.NopCloser(bytes.NewReader(m.objects[key])), args.Error(0)
+}
+
+func (m *MockStorage) Delete(ctx context.Context, key string) error {
+	delete(m.objects, key)
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+type MockChatAccess struct {
+	mock.Mock
+}
+
+func (m *MockChatAccess) Access(ctx context.Context, caller models.Caller, conversationID primitive.ObjectID) (models.ChatAccess, error) {
+	args := m.Called(ctx, caller, conversationID)
+	return args.Get(0).(models.ChatAccess), args.Error(1)
+}
+
+type MockJobs struct {
+	mock.Mock
+}
+
+func (m *MockJobs) Publish(msg interface{}) error {
+	args := m.Called(msg)
+	return args.Error(0)
+}
+
+var (
+	alice = models.Caller{UserID: "alice", Token: "alice-token"}
+	bob   = models.Caller{UserID: "bob", Token: "bob-token"}
+)
+
+// newService has a user with nothing stored, quota tests set their own
+// usage with newServiceWithPolicy
+func newService() (*logic.FileService, *MockRepo, *MockStorage, *MockChatAccess, *MockJobs) {
+	service, repo, storage, chats, jobs := newServiceWithPolicy(logic.DefaultPolicy)
+	repo.On("GetUsage", mock.Anything, mock.Anything).Return(&models.Usage{}, nil).Maybe()
+	return service, repo, storage, chats, jobs
+}
+
+// every upload is queued for a scan
+func newServiceWithPolicy(policy logic.Policy) (*logic.FileService, *MockRepo, *MockStorage, *MockChatAccess, *MockJobs) {
+	repo := new(MockRepo)
+	storage := new(MockStorage)
+	chats := new(MockChatAccess)
+	jobs := new(MockJobs)
+	jobs.On("Publish", mock.AnythingOfType("models.FileJob")).Return(nil).Maybe()
+	return logic.NewFileService(repo, storage, chats, jobs, policy), repo, storage, chats, jobs
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUpload_Success(t *testing.T) {
+	service, repo, storage, chats, _ := newService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+	content := []byte("%PDF-1.4 some document")
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	storage.On("Put", ctx, mock.Anything, "application/pdf").Return(nil)
+	repo.On("CreateFile", ctx, mock.AnythingOfType("*models.File")).Return(nil)
+
+	file, err := service.Upload(ctx, alice, logic.Upload{
+		ConversationID: chatID,
+		Name:           "../../report.pdf",
+		Content:        bytes.NewReader(content),
+		SHA256:         strings.ToUpper(checksum(content)),
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "report.pdf", file.Name)
+	assert.Equal(t, "application/pdf", file.ContentType)
+	assert.Equal(t, int64(len(content)), file.Size)
+	assert.Equal(t, checksum(content), file.SHA256)
+	assert.Equal(t, "alice", file.UploadedBy)
+	assert.Equal(t, content, storage.objects[file.StorageKey])
+	repo.AssertExpectations(t)
+}
+
+func TestUpload_NeedsSendPermission(t *testing.T) {
+	service, _, storage, chats, _ := newService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true}, nil)
+
+	_, err := service.Upload(ctx, alice, logic.Upload{ConversationID: chatID, Name: "a.txt", Content: strings.NewReader("hi")})
+
+	assert.ErrorIs(t, err, logic.ErrMissingPermission)
+	storage.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestUpload_NotMember(t *testing.T) {
+	service, _, _, chats, _ := newService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{}, nil)
+
+	_, err := service.Upload(ctx, alice, logic.Upload{ConversationID: chatID, Name: "a.txt", Content: strings.NewReader("hi")})
+
+	assert.ErrorIs(t, err, logic.ErrNotMember)
+}
+
+func TestUpload_Empty(t *testing.T) {
+	service, _, _, chats, _ := newService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+
+	_, err := service.Upload(ctx, alice, logic.Upload{ConversationID: chatID, Name: "a.txt", Content: strings.NewReader("")})
+
+	assert.ErrorIs(t, err, logic.ErrEmptyFile)
+}
+
+func TestUpload_ChecksumMismatchRemovesObject(t *testing.T) {
+	service, repo, storage, chats, _ := newService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	storage.On("Put", ctx, mock.Anything, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	_, err := service.Upload(ctx, alice, logic.Upload{
+		ConversationID: chatID,
+		Name:           "a.txt",
+		Content:        strings.NewReader("hello"),
+		SHA256:         checksum([]byte("something else")),
+	})
+
+	assert.ErrorIs(t, err, logic.ErrChecksumMismatch)
+	assert.Empty(t, storage.objects)
+	repo.AssertNotCalled(t, "CreateFile", mock.Anything, mock.Anything)
+}
+
+func TestUpload_TooLarge(t *testing.T) {
+	policy := logic.DefaultPolicy
+	policy.MaxFileSize = 1 << 20
+	service, repo, storage, chats, _ := newServiceWithPolicy(policy)
+	repo.On("GetUsage", mock.Anything, mock.Anything).Return(&models.Usage{}, nil).Maybe()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	storage.On("Put", ctx, mock.Anything, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	content := io.LimitReader(zeroReader{}, policy.MaxFileSize+10)
+	_, err := service.Upload(ctx, alice, logic.Upload{ConversationID: chatID, Name: "big.bin", Content: content})
+
+	assert.ErrorIs(t, err, logic.ErrFileTooLarge)
+	assert.Empty(t, storage.objects)
+	repo.AssertNotCalled(t, "CreateFile", mock.Anything, mock.Anything)
+}
+
+func TestGetFile_UploaderNeedsNoAccessCheck(t *testing.T) {
+	service, repo, _, chats, _ := newService()
+	ctx := context.Background()
+	file := &models.File{ID: primitive.NewObjectID(), ConversationID: primitive.NewObjectID(), UploadedBy: "alice"}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+
+	got, err := service.GetFile(ctx, alice, file.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, file, got)
+	chats.AssertNotCalled(t, "Access", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestOpen_OtherMemberCanView(t *testing.T) {
+	service, repo, storage, chats, _ := newService()
+	ctx := context.Background()
+	file := &models.File{ID: primitive.NewObjectID(), ConversationID: primitive.NewObjectID(), UploadedBy: "alice", StorageKey: "key"}
+	storage.objects = map[string][]byte{"key": []byte("hello")}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	chats.On("Access", ctx, bob, file.ConversationID).Return(models.ChatAccess{CanView: true}, nil)
+	storage.On("Get", ctx, "key").Return(nil)
+
+	download, err := service.Open(ctx, bob, file.ID, 0)
+
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(download.Content)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestOpen_NonMember(t *testing.T) {
+	service, repo, storage, chats, _ := newService()
+	ctx := context.Background()
+	file := &models.File{ID: primitive.NewObjectID(), ConversationID: primitive.NewObjectID(), UploadedBy: "alice", StorageKey: "key"}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	chats.On("Access", ctx, bob, file.ConversationID).Return(models.ChatAccess{}, nil)
+
+	_, err := service.Open(ctx, bob, file.ID, 0)
+
+	assert.ErrorIs(t, err, logic.ErrNotMember)
+	storage.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+}
+
+func TestDeleteFile_OnlyUploader(t *testing.T) {
+	service, repo, _, _, _ := newService()
+	ctx := context.Background()
+	file := &models.File{ID: primitive.NewObjectID(), UploadedBy: "alice", StorageKey: "key"}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+
+	err := service.DeleteFile(ctx, bob, file.ID)
+
+	assert.ErrorIs(t, err, logic.ErrNotUploader)
+	repo.AssertNotCalled(t, "DeleteFile", mock.Anything, mock.Anything)
+}
+
+func TestDeleteFile_Success(t *testing.T) {
+	service, repo, storage, _, _ := newService()
+	ctx := context.Background()
+	file := &models.File{ID: primitive.NewObjectID(), UploadedBy: "alice", StorageKey: "key"}
+	storage.objects = map[string][]byte{"key": []byte("hello")}
+
+	repo.On("GetFile", ctx, file.ID).Return(file, nil)
+	repo.On("DeleteFile", ctx, file.ID).Return(nil)
+	storage.On("Delete", mock.Anything, "key").Return(nil)
+
+	err := service.DeleteFile(ctx, alice, file.ID)
+
+	assert.NoError(t, err)
+	assert.Empty(t, storage.objects)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
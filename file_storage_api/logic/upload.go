@@ -0,0 +1,350 @@
+package logic
+
+import (
+	"bytes"
+	"cloudcord/fileStorage/models"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// the default largest file, resumable uploads are meant for files too
+	// large to send in one request
+	MaxResumableSize = 1 << 30
+
+	// sessions without a chunk for this long are abandoned
+	UploadExpiry = 24 * time.Hour
+
+	// expired sessions removed per sweep, the rest wait for the next one
+	expirySweepBatch = 500
+)
+
+var (
+	ErrOffsetMismatch      = errors.New("offset does not match the upload")
+	ErrUploadExpired       = errors.New("upload has expired")
+	ErrChunkChecksum       = errors.New("chunk does not match its checksum")
+	ErrUnsupportedChecksum = errors.New("unsupported checksum algorithm")
+	ErrInvalidLength       = errors.New("upload length must be positive")
+)
+
+type UploadRepository interface {
+	CreateUpload(ctx context.Context, upload *models.UploadSession) error
+	GetUpload(ctx context.Context, uploadID primitive.ObjectID) (*models.UploadSession, error)
+	AppendPart(ctx context.Context, uploadID primitive.ObjectID, offset int64, part models.UploadPart, expiresAt time.Time) (*models.UploadSession, error)
+	DeleteUpload(ctx context.Context, uploadID primitive.ObjectID) error
+	GetExpiredUploads(ctx context.Context, now time.Time, limit int) ([]models.UploadSession, error)
+	GetUploadsByUploader(ctx context.Context, userID string) ([]models.UploadSession, error)
+}
+
+// UploadService takes files in chunks, so a client can resume an upload
+// after losing its connection. Finished uploads become files of FileService.
+type UploadService struct {
+	files *FileService
+	repo  UploadRepository
+}
+
+// constructor
+func NewUploadService(files *FileService, repo UploadRepository) *UploadService {
+	return &UploadService{files: files, repo: repo}
+}
+
+// NewUpload describes a file about to be sent in chunks, SHA256 is optional
+// and checked once every chunk is in
+type NewUpload struct {
+	ConversationID primitive.ObjectID
+	Name           string
+	Length         int64
+	SHA256         string
+}
+
+// ChunkChecksum is an optional checksum of a single chunk
+type ChunkChecksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// start a resumable upload to a conversation the caller can send messages to
+func (s *UploadService) CreateUpload(ctx context.Context, caller models.Caller, upload NewUpload) (*models.UploadSession, error) {
+	if upload.Length <= 0 {
+		return nil, ErrInvalidLength
+	}
+	if upload.Length > s.files.policy.MaxFileSize {
+		return nil, ErrFileTooLarge
+	}
+
+	if err := s.files.requireAccess(ctx, caller, upload.ConversationID, true); err != nil {
+		return nil, err
+	}
+
+	// the whole length is set aside, so the user can't run out of room
+	// halfway through
+	if err := s.files.checkQuota(ctx, caller.UserID, upload.Length); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &models.UploadSession{
+		ID:             primitive.NewObjectID(),
+		ConversationID: upload.ConversationID,
+		UploadedBy:     caller.UserID,
+		Name:           cleanFileName(upload.Name),
+		Length:         upload.Length,
+		SHA256:         strings.ToLower(upload.SHA256),
+		Parts:          []models.UploadPart{},
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(UploadExpiry),
+	}
+	if err := s.repo.CreateUpload(ctx, session); err != nil {
+		return nil, err
+	}
+
+	// checked again now that the session counts, in case uploads started
+	// meanwhile took the room
+	if err := s.files.checkQuota(ctx, caller.UserID, 0); err != nil {
+		if err := s.repo.DeleteUpload(ctx, session.ID); err != nil {
+			log.Printf("Failed to remove upload %s over quota: %v", session.ID.Hex(), err)
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// get an upload of the caller, to learn where to resume it
+func (s *UploadService) GetUpload(ctx context.Context, caller models.Caller, uploadID primitive.ObjectID) (*models.UploadSession, error) {
+	session, err := s.repo.GetUpload(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.UploadedBy != caller.UserID {
+		return nil, ErrNotUploader
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	return session, nil
+}
+
+// add the chunk starting at offset. The chunk that completes the upload
+// turns it into a file with the ID of the upload.
+func (s *UploadService) AppendChunk(ctx context.Context, caller models.Caller, uploadID primitive.ObjectID, offset int64, chunk io.Reader, checksum *ChunkChecksum) (*models.UploadSession, error) {
+	session, err := s.GetUpload(ctx, caller, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if offset != session.Offset {
+		return nil, ErrOffsetMismatch
+	}
+
+	var digest hash.Hash
+	if checksum != nil {
+		digest, err = newDigest(checksum.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the type shows in the first bytes, a file that won't be accepted is
+	// refused before the rest of it is sent
+	if offset == 0 {
+		head := make([]byte, sniffLength)
+		n, err := io.ReadFull(chunk, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if n > 0 && !s.files.policy.AllowsType(http.DetectContentType(head[:n])) {
+			s.removeUpload(ctx, session)
+			return nil, ErrTypeNotAllowed
+		}
+		chunk = io.MultiReader(bytes.NewReader(head[:n]), chunk)
+	}
+
+	part := models.UploadPart{StorageKey: session.ID.Hex() + "." + primitive.NewObjectID().Hex()}
+
+	// a byte past the end is enough to know the chunk is too long
+	remaining := session.Length - session.Offset
+	counter := &countingReader{r: io.LimitReader(chunk, remaining+1)}
+	var content io.Reader = counter
+	if digest != nil {
+		content = io.TeeReader(counter, digest)
+	}
+
+	if err := s.files.storage.Put(ctx, part.StorageKey, content, "application/octet-stream"); err != nil {
+		return nil, err
+	}
+	part.Size = counter.n
+
+	switch {
+	case part.Size > remaining:
+		err = ErrFileTooLarge
+	case digest != nil && !bytes.Equal(digest.Sum(nil), checksum.Sum):
+		err = ErrChunkChecksum
+	}
+	if err != nil {
+		s.files.removeObject(part.StorageKey)
+		return nil, err
+	}
+
+	// an empty chunk only retries completing the upload
+	if part.Size == 0 {
+		s.files.removeObject(part.StorageKey)
+	} else {
+		session, err = s.repo.AppendPart(ctx, session.ID, offset, part, time.Now().Add(UploadExpiry))
+		if err != nil {
+			s.files.removeObject(part.StorageKey)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, ErrOffsetMismatch
+			}
+			return nil, err
+		}
+	}
+
+	if session.Offset == session.Length {
+		if err := s.complete(ctx, caller, session); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// join the parts into the file. Access is checked again as the caller may
+// have left the conversation during a long upload. A file that fails its
+// checksum or turns out to be of a refused type can't be resumed, the
+// upload is dropped.
+func (s *UploadService) complete(ctx context.Context, caller models.Caller, session *models.UploadSession) error {
+	if err := s.files.requireAccess(ctx, caller, session.ConversationID, true); err != nil {
+		return err
+	}
+
+	file := &models.File{
+		ID:             session.ID,
+		ConversationID: session.ConversationID,
+		UploadedBy:     session.UploadedBy,
+		Name:           session.Name,
+		CreatedAt:      time.Now(),
+	}
+
+	parts := &partsReader{ctx: ctx, storage: s.files.storage, parts: session.Parts}
+	err := s.files.store(ctx, file, parts, session.SHA256, session.Length, session.Length)
+	parts.Close()
+
+	if err != nil && !errors.Is(err, ErrChecksumMismatch) && !errors.Is(err, ErrTypeNotAllowed) {
+		return err
+	}
+
+	s.removeUpload(ctx, session)
+	return err
+}
+
+// give up an upload of the caller
+func (s *UploadService) TerminateUpload(ctx context.Context, caller models.Caller, uploadID primitive.ObjectID) error {
+	session, err := s.GetUpload(ctx, caller, uploadID)
+	if err != nil {
+		return err
+	}
+
+	s.removeUpload(ctx, session)
+	return nil
+}
+
+// remove sessions nobody resumed in time, with their parts
+func (s *UploadService) ExpireUploads(ctx context.Context) error {
+	sessions, err := s.repo.GetExpiredUploads(ctx, time.Now(), expirySweepBatch)
+	if err != nil {
+		return err
+	}
+
+	for i := range sessions {
+		s.removeUpload(ctx, &sessions[i])
+	}
+	if len(sessions) > 0 {
+		log.Printf("Removed %d expired uploads", len(sessions))
+	}
+	return nil
+}
+
+// remove the uploads in progress of a deleted user
+func (s *UploadService) DeleteUserUploads(ctx context.Context, userID string) error {
+	sessions, err := s.repo.GetUploadsByUploader(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for i := range sessions {
+		s.removeUpload(ctx, &sessions[i])
+	}
+	return nil
+}
+
+func (s *UploadService) removeUpload(ctx context.Context, session *models.UploadSession) {
+	if err := s.repo.DeleteUpload(ctx, session.ID); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Failed to delete upload %s: %v", session.ID.Hex(), err)
+		return
+	}
+	for _, part := range session.Parts {
+		s.files.removeObject(part.StorageKey)
+	}
+}
+
+func newDigest(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	}
+	return nil, ErrUnsupportedChecksum
+}
+
+// partsReader reads the parts of an upload one after the other, opening
+// each only when it's reached
+type partsReader struct {
+	ctx     context.Context
+	storage Storage
+	parts   []models.UploadPart
+	current io.ReadCloser
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if len(p.parts) == 0 {
+				return 0, io.EOF
+			}
+			part, err := p.storage.Get(p.ctx, p.parts[0].StorageKey)
+			if err != nil {
+				return 0, err
+			}
+			p.current = part
+			p.parts = p.parts[1:]
+		}
+
+		n, err := p.current.Read(b)
+		if errors.Is(err, io.EOF) {
+			p.current.Close()
+			p.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() {
+	if p.current != nil {
+		p.current.Close()
+	}
+}
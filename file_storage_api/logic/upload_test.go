@@ -0,0 +1,298 @@
+package logic_test
+
+import (
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/models"
+	"context"
+	"crypto/sha1"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MockUploadRepo struct {
+	mock.Mock
+}
+
+func (m *MockUploadRepo) CreateUpload(ctx context.Context, upload *models.UploadSession) error {
+	args := m.Called(ctx, upload)
+	return args.Error(0)
+}
+
+func (m *MockUploadRepo) GetUpload(ctx context.Context, uploadID primitive.ObjectID) (*models.UploadSession, error) {
+	args := m.Called(ctx, uploadID)
+	upload, _ := args.Get(0).(*models.UploadSession)
+	return upload, args.Error(1)
+}
+
+// AppendPart moves the offset like the database would when it matches
+func (m *MockUploadRepo) AppendPart(ctx context.Context, uploadID primitive.ObjectID, offset int64, part models.UploadPart, expiresAt time.Time) (*models.UploadSession, error) {
+	args := m.Called(ctx, uploadID, offset)
+	upload, _ := args.Get(0).(*models.UploadSession)
+	if upload == nil {
+		return nil, args.Error(1)
+	}
+	updated := *upload
+	updated.Parts = append(append([]models.UploadPart{}, upload.Parts...), part)
+	updated.Offset += part.Size
+	updated.ExpiresAt = expiresAt
+	return &updated, args.Error(1)
+}
+
+func (m *MockUploadRepo) DeleteUpload(ctx context.Context, uploadID primitive.ObjectID) error {
+	args := m.Called(ctx, uploadID)
+	return args.Error(0)
+}
+
+func (m *MockUploadRepo) GetExpiredUploads(ctx context.Context, now time.Time, limit int) ([]models.UploadSession, error) {
+	args := m.Called(ctx)
+	uploads, _ := args.Get(0).([]models.UploadSession)
+	return uploads, args.Error(1)
+}
+
+func (m *MockUploadRepo) GetUploadsByUploader(ctx context.Context, userID string) ([]models.UploadSession, error) {
+	args := m.Called(ctx, userID)
+	uploads, _ := args.Get(0).([]models.UploadSession)
+	return uploads, args.Error(1)
+}
+
+func newUploadService() (*logic.UploadService, *MockUploadRepo, *MockRepo, *MockStorage, *MockChatAccess) {
+	files, repo, storage, chats, _ := newService()
+	uploads := new(MockUploadRepo)
+	return logic.NewUploadService(files, uploads), uploads, repo, storage, chats
+}
+
+func openSession(length int64, sha string) *models.UploadSession {
+	return &models.UploadSession{
+		ID:             primitive.NewObjectID(),
+		ConversationID: primitive.NewObjectID(),
+		UploadedBy:     "alice",
+		Name:           "notes.txt",
+		Length:         length,
+		SHA256:         sha,
+		ExpiresAt:      time.Now().Add(time.Hour),
+	}
+}
+
+func TestCreateUpload_NeedsSendPermission(t *testing.T) {
+	service, uploads, _, _, chats := newUploadService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true}, nil)
+
+	_, err := service.CreateUpload(ctx, alice, logic.NewUpload{ConversationID: chatID, Name: "a.bin", Length: 10})
+
+	assert.ErrorIs(t, err, logic.ErrMissingPermission)
+	uploads.AssertNotCalled(t, "CreateUpload", mock.Anything, mock.Anything)
+}
+
+func TestCreateUpload_TooLarge(t *testing.T) {
+	service, _, _, _, chats := newUploadService()
+	ctx := context.Background()
+
+	_, err := service.CreateUpload(ctx, alice, logic.NewUpload{ConversationID: primitive.NewObjectID(), Length: logic.MaxResumableSize + 1})
+
+	assert.ErrorIs(t, err, logic.ErrFileTooLarge)
+	chats.AssertNotCalled(t, "Access", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateUpload_Success(t *testing.T) {
+	service, uploads, _, _, chats := newUploadService()
+	ctx := context.Background()
+	chatID := primitive.NewObjectID()
+
+	chats.On("Access", ctx, alice, chatID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	uploads.On("CreateUpload", ctx, mock.AnythingOfType("*models.UploadSession")).Return(nil)
+
+	session, err := service.CreateUpload(ctx, alice, logic.NewUpload{ConversationID: chatID, Name: "dir/movie.mp4", Length: 1000, SHA256: "ABC"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "movie.mp4", session.Name)
+	assert.Equal(t, "abc", session.SHA256)
+	assert.Equal(t, int64(0), session.Offset)
+	assert.True(t, session.ExpiresAt.After(time.Now()))
+}
+
+func TestGetUpload_OtherUser(t *testing.T) {
+	service, uploads, _, _, _ := newUploadService()
+	ctx := context.Background()
+	session := openSession(10, "")
+
+	uploads.On("GetUpload", ctx, session.ID).Return(session, nil)
+
+	_, err := service.GetUpload(ctx, bob, session.ID)
+
+	assert.ErrorIs(t, err, logic.ErrNotUploader)
+}
+
+func TestGetUpload_Expired(t *testing.T) {
+	service, uploads, _, _, _ := newUploadService()
+	ctx := context.Background()
+	session := openSession(10, "")
+	session.ExpiresAt = time.Now().Add(-time.Minute)
+
+	uploads.On("GetUpload", ctx, session.ID).Return(session, nil)
+
+	_, err := service.GetUpload(ctx, alice, session.ID)
+
+	assert.ErrorIs(t, err, logic.ErrUploadExpired)
+}
+
+func TestAppendChunk_OffsetMismatch(t *testing.T) {
+	service, uploads, _, storage, _ := newUploadService()
+	ctx := context.Background()
+	session := openSession(10, "")
+
+	uploads.On("GetUpload", ctx, session.ID).Return(session, nil)
+
+	_, err := service.AppendChunk(ctx, alice, session.ID, 4, strings.NewReader("abc"), nil)
+
+	assert.ErrorIs(t, err, logic.ErrOffsetMismatch)
+	storage.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAppendChunk_LostRace(t *testing.T) {
+	service, uploads, _, storage, _ := newUploadService()
+	ctx := context.Background()
+	session := openSession(10, "")
+
+	uploads.On("GetUpload", ctx, session.ID).Return(session, nil)
+	uploads.On("AppendPart", ctx, session.ID, int64(0)).Return(nil, mongo.ErrNoDocuments)
+	storage.On("Put", ctx, mock.Anything, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	_, err := service.AppendChunk(ctx, alice, session.ID, 0, strings.NewReader("abc"), nil)
+
+	assert.ErrorIs(t, err, logic.ErrOffsetMismatch)
+	assert.Empty(t, storage.objects)
+}
+
+func TestAppendChunk_ChecksumMismatchRemovesPart(t *testing.T) {
+	service, uploads, _, storage, _ := newUploadService()
+	ctx := context.Background()
+	session := openSession(10, "")
+
+	uploads.On("GetUpload", ctx, session.ID).Return(session, nil)
+	storage.On("Put", ctx, mock.Anything, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	sum := sha1.Sum([]byte("something else"))
+	_, err := service.AppendChunk(ctx, alice, session.ID, 0, strings.NewReader("abc"), &logic.ChunkChecksum{Algorithm: "sha1", Sum: sum[:]})
+
+	assert.ErrorIs(t, err, logic.ErrChunkChecksum)
+	assert.Empty(t, storage.objects)
+	uploads.AssertNotCalled(t, "AppendPart", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAppendChunk_UnsupportedChecksum(t *testing.T) {
+	service, uploads, _, storage, _ := newUploadService()
+	ctx := context.Background()
+	session := openSession(10, "")
+
+	uploads.On("GetUpload", ctx, session.ID).Return(session, nil)
+
+	_, err := service.AppendChunk(ctx, alice, session.ID, 0, strings.NewReader("abc"), &logic.ChunkChecksum{Algorithm: "md5"})
+
+	assert.ErrorIs(t, err, logic.ErrUnsupportedChecksum)
+	storage.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAppendChunk_PastLength(t *testing.T) {
+	service, uploads, _, storage, _ := newUploadService()
+	ctx := context.Background()
+	session := openSession(3, "")
+
+	uploads.On("GetUpload", ctx, session.ID).Return(session, nil)
+	storage.On("Put", ctx, mock.Anything, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	_, err := service.AppendChunk(ctx, alice, session.ID, 0, strings.NewReader("abcdef"), nil)
+
+	assert.ErrorIs(t, err, logic.ErrFileTooLarge)
+	assert.Empty(t, storage.objects)
+}
+
+func TestAppendChunk_CompletesFile(t *testing.T) {
+	service, uploads, repo, storage, chats := newUploadService()
+	ctx := context.Background()
+	content := "hello, resumable world"
+	session := openSession(int64(len(content)), checksum([]byte(content)))
+
+	uploads.On("GetUpload", ctx, session.ID).Return(session, nil).Once()
+	uploads.On("AppendPart", ctx, session.ID, int64(0)).Return(session, nil)
+	storage.On("Put", ctx, mock.Anything, mock.Anything).Return(nil)
+
+	sum := sha1.Sum([]byte(content[:10]))
+	first, err := service.AppendChunk(ctx, alice, session.ID, 0, strings.NewReader(content[:10]), &logic.ChunkChecksum{Algorithm: "sha1", Sum: sum[:]})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), first.Offset)
+
+	// the client resumes from where the first chunk ended
+	uploads.On("GetUpload", ctx, session.ID).Return(first, nil).Once()
+	uploads.On("AppendPart", ctx, session.ID, int64(10)).Return(first, nil)
+	chats.On("Access", ctx, alice, session.ConversationID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	var file *models.File
+	repo.On("CreateFile", ctx, mock.AnythingOfType("*models.File")).Run(func(args mock.Arguments) {
+		file = args.Get(1).(*models.File)
+	}).Return(nil)
+	uploads.On("DeleteUpload", ctx, session.ID).Return(nil)
+	storage.On("Get", ctx, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	last, err := service.AppendChunk(ctx, alice, session.ID, 10, strings.NewReader(content[10:]), nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, last.Length, last.Offset)
+
+	assert.Equal(t, session.ID, file.ID)
+	assert.Equal(t, "notes.txt", file.Name)
+	assert.Equal(t, int64(len(content)), file.Size)
+	assert.Equal(t, checksum([]byte(content)), file.SHA256)
+
+	// only the joined file is left
+	assert.Equal(t, map[string][]byte{file.StorageKey: []byte(content)}, storage.objects)
+}
+
+func TestAppendChunk_FinalChecksumMismatchDropsUpload(t *testing.T) {
+	service, uploads, repo, storage, chats := newUploadService()
+	ctx := context.Background()
+	session := openSession(5, checksum([]byte("world")))
+
+	uploads.On("GetUpload", ctx, session.ID).Return(session, nil)
+	uploads.On("AppendPart", ctx, session.ID, int64(0)).Return(session, nil)
+	chats.On("Access", ctx, alice, session.ConversationID).Return(models.ChatAccess{CanView: true, CanSend: true}, nil)
+	uploads.On("DeleteUpload", ctx, session.ID).Return(nil)
+	storage.On("Put", ctx, mock.Anything, mock.Anything).Return(nil)
+	storage.On("Get", ctx, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	_, err := service.AppendChunk(ctx, alice, session.ID, 0, strings.NewReader("hello"), nil)
+
+	assert.ErrorIs(t, err, logic.ErrChecksumMismatch)
+	assert.Empty(t, storage.objects)
+	uploads.AssertCalled(t, "DeleteUpload", ctx, session.ID)
+	repo.AssertNotCalled(t, "CreateFile", mock.Anything, mock.Anything)
+}
+
+func TestExpireUploads_RemovesParts(t *testing.T) {
+	service, uploads, _, storage, _ := newUploadService()
+	ctx := context.Background()
+	session := openSession(10, "")
+	session.Parts = []models.UploadPart{{StorageKey: "part-1", Size: 4}, {StorageKey: "part-2", Size: 4}}
+	storage.objects = map[string][]byte{"part-1": []byte("abcd"), "part-2": []byte("efgh")}
+
+	uploads.On("GetExpiredUploads", ctx).Return([]models.UploadSession{*session}, nil)
+	uploads.On("DeleteUpload", ctx, session.ID).Return(nil)
+	storage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+	err := service.ExpireUploads(ctx)
+
+	assert.NoError(t, err)
+	assert.Empty(t, storage.objects)
+}
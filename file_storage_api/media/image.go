@@ -0,0 +1,222 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	// decoders for image.Decode
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// images larger than this are not decoded, a small file can expand to
+// gigabytes of pixels
+const maxPixels = 50_000_000
+
+var (
+	ErrNotImage      = errors.New("not a supported image")
+	ErrImageTooLarge = errors.New("image has too many pixels")
+)
+
+// IsImage tells whether a sniffed content type is an image this package
+// processes
+func IsImage(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Thumbnail is an encoded resized copy of an image
+type Thumbnail struct {
+	Size        int
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+// Info is what is learned about an image while processing it
+type Info struct {
+	Width         int
+	Height        int
+	DominantColor string
+	Thumbnails    []Thumbnail
+}
+
+// Process decodes an image and makes a thumbnail fitting in each of sizes,
+// skipping sizes the image already fits in. Width, height and thumbnails
+// follow the EXIF orientation.
+func Process(data []byte, contentType string, orientation int, sizes []int) (*Info, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotImage, err)
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotImage, err)
+	}
+
+	bounds := img.Bounds()
+	info := &Info{Width: bounds.Dx(), Height: bounds.Dy()}
+	if orientation >= 5 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+
+	// JPEG stays JPEG, everything else may be transparent
+	thumbType := "image/png"
+	if contentType == "image/jpeg" {
+		thumbType = "image/jpeg"
+	}
+
+	var smallest image.Image
+	for _, size := range sizes {
+		if info.Width <= size && info.Height <= size {
+			continue
+		}
+
+		thumb := orient(resize(img, size), orientation)
+		encoded, err := encode(thumb, thumbType)
+		if err != nil {
+			return nil, err
+		}
+
+		info.Thumbnails = append(info.Thumbnails, Thumbnail{
+			Size:        size,
+			Width:       thumb.Bounds().Dx(),
+			Height:      thumb.Bounds().Dy(),
+			ContentType: thumbType,
+			Data:        encoded,
+		})
+		if smallest == nil || thumb.Bounds().Dx() < smallest.Bounds().Dx() {
+			smallest = thumb
+		}
+	}
+
+	if smallest == nil {
+		smallest = img
+	}
+	info.DominantColor = dominantColor(smallest)
+	return info, nil
+}
+
+// scale img to fit in a size by size square
+func resize(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// turn img the way EXIF orientation says it was taken
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// the most common colour as #rrggbb, counted in buckets of similar colours
+// and averaged within the winning bucket. Mostly transparent pixels don't
+// count, an image without opaque pixels has no dominant colour.
+func dominantColor(img image.Image) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[uint32]*bucket)
+
+	var best *bucket
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+
+			key := uint32(c.R>>4)<<8 | uint32(c.G>>4)<<4 | uint32(c.B>>4)
+			b := buckets[key]
+			if b == nil {
+				b = &bucket{}
+				buckets[key] = b
+			}
+			b.count++
+			b.r += int(c.R)
+			b.g += int(c.G)
+			b.b += int(c.B)
+
+			if best == nil || b.count > best.count {
+				best = b
+			}
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
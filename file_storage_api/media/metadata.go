@@ -0,0 +1,266 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("malformed image metadata")
+
+const (
+	tagOrientation = 0x0112
+	tagGPSInfo     = 0x8825
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// StripLocation removes the location an image may carry in its metadata and
+// returns the EXIF orientation, 1 when there is none. The GPS part of EXIF
+// is blanked and XMP, which can repeat it, is dropped; the rest of the file
+// is left byte for byte. Formats without location metadata come back as is.
+func StripLocation(data []byte, contentType string) ([]byte, int, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		out, err := stripPNG(data)
+		return out, 1, err
+	case "image/webp":
+		out, err := stripWebP(data)
+		return out, 1, err
+	}
+	return data, 1, nil
+}
+
+// a JPEG is a list of segments up to the start of scan, after which the
+// image data runs to the end of the file
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 1, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	orientation := 1
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, 1, errMalformed
+		}
+		// markers may be padded with any number of 0xFF
+		if i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, 1, errMalformed
+		}
+
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 1, errMalformed
+		}
+
+		// start of scan, the rest is image data
+		if marker == 0xDA {
+			return append(out, data[i:]...), orientation, nil
+		}
+
+		segment := data[i:end]
+		payload := segment[4:]
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, xmpHeader):
+			// dropped
+		case marker == 0xE1 && bytes.HasPrefix(payload, exifHeader):
+			exif := append([]byte(nil), segment...)
+			o, err := scrubTIFF(exif[4+len(exifHeader):])
+			if err == nil {
+				orientation = o
+				out = append(out, exif...)
+			}
+			// EXIF that can't be read can't be checked, so it is dropped
+		default:
+			out = append(out, segment...)
+		}
+		i = end
+	}
+	return nil, 1, errMalformed
+}
+
+// blank the GPS directory of TIFF structured EXIF in place, keeping the
+// entry that points to it so offsets elsewhere stay valid
+func scrubTIFF(t []byte) (int, error) {
+	if len(t) < 8 {
+		return 1, errMalformed
+	}
+
+	var order binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1, errMalformed
+	}
+
+	entries, err := ifdEntries(t, order, order.Uint32(t[4:]))
+	if err != nil {
+		return 1, err
+	}
+
+	orientation := 1
+	for _, e := range entries {
+		switch order.Uint16(t[e:]) {
+		case tagOrientation:
+			if o := int(order.Uint16(t[e+8:])); o >= 1 && o <= 8 {
+				orientation = o
+			}
+		case tagGPSInfo:
+			if err := blankIFD(t, order, order.Uint32(t[e+8:])); err != nil {
+				return 1, err
+			}
+		}
+	}
+	return orientation, nil
+}
+
+// the offsets of the 12 byte entries of the directory at offset
+func ifdEntries(t []byte, order binary.ByteOrder, offset uint32) ([]int, error) {
+	start := int(offset)
+	if offset > uint32(len(t)) || start+2 > len(t) {
+		return nil, errMalformed
+	}
+
+	count := int(order.Uint16(t[start:]))
+	if start+2+12*count+4 > len(t) {
+		return nil, errMalformed
+	}
+
+	entries := make([]int, count)
+	for i := range entries {
+		entries[i] = start + 2 + 12*i
+	}
+	return entries, nil
+}
+
+// sizes of the TIFF field types, by type number
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// zero a directory and the values it points to. What's left reads as an
+// empty directory.
+func blankIFD(t []byte, order binary.ByteOrder, offset uint32) error {
+	entries, err := ifdEntries(t, order, offset)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		size := typeSizes[order.Uint16(t[e+2:])] * int(order.Uint32(t[e+4:]))
+		if size <= 4 {
+			continue
+		}
+
+		valueOffset := order.Uint32(t[e+8:])
+		if valueOffset > uint32(len(t)) || size > len(t)-int(valueOffset) {
+			return errMalformed
+		}
+		clear(t[valueOffset : int(valueOffset)+size])
+	}
+
+	start := int(offset)
+	clear(t[start : start+2+12*len(entries)+4])
+	return nil
+}
+
+// drop the eXIf chunk and XMP text chunks of a PNG
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngHeader) {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngHeader...)
+
+	i := len(pngHeader)
+	for i < len(data) {
+		if i+12 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, errMalformed
+		}
+
+		chunkType := string(data[i+4 : i+8])
+		chunkData := data[i+8 : i+8+length]
+		drop := chunkType == "eXIf" ||
+			(chunkType == "iTXt" && bytes.HasPrefix(chunkData, []byte("XML:com.adobe.xmp\x00")))
+		if !drop {
+			out = append(out, data[i:end]...)
+		}
+
+		i = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+	return out, nil
+}
+
+// VP8X flags telling that EXIF and XMP chunks follow
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// drop the EXIF and XMP chunks of a WebP and clear the flags announcing them
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size
+		if end > len(data) {
+			return nil, errMalformed
+		}
+		// odd chunks are padded, some writers leave it out at the end
+		if size%2 == 1 && end < len(data) {
+			end++
+		}
+
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if size > 0 {
+				chunk[8] &^= webpFlagEXIF | webpFlagXMP
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// File is an upload to a conversation. Its bytes are the blob named by
+// SHA256, stored under StorageKey; only members of the conversation can
+// read it. Avatars belong to no conversation, every user can read them.
+type File struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	UploadedBy     string             `bson:"uploaded_by" json:"uploaded_by"`
+	Name           string             `bson:"name" json:"name"`
+	ContentType    string             `bson:"content_type" json:"content_type"`
+	Size           int64              `bson:"size" json:"size"`
+	SHA256         string             `bson:"sha256" json:"sha256"`
+	StorageKey     string             `bson:"storage_key" json:"-"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+
+	// images are processed after the upload, other files have no state
+	Processing string `bson:"processing,omitempty" json:"processing,omitempty"`
+	Media      *Media `bson:"media,omitempty" json:"media,omitempty"`
+
+	// files are scanned for malware before others may download them, files
+	// from before scanning have no state
+	Scan   string `bson:"scan,omitempty" json:"scan,omitempty"`
+	Threat string `bson:"threat,omitempty" json:"threat,omitempty"`
+
+	// empty for attachments
+	Purpose string `bson:"purpose,omitempty" json:"purpose,omitempty"`
+}
+
+// the file is the profile picture of its uploader
+const PurposeAvatar = "avatar"
+
+const (
+	ProcessingPending = "pending"
+	ProcessingDone    = "done"
+	ProcessingFailed  = "failed"
+)
+
+const (
+	ScanPending     = "pending"
+	ScanClean       = "clean"
+	ScanQuarantined = "quarantined"
+)
+
+// Media describes a processed image so clients can lay out a placeholder
+// before it loads
+type Media struct {
+	Width         int       `bson:"width" json:"width"`
+	Height        int       `bson:"height" json:"height"`
+	DominantColor string    `bson:"dominant_color" json:"dominant_color"`
+	Variants      []Variant `bson:"variants,omitempty" json:"variants,omitempty"`
+}
+
+// Variant is a thumbnail fitting in a Size by Size square, images smaller
+// than Size have none
+type Variant struct {
+	Size        int    `bson:"size" json:"size"`
+	Width       int    `bson:"width" json:"width"`
+	Height      int    `bson:"height" json:"height"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	Bytes       int64  `bson:"bytes" json:"bytes"`
+	StorageKey  string `bson:"storage_key" json:"-"`
+}
+
+// FileJob is work on an uploaded file, the queue it's on tells what
+type FileJob struct {
+	FileID primitive.ObjectID `json:"file_id"`
+}
+
+// MediaJob asks a worker to process an uploaded image
+type MediaJob = FileJob
+
+// ScanJob asks a worker to scan an uploaded file
+type ScanJob = FileJob
+
+// AttachmentStatus tells chat_api a file of a conversation changed state
+type AttachmentStatus struct {
+	FileID         primitive.ObjectID `json:"file_id"`
+	ConversationID primitive.ObjectID `json:"conversation_id"`
+	Status         string             `json:"status"`
+}
+
+// the attachment was quarantined and can't be downloaded
+const AttachmentBlocked = "blocked"
+
+// UserDeletedMessage is published by user_api when a user deletes their
+// account
+type UserDeletedMessage struct {
+	Auth0ID string `json:"auth0_id"`
+}
+
+// Caller is the user of a request, Token is forwarded to chat_api to
+// check what they may do in a conversation
+type Caller struct {
+	UserID string
+	Token  string
+}
+
+// ChatAccess is what the caller may do in a conversation
+type ChatAccess struct {
+	CanView bool
+	CanSend bool
+}
+
+// Variant gets the thumbnail of a size, nil when there is none
+func (f *File) Variant(size int) *Variant {
+	if f.Media == nil {
+		return nil
+	}
+	for i := range f.Media.Variants {
+		if f.Media.Variants[i].Size == size {
+			return &f.Media.Variants[i]
+		}
+	}
+	return nil
+}
+
+// Usage is how much of their quota a user takes. Pending is set aside by
+// resumable uploads in progress, a Quota of 0 is unlimited.
+type Usage struct {
+	Bytes       int64 `json:"bytes"`
+	Files       int64 `json:"files"`
+	Pending     int64 `json:"pending"`
+	Quota       int64 `json:"quota"`
+	MaxFileSize int64 `json:"max_file_size"`
+}
+
+// Blob holds the bytes shared by every file with the same SHA256. It is
+// removed from storage with its thumbnails once no file refers to it.
+type Blob struct {
+	SHA256      string    `bson:"_id"`
+	StorageKey  string    `bson:"storage_key"`
+	Size        int64     `bson:"size"`
+	ContentType string    `bson:"content_type"`
+	Refs        int64     `bson:"refs"`
+	CreatedAt   time.Time `bson:"created_at"`
+}
+
+// AttachmentsReleased is published by chat_api when the messages holding
+// these files are deleted
+type AttachmentsReleased struct {
+	FileIDs []primitive.ObjectID `json:"file_ids"`
+}
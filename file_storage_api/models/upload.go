@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UploadSession is a resumable upload in progress. Every chunk received is
+// stored as a part, the parts are joined into the file once Offset reaches
+// Length. The file gets the ID of the session.
+type UploadSession struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	ConversationID primitive.ObjectID `bson:"conversation_id"`
+	UploadedBy     string             `bson:"uploaded_by"`
+	Name           string             `bson:"name"`
+	Length         int64              `bson:"length"`
+	Offset         int64              `bson:"offset"`
+	SHA256         string             `bson:"sha256,omitempty"`
+	Parts          []UploadPart       `bson:"parts"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	ExpiresAt      time.Time          `bson:"expires_at"`
+}
+
+type UploadPart struct {
+	StorageKey string `bson:"storage_key"`
+	Size       int64  `bson:"size"`
+}
@@ -0,0 +1,218 @@
+package mq
+
+import (
+	"cloudcord/fileStorage/models"
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// how long a worker may take for one file
+const jobTimeout = 2 * time.Minute
+
+// StartFileJobConsumer handles the jobs of a queue, scans or media
+// processing, one at a time. A job is acked once handled, so the jobs of a
+// crashed replica go to another one, and requeued when handle fails.
+func StartFileJobConsumer(amqpURL string, queueName string, handle func(context.Context, primitive.ObjectID) error) error {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	if _, err := declareQueue(ch, queueName); err != nil {
+		return err
+	}
+
+	if err := ch.Qos(1, 0, false); err != nil {
+		return err
+	}
+
+	msgs, err := ch.Consume(
+		queueName,
+		"",
+		false, // auto-ack
+		false, // exclusive
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range msgs {
+			var job models.FileJob
+			if err := json.Unmarshal(d.Body, &job); err != nil {
+				log.Printf("Failed to parse job from %s: %v", queueName, err)
+				d.Ack(false)
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+			err := handle(ctx, job.FileID)
+			cancel()
+
+			if err != nil {
+				log.Printf("❌ Failed to process file %s, retrying: %v", job.FileID.Hex(), err)
+				// don't spin on a store that is down
+				time.Sleep(5 * time.Second)
+				d.Nack(false, true)
+				continue
+			}
+			d.Ack(false)
+		}
+	}()
+
+	return nil
+}
+
+// StartReleaseConsumer removes the files chat_api no longer refers to,
+// requeueing what fails
+func StartReleaseConsumer(amqpURL string, queueName string, handle func(context.Context, []primitive.ObjectID) error) error {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	if _, err := declareQueue(ch, queueName); err != nil {
+		return err
+	}
+
+	msgs, err := ch.Consume(
+		queueName,
+		"",
+		false, // auto-ack
+		false, // exclusive
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range msgs {
+			var msg models.AttachmentsReleased
+			if err := json.Unmarshal(d.Body, &msg); err != nil {
+				log.Printf("Failed to parse released attachments: %v", err)
+				d.Ack(false)
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err := handle(ctx, msg.FileIDs)
+			cancel()
+
+			if err != nil {
+				log.Printf("❌ Failed to release attachments, retrying: %v", err)
+				time.Sleep(5 * time.Second)
+				d.Nack(false, true)
+				continue
+			}
+			d.Ack(false)
+		}
+	}()
+
+	return nil
+}
+
+// how long removing the files of a deleted user may take
+const deletionTimeout = 10 * time.Minute
+
+// StartUserDeletionConsumer binds a durable queue of file storage to the
+// user deletion exchange of user_api, so it gets every deletion even when
+// it was down. A deletion that fails is requeued.
+func StartUserDeletionConsumer(amqpURL string, exchange string, queueName string, handle func(context.Context, string) error) error {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	err = ch.ExchangeDeclare(
+		exchange,
+		"fanout",
+		true,  // durable
+		false, // auto-delete
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	q, err := declareQueue(ch, queueName)
+	if err != nil {
+		return err
+	}
+
+	if err := ch.QueueBind(q.Name, "", exchange, false, nil); err != nil {
+		return err
+	}
+
+	if err := ch.Qos(1, 0, false); err != nil {
+		return err
+	}
+
+	msgs, err := ch.Consume(
+		q.Name,
+		"",
+		false, // auto-ack
+		false, // exclusive
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range msgs {
+			var msg models.UserDeletedMessage
+			if err := json.Unmarshal(d.Body, &msg); err != nil || msg.Auth0ID == "" {
+				log.Printf("Failed to parse user deletion message: %v", err)
+				d.Ack(false)
+				continue
+			}
+
+			log.Printf("Received user deletion for Auth0ID: %s", msg.Auth0ID)
+
+			ctx, cancel := context.WithTimeout(context.Background(), deletionTimeout)
+			err := handle(ctx, msg.Auth0ID)
+			cancel()
+
+			if err != nil {
+				log.Printf("❌ Failed to delete files of user %s, retrying: %v", msg.Auth0ID, err)
+				time.Sleep(5 * time.Second)
+				d.Nack(false, true)
+				continue
+			}
+			log.Printf("✅ Deleted files of user %s", msg.Auth0ID)
+			d.Ack(false)
+		}
+	}()
+
+	return nil
+}
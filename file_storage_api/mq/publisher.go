@@ -0,0 +1,64 @@
+package mq
+
+import (
+	"encoding/json"
+
+	"github.com/streadway/amqp"
+)
+
+type Publisher struct {
+	channel *amqp.Channel
+	queue   amqp.Queue
+}
+
+func NewPublisher(amqpURL, queueName string) (*Publisher, error) {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := declareQueue(ch, queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Publisher{
+		channel: ch,
+		queue:   q,
+	}, nil
+}
+
+func (p *Publisher) Publish(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return p.channel.Publish(
+		"",
+		p.queue.Name,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+		},
+	)
+}
+
+func declareQueue(ch *amqp.Channel, queueName string) (amqp.Queue, error) {
+	return ch.QueueDeclare(
+		queueName,
+		true,  // durable
+		false, // auto-delete
+		false,
+		false,
+		nil,
+	)
+}
@@ -0,0 +1,106 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// chunks sent to clamd at a time, well under its StreamMaxLength
+const clamdChunkSize = 64 << 10
+
+// Clamd scans with a ClamAV daemon over TCP, streaming the file with the
+// INSTREAM command. Files over clamd's StreamMaxLength are rejected.
+type Clamd struct {
+	address string
+	timeout time.Duration
+}
+
+// constructor, address is host:port of clamd
+func NewClamd(address string) *Clamd {
+	return &Clamd{address: address, timeout: 10 * time.Second}
+}
+
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// clamd closes the stream early when the file is too large, its reply
+	// says so
+	if err := c.stream(conn, r); err != nil {
+		reply, replyErr := readReply(conn)
+		if replyErr != nil {
+			return Result{}, err
+		}
+		return parseReply(reply)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseReply(reply)
+}
+
+// INSTREAM sends chunks prefixed with their length, a zero length ends it
+func (c *Clamd) stream(conn net.Conn, r io.Reader) error {
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(reply, "\x00"), nil
+}
+
+// replies look like "stream: OK", "stream: Eicar-Signature FOUND" or
+// "INSTREAM size limit exceeded. ERROR"
+func parseReply(reply string) (Result, error) {
+	switch {
+	case reply == "stream: OK":
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		threat := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return Result{Infected: true, Threat: threat}, nil
+	case strings.HasSuffix(reply, " ERROR"):
+		return Result{}, fmt.Errorf("%w: %s", ErrRejected, strings.TrimSuffix(reply, " ERROR"))
+	}
+	return Result{}, fmt.Errorf("unexpected reply from clamd: %q", reply)
+}
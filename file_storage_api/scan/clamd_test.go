@@ -0,0 +1,83 @@
+package scan_test
+
+import (
+	"cloudcord/fileStorage/scan"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClamd answers one INSTREAM with the reply for what it received
+func fakeClamd(t *testing.T, reply func(received string) string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		command := make([]byte, len("zINSTREAM\x00"))
+		if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+			return
+		}
+
+		var received strings.Builder
+		for {
+			var size uint32
+			if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&received, conn, int64(size)); err != nil {
+				return
+			}
+		}
+		conn.Write([]byte(reply(received.String()) + "\x00"))
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestClamd_Clean(t *testing.T) {
+	content := strings.Repeat("harmless ", 20000)
+	var got string
+	address := fakeClamd(t, func(received string) string {
+		got = received
+		return "stream: OK"
+	})
+
+	result, err := scan.NewClamd(address).Scan(context.Background(), strings.NewReader(content))
+
+	assert.NoError(t, err)
+	assert.False(t, result.Infected)
+	assert.Equal(t, content, got)
+}
+
+func TestClamd_Infected(t *testing.T) {
+	address := fakeClamd(t, func(string) string { return "stream: Win.Test.EICAR_HDB-1 FOUND" })
+
+	result, err := scan.NewClamd(address).Scan(context.Background(), strings.NewReader("x"))
+
+	assert.NoError(t, err)
+	assert.True(t, result.Infected)
+	assert.Equal(t, "Win.Test.EICAR_HDB-1", result.Threat)
+}
+
+func TestClamd_Rejected(t *testing.T) {
+	address := fakeClamd(t, func(string) string { return "INSTREAM size limit exceeded. ERROR" })
+
+	_, err := scan.NewClamd(address).Scan(context.Background(), strings.NewReader("x"))
+
+	assert.ErrorIs(t, err, scan.ErrRejected)
+}
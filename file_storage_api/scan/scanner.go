@@ -0,0 +1,54 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrRejected is returned when the scanner refuses a file rather than
+// failing to be reached, scanning it again won't help
+var ErrRejected = errors.New("scanner rejected the file")
+
+// Result is the verdict on a file, Threat names what was found
+type Result struct {
+	Infected bool
+	Threat   string
+}
+
+// Scanner looks for malware in the bytes of a file
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// Noop finds every file clean, for deployments without a scanner
+type Noop struct{}
+
+func (Noop) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	return Result{}, nil
+}
+
+// the EICAR test file, which every scanner reports as a virus
+var eicar = []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`)
+
+// Fake flags files holding the EICAR test string, like a real scanner
+// would, and fails with Err when it is set
+type Fake struct {
+	Err error
+}
+
+func (f Fake) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	if f.Err != nil {
+		return Result{}, f.Err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Result{}, err
+	}
+	if bytes.Contains(data, eicar) {
+		return Result{Infected: true, Threat: "Eicar-Test-Signature"}, nil
+	}
+	return Result{}, nil
+}
@@ -0,0 +1,158 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// shorter keys are refused, HMAC-SHA256 gains nothing from longer ones
+const minKeyLength = 32
+
+var (
+	// the link was tampered with, or signed by a key that was rotated out
+	ErrInvalidLink = errors.New("invalid download link")
+	ErrLinkExpired = errors.New("download link has expired")
+)
+
+// Link lets whoever holds it download a file, or one of its thumbnails, until
+// it expires. A link with a Nonce may be used once.
+type Link struct {
+	FileID    primitive.ObjectID
+	Size      int
+	ExpiresAt time.Time
+	Nonce     string
+}
+
+// Signer signs links with its active key and verifies them with any key it
+// holds. Removing a key revokes every link it signed.
+type Signer struct {
+	active string
+	keys   map[string][]byte
+}
+
+// constructor, active names the key new links are signed with
+func NewSigner(keys map[string][]byte, active string) (*Signer, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("no signing key %q", active)
+	}
+	for id, key := range keys {
+		if id == "" || strings.ContainsAny(id, ":,") {
+			return nil, fmt.Errorf("invalid signing key ID %q", id)
+		}
+		if len(key) < minKeyLength {
+			return nil, fmt.Errorf("signing key %q is shorter than %d bytes", id, minKeyLength)
+		}
+	}
+	return &Signer{active: active, keys: keys}, nil
+}
+
+// ParseKeys reads keys written as "id:base64,id:base64", the first one signs
+// and the others are kept to verify links signed before a rotation
+func ParseKeys(spec string) (*Signer, error) {
+	keys := make(map[string][]byte)
+	active := ""
+
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("signing key %q is not id:base64", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", id, err)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, fmt.Errorf("signing key %q is given twice", id)
+		}
+
+		keys[id] = key
+		if active == "" {
+			active = id
+		}
+	}
+	return NewSigner(keys, active)
+}
+
+// RandomSigner signs with a key that lives as long as the process, its
+// links stop working on restart and on other replicas. Only for tests.
+func RandomSigner() *Signer {
+	key := make([]byte, minKeyLength)
+	rand.Read(key)
+	return &Signer{active: "ephemeral", keys: map[string][]byte{"ephemeral": key}}
+}
+
+// NewNonce makes a nonce for a single use link
+func NewNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Sign gives the query parameters that carry a link
+func (s *Signer) Sign(link Link) url.Values {
+	values := url.Values{}
+	values.Set("exp", strconv.FormatInt(link.ExpiresAt.Unix(), 10))
+	values.Set("kid", s.active)
+	if link.Size != 0 {
+		values.Set("size", strconv.Itoa(link.Size))
+	}
+	if link.Nonce != "" {
+		values.Set("once", link.Nonce)
+	}
+	values.Set("sig", base64.RawURLEncoding.EncodeToString(sign(s.keys[s.active], link)))
+	return values
+}
+
+// Verify reads back the link to a file from its query parameters. Only the
+// signature is checked, nothing is looked up.
+func (s *Signer) Verify(fileID primitive.ObjectID, values url.Values, now time.Time) (Link, error) {
+	key, ok := s.keys[values.Get("kid")]
+	if !ok {
+		return Link{}, ErrInvalidLink
+	}
+
+	expires, err := strconv.ParseInt(values.Get("exp"), 10, 64)
+	if err != nil {
+		return Link{}, ErrInvalidLink
+	}
+
+	link := Link{
+		FileID:    fileID,
+		ExpiresAt: time.Unix(expires, 0),
+		Nonce:     values.Get("once"),
+	}
+	if size := values.Get("size"); size != "" {
+		link.Size, err = strconv.Atoi(size)
+		if err != nil {
+			return Link{}, ErrInvalidLink
+		}
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(values.Get("sig"))
+	if err != nil || !hmac.Equal(sig, sign(key, link)) {
+		return Link{}, ErrInvalidLink
+	}
+
+	// checked after the signature, only a genuine link learns it expired
+	if now.After(link.ExpiresAt) {
+		return Link{}, ErrLinkExpired
+	}
+	return link, nil
+}
+
+// every field is signed, separated so one can't run into the next
+func sign(key []byte, link Link) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "v1\n%s\n%d\n%d\n%s", link.FileID.Hex(), link.Size, link.ExpiresAt.Unix(), link.Nonce)
+	return mac.Sum(nil)
+}
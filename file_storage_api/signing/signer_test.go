@@ -0,0 +1,87 @@
+package signing_test
+
+import (
+	"bytes"
+	"cloudcord/fileStorage/signing"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer, err := signing.ParseKeys("k1:" + key(1))
+	assert.NoError(t, err)
+
+	now := time.Now()
+	link := signing.Link{FileID: primitive.NewObjectID(), Size: 256, ExpiresAt: now.Add(time.Hour).Truncate(time.Second), Nonce: signing.NewNonce()}
+
+	verified, err := signer.Verify(link.FileID, signer.Sign(link), now)
+
+	assert.NoError(t, err)
+	assert.Equal(t, link.Size, verified.Size)
+	assert.Equal(t, link.Nonce, verified.Nonce)
+	assert.True(t, link.ExpiresAt.Equal(verified.ExpiresAt))
+}
+
+func TestVerify_RejectsTampering(t *testing.T) {
+	signer, _ := signing.ParseKeys("k1:" + key(1))
+	now := time.Now()
+	link := signing.Link{FileID: primitive.NewObjectID(), ExpiresAt: now.Add(time.Hour), Nonce: "n"}
+
+	// another file
+	_, err := signer.Verify(primitive.NewObjectID(), signer.Sign(link), now)
+	assert.ErrorIs(t, err, signing.ErrInvalidLink)
+
+	// a later expiry
+	values := signer.Sign(link)
+	values.Set("exp", "99999999999")
+	_, err = signer.Verify(link.FileID, values, now)
+	assert.ErrorIs(t, err, signing.ErrInvalidLink)
+
+	// made reusable
+	values = signer.Sign(link)
+	values.Del("once")
+	_, err = signer.Verify(link.FileID, values, now)
+	assert.ErrorIs(t, err, signing.ErrInvalidLink)
+}
+
+func TestVerify_Expired(t *testing.T) {
+	signer, _ := signing.ParseKeys("k1:" + key(1))
+	link := signing.Link{FileID: primitive.NewObjectID(), ExpiresAt: time.Now().Add(-time.Minute)}
+
+	_, err := signer.Verify(link.FileID, signer.Sign(link), time.Now())
+
+	assert.ErrorIs(t, err, signing.ErrLinkExpired)
+}
+
+func TestVerify_KeyRotation(t *testing.T) {
+	old, _ := signing.ParseKeys("k1:" + key(1))
+	now := time.Now()
+	link := signing.Link{FileID: primitive.NewObjectID(), ExpiresAt: now.Add(time.Hour)}
+	values := old.Sign(link)
+
+	// k2 signs now, links of k1 keep working until it's removed
+	rotated, err := signing.ParseKeys("k2:" + key(2) + ",k1:" + key(1))
+	assert.NoError(t, err)
+	_, err = rotated.Verify(link.FileID, values, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", rotated.Sign(link).Get("kid"))
+
+	revoked, _ := signing.ParseKeys("k2:" + key(2))
+	_, err = revoked.Verify(link.FileID, values, now)
+	assert.ErrorIs(t, err, signing.ErrInvalidLink)
+}
+
+func TestParseKeys_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "k1", "k1:not base64", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "k1:" + key(1) + ",k1:" + key(2)} {
+		_, err := signing.ParseKeys(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Backend stores the bytes of files by key. Keys are generated by the
+// service and never contain path separators.
+type Backend interface {
+	// store everything r yields under key, replacing what was there
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+
+	// open the object stored under key, ErrNotFound when there is none
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// remove the object stored under key, removing a missing one is no error
+	Delete(ctx context.Context, key string) error
+}
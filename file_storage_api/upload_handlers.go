@@ -0,0 +1,221 @@
+package main
+
+import (
+	"cloudcord/fileStorage/logic"
+	"cloudcord/fileStorage/models"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// resumable uploads follow the tus protocol, https://tus.io/protocols/resumable-upload
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,checksum,termination"
+	tusChecksums  = "sha1,sha256"
+	uploadsPath   = "/files/uploads/"
+
+	// a chunk may be large, it gets longer than a whole simple upload
+	chunkTimeout = 10 * time.Minute
+)
+
+// withTus answers the protocol discovery and refuses clients speaking another
+// version of the protocol
+func withTus(maxSize int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Tus-Version", tusVersion)
+			w.Header().Set("Tus-Extension", tusExtensions)
+			w.Header().Set("Tus-Checksum-Algorithm", tusChecksums)
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+		} else if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// POST /files/uploads starts an upload. Upload-Metadata carries the
+// conversation_id, the filename and optionally the sha256 of the whole file.
+func createUploadHandler(uploadLogic *logic.UploadService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		caller, ok := callerFromRequest(r)
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+			return
+		}
+
+		metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			http.Error(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+			return
+		}
+
+		conversationID, err := primitive.ObjectIDFromHex(metadata["conversation_id"])
+		if err != nil {
+			http.Error(w, "Invalid conversation_id", http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		session, err := uploadLogic.CreateUpload(ctx, caller, logic.NewUpload{
+			ConversationID: conversationID,
+			Name:           metadata["filename"],
+			Length:         length,
+			SHA256:         metadata["sha256"],
+		})
+		if err != nil {
+			http.Error(w, "Failed to create upload: "+err.Error(), statusForError(err))
+			return
+		}
+
+		w.Header().Set("Location", uploadsPath+session.ID.Hex())
+		writeUploadHeaders(w, session)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// /files/uploads/{id}: HEAD tells where to resume, PATCH sends the next
+// chunk and DELETE gives the upload up
+func uploadRoutes(uploadLogic *logic.UploadService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := callerFromRequest(r)
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		uploadID, err := primitive.ObjectIDFromHex(strings.TrimPrefix(r.URL.Path, uploadsPath))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodHead:
+			uploadOffset(w, r, uploadLogic, caller, uploadID)
+		case http.MethodPatch:
+			appendChunk(w, r, uploadLogic, caller, uploadID)
+		case http.MethodDelete:
+			terminateUpload(w, r, uploadLogic, caller, uploadID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func uploadOffset(w http.ResponseWriter, r *http.Request, uploadLogic *logic.UploadService, caller models.Caller, uploadID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	session, err := uploadLogic.GetUpload(ctx, caller, uploadID)
+	if err != nil {
+		w.WriteHeader(statusForError(err))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeUploadHeaders(w, session)
+	w.WriteHeader(http.StatusOK)
+}
+
+func appendChunk(w http.ResponseWriter, r *http.Request, uploadLogic *logic.UploadService, caller models.Caller, uploadID primitive.ObjectID) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	var checksum *logic.ChunkChecksum
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		algorithm, encoded, _ := strings.Cut(header, " ")
+		sum, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			http.Error(w, "Invalid Upload-Checksum", http.StatusBadRequest)
+			return
+		}
+		checksum = &logic.ChunkChecksum{Algorithm: algorithm, Sum: sum}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), chunkTimeout)
+	defer cancel()
+
+	session, err := uploadLogic.AppendChunk(ctx, caller, uploadID, offset, r.Body, checksum)
+	if err != nil {
+		http.Error(w, "Failed to upload chunk: "+err.Error(), statusForError(err))
+		return
+	}
+
+	writeUploadHeaders(w, session)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func terminateUpload(w http.ResponseWriter, r *http.Request, uploadLogic *logic.UploadService, caller models.Caller, uploadID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := uploadLogic.TerminateUpload(ctx, caller, uploadID); err != nil {
+		http.Error(w, "Failed to terminate upload: "+err.Error(), statusForError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeUploadHeaders(w http.ResponseWriter, session *models.UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+	w.Header().Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// Upload-Metadata is a comma separated list of keys, each with an optional
+// base64 encoded value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
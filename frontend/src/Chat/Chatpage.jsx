@@ -17,7 +17,12 @@ const ChatPage = () => {
   useEffect(() => {
     const fetchChat = async () => {
       try {
-        const response = await fetch(`https://cloudcord.info/message/chat?user1=${user1}&user2=${user2}`);
+        const token = await getAccessTokenSilently();
+        const response = await fetch(`https://cloudcord.info/message/chat?user1=${user1}&user2=${user2}`, {
+          headers: {
+            Authorization: `Bearer ${token}`,
+          },
+        });
         if (!response.ok) throw new Error('Failed to fetch chat');
         const data = await response.json();
         setMessages(data.messages ?? []);
@@ -29,20 +34,21 @@ const ChatPage = () => {
     if (user1 && user2) {
       fetchChat();
     }
-  }, [user1, user2]);
+  }, [user1, user2, getAccessTokenSilently]);
 
   const handleSend = async () => {
     if (!input.trim()) return; // Prevent empty sends
 
     try {
       const token = await getAccessTokenSilently();
+      const headers = {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${token}`,
+      };
 
-      const response = await fetch('https://cloudcord.info/message/send', {
+      const send = () => fetch('https://cloudcord.info/message/send', {
         method: 'POST',
-        headers: {
-          'Content-Type': 'application/json',
-          Authorization: `Bearer ${token}`,
-        },
+        headers,
         body: JSON.stringify({
           sender: user1,
           receiver: user2,
@@ -50,6 +56,19 @@ const ChatPage = () => {
         }),
       });
 
+      let response = await send();
+
+      // the first message needs the chat to be started
+      if (response.status === 404) {
+        const started = await fetch('https://cloudcord.info/message/chat', {
+          method: 'POST',
+          headers,
+          body: JSON.stringify({ user: user2 }),
+        });
+        if (!started.ok) throw new Error('Failed to start chat');
+        response = await send();
+      }
+
       if (!response.ok) throw new Error('Failed to send message');
 
       const data = await response.json();
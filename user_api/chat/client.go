@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var ErrNoPresence = errors.New("chat_api returned no presence")
+
+// Presence is whether a user is online, idle or offline in chat_api.
+// LastSeen is zero when chat_api hasn't seen the user since it started, or
+// not within the last week.
+type Presence struct {
+	UserID   string    `json:"user_id"`
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Client asks chat_api about users on behalf of the caller by forwarding
+// their Authorization header
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// constructor
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Presence gets the presence of the user with the given Auth0 ID
+func (c *Client) Presence(ctx context.Context, authorization string, auth0ID string) (*Presence, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/message/presence?user="+url.QueryEscape(auth0ID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("chat_api returned %s", resp.Status)
+	}
+
+	var presence []Presence
+	if err := json.NewDecoder(resp.Body).Decode(&presence); err != nil {
+		return nil, err
+	}
+	if len(presence) == 0 {
+		return nil, ErrNoPresence
+	}
+	return &presence[0], nil
+}
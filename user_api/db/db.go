@@ -20,7 +20,8 @@ func Connect() {
 		os.Getenv("POSTGRES_PASSWORD"),
 	)
 	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// TranslateError turns unique violations into gorm.ErrDuplicatedKey
+	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("Error connecting to the database: %v", err)
 	}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"cloudcord/user_api/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (r *Repository) CreateFriendRequest(request *models.FriendRequest) error {
+	return r.DB.Create(request).Error
+}
+
+func (r *Repository) GetFriendRequest(id uint) (*models.FriendRequest, error) {
+	var request models.FriendRequest
+	if err := r.DB.First(&request, id).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// the pending request from sender to receiver, nil when there is none
+func (r *Repository) GetPendingFriendRequest(senderID, receiverID uint) (*models.FriendRequest, error) {
+	var request models.FriendRequest
+	err := r.DB.Where("sender_id = ? AND receiver_id = ? AND status = ?", senderID, receiverID, models.FriendRequestPending).
+		First(&request).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// the pending requests a user sent or received, newest first
+func (r *Repository) GetPendingFriendRequests(userID uint) ([]models.FriendRequest, error) {
+	var requests []models.FriendRequest
+	err := r.DB.Where("(sender_id = ? OR receiver_id = ?) AND status = ?", userID, userID, models.FriendRequestPending).
+		Order("created_at DESC").
+		Find(&requests).Error
+	return requests, err
+}
+
+// Accept a pending request and write both friendship rows in one
+// transaction. ErrRecordNotFound means it was no longer pending.
+func (r *Repository) AcceptFriendRequest(request *models.FriendRequest, at time.Time) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := closeFriendRequest(tx, request.ID, models.FriendRequestAccepted, at); err != nil {
+			return err
+		}
+
+		friendships := []models.Friendship{
+			{UserID: request.SenderID, FriendID: request.ReceiverID},
+			{UserID: request.ReceiverID, FriendID: request.SenderID},
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&friendships).Error
+	})
+}
+
+// Decline or cancel a pending request. ErrRecordNotFound means it was no
+// longer pending.
+func (r *Repository) CloseFriendRequest(id uint, status string, at time.Time) error {
+	return closeFriendRequest(r.DB, id, status, at)
+}
+
+func closeFriendRequest(tx *gorm.DB, id uint, status string, at time.Time) error {
+	result := tx.Model(&models.FriendRequest{}).
+		Where("id = ? AND status = ?", id, models.FriendRequestPending).
+		Updates(map[string]interface{}{"status": status, "responded_at": at})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -62,28 +62,23 @@ func (r *Repository) GetAllUsers() ([]models.User, error) {
 	return users, nil
 }
 
-func (r *Repository) DeleteUserByAuth0ID(auth0ID string) error {
-	result := r.DB.Where("auth0_id = ?", auth0ID).Delete(&models.User{})
-	return result.Error
-}
-
-func (r *Repository) AddFriend(userID, friendID uint) error {
-	if userID == friendID {
-		return nil
-	}
-
-	var count int64
-	r.DB.Model(&models.Friendship{}).Where("user_id = ? AND friend_id = ?", userID, friendID).Count(&count)
-	if count > 0 {
-		return nil
+// Save every field of a user's profile, cleared ones included
+func (r *Repository) UpdateProfile(userID uint, profile models.Profile) error {
+	result := r.DB.Model(&models.User{UserID: userID}).
+		Select("display_name", "avatar_file_id", "bio", "pronouns", "status_text", "status_emoji").
+		Updates(models.User{Profile: profile})
+	if result.Error != nil {
+		return result.Error
 	}
-
-	friendships := []models.Friendship{
-		{UserID: userID, FriendID: friendID},
-		{UserID: friendID, FriendID: userID},
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
 	}
+	return nil
+}
 
-	return r.DB.Create(&friendships).Error
+func (r *Repository) DeleteUserByAuth0ID(auth0ID string) error {
+	result := r.DB.Where("auth0_id = ?", auth0ID).Delete(&models.User{})
+	return result.Error
 }
 
 func (r *Repository) AreFriends(userID, otherUserID uint) (bool, error) {
@@ -96,3 +91,41 @@ func (r *Repository) AreFriends(userID, otherUserID uint) (bool, error) {
 	}
 	return count > 0, nil
 }
+
+// A page of a user's friends ordered by username, and how many they have
+func (r *Repository) GetFriends(userID uint, limit, offset int) ([]models.User, int64, error) {
+	query := r.DB.Model(&models.User{}).
+		Joins("JOIN friendships ON friendships.friend_id = users.user_id").
+		Where("friendships.user_id = ?", userID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var friends []models.User
+	err := query.Order("users.username, users.user_id").
+		Limit(limit).
+		Offset(offset).
+		Find(&friends).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return friends, total, nil
+}
+
+// Delete both rows of a friendship and run sync before committing, so the
+// rows stay when it fails. ErrRecordNotFound means they weren't friends.
+func (r *Repository) RemoveFriendship(userID, friendID uint, sync func() error) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("(user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)", userID, friendID, friendID, userID).
+			Delete(&models.Friendship{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return sync()
+	})
+}
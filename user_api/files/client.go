@@ -0,0 +1,69 @@
+package files
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var ErrFileNotFound = errors.New("file not found")
+
+// the file is an avatar, not an attachment
+const PurposeAvatar = "avatar"
+
+// File is the metadata file_storage_api keeps about an upload
+type File struct {
+	ID          string `json:"id"`
+	UploadedBy  string `json:"uploaded_by"`
+	ContentType string `json:"content_type"`
+	Purpose     string `json:"purpose"`
+}
+
+// Client looks up uploads in file_storage_api on behalf of the caller by
+// forwarding their Authorization header
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// constructor
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Get the metadata of a file the caller can see, ErrFileNotFound when it
+// doesn't exist or they can't
+func (c *Client) Get(ctx context.Context, authorization string, fileID string) (*File, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/files/"+url.PathEscape(fileID)+"/info", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authorization)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden, http.StatusBadRequest:
+		return nil, ErrFileNotFound
+	default:
+		return nil, fmt.Errorf("file_storage_api returned %s", resp.Status)
+	}
+
+	var file File
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"cloudcord/user_api/logic"
+	"cloudcord/user_api/middleware"
+	"cloudcord/user_api/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// the user a request acts on, writing the error response when there is
+// none: the caller, or with ?auth0_id= anyone for an admin
+func currentUser(w http.ResponseWriter, r *http.Request, userLogic *logic.UserLogic) (*models.User, bool) {
+	auth0ID, ok := targetAuth0ID(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	user, err := userLogic.GetUserByAuth0ID(auth0ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	return user, true
+}
+
+// the Auth0 ID given by ?auth0_id= or else the caller's, writing the error
+// response when the caller may not act on it
+func targetAuth0ID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	actor, ok := middleware.ActorFromRequest(r)
+	if !ok {
+		http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+		return "", false
+	}
+
+	auth0ID := r.URL.Query().Get("auth0_id")
+	if auth0ID == "" {
+		auth0ID = actor.Auth0ID
+	}
+	if !actor.CanActOn(auth0ID) {
+		http.Error(w, "Forbidden: you can only act on your own account", http.StatusForbidden)
+		return "", false
+	}
+	return auth0ID, true
+}
+
+// GET lists the caller's pending requests, POST sends one to receiver_id
+func handleFriendRequests(userLogic *logic.UserLogic, friendService *logic.FriendService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, ok := currentUser(w, r, userLogic)
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			requests, err := friendService.ListFriendRequests(user.UserID)
+			if err != nil {
+				http.Error(w, "Could not retrieve friend requests", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(requests)
+			return
+		}
+
+		var req struct {
+			ReceiverID uint `json:"receiver_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ReceiverID == 0 {
+			http.Error(w, "Invalid or missing receiver_id", http.StatusBadRequest)
+			return
+		}
+
+		request, err := friendService.SendFriendRequest(user.UserID, req.ReceiverID)
+		if err != nil {
+			http.Error(w, "Failed to send friend request: "+err.Error(), statusForFriendError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(request)
+	}
+}
+
+// POST ?id= accepts, declines or cancels a request of the caller
+func handleAnswerFriendRequest(userLogic *logic.UserLogic, answer func(userID, requestID uint) (*models.FriendRequest, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		requestID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+		if err != nil || requestID == 0 {
+			http.Error(w, "Invalid or missing id", http.StatusBadRequest)
+			return
+		}
+
+		user, ok := currentUser(w, r, userLogic)
+		if !ok {
+			return
+		}
+
+		request, err := answer(user.UserID, uint(requestID))
+		if err != nil {
+			http.Error(w, "Failed to update friend request: "+err.Error(), statusForFriendError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(request)
+	}
+}
+
+// GET lists the caller's friends a page at a time, ?limit= and ?offset=
+func handleListFriends(userLogic *logic.UserLogic, friendService *logic.FriendService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit, offset := 0, 0
+		var err error
+		if s := r.URL.Query().Get("limit"); s != "" {
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+		if s := r.URL.Query().Get("offset"); s != "" {
+			if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+				http.Error(w, "Invalid offset", http.StatusBadRequest)
+				return
+			}
+		}
+
+		user, ok := currentUser(w, r, userLogic)
+		if !ok {
+			return
+		}
+
+		page, err := friendService.ListFriends(user.UserID, limit, offset)
+		if err != nil {
+			http.Error(w, "Could not retrieve friends", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// DELETE ?friend_id= ends a friendship of the caller
+func handleRemoveFriend(userLogic *logic.UserLogic, friendService *logic.FriendService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		friendID, err := strconv.ParseUint(r.URL.Query().Get("friend_id"), 10, 32)
+		if err != nil || friendID == 0 {
+			http.Error(w, "Invalid or missing friend_id", http.StatusBadRequest)
+			return
+		}
+
+		user, ok := currentUser(w, r, userLogic)
+		if !ok {
+			return
+		}
+
+		if err := friendService.RemoveFriend(user.UserID, uint(friendID)); err != nil {
+			http.Error(w, "Failed to remove friend: "+err.Error(), statusForFriendError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Friend removed",
+		})
+	}
+}
+
+func statusForFriendError(err error) int {
+	switch {
+	case errors.Is(err, logic.ErrUserNotFound),
+		errors.Is(err, logic.ErrRequestNotFound),
+		errors.Is(err, logic.ErrNotFriends):
+		return http.StatusNotFound
+	case errors.Is(err, logic.ErrNotRequestRecipient),
+		errors.Is(err, logic.ErrNotRequestSender):
+		return http.StatusForbidden
+	case errors.Is(err, logic.ErrAlreadyFriends),
+		errors.Is(err, logic.ErrRequestPending),
+		errors.Is(err, logic.ErrRequestNotPending):
+		return http.StatusConflict
+	case errors.Is(err, logic.ErrSelfFriendRequest):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
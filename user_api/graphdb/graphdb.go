@@ -27,6 +27,17 @@ func Close() {
 	driver.Close(context.Background())
 }
 
+// Graph is the friendship graph, for code taking it as a dependency
+type Graph struct{}
+
+func (Graph) CreateFriendship(userID, friendID uint) error {
+	return CreateFriendship(userID, friendID)
+}
+
+func (Graph) RemoveFriendship(userID, friendID uint) error {
+	return RemoveFriendship(userID, friendID)
+}
+
 func CreateFriendship(userID, friendID uint) error {
 	ctx := context.Background()
 
@@ -53,6 +64,32 @@ func CreateFriendship(userID, friendID uint) error {
 	return nil
 }
 
+// RemoveFriendship deletes the FRIEND edges both ways, it's a no-op when
+// there are none
+func RemoveFriendship(userID, friendID uint) error {
+	ctx := context.Background()
+
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (u1:User {id: $userID})-[f:FRIEND]-(u2:User {id: $friendID})
+			DELETE f
+		`
+		params := map[string]interface{}{
+			"userID":   strconv.Itoa(int(userID)),
+			"friendID": strconv.Itoa(int(friendID)),
+		}
+		_, err := tx.Run(ctx, query, params)
+		return nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("Neo4j RemoveFriendship error: %w", err)
+	}
+	return nil
+}
+
 type Recommendation struct {
 	UserID            uint
 	MutualFriendCount int
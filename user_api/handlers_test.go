@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -169,7 +170,7 @@ func TestDeleteUser_Auth0NotFoundHandledGracefully(t *testing.T) {
 	}
 	defer func() { middleware.DeleteUserFromAuth0 = originalAuth0Delete }()
 
-	req := httptest.NewRequest(http.MethodDelete, "/delete?auth0_id="+testUser.Auth0ID, nil)
+	req := withClaims(httptest.NewRequest(http.MethodDelete, "/delete?auth0_id="+testUser.Auth0ID, nil), testUser.Auth0ID)
 	rr := httptest.NewRecorder()
 
 	userLogic := logic.NewUserLogic(repo)
@@ -180,3 +181,410 @@ func TestDeleteUser_Auth0NotFoundHandledGracefully(t *testing.T) {
 		t.Fatalf("Expected 200 OK, got %d", rr.Code)
 	}
 }
+
+type noopGraph struct{}
+
+func (noopGraph) CreateFriendship(userID, friendID uint) error { return nil }
+func (noopGraph) RemoveFriendship(userID, friendID uint) error { return nil }
+
+type noopNotifier struct{}
+
+func (noopNotifier) Publish(msg interface{}) error { return nil }
+
+// the request as ValidateJWT passes it on for sub, with the given roles
+func withClaims(req *http.Request, sub string, roles ...string) *http.Request {
+	claims := jwt.MapClaims{"sub": sub}
+	if len(roles) > 0 {
+		claimRoles := []interface{}{}
+		for _, role := range roles {
+			claimRoles = append(claimRoles, role)
+		}
+		claims[middleware.RolesClaim] = claimRoles
+	}
+	return req.WithContext(context.WithValue(req.Context(), middleware.UserContextKey, claims))
+}
+
+// a user removed again with their friendships and requests after the test
+func createTestUser(t *testing.T, auth0ID, username string) *models.User {
+	t.Helper()
+	user := &models.User{Auth0ID: auth0ID, Username: username}
+	if err := db.NewRepository(db.DB).CreateUser(user); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	t.Cleanup(func() {
+		db.DB.Where("user_id = ? OR friend_id = ?", user.UserID, user.UserID).Delete(&models.Friendship{})
+		db.DB.Where("sender_id = ? OR receiver_id = ?", user.UserID, user.UserID).Delete(&models.FriendRequest{})
+		db.DB.Unscoped().Delete(&models.User{}, user.UserID)
+	})
+	return user
+}
+
+func stubAuth0Delete(t *testing.T) {
+	original := middleware.DeleteUserFromAuth0
+	middleware.DeleteUserFromAuth0 = func(auth0ID string) error {
+		return nil
+	}
+	t.Cleanup(func() { middleware.DeleteUserFromAuth0 = original })
+}
+
+func userExists(t *testing.T, auth0ID string) bool {
+	t.Helper()
+	user, err := db.NewRepository(db.DB).GetUserByAuth0ID(auth0ID)
+	if err != nil {
+		t.Fatalf("Failed to look up user: %v", err)
+	}
+	return user != nil
+}
+
+func newTestFriendService() *logic.FriendService {
+	return logic.NewFriendService(db.NewRepository(db.DB), noopGraph{}, noopNotifier{})
+}
+
+func TestDeleteUser_RequiresToken(t *testing.T) {
+	stubAuth0Delete(t)
+	victim := createTestUser(t, "auth0|authz_delete_victim", "victim")
+
+	req := httptest.NewRequest(http.MethodDelete, "/delete?auth0_id="+victim.Auth0ID, nil)
+	rr := httptest.NewRecorder()
+	handleDeleteUser(logic.NewUserLogic(db.NewRepository(db.DB))).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 Unauthorized, got %d", rr.Code)
+	}
+	if !userExists(t, victim.Auth0ID) {
+		t.Errorf("User was deleted without a token")
+	}
+}
+
+func TestDeleteUser_OtherUserForbidden(t *testing.T) {
+	stubAuth0Delete(t)
+	caller := createTestUser(t, "auth0|authz_delete_caller", "caller")
+	victim := createTestUser(t, "auth0|authz_delete_victim", "victim")
+
+	req := withClaims(httptest.NewRequest(http.MethodDelete, "/delete?auth0_id="+victim.Auth0ID, nil), caller.Auth0ID)
+	rr := httptest.NewRecorder()
+	handleDeleteUser(logic.NewUserLogic(db.NewRepository(db.DB))).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 Forbidden, got %d", rr.Code)
+	}
+	if !userExists(t, victim.Auth0ID) {
+		t.Errorf("Another user's account was deleted")
+	}
+}
+
+func TestDeleteUser_OwnAccountByDefault(t *testing.T) {
+	stubAuth0Delete(t)
+	caller := createTestUser(t, "auth0|authz_delete_self", "self")
+
+	req := withClaims(httptest.NewRequest(http.MethodDelete, "/delete", nil), caller.Auth0ID)
+	rr := httptest.NewRecorder()
+	handleDeleteUser(logic.NewUserLogic(db.NewRepository(db.DB))).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", rr.Code)
+	}
+	if userExists(t, caller.Auth0ID) {
+		t.Errorf("Caller's account was not deleted")
+	}
+}
+
+func TestDeleteUser_AdminDeletesOther(t *testing.T) {
+	stubAuth0Delete(t)
+	admin := createTestUser(t, "auth0|authz_delete_admin", "admin")
+	victim := createTestUser(t, "auth0|authz_delete_victim", "victim")
+
+	req := withClaims(httptest.NewRequest(http.MethodDelete, "/delete?auth0_id="+victim.Auth0ID, nil), admin.Auth0ID, middleware.AdminRole)
+	rr := httptest.NewRecorder()
+	handleDeleteUser(logic.NewUserLogic(db.NewRepository(db.DB))).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d", rr.Code)
+	}
+	if userExists(t, victim.Auth0ID) {
+		t.Errorf("Admin could not delete another user")
+	}
+}
+
+func addFriend(t *testing.T, req *http.Request) (*httptest.ResponseRecorder, *models.FriendRequest) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	handleAddFriend(logic.NewUserLogic(db.NewRepository(db.DB)), newTestFriendService()).ServeHTTP(rr, req)
+
+	var resp struct {
+		FriendRequest *models.FriendRequest `json:"friend_request"`
+	}
+	if rr.Code == http.StatusCreated {
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response JSON: %v", err)
+		}
+	}
+	return rr, resp.FriendRequest
+}
+
+func TestAddFriend_OtherSenderForbidden(t *testing.T) {
+	caller := createTestUser(t, "auth0|authz_friend_caller", "caller")
+	victim := createTestUser(t, "auth0|authz_friend_victim", "victim")
+	friend := createTestUser(t, "auth0|authz_friend_friend", "friend")
+
+	body := fmt.Sprintf(`{"user_id": %d, "friend_id": %d}`, victim.UserID, friend.UserID)
+	req := withClaims(httptest.NewRequest(http.MethodPost, "/add-friend", strings.NewReader(body)), caller.Auth0ID)
+	rr, _ := addFriend(t, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 Forbidden, got %d", rr.Code)
+	}
+}
+
+func TestAddFriend_SendsAsCaller(t *testing.T) {
+	caller := createTestUser(t, "auth0|authz_friend_caller", "caller")
+	friend := createTestUser(t, "auth0|authz_friend_friend", "friend")
+
+	body := fmt.Sprintf(`{"friend_id": %d}`, friend.UserID)
+	req := withClaims(httptest.NewRequest(http.MethodPost, "/add-friend", strings.NewReader(body)), caller.Auth0ID)
+	rr, request := addFriend(t, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 Created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if request.SenderID != caller.UserID || request.ReceiverID != friend.UserID {
+		t.Errorf("Expected a request from %d to %d, got %d to %d", caller.UserID, friend.UserID, request.SenderID, request.ReceiverID)
+	}
+}
+
+func TestAddFriend_AdminSendsForOther(t *testing.T) {
+	admin := createTestUser(t, "auth0|authz_friend_admin", "admin")
+	other := createTestUser(t, "auth0|authz_friend_other", "other")
+	friend := createTestUser(t, "auth0|authz_friend_friend", "friend")
+
+	body := fmt.Sprintf(`{"user_id": %d, "friend_id": %d}`, other.UserID, friend.UserID)
+	req := withClaims(httptest.NewRequest(http.MethodPost, "/add-friend", strings.NewReader(body)), admin.Auth0ID, middleware.AdminRole)
+	rr, request := addFriend(t, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 Created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if request.SenderID != other.UserID {
+		t.Errorf("Expected a request from %d, got %d", other.UserID, request.SenderID)
+	}
+}
+
+func TestAnswerFriendRequest_OnlyReceiver(t *testing.T) {
+	sender := createTestUser(t, "auth0|authz_answer_sender", "sender")
+	receiver := createTestUser(t, "auth0|authz_answer_receiver", "receiver")
+	caller := createTestUser(t, "auth0|authz_answer_caller", "caller")
+	friendService := newTestFriendService()
+
+	request, err := friendService.SendFriendRequest(sender.UserID, receiver.UserID)
+	if err != nil {
+		t.Fatalf("Failed to send friend request: %v", err)
+	}
+
+	url := fmt.Sprintf("/friend-requests/accept?id=%d", request.ID)
+	req := withClaims(httptest.NewRequest(http.MethodPost, url, nil), caller.Auth0ID)
+	rr := httptest.NewRecorder()
+	handleAnswerFriendRequest(logic.NewUserLogic(db.NewRepository(db.DB)), friendService.AcceptFriendRequest).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 Forbidden, got %d", rr.Code)
+	}
+}
+
+// the answers to a friend request and whether the sender or the receiver gives them
+var friendRequestAnswers = []struct {
+	action   string
+	bySender bool
+	answer   func(fs *logic.FriendService) func(userID, requestID uint) (*models.FriendRequest, error)
+}{
+	{"accept", false, func(fs *logic.FriendService) func(uint, uint) (*models.FriendRequest, error) {
+		return fs.AcceptFriendRequest
+	}},
+	{"decline", false, func(fs *logic.FriendService) func(uint, uint) (*models.FriendRequest, error) {
+		return fs.DeclineFriendRequest
+	}},
+	{"cancel", true, func(fs *logic.FriendService) func(uint, uint) (*models.FriendRequest, error) {
+		return fs.CancelFriendRequest
+	}},
+}
+
+// answers a request between sender and receiver as caller on behalf of
+// whichever of them gives the answer
+func answerFor(t *testing.T, action string, bySender bool, answer func(*logic.FriendService) func(uint, uint) (*models.FriendRequest, error), caller *models.User, roles ...string) (*httptest.ResponseRecorder, *models.FriendRequest) {
+	t.Helper()
+	sender := createTestUser(t, "auth0|admin_answer_sender", "sender")
+	receiver := createTestUser(t, "auth0|admin_answer_receiver", "receiver")
+	friendService := newTestFriendService()
+
+	request, err := friendService.SendFriendRequest(sender.UserID, receiver.UserID)
+	if err != nil {
+		t.Fatalf("Failed to send friend request: %v", err)
+	}
+
+	onBehalf := receiver
+	if bySender {
+		onBehalf = sender
+	}
+	url := fmt.Sprintf("/friend-requests/%s?id=%d&auth0_id=%s", action, request.ID, onBehalf.Auth0ID)
+	req := withClaims(httptest.NewRequest(http.MethodPost, url, nil), caller.Auth0ID, roles...)
+	rr := httptest.NewRecorder()
+	handleAnswerFriendRequest(logic.NewUserLogic(db.NewRepository(db.DB)), answer(friendService)).ServeHTTP(rr, req)
+
+	stored, err := db.NewRepository(db.DB).GetFriendRequest(request.ID)
+	if err != nil {
+		t.Fatalf("Failed to reload friend request: %v", err)
+	}
+	return rr, stored
+}
+
+func TestAnswerFriendRequest_OtherUserForbidden(t *testing.T) {
+	for _, tc := range friendRequestAnswers {
+		t.Run(tc.action, func(t *testing.T) {
+			caller := createTestUser(t, "auth0|admin_answer_caller", "caller")
+
+			rr, request := answerFor(t, tc.action, tc.bySender, tc.answer, caller)
+
+			if rr.Code != http.StatusForbidden {
+				t.Fatalf("Expected 403 Forbidden, got %d", rr.Code)
+			}
+			if request.Status != models.FriendRequestPending {
+				t.Errorf("Expected the request to stay pending, got %s", request.Status)
+			}
+		})
+	}
+}
+
+func TestAnswerFriendRequest_AdminForOther(t *testing.T) {
+	for _, tc := range friendRequestAnswers {
+		t.Run(tc.action, func(t *testing.T) {
+			admin := createTestUser(t, "auth0|admin_answer_admin", "admin")
+
+			rr, request := answerFor(t, tc.action, tc.bySender, tc.answer, admin, middleware.AdminRole)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if request.Status == models.FriendRequestPending {
+				t.Errorf("Expected the request to be answered")
+			}
+		})
+	}
+}
+
+// alice and bob as friends, with a request to remove their friendship on
+// alice's behalf as caller
+func removeFriendFor(t *testing.T, caller *models.User, roles ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	alice := createTestUser(t, "auth0|admin_remove_alice", "alice")
+	bob := createTestUser(t, "auth0|admin_remove_bob", "bob")
+	friendService := newTestFriendService()
+
+	request, err := friendService.SendFriendRequest(alice.UserID, bob.UserID)
+	if err != nil {
+		t.Fatalf("Failed to send friend request: %v", err)
+	}
+	if _, err := friendService.AcceptFriendRequest(bob.UserID, request.ID); err != nil {
+		t.Fatalf("Failed to accept friend request: %v", err)
+	}
+
+	url := fmt.Sprintf("/friend?friend_id=%d&auth0_id=%s", bob.UserID, alice.Auth0ID)
+	req := withClaims(httptest.NewRequest(http.MethodDelete, url, nil), caller.Auth0ID, roles...)
+	rr := httptest.NewRecorder()
+	handleRemoveFriend(logic.NewUserLogic(db.NewRepository(db.DB)), friendService).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRemoveFriend_OtherUserForbidden(t *testing.T) {
+	caller := createTestUser(t, "auth0|admin_remove_caller", "caller")
+
+	rr := removeFriendFor(t, caller)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 Forbidden, got %d", rr.Code)
+	}
+}
+
+func TestRemoveFriend_AdminForOther(t *testing.T) {
+	admin := createTestUser(t, "auth0|admin_remove_admin", "admin")
+
+	rr := removeFriendFor(t, admin, middleware.AdminRole)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRemoveFriend_OnlyCallersFriendships(t *testing.T) {
+	alice := createTestUser(t, "auth0|authz_remove_alice", "alice")
+	bob := createTestUser(t, "auth0|authz_remove_bob", "bob")
+	caller := createTestUser(t, "auth0|authz_remove_caller", "caller")
+	friendService := newTestFriendService()
+
+	request, err := friendService.SendFriendRequest(alice.UserID, bob.UserID)
+	if err != nil {
+		t.Fatalf("Failed to send friend request: %v", err)
+	}
+	if _, err := friendService.AcceptFriendRequest(bob.UserID, request.ID); err != nil {
+		t.Fatalf("Failed to accept friend request: %v", err)
+	}
+
+	url := fmt.Sprintf("/friend?friend_id=%d", bob.UserID)
+	req := withClaims(httptest.NewRequest(http.MethodDelete, url, nil), caller.Auth0ID)
+	rr := httptest.NewRecorder()
+	handleRemoveFriend(logic.NewUserLogic(db.NewRepository(db.DB)), friendService).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 Not Found, got %d", rr.Code)
+	}
+	friends, err := db.NewRepository(db.DB).AreFriends(alice.UserID, bob.UserID)
+	if err != nil || !friends {
+		t.Errorf("Expected alice and bob to still be friends, got %v (%v)", friends, err)
+	}
+}
+
+func TestUpdateProfile_RequiresToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPatch, "/profile", strings.NewReader(`{"bio": "hijacked"}`))
+	rr := httptest.NewRecorder()
+	handleProfile(logic.NewUserLogic(db.NewRepository(db.DB)), nil).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 Unauthorized, got %d", rr.Code)
+	}
+}
+
+func updateBioFor(t *testing.T, target, caller *models.User, roles ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := withClaims(httptest.NewRequest(http.MethodPatch, "/profile?auth0_id="+target.Auth0ID, strings.NewReader(`{"bio": "updated"}`)), caller.Auth0ID, roles...)
+	rr := httptest.NewRecorder()
+	handleProfile(logic.NewUserLogic(db.NewRepository(db.DB)), nil).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUpdateProfile_OtherUserForbidden(t *testing.T) {
+	caller := createTestUser(t, "auth0|admin_profile_caller", "caller")
+	victim := createTestUser(t, "auth0|admin_profile_victim", "victim")
+
+	rr := updateBioFor(t, victim, caller)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 Forbidden, got %d", rr.Code)
+	}
+	stored, err := db.NewRepository(db.DB).GetUserByAuth0ID(victim.Auth0ID)
+	if err != nil || stored.Profile.Bio != "" {
+		t.Errorf("Expected the victim's bio to be unchanged, got %q (%v)", stored.Profile.Bio, err)
+	}
+}
+
+func TestUpdateProfile_AdminUpdatesOther(t *testing.T) {
+	admin := createTestUser(t, "auth0|admin_profile_admin", "admin")
+	other := createTestUser(t, "auth0|admin_profile_other", "other")
+
+	rr := updateBioFor(t, other, admin, middleware.AdminRole)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+	}
+	stored, err := db.NewRepository(db.DB).GetUserByAuth0ID(other.Auth0ID)
+	if err != nil || stored.Profile.Bio != "updated" {
+		t.Errorf("Expected the admin to update the bio, got %q (%v)", stored.Profile.Bio, err)
+	}
+}
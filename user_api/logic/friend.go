@@ -0,0 +1,316 @@
+package logic
+
+import (
+	"cloudcord/user_api/models"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSelfFriendRequest   = errors.New("cannot send a friend request to yourself")
+	ErrAlreadyFriends      = errors.New("already friends")
+	ErrRequestPending      = errors.New("a friend request is already pending")
+	ErrRequestNotFound     = errors.New("friend request not found")
+	ErrRequestNotPending   = errors.New("friend request is no longer pending")
+	ErrNotRequestRecipient = errors.New("only the receiver can answer this friend request")
+	ErrNotRequestSender    = errors.New("only the sender can cancel this friend request")
+	ErrNotFriends          = errors.New("not friends")
+)
+
+const (
+	DefaultFriendsPageSize = 50
+	MaxFriendsPageSize     = 100
+)
+
+type FriendRepository interface {
+	GetUserByID(id uint) (*models.User, error)
+	AreFriends(userID, otherUserID uint) (bool, error)
+	CreateFriendRequest(request *models.FriendRequest) error
+	GetFriendRequest(id uint) (*models.FriendRequest, error)
+	GetPendingFriendRequest(senderID, receiverID uint) (*models.FriendRequest, error)
+	GetPendingFriendRequests(userID uint) ([]models.FriendRequest, error)
+	AcceptFriendRequest(request *models.FriendRequest, at time.Time) error
+	CloseFriendRequest(id uint, status string, at time.Time) error
+	GetFriends(userID uint, limit, offset int) ([]models.User, int64, error)
+	RemoveFriendship(userID, friendID uint, sync func() error) error
+}
+
+// FriendGraph mirrors friendships into Neo4j for recommendations, see
+// graphdb.Graph
+type FriendGraph interface {
+	CreateFriendship(userID, friendID uint) error
+	RemoveFriendship(userID, friendID uint) error
+}
+
+// FriendService takes friendships through a request the other user has to
+// accept. Postgres holds the friendships, the graph follows it.
+type FriendService struct {
+	repo     FriendRepository
+	graph    FriendGraph
+	notifier Publisher
+}
+
+// constructor
+func NewFriendService(repo FriendRepository, graph FriendGraph, notifier Publisher) *FriendService {
+	return &FriendService{repo: repo, graph: graph, notifier: notifier}
+}
+
+// FriendRequests are the pending requests of a user
+type FriendRequests struct {
+	Incoming []models.FriendRequest `json:"incoming"`
+	Outgoing []models.FriendRequest `json:"outgoing"`
+}
+
+// FriendsPage is a page of a user's friends, Total counts all of them
+type FriendsPage struct {
+	Friends []models.User `json:"friends"`
+	Total   int64         `json:"total"`
+	Limit   int           `json:"limit"`
+	Offset  int           `json:"offset"`
+}
+
+// ask another user to be friends. When they already asked the sender, that
+// request is accepted instead.
+func (fs *FriendService) SendFriendRequest(senderID, receiverID uint) (*models.FriendRequest, error) {
+	if senderID == receiverID {
+		return nil, ErrSelfFriendRequest
+	}
+
+	if _, err := fs.repo.GetUserByID(receiverID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	friends, err := fs.repo.AreFriends(senderID, receiverID)
+	if err != nil {
+		return nil, err
+	}
+	if friends {
+		return nil, ErrAlreadyFriends
+	}
+
+	reverse, err := fs.repo.GetPendingFriendRequest(receiverID, senderID)
+	if err != nil {
+		return nil, err
+	}
+	if reverse != nil {
+		return fs.AcceptFriendRequest(senderID, reverse.ID)
+	}
+
+	existing, err := fs.repo.GetPendingFriendRequest(senderID, receiverID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrRequestPending
+	}
+
+	request := &models.FriendRequest{
+		SenderID:   senderID,
+		ReceiverID: receiverID,
+		Status:     models.FriendRequestPending,
+		CreatedAt:  time.Now(),
+	}
+	if err := fs.repo.CreateFriendRequest(request); err != nil {
+		// the unique index settles two requests sent at once
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrRequestPending
+		}
+		return nil, err
+	}
+
+	log.Printf("User %d sent a friend request to user %d", senderID, receiverID)
+	fs.notify(request, receiverID, models.NotifyFriendRequestReceived, "sent you a friend request", senderID)
+	return request, nil
+}
+
+// the pending requests a user received and sent
+func (fs *FriendService) ListFriendRequests(userID uint) (*FriendRequests, error) {
+	requests, err := fs.repo.GetPendingFriendRequests(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &FriendRequests{Incoming: []models.FriendRequest{}, Outgoing: []models.FriendRequest{}}
+	for _, request := range requests {
+		if request.ReceiverID == userID {
+			list.Incoming = append(list.Incoming, request)
+		} else {
+			list.Outgoing = append(list.Outgoing, request)
+		}
+	}
+	return list, nil
+}
+
+// accept a request the user received, which makes them friends
+func (fs *FriendService) AcceptFriendRequest(userID, requestID uint) (*models.FriendRequest, error) {
+	request, err := fs.pendingRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if request.ReceiverID != userID {
+		return nil, ErrNotRequestRecipient
+	}
+
+	now := time.Now()
+	if err := fs.repo.AcceptFriendRequest(request, now); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRequestNotPending
+		}
+		return nil, err
+	}
+	request.Status = models.FriendRequestAccepted
+	request.RespondedAt = &now
+
+	// the friendship is stored, a graph out of date only costs
+	// recommendations
+	if err := fs.graph.CreateFriendship(request.SenderID, request.ReceiverID); err != nil {
+		log.Printf("Failed to sync friendship of users %d and %d to Neo4j: %v", request.SenderID, request.ReceiverID, err)
+	}
+
+	log.Printf("User %d and User %d are now friends", request.SenderID, request.ReceiverID)
+	fs.notify(request, request.SenderID, models.NotifyFriendRequestAccepted, "accepted your friend request", request.ReceiverID)
+	return request, nil
+}
+
+// turn down a request the user received
+func (fs *FriendService) DeclineFriendRequest(userID, requestID uint) (*models.FriendRequest, error) {
+	request, err := fs.pendingRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if request.ReceiverID != userID {
+		return nil, ErrNotRequestRecipient
+	}
+
+	if err := fs.close(request, models.FriendRequestDeclined); err != nil {
+		return nil, err
+	}
+
+	fs.notify(request, request.SenderID, models.NotifyFriendRequestDeclined, "declined your friend request", request.ReceiverID)
+	return request, nil
+}
+
+// take back a request the user sent
+func (fs *FriendService) CancelFriendRequest(userID, requestID uint) (*models.FriendRequest, error) {
+	request, err := fs.pendingRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if request.SenderID != userID {
+		return nil, ErrNotRequestSender
+	}
+
+	if err := fs.close(request, models.FriendRequestCancelled); err != nil {
+		return nil, err
+	}
+
+	fs.notify(request, request.ReceiverID, models.NotifyFriendRequestCancelled, "cancelled their friend request", request.SenderID)
+	return request, nil
+}
+
+// a page of the user's friends with their profiles, limit is clamped to
+// MaxFriendsPageSize and defaults to DefaultFriendsPageSize
+func (fs *FriendService) ListFriends(userID uint, limit, offset int) (*FriendsPage, error) {
+	if limit <= 0 {
+		limit = DefaultFriendsPageSize
+	}
+	if limit > MaxFriendsPageSize {
+		limit = MaxFriendsPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	friends, total, err := fs.repo.GetFriends(userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if friends == nil {
+		friends = []models.User{}
+	}
+	return &FriendsPage{Friends: friends, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+// end a friendship for both users. The graph edges go inside the Postgres
+// transaction, so recommendations never count an ex-friend as mutual and a
+// failure leaves the friendship whole to retry.
+func (fs *FriendService) RemoveFriend(userID, friendID uint) error {
+	err := fs.repo.RemoveFriendship(userID, friendID, func() error {
+		return fs.graph.RemoveFriendship(userID, friendID)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFriends
+		}
+		return err
+	}
+
+	log.Printf("User %d and User %d are no longer friends", userID, friendID)
+	return nil
+}
+
+func (fs *FriendService) pendingRequest(requestID uint) (*models.FriendRequest, error) {
+	request, err := fs.repo.GetFriendRequest(requestID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRequestNotFound
+		}
+		return nil, err
+	}
+	if request.Status != models.FriendRequestPending {
+		return nil, ErrRequestNotPending
+	}
+	return request, nil
+}
+
+func (fs *FriendService) close(request *models.FriendRequest, status string) error {
+	now := time.Now()
+	if err := fs.repo.CloseFriendRequest(request.ID, status, now); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRequestNotPending
+		}
+		return err
+	}
+	request.Status = status
+	request.RespondedAt = &now
+	return nil
+}
+
+// tell a user about a change to a request, the change is stored so a
+// failure is only logged
+func (fs *FriendService) notify(request *models.FriendRequest, recipientID uint, notificationType string, action string, actorID uint) {
+	recipient, err := fs.repo.GetUserByID(recipientID)
+	if err != nil {
+		log.Printf("Failed to get user %d to notify: %v", recipientID, err)
+		return
+	}
+	actor, err := fs.repo.GetUserByID(actorID)
+	if err != nil {
+		log.Printf("Failed to get user %d to notify about: %v", actorID, err)
+		return
+	}
+
+	notification := models.FriendRequestNotification{
+		Type:       notificationType,
+		ReceiverID: recipient.Auth0ID,
+		Message:    displayName(actor) + " " + action,
+		Request:    *request,
+	}
+	if err := fs.notifier.Publish(notification); err != nil {
+		log.Printf("Failed to publish %s notification: %v", notificationType, err)
+	}
+}
+
+// the name a user goes by, their display name when they set one
+func displayName(user *models.User) string {
+	if user.Profile.DisplayName != "" {
+		return user.Profile.DisplayName
+	}
+	return user.Username
+}
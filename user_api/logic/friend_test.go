@@ -0,0 +1,352 @@
+package logic_test
+
+import (
+	"cloudcord/user_api/logic"
+	"cloudcord/user_api/models"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type MockFriendRepo struct {
+	mock.Mock
+}
+
+func (m *MockFriendRepo) GetUserByID(id uint) (*models.User, error) {
+	args := m.Called(id)
+	user, _ := args.Get(0).(*models.User)
+	return user, args.Error(1)
+}
+
+func (m *MockFriendRepo) AreFriends(userID, otherUserID uint) (bool, error) {
+	args := m.Called(userID, otherUserID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockFriendRepo) CreateFriendRequest(request *models.FriendRequest) error {
+	args := m.Called(request)
+	return args.Error(0)
+}
+
+func (m *MockFriendRepo) GetFriendRequest(id uint) (*models.FriendRequest, error) {
+	args := m.Called(id)
+	request, _ := args.Get(0).(*models.FriendRequest)
+	return request, args.Error(1)
+}
+
+func (m *MockFriendRepo) GetPendingFriendRequest(senderID, receiverID uint) (*models.FriendRequest, error) {
+	args := m.Called(senderID, receiverID)
+	request, _ := args.Get(0).(*models.FriendRequest)
+	return request, args.Error(1)
+}
+
+func (m *MockFriendRepo) GetPendingFriendRequests(userID uint) ([]models.FriendRequest, error) {
+	args := m.Called(userID)
+	requests, _ := args.Get(0).([]models.FriendRequest)
+	return requests, args.Error(1)
+}
+
+func (m *MockFriendRepo) AcceptFriendRequest(request *models.FriendRequest, at time.Time) error {
+	args := m.Called(request, at)
+	return args.Error(0)
+}
+
+func (m *MockFriendRepo) CloseFriendRequest(id uint, status string, at time.Time) error {
+	args := m.Called(id, status, at)
+	return args.Error(0)
+}
+
+func (m *MockFriendRepo) GetFriends(userID uint, limit, offset int) ([]models.User, int64, error) {
+	args := m.Called(userID, limit, offset)
+	friends, _ := args.Get(0).([]models.User)
+	return friends, args.Get(1).(int64), args.Error(2)
+}
+
+// sync runs like it would inside the transaction, its error is returned
+func (m *MockFriendRepo) RemoveFriendship(userID, friendID uint, sync func() error) error {
+	args := m.Called(userID, friendID)
+	if err := args.Error(0); err != nil {
+		return err
+	}
+	return sync()
+}
+
+type MockGraph struct {
+	mock.Mock
+}
+
+func (m *MockGraph) CreateFriendship(userID, friendID uint) error {
+	args := m.Called(userID, friendID)
+	return args.Error(0)
+}
+
+func (m *MockGraph) RemoveFriendship(userID, friendID uint) error {
+	args := m.Called(userID, friendID)
+	return args.Error(0)
+}
+
+type MockPublisher struct {
+	mock.Mock
+}
+
+func (m *MockPublisher) Publish(msg interface{}) error {
+	args := m.Called(msg)
+	return args.Error(0)
+}
+
+var (
+	alice = &models.User{UserID: 1, Auth0ID: "auth0|alice", Username: "alice"}
+	bob   = &models.User{UserID: 2, Auth0ID: "auth0|bob", Username: "bob", Profile: models.Profile{DisplayName: "Bobby"}}
+)
+
+func newFriendService() (*logic.FriendService, *MockFriendRepo, *MockGraph, *MockPublisher) {
+	repo := new(MockFriendRepo)
+	graph := new(MockGraph)
+	notifier := new(MockPublisher)
+	repo.On("GetUserByID", alice.UserID).Return(alice, nil).Maybe()
+	repo.On("GetUserByID", bob.UserID).Return(bob, nil).Maybe()
+	return logic.NewFriendService(repo, graph, notifier), repo, graph, notifier
+}
+
+// a notification of the given type to the given user
+func notification(notificationType string, receiver *models.User) interface{} {
+	return mock.MatchedBy(func(n models.FriendRequestNotification) bool {
+		return n.Type == notificationType && n.ReceiverID == receiver.Auth0ID
+	})
+}
+
+func pendingRequest() *models.FriendRequest {
+	return &models.FriendRequest{ID: 7, SenderID: alice.UserID, ReceiverID: bob.UserID, Status: models.FriendRequestPending}
+}
+
+func TestSendFriendRequest(t *testing.T) {
+	service, repo, graph, notifier := newFriendService()
+
+	repo.On("AreFriends", alice.UserID, bob.UserID).Return(false, nil)
+	repo.On("GetPendingFriendRequest", bob.UserID, alice.UserID).Return(nil, nil)
+	repo.On("GetPendingFriendRequest", alice.UserID, bob.UserID).Return(nil, nil)
+	repo.On("CreateFriendRequest", mock.AnythingOfType("*models.FriendRequest")).Return(nil)
+	notifier.On("Publish", mock.MatchedBy(func(n models.FriendRequestNotification) bool {
+		return n.Type == models.NotifyFriendRequestReceived && n.ReceiverID == bob.Auth0ID && n.Message == "alice sent you a friend request"
+	})).Return(nil)
+
+	request, err := service.SendFriendRequest(alice.UserID, bob.UserID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.FriendRequestPending, request.Status)
+	notifier.AssertExpectations(t)
+	graph.AssertNotCalled(t, "CreateFriendship", mock.Anything, mock.Anything)
+}
+
+func TestSendFriendRequest_Refused(t *testing.T) {
+	service, repo, _, _ := newFriendService()
+
+	_, err := service.SendFriendRequest(alice.UserID, alice.UserID)
+	assert.ErrorIs(t, err, logic.ErrSelfFriendRequest)
+
+	repo.On("GetUserByID", uint(99)).Return(nil, gorm.ErrRecordNotFound)
+	_, err = service.SendFriendRequest(alice.UserID, 99)
+	assert.ErrorIs(t, err, logic.ErrUserNotFound)
+
+	repo.On("AreFriends", alice.UserID, bob.UserID).Return(true, nil)
+	_, err = service.SendFriendRequest(alice.UserID, bob.UserID)
+	assert.ErrorIs(t, err, logic.ErrAlreadyFriends)
+}
+
+func TestSendFriendRequest_AlreadyPending(t *testing.T) {
+	service, repo, _, _ := newFriendService()
+
+	repo.On("AreFriends", alice.UserID, bob.UserID).Return(false, nil)
+	repo.On("GetPendingFriendRequest", bob.UserID, alice.UserID).Return(nil, nil)
+	repo.On("GetPendingFriendRequest", alice.UserID, bob.UserID).Return(pendingRequest(), nil)
+
+	_, err := service.SendFriendRequest(alice.UserID, bob.UserID)
+
+	assert.ErrorIs(t, err, logic.ErrRequestPending)
+	repo.AssertNotCalled(t, "CreateFriendRequest", mock.Anything)
+}
+
+func TestSendFriendRequest_AcceptsTheirRequest(t *testing.T) {
+	service, repo, graph, notifier := newFriendService()
+	theirs := pendingRequest()
+
+	repo.On("AreFriends", bob.UserID, alice.UserID).Return(false, nil)
+	repo.On("GetPendingFriendRequest", alice.UserID, bob.UserID).Return(theirs, nil)
+	repo.On("GetFriendRequest", theirs.ID).Return(theirs, nil)
+	repo.On("AcceptFriendRequest", theirs, mock.Anything).Return(nil)
+	graph.On("CreateFriendship", alice.UserID, bob.UserID).Return(nil)
+	notifier.On("Publish", notification(models.NotifyFriendRequestAccepted, alice)).Return(nil)
+
+	request, err := service.SendFriendRequest(bob.UserID, alice.UserID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.FriendRequestAccepted, request.Status)
+	repo.AssertNotCalled(t, "CreateFriendRequest", mock.Anything)
+}
+
+func TestAcceptFriendRequest(t *testing.T) {
+	service, repo, graph, notifier := newFriendService()
+	request := pendingRequest()
+
+	repo.On("GetFriendRequest", request.ID).Return(request, nil)
+	repo.On("AcceptFriendRequest", request, mock.Anything).Return(nil)
+	graph.On("CreateFriendship", alice.UserID, bob.UserID).Return(nil)
+	notifier.On("Publish", mock.MatchedBy(func(n models.FriendRequestNotification) bool {
+		return n.Type == models.NotifyFriendRequestAccepted && n.ReceiverID == alice.Auth0ID && n.Message == "Bobby accepted your friend request"
+	})).Return(nil)
+
+	accepted, err := service.AcceptFriendRequest(bob.UserID, request.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.FriendRequestAccepted, accepted.Status)
+	assert.NotNil(t, accepted.RespondedAt)
+	graph.AssertExpectations(t)
+	notifier.AssertExpectations(t)
+}
+
+func TestAcceptFriendRequest_OnlyByReceiver(t *testing.T) {
+	service, repo, graph, _ := newFriendService()
+	request := pendingRequest()
+
+	repo.On("GetFriendRequest", request.ID).Return(request, nil)
+
+	_, err := service.AcceptFriendRequest(alice.UserID, request.ID)
+
+	assert.ErrorIs(t, err, logic.ErrNotRequestRecipient)
+	repo.AssertNotCalled(t, "AcceptFriendRequest", mock.Anything, mock.Anything)
+	graph.AssertNotCalled(t, "CreateFriendship", mock.Anything, mock.Anything)
+}
+
+func TestAcceptFriendRequest_NoLongerPending(t *testing.T) {
+	service, repo, graph, _ := newFriendService()
+	request := pendingRequest()
+
+	// cancelled between reading it and accepting it
+	repo.On("GetFriendRequest", request.ID).Return(request, nil)
+	repo.On("AcceptFriendRequest", request, mock.Anything).Return(gorm.ErrRecordNotFound)
+
+	_, err := service.AcceptFriendRequest(bob.UserID, request.ID)
+
+	assert.ErrorIs(t, err, logic.ErrRequestNotPending)
+	graph.AssertNotCalled(t, "CreateFriendship", mock.Anything, mock.Anything)
+}
+
+func TestDeclineFriendRequest(t *testing.T) {
+	service, repo, graph, notifier := newFriendService()
+	request := pendingRequest()
+
+	repo.On("GetFriendRequest", request.ID).Return(request, nil)
+	repo.On("CloseFriendRequest", request.ID, models.FriendRequestDeclined, mock.Anything).Return(nil)
+	notifier.On("Publish", notification(models.NotifyFriendRequestDeclined, alice)).Return(nil)
+
+	declined, err := service.DeclineFriendRequest(bob.UserID, request.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.FriendRequestDeclined, declined.Status)
+	notifier.AssertExpectations(t)
+	graph.AssertNotCalled(t, "CreateFriendship", mock.Anything, mock.Anything)
+}
+
+func TestCancelFriendRequest(t *testing.T) {
+	service, repo, _, notifier := newFriendService()
+	request := pendingRequest()
+
+	repo.On("GetFriendRequest", request.ID).Return(request, nil)
+	repo.On("CloseFriendRequest", request.ID, models.FriendRequestCancelled, mock.Anything).Return(nil)
+	notifier.On("Publish", notification(models.NotifyFriendRequestCancelled, bob)).Return(nil)
+
+	_, err := service.CancelFriendRequest(bob.UserID, request.ID)
+	assert.ErrorIs(t, err, logic.ErrNotRequestSender)
+
+	cancelled, err := service.CancelFriendRequest(alice.UserID, request.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, models.FriendRequestCancelled, cancelled.Status)
+	notifier.AssertExpectations(t)
+}
+
+func TestAnswerFriendRequest_Closed(t *testing.T) {
+	service, repo, _, _ := newFriendService()
+	request := pendingRequest()
+	request.Status = models.FriendRequestDeclined
+
+	repo.On("GetFriendRequest", request.ID).Return(request, nil)
+	repo.On("GetFriendRequest", uint(404)).Return(nil, gorm.ErrRecordNotFound)
+
+	_, err := service.AcceptFriendRequest(bob.UserID, request.ID)
+	assert.ErrorIs(t, err, logic.ErrRequestNotPending)
+
+	_, err = service.DeclineFriendRequest(bob.UserID, 404)
+	assert.ErrorIs(t, err, logic.ErrRequestNotFound)
+}
+
+func TestListFriendRequests(t *testing.T) {
+	service, repo, _, _ := newFriendService()
+	outgoing := pendingRequest()
+	incoming := models.FriendRequest{ID: 8, SenderID: 3, ReceiverID: alice.UserID, Status: models.FriendRequestPending}
+
+	repo.On("GetPendingFriendRequests", alice.UserID).Return([]models.FriendRequest{*outgoing, incoming}, nil)
+
+	list, err := service.ListFriendRequests(alice.UserID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.FriendRequest{incoming}, list.Incoming)
+	assert.Equal(t, []models.FriendRequest{*outgoing}, list.Outgoing)
+}
+
+func TestListFriends(t *testing.T) {
+	service, repo, _, _ := newFriendService()
+
+	repo.On("GetFriends", alice.UserID, logic.DefaultFriendsPageSize, 0).Return([]models.User{*bob}, int64(1), nil)
+	repo.On("GetFriends", alice.UserID, logic.MaxFriendsPageSize, 100).Return(nil, int64(1), nil)
+
+	page, err := service.ListFriends(alice.UserID, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.User{*bob}, page.Friends)
+	assert.Equal(t, "Bobby", page.Friends[0].Profile.DisplayName)
+	assert.Equal(t, int64(1), page.Total)
+
+	page, err = service.ListFriends(alice.UserID, 1000, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, logic.MaxFriendsPageSize, page.Limit)
+	assert.Equal(t, []models.User{}, page.Friends)
+}
+
+func TestRemoveFriend(t *testing.T) {
+	service, repo, graph, _ := newFriendService()
+
+	repo.On("RemoveFriendship", alice.UserID, bob.UserID).Return(nil)
+	graph.On("RemoveFriendship", alice.UserID, bob.UserID).Return(nil)
+
+	err := service.RemoveFriend(alice.UserID, bob.UserID)
+
+	assert.NoError(t, err)
+	graph.AssertExpectations(t)
+}
+
+func TestRemoveFriend_NotFriends(t *testing.T) {
+	service, repo, graph, _ := newFriendService()
+
+	repo.On("RemoveFriendship", alice.UserID, bob.UserID).Return(gorm.ErrRecordNotFound)
+
+	err := service.RemoveFriend(alice.UserID, bob.UserID)
+
+	assert.ErrorIs(t, err, logic.ErrNotFriends)
+	graph.AssertNotCalled(t, "RemoveFriendship", mock.Anything, mock.Anything)
+}
+
+func TestRemoveFriend_GraphFails(t *testing.T) {
+	service, repo, graph, _ := newFriendService()
+	graphErr := errors.New("neo4j unavailable")
+
+	repo.On("RemoveFriendship", alice.UserID, bob.UserID).Return(nil)
+	graph.On("RemoveFriendship", alice.UserID, bob.UserID).Return(graphErr)
+
+	// the error rolls back the Postgres delete
+	err := service.RemoveFriend(alice.UserID, bob.UserID)
+
+	assert.ErrorIs(t, err, graphErr)
+}
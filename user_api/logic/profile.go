@@ -0,0 +1,128 @@
+package logic
+
+import (
+	"cloudcord/user_api/models"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// longest profile fields, in characters
+const (
+	MaxDisplayName = 32
+	MaxBio         = 190
+	MaxPronouns    = 40
+	MaxStatusText  = 128
+
+	// an emoji may be several code points, a family or a flag
+	MaxStatusEmoji = 10
+)
+
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrInvalidProfile = errors.New("invalid profile")
+)
+
+// change the profile of a user, only the fields set in the update. Nothing
+// is saved when a field is invalid.
+func (ul *UserLogic) UpdateProfile(auth0ID string, update models.ProfileUpdate) (*models.User, error) {
+	user, err := ul.repo.GetUserByAuth0ID(auth0ID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	profile := user.Profile
+	fields := []struct {
+		name   string
+		value  *string
+		target *string
+		check  func(string) error
+	}{
+		{"display_name", update.DisplayName, &profile.DisplayName, textCheck(MaxDisplayName, false)},
+		{"avatar_file_id", update.AvatarFileID, &profile.AvatarFileID, checkFileID},
+		{"bio", update.Bio, &profile.Bio, textCheck(MaxBio, true)},
+		{"pronouns", update.Pronouns, &profile.Pronouns, textCheck(MaxPronouns, false)},
+		{"status_text", update.StatusText, &profile.StatusText, textCheck(MaxStatusText, false)},
+		{"status_emoji", update.StatusEmoji, &profile.StatusEmoji, checkEmoji},
+	}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*field.value)
+		if err := field.check(value); err != nil {
+			return nil, fmt.Errorf("%w: %s %v", ErrInvalidProfile, field.name, err)
+		}
+		*field.target = value
+	}
+
+	if err := ul.repo.UpdateProfile(user.UserID, profile); err != nil {
+		log.Printf("Failed to update profile of user %d: %v", user.UserID, err)
+		return nil, err
+	}
+
+	user.Profile = profile
+	return user, nil
+}
+
+// text of at most max characters, without control characters other than
+// line breaks when multiline
+func textCheck(max int, multiline bool) func(string) error {
+	return func(value string) error {
+		if !utf8.ValidString(value) {
+			return errors.New("is not valid UTF-8")
+		}
+		if utf8.RuneCountInString(value) > max {
+			return fmt.Errorf("is longer than %d characters", max)
+		}
+		for _, r := range value {
+			if unicode.IsControl(r) && !(multiline && r == '\n') {
+				return errors.New("has control characters")
+			}
+		}
+		return nil
+	}
+}
+
+// file_storage_api IDs are 24 hex digits
+func checkFileID(value string) error {
+	if value == "" {
+		return nil
+	}
+	if len(value) != 24 || strings.Trim(strings.ToLower(value), "0123456789abcdef") != "" {
+		return errors.New("is not a file ID")
+	}
+	return nil
+}
+
+// a single emoji: symbols with the joiners, selectors, skin tones and tags
+// that make up sequences
+func checkEmoji(value string) error {
+	if utf8.RuneCountInString(value) > MaxStatusEmoji {
+		return errors.New("is not a single emoji")
+	}
+
+	symbols := 0
+	for _, r := range value {
+		switch {
+		case unicode.Is(unicode.So, r):
+			symbols++
+		case r == '\u200d', r == '\ufe0f', r == '\u20e3',
+			r >= 0x1f3fb && r <= 0x1f3ff,
+			r >= 0xe0020 && r <= 0xe007f,
+			r == '#', r == '*', r >= '0' && r <= '9':
+		default:
+			return errors.New("is not an emoji")
+		}
+	}
+	if value != "" && symbols == 0 && !strings.ContainsRune(value, '\u20e3') {
+		return errors.New("is not an emoji")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package logic_test
+
+import (
+	"cloudcord/user_api/logic"
+	"cloudcord/user_api/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func text(s string) *string {
+	return &s
+}
+
+func TestUpdateProfile_ChangesOnlyGivenFields(t *testing.T) {
+	mockRepo := new(MockUserRepo)
+	userLogic := logic.NewUserLogic(mockRepo)
+
+	user := &models.User{UserID: 1, Auth0ID: "auth0|123", Username: "alice", Profile: models.Profile{Bio: "hi", Pronouns: "she/her"}}
+	expected := models.Profile{DisplayName: "Alice", Bio: "hi", StatusText: "at lunch", StatusEmoji: "🍜"}
+
+	mockRepo.On("GetUserByAuth0ID", "auth0|123").Return(user, nil)
+	mockRepo.On("UpdateProfile", uint(1), expected).Return(nil)
+
+	updated, err := userLogic.UpdateProfile("auth0|123", models.ProfileUpdate{
+		DisplayName: text("  Alice "),
+		Pronouns:    text(""),
+		StatusText:  text("at lunch"),
+		StatusEmoji: text("🍜"),
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, updated.Profile)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUpdateProfile_Invalid(t *testing.T) {
+	cases := map[string]models.ProfileUpdate{
+		"long display name":  {DisplayName: text(strings.Repeat("a", logic.MaxDisplayName+1))},
+		"control characters": {StatusText: text("away\x07")},
+		"line break":         {Pronouns: text("they\nthem")},
+		"long bio":           {Bio: text(strings.Repeat("é", logic.MaxBio+1))},
+		"not an emoji":       {StatusEmoji: text("hi")},
+		"bad avatar":         {AvatarFileID: text("../../etc/passwd")},
+	}
+
+	for name, update := range cases {
+		t.Run(name, func(t *testing.T) {
+			mockRepo := new(MockUserRepo)
+			userLogic := logic.NewUserLogic(mockRepo)
+
+			mockRepo.On("GetUserByAuth0ID", "auth0|123").Return(&models.User{UserID: 1, Auth0ID: "auth0|123"}, nil)
+
+			_, err := userLogic.UpdateProfile("auth0|123", update)
+
+			assert.ErrorIs(t, err, logic.ErrInvalidProfile)
+			mockRepo.AssertNotCalled(t, "UpdateProfile", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestUpdateProfile_Emoji(t *testing.T) {
+	mockRepo := new(MockUserRepo)
+	userLogic := logic.NewUserLogic(mockRepo)
+
+	mockRepo.On("GetUserByAuth0ID", "auth0|123").Return(&models.User{UserID: 1, Auth0ID: "auth0|123"}, nil)
+	mockRepo.On("UpdateProfile", uint(1), mock.Anything).Return(nil)
+
+	// a skin tone, a family joined by ZWJ, a flag and a keycap
+	for _, emoji := range []string{"👍🏽", "👩‍👩‍👧", "🇳🇱", "1️⃣", "❤️"} {
+		_, err := userLogic.UpdateProfile("auth0|123", models.ProfileUpdate{StatusEmoji: text(emoji)})
+		assert.NoError(t, err, emoji)
+	}
+}
+
+func TestUpdateProfile_UnknownUser(t *testing.T) {
+	mockRepo := new(MockUserRepo)
+	userLogic := logic.NewUserLogic(mockRepo)
+
+	mockRepo.On("GetUserByAuth0ID", "auth0|404").Return(nil, nil)
+
+	_, err := userLogic.UpdateProfile("auth0|404", models.ProfileUpdate{Bio: text("hello")})
+
+	assert.ErrorIs(t, err, logic.ErrUserNotFound)
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"cloudcord/user_api/chat"
 	"cloudcord/user_api/db"
+	"cloudcord/user_api/files"
 	"cloudcord/user_api/graphdb"
 	"cloudcord/user_api/logic"
 	"cloudcord/user_api/middleware"
 	"cloudcord/user_api/models"
 	"cloudcord/user_api/mq"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -87,6 +91,8 @@ func handleGetUserByID(w http.ResponseWriter, r *http.Request) {
 		"message":  "User retrieved successfully",
 		"userID":   user.UserID,
 		"username": user.Username,
+		"auth0_id": user.Auth0ID,
+		"profile":  user.Profile,
 	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -119,6 +125,7 @@ func handleGetUserByAuth0ID(w http.ResponseWriter, r *http.Request) {
 		"userID":   user.UserID,
 		"username": user.Username,
 		"auth0_id": user.Auth0ID,
+		"profile":  user.Profile,
 	}
 	json.NewEncoder(w).Encode(response)
 }
@@ -140,6 +147,7 @@ func handleGetAllUsers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(users)
 }
 
+// deletes the caller's account, or with auth0_id an admin deletes anyone's
 func handleDeleteUser(userLogic *logic.UserLogic) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
@@ -147,9 +155,18 @@ func handleDeleteUser(userLogic *logic.UserLogic) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := middleware.ActorFromRequest(r)
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
 		auth0ID := r.URL.Query().Get("auth0_id")
 		if auth0ID == "" {
-			http.Error(w, "auth0_id is required", http.StatusBadRequest)
+			auth0ID = actor.Auth0ID
+		}
+		if !actor.CanActOn(auth0ID) {
+			http.Error(w, "Forbidden: you can only delete your own account", http.StatusForbidden)
 			return
 		}
 
@@ -173,7 +190,9 @@ func handleDeleteUser(userLogic *logic.UserLogic) http.HandlerFunc {
 	}
 }
 
-func handleAddFriend(userLogic *logic.UserLogic) http.HandlerFunc {
+// sends a friend request from the caller to friend_id, they become friends
+// once it's accepted. user_id may only name someone else for an admin.
+func handleAddFriend(userLogic *logic.UserLogic, friendService *logic.FriendService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
@@ -191,19 +210,36 @@ func handleAddFriend(userLogic *logic.UserLogic) http.HandlerFunc {
 			return
 		}
 
-		if req.UserID == 0 || req.FriendID == 0 {
-			http.Error(w, "Missing user IDs", http.StatusBadRequest)
+		if req.FriendID == 0 {
+			http.Error(w, "Missing friend_id", http.StatusBadRequest)
+			return
+		}
+
+		caller, ok := currentUser(w, r, userLogic)
+		if !ok {
+			return
+		}
+
+		senderID := req.UserID
+		if senderID == 0 {
+			senderID = caller.UserID
+		}
+		if actor, _ := middleware.ActorFromRequest(r); senderID != caller.UserID && !actor.Admin {
+			http.Error(w, "Forbidden: you can only add friends for yourself", http.StatusForbidden)
 			return
 		}
 
-		err := userLogic.AddFriend(req.UserID, req.FriendID)
+		request, err := friendService.SendFriendRequest(senderID, req.FriendID)
 		if err != nil {
-			http.Error(w, "Failed to add friend", http.StatusInternalServerError)
+			http.Error(w, "Failed to send friend request: "+err.Error(), statusForFriendError(err))
 			return
 		}
 
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(map[string]string{"message": "Friend added successfully"})
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":        "Friend request sent",
+			"friend_request": request,
+		})
 	}
 }
 
@@ -255,6 +291,109 @@ func handleFriendRecommendations(userLogic *logic.UserLogic) http.HandlerFunc {
 	}
 }
 
+// the Auth0 ID of the caller, from the token checked by ValidateJWT
+func auth0IDFromRequest(r *http.Request) (string, bool) {
+	actor, ok := middleware.ActorFromRequest(r)
+	return actor.Auth0ID, ok
+}
+
+// GET reads a profile, of the user given by id or auth0_id or else of the
+// caller. PATCH changes the caller's own, or an admin's the one of auth0_id.
+func handleProfile(userLogic *logic.UserLogic, fileStore *files.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			handleGetProfile(userLogic)(w, r)
+		case http.MethodPatch:
+			handleUpdateProfile(userLogic, fileStore)(w, r)
+		default:
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func handleGetProfile(userLogic *logic.UserLogic) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth0ID, ok := auth0IDFromRequest(r)
+		if !ok {
+			http.Error(w, "Unauthorized: no valid token claims found", http.StatusUnauthorized)
+			return
+		}
+
+		var user *models.User
+		var err error
+		if idStr := r.URL.Query().Get("id"); idStr != "" {
+			id, parseErr := strconv.ParseUint(idStr, 10, 32)
+			if parseErr != nil {
+				http.Error(w, "Invalid ID format", http.StatusBadRequest)
+				return
+			}
+			user, err = userLogic.GetUserByIDHandler(uint(id))
+		} else {
+			if other := r.URL.Query().Get("auth0_id"); other != "" {
+				auth0ID = other
+			}
+			user, err = userLogic.GetUserByAuth0ID(auth0ID)
+		}
+		if err != nil || user == nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	}
+}
+
+// an avatar must be an image the user uploaded to file_storage_api as one,
+// which is checked with the caller's token
+func handleUpdateProfile(userLogic *logic.UserLogic, fileStore *files.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth0ID, ok := targetAuth0ID(w, r)
+		if !ok {
+			return
+		}
+
+		var update models.ProfileUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if update.AvatarFileID != nil && *update.AvatarFileID != "" {
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+			defer cancel()
+
+			file, err := fileStore.Get(ctx, r.Header.Get("Authorization"), *update.AvatarFileID)
+			if err != nil && !errors.Is(err, files.ErrFileNotFound) {
+				log.Printf("Failed to look up avatar %s: %v", *update.AvatarFileID, err)
+				http.Error(w, "Could not check avatar", http.StatusBadGateway)
+				return
+			}
+			if file == nil || file.UploadedBy != auth0ID || file.Purpose != files.PurposeAvatar {
+				http.Error(w, "avatar_file_id must be an avatar the user uploaded", http.StatusBadRequest)
+				return
+			}
+		}
+
+		user, err := userLogic.UpdateProfile(auth0ID, update)
+		if err != nil {
+			switch {
+			case errors.Is(err, logic.ErrInvalidProfile):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case errors.Is(err, logic.ErrUserNotFound):
+				http.Error(w, "User not found", http.StatusNotFound)
+			default:
+				http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(user)
+	}
+}
+
 func withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
@@ -265,7 +404,7 @@ func withCORS(next http.Handler) http.Handler {
 		}
 
 		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE,OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -276,6 +415,52 @@ func withCORS(next http.Handler) http.Handler {
 	})
 }
 
+// get whether a user is online in chat and when they were last seen there.
+// Presence lives in chat_api, which is asked with the caller's token.
+func handleGetLastSeen(presence *chat.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+		if err != nil || id == 0 {
+			http.Error(w, "Invalid or missing id", http.StatusBadRequest)
+			return
+		}
+
+		userLogic := logic.NewUserLogic(db.NewRepository(db.DB))
+
+		user, err := userLogic.GetUserByIDHandler(uint(id))
+		if err != nil || user == nil {
+			http.Error(w, "User ID not found", http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		p, err := presence.Presence(ctx, r.Header.Get("Authorization"), user.Auth0ID)
+		if err != nil {
+			log.Printf("Failed to get presence of user %d: %v", user.UserID, err)
+			http.Error(w, "Could not retrieve presence", http.StatusBadGateway)
+			return
+		}
+
+		response := map[string]interface{}{
+			"userID": user.UserID,
+			"status": p.Status,
+		}
+		if !p.LastSeen.IsZero() {
+			response["last_seen"] = p.LastSeen
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
 func main() {
 	db.Connect()
 
@@ -302,8 +487,12 @@ func main() {
 		log.Fatal("RabbitMQ path not set in environment")
 	}
 
+	// the queues of chat_api and file_storage_api, bound here too so no
+	// deletion is lost when user_api starts before them
+	deletionQueues := []string{"user_deletion", "file_storage.user_deletion"}
+
 	for i := 0; i < 10; i++ {
-		publisher, err2 = mq.NewPublisher(rabbitURI, "user_deletion")
+		publisher, err2 = mq.NewPublisher(rabbitURI, "user_deletion", deletionQueues...)
 		if err2 == nil {
 			log.Println("✅ RabbitMQ publisher set up successfully")
 			break
@@ -316,7 +505,35 @@ func main() {
 		log.Fatalf("Failed to set up RabbitMQ publisher after retries: %v", err2)
 	}
 
+	var notifier *mq.QueuePublisher
+	for i := 0; i < 10; i++ {
+		notifier, err2 = mq.NewQueuePublisher(rabbitURI, "message_notifications")
+		if err2 == nil {
+			log.Println("✅ RabbitMQ notification publisher set up successfully")
+			break
+		}
+		log.Printf("Attempt %d: Failed to set up RabbitMQ notification publisher: %v", i+1, err2)
+		time.Sleep(3 * time.Second)
+	}
+
+	if err2 != nil {
+		log.Fatalf("Failed to set up RabbitMQ notification publisher after retries: %v", err2)
+	}
+
 	userLogic := logic.NewUserLogicRabbitMQ(repo, publisher)
+	friendService := logic.NewFriendService(repo, graphdb.Graph{}, notifier)
+
+	chatAPIURL := os.Getenv("CHAT_API_URL")
+	if chatAPIURL == "" {
+		chatAPIURL = "http://chat-api-service:8084"
+	}
+	chatClient := chat.NewClient(chatAPIURL)
+
+	fileStorageURL := os.Getenv("FILE_STORAGE_URL")
+	if fileStorageURL == "" {
+		fileStorageURL = "http://file-storage-service:8082"
+	}
+	fileStore := files.NewClient(fileStorageURL)
 
 	http.HandleFunc("/", handleOK)
 
@@ -325,9 +542,17 @@ func main() {
 	http.Handle("/user/auth-user", middleware.ValidateJWT(http.HandlerFunc(handleGetUserByAuth0ID)))
 	http.Handle("/user/users", withCORS(middleware.ValidateJWT(http.HandlerFunc(handleGetAllUsers))))
 	http.Handle("/user/delete", withCORS(middleware.ValidateJWT(handleDeleteUser(userLogic))))
-	http.Handle("/user/add-friend", withCORS(middleware.ValidateJWT(handleAddFriend(userLogic))))
+	http.Handle("/user/add-friend", withCORS(middleware.ValidateJWT(handleAddFriend(userLogic, friendService))))
+	http.Handle("/user/friend-requests", withCORS(middleware.ValidateJWT(handleFriendRequests(userLogic, friendService))))
+	http.Handle("/user/friend-requests/accept", withCORS(middleware.ValidateJWT(handleAnswerFriendRequest(userLogic, friendService.AcceptFriendRequest))))
+	http.Handle("/user/friend-requests/decline", withCORS(middleware.ValidateJWT(handleAnswerFriendRequest(userLogic, friendService.DeclineFriendRequest))))
+	http.Handle("/user/friend-requests/cancel", withCORS(middleware.ValidateJWT(handleAnswerFriendRequest(userLogic, friendService.CancelFriendRequest))))
+	http.Handle("/user/friends", withCORS(middleware.ValidateJWT(handleListFriends(userLogic, friendService))))
+	http.Handle("/user/friend", withCORS(middleware.ValidateJWT(handleRemoveFriend(userLogic, friendService))))
 	http.Handle("/user/is-friend", withCORS(middleware.ValidateJWT(handleAreFriends(userLogic))))
 	http.Handle("/user/recommendations", withCORS(middleware.ValidateJWT(handleFriendRecommendations(userLogic))))
+	http.Handle("/user/profile", withCORS(middleware.ValidateJWT(handleProfile(userLogic, fileStore))))
+	http.Handle("/user/last-seen", withCORS(middleware.ValidateJWT(handleGetLastSeen(chatClient))))
 
 	go func() {
 		fmt.Println("Starting metrics server on :2112...")
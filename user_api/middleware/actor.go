@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// RolesClaim is the custom claim an Auth0 Action puts the user's roles in,
+// namespaced like the audience as Auth0 requires
+const RolesClaim = "https://cloudcord/roles"
+
+// AdminRole may act on any user's account
+const AdminRole = "admin"
+
+// Actor is the caller of a request, from the token checked by ValidateJWT
+type Actor struct {
+	Auth0ID string
+	Admin   bool
+}
+
+// ActorFromRequest resolves the caller from the claims in UserContextKey,
+// false when there are none or they have no sub
+func ActorFromRequest(r *http.Request) (Actor, bool) {
+	claims, ok := r.Context().Value(UserContextKey).(jwt.MapClaims)
+	if !ok || claims == nil {
+		return Actor{}, false
+	}
+	auth0ID, ok := claims["sub"].(string)
+	if !ok || auth0ID == "" {
+		return Actor{}, false
+	}
+	return Actor{Auth0ID: auth0ID, Admin: hasRole(claims, AdminRole)}, true
+}
+
+// CanActOn reports whether the actor may change the account of auth0ID,
+// their own or anyone's for an admin
+func (a Actor) CanActOn(auth0ID string) bool {
+	return a.Admin || a.Auth0ID == auth0ID
+}
+
+func hasRole(claims jwt.MapClaims, role string) bool {
+	switch roles := claims[RolesClaim].(type) {
+	case string:
+		return roles == role
+	case []interface{}:
+		for _, r := range roles {
+			if s, ok := r.(string); ok && s == role {
+				return true
+			}
+		}
+	case []string:
+		for _, s := range roles {
+			if s == role {
+				return true
+			}
+		}
+	}
+	return false
+}
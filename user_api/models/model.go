@@ -1,11 +1,37 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
-	UserID   uint   `gorm:"primaryKey;autoIncrement" json:"user_id"`
-	Auth0ID  string `gorm:"uniqueIndex;not null" json:"auth0_id"`
-	Username string `gorm:"type:varchar(100);not null" json:"username"`
+	UserID   uint    `gorm:"primaryKey;autoIncrement" json:"user_id"`
+	Auth0ID  string  `gorm:"uniqueIndex;not null" json:"auth0_id"`
+	Username string  `gorm:"type:varchar(100);not null" json:"username"`
+	Profile  Profile `gorm:"embedded" json:"profile"`
+}
+
+// Profile is what a user tells others about themselves, every field is
+// optional. The avatar is a file uploaded to file_storage_api.
+type Profile struct {
+	DisplayName  string `gorm:"type:varchar(32);not null;default:''" json:"display_name"`
+	AvatarFileID string `gorm:"type:varchar(24);not null;default:''" json:"avatar_file_id"`
+	Bio          string `gorm:"type:varchar(190);not null;default:''" json:"bio"`
+	Pronouns     string `gorm:"type:varchar(40);not null;default:''" json:"pronouns"`
+	StatusText   string `gorm:"type:varchar(128);not null;default:''" json:"status_text"`
+	StatusEmoji  string `gorm:"type:varchar(32);not null;default:''" json:"status_emoji"`
+}
+
+// ProfileUpdate changes the fields that are set, an empty string clears one
+type ProfileUpdate struct {
+	DisplayName  *string `json:"display_name"`
+	AvatarFileID *string `json:"avatar_file_id"`
+	Bio          *string `json:"bio"`
+	Pronouns     *string `json:"pronouns"`
+	StatusText   *string `json:"status_text"`
+	StatusEmoji  *string `json:"status_emoji"`
 }
 
 type UserRecommendation struct {
@@ -23,10 +49,47 @@ func (Friendship) TableName() string {
 	return "friendships"
 }
 
+// FriendRequest asks the receiver to become friends with the sender. Only
+// one request between two users may be pending at a time.
+type FriendRequest struct {
+	ID          uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	SenderID    uint       `gorm:"not null;uniqueIndex:idx_pending_friend_request,where:status = 'pending'" json:"sender_id"`
+	ReceiverID  uint       `gorm:"not null;index;uniqueIndex:idx_pending_friend_request,where:status = 'pending'" json:"receiver_id"`
+	Status      string     `gorm:"type:varchar(16);not null;default:'pending'" json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+}
+
+const (
+	FriendRequestPending   = "pending"
+	FriendRequestAccepted  = "accepted"
+	FriendRequestDeclined  = "declined"
+	FriendRequestCancelled = "cancelled"
+)
+
+// FriendRequestNotification goes to notification_api when a request
+// changes, ReceiverID is the Auth0 ID of the user to notify
+type FriendRequestNotification struct {
+	Type       string        `json:"type"`
+	ReceiverID string        `json:"receiver_id"`
+	Message    string        `json:"message"`
+	Request    FriendRequest `json:"friend_request"`
+}
+
+// notification types, one per transition
+const (
+	NotifyFriendRequestReceived  = "friend_request.received"
+	NotifyFriendRequestAccepted  = "friend_request.accepted"
+	NotifyFriendRequestDeclined  = "friend_request.declined"
+	NotifyFriendRequestCancelled = "friend_request.cancelled"
+)
+
 type UserDeletedMessage struct {
 	Auth0ID string `json:"auth0_id"`
 }
 
+// MigrateAll creates the tables and adds the columns of newer fields, the
+// profile columns default to empty for existing users
 func MigrateAll(db *gorm.DB) error {
 	if err := db.AutoMigrate(&User{}); err != nil {
 		return err
@@ -34,5 +97,8 @@ func MigrateAll(db *gorm.DB) error {
 	if err := db.AutoMigrate(&Friendship{}); err != nil {
 		return err
 	}
+	if err := db.AutoMigrate(&FriendRequest{}); err != nil {
+		return err
+	}
 	return nil
 }
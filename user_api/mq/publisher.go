@@ -6,9 +6,13 @@ import (
 	"github.com/streadway/amqp"
 )
 
+// Publisher publishes to a fanout exchange, every service interested in the
+// event binds its own queue to it. An event published while no queue is
+// bound is dropped, so the publisher declares and binds the durable queues
+// of the consumers it knows as well.
 type Publisher struct {
-	channel *amqp.Channel
-	queue   amqp.Queue
+	channel  *amqp.Channel
+	exchange string
 }
 
 type NoopPublisher struct{}
@@ -17,7 +21,9 @@ func (n *NoopPublisher) Publish(deleteUsr interface{}) error {
 	return nil
 }
 
-func NewPublisher(amqpURL, queueName string) (*Publisher, error) {
+// NewPublisher declares the exchange and binds the given consumer queues to
+// it, declared the same way their consumers do
+func NewPublisher(amqpURL, exchange string, queues ...string) (*Publisher, error) {
 	conn, err := amqp.Dial(amqpURL)
 	if err != nil {
 		return nil, err
@@ -28,10 +34,11 @@ func NewPublisher(amqpURL, queueName string) (*Publisher, error) {
 		return nil, err
 	}
 
-	q, err := ch.QueueDeclare(
-		queueName,
-		true,
-		false,
+	err = ch.ExchangeDeclare(
+		exchange,
+		"fanout",
+		true,  // durable
+		false, // auto-delete
 		false,
 		false,
 		nil,
@@ -40,9 +47,26 @@ func NewPublisher(amqpURL, queueName string) (*Publisher, error) {
 		return nil, err
 	}
 
+	for _, queueName := range queues {
+		q, err := ch.QueueDeclare(
+			queueName,
+			true,  // durable
+			false, // auto-delete
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := ch.QueueBind(q.Name, "", exchange, false, nil); err != nil {
+			return nil, err
+		}
+	}
+
 	return &Publisher{
-		channel: ch,
-		queue:   q,
+		channel:  ch,
+		exchange: exchange,
 	}, nil
 }
 
@@ -53,13 +77,14 @@ func (p *Publisher) Publish(deleteUser interface{}) error {
 	}
 
 	return p.channel.Publish(
+		p.exchange,
 		"",
-		p.queue.Name,
 		false,
 		false,
 		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
 		},
 	)
 }
@@ -0,0 +1,61 @@
+package mq
+
+import (
+	"encoding/json"
+
+	"github.com/streadway/amqp"
+)
+
+// QueuePublisher publishes to a single durable queue, for work one consumer
+// handles, like the notifications of notification_api
+type QueuePublisher struct {
+	channel *amqp.Channel
+	queue   amqp.Queue
+}
+
+func NewQueuePublisher(amqpURL, queueName string) (*QueuePublisher, error) {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := ch.QueueDeclare(
+		queueName,
+		true,  // durable
+		false, // auto-delete
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueuePublisher{
+		channel: ch,
+		queue:   q,
+	}, nil
+}
+
+func (p *QueuePublisher) Publish(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return p.channel.Publish(
+		"",
+		p.queue.Name,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		},
+	)
+}